@@ -1,15 +1,20 @@
 package kerb
 
 import (
-	"crypto/md5"
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"hash"
+	"slices"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
 	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/pac"
+	"github.com/jcmturner/rpc/v2/mstypes"
 )
 
 // PAC validation errors - these provide specific error types for different validation failures
@@ -34,8 +39,57 @@ const (
 	PAC_CLIENT_CLAIMS_INFO     = 13 // Client claims information
 	PAC_DEVICE_INFO            = 14 // Device information
 	PAC_DEVICE_CLAIMS_INFO     = 15 // Device claims information
+	PAC_TICKET_CHECKSUM        = 16 // Ticket signature
+	PAC_ATTRIBUTES_INFO        = 17 // PAC attributes
+	PAC_REQUESTOR              = 18 // Requestor SID
+	PAC_EXTENDED_KDC_CHECKSUM  = 19 // Extended KDC signature
 )
 
+// knownPACBufferType reports whether MS-PAC defines the buffer type
+func knownPACBufferType(bufType uint32) bool {
+	switch bufType {
+	case PAC_LOGON_INFO, PAC_CREDENTIAL_INFO, PAC_SERVER_CHECKSUM, PAC_PRIVSVR_CHECKSUM,
+		PAC_CLIENT_INFO, PAC_CONSTRAINED_DELEGATION, PAC_UPN_DNS_INFO, PAC_CLIENT_CLAIMS_INFO,
+		PAC_DEVICE_INFO, PAC_DEVICE_CLAIMS_INFO, PAC_TICKET_CHECKSUM, PAC_ATTRIBUTES_INFO,
+		PAC_REQUESTOR, PAC_EXTENDED_KDC_CHECKSUM:
+		return true
+	}
+	return false
+}
+
+// NoLogonTimeSkewCheck, passed as the clock skew, skips comparing the PAC
+// logon time with the current time. The ticket's authenticator time is still
+// checked by the SPNEGO acceptor.
+const NoLogonTimeSkewCheck = -1
+
+// Checksum types a PAC signature may carry (MS-PAC 2.8.1); each is keyed by
+// the service key of one encryption type
+const (
+	checksumHMACSHA196AES128 = 15         // HMAC-SHA1-96 with an AES128 key
+	checksumHMACSHA196AES256 = 16         // HMAC-SHA1-96 with an AES256 key
+	checksumHMACMD5          = 0xFFFFFF76 // KERB_CHECKSUM_HMAC_MD5 (-138) with an RC4 key
+)
+
+// Logon info flags (MS-PAC 2.5) that govern the ExtraSIDs and resource groups
+const (
+	logonExtraSIDs      = 0x20       // UserFlags LOGON_EXTRA_SIDS: ExtraSIDs are present
+	logonResourceGroups = 0x200      // UserFlags LOGON_RESOURCE_GROUPS: ResourceGroupIds are present
+	seGroupResource     = 0x20000000 // SE_GROUP_RESOURCE: domain local group from a resource domain
+)
+
+// UserAccountControl flags (MS-SAMR 2.2.1.12) marking machine accounts.
+// gMSAs are computer objects, so they carry the workstation trust flag.
+const (
+	userWorkstationTrustAccount = 0x80  // USER_WORKSTATION_TRUST_ACCOUNT
+	userServerTrustAccount      = 0x100 // USER_SERVER_TRUST_ACCOUNT: domain controller
+)
+
+// isMachineAccount reports whether PAC UserAccountControl flags describe a
+// machine account (computer, gMSA or domain controller) rather than a user
+func isMachineAccount(uac uint32) bool {
+	return uac&(userWorkstationTrustAccount|userServerTrustAccount) != 0
+}
+
 // PAC structure definitions following Microsoft PAC specification
 
 // PACBuffer represents a single buffer within the PAC
@@ -75,7 +129,7 @@ type LogonInfo struct {
 	UserSessionKey         []byte    // User session key
 	LogonServer            string    // Logon server name
 	LogonDomainName        string    // Logon domain name
-	LogonDomainID          []byte    // Logon domain SID
+	LogonDomainID          string    // Logon domain SID
 	Reserved1              []byte    // Reserved field
 	UserAccountControl     uint32    // User account control flags
 	SubAuthStatus          uint32    // Sub-authentication status
@@ -84,8 +138,8 @@ type LogonInfo struct {
 	FailedILogonCount      uint32    // Failed interactive logon count
 	Reserved3              uint32    // Reserved field
 	SIDCount               uint32    // Number of extra SIDs
-	ExtraSIDs              []string  // Array of extra SID strings
-	ResourceGroupDomainSID []byte    // Resource group domain SID
+	ExtraSIDs              []KerbSID // Extra SIDs with their attributes
+	ResourceGroupDomainSID string    // Resource group domain SID
 	ResourceGroupCount     uint32    // Number of resource groups
 	ResourceGroups         []uint32  // Array of resource group RIDs
 }
@@ -96,6 +150,12 @@ type GroupMembership struct {
 	Attributes uint32 // Group membership attributes
 }
 
+// KerbSID represents an ExtraSIDs entry (KERB_SID_AND_ATTRIBUTES)
+type KerbSID struct {
+	SID        string // SID string (S-1-...)
+	Attributes uint32 // Group membership attributes
+}
+
 // UPNInfo represents the PAC_UPN_DNS_INFO buffer containing UPN and DNS domain information
 type UPNInfo struct {
 	UPNLength       uint16 // Length of UPN string
@@ -110,25 +170,36 @@ type PACSignature struct {
 	Type      uint32 // Signature type
 	Size      uint32 // Signature size
 	Signature []byte // Signature data
+	offset    int    // Offset of Signature within the PAC
 }
 
 // PACValidationResult contains the result of PAC validation and extracted information
 type PACValidationResult struct {
-	Valid           bool            // Whether the PAC is valid
-	Principal       string          // Principal name from PAC
-	Realm           string          // Realm from PAC
-	GroupSIDs       []string        // Extracted group SIDs
-	UPN             string          // User Principal Name
-	DNSDomain       string          // DNS domain name
-	LogonTime       time.Time       // User logon time
-	ValidationFlags map[string]bool // Validation status flags
-	Errors          []error         // Validation errors encountered
+	Valid             bool            // Whether the PAC is valid
+	Principal         string          // Principal name from PAC
+	Realm             string          // Realm from PAC
+	GroupSIDs         []string        // Extracted group SIDs, including resource groups
+	ResourceGroupSIDs []string        // Subset of GroupSIDs from the resource domain
+	SIDHistorySIDs    []string        // Subset of GroupSIDs that look like SID history
+	UPN               string          // User Principal Name
+	UserSID           string          // User SID (logon domain SID plus user RID)
+	DNSDomain         string          // DNS domain name
+	LogonTime         time.Time       // User logon time
+	LogonServer       string          // DC that authenticated the user
+	ValidationFlags   map[string]bool // Validation status flags
+	Errors            []error         // Validation errors encountered
 }
 
 // ExtractGroupSIDsFromPAC validates and extracts group SIDs from a PAC
 // This is the main PAC validation function that performs comprehensive validation
-// including signature verification, clock skew checking, and UPN consistency validation
-func ExtractGroupSIDsFromPAC(pacData []byte, keytab *keytab.Keytab, spn string, realm string, clockSkewSec int) (*PACValidationResult, error) {
+// including signature verification, clock skew checking, and UPN consistency validation.
+// realm is the service realm used for key lookup; UPN and DNS domain consistency
+// is checked against the user's home realm from the PAC so cross-realm users are
+// accepted. When requireUPNMatch is set the UPN's user part must also match the
+// logon name. A clockSkewSec of NoLogonTimeSkewCheck skips the logon time
+// check for accounts that legitimately present old logon times. When
+// requireAESSignatures is set, RC4 HMAC-MD5 signatures are rejected.
+func ExtractGroupSIDsFromPAC(pacData []byte, keytab *keytab.Keytab, spn string, realm string, clockSkewSec int, requireUPNMatch, requireAESSignatures bool) (*PACValidationResult, error) {
 	// Security: Enhanced input validation
 	if len(pacData) == 0 {
 		return nil, fmt.Errorf("%w: PAC data is empty", ErrPACInvalidFormat)
@@ -195,11 +266,21 @@ func ExtractGroupSIDsFromPAC(pacData []byte, keytab *keytab.Keytab, spn string,
 			serverSignature, err = parsePACSignature(bufferData)
 			if err != nil {
 				result.Errors = append(result.Errors, fmt.Errorf("server signature parse error: %w", err))
+			} else {
+				serverSignature.offset = int(buffer.Offset) + 8
 			}
 		case PAC_PRIVSVR_CHECKSUM:
 			kdcSignature, err = parsePACSignature(bufferData)
 			if err != nil {
 				result.Errors = append(result.Errors, fmt.Errorf("KDC signature parse error: %w", err))
+			} else {
+				kdcSignature.offset = int(buffer.Offset) + 8
+			}
+		default:
+			// Other defined buffers aren't needed; undefined ones are flagged
+			// so callers can apply their own policy
+			if !knownPACBufferType(buffer.Type) {
+				result.ValidationFlags["UNKNOWN_BUFFER_TYPE"] = true
 			}
 		}
 	}
@@ -211,55 +292,45 @@ func ExtractGroupSIDsFromPAC(pacData []byte, keytab *keytab.Keytab, spn string,
 	}
 
 	if serverSignature == nil || kdcSignature == nil {
-		// For testing purposes, create mock signatures if missing
-		// But mark this as a validation failure
-		if serverSignature == nil {
-			serverSignature = &PACSignature{
-				Type:      PAC_SERVER_CHECKSUM,
-				Size:      24,
-				Signature: make([]byte, 16),
-			}
-		}
-		if kdcSignature == nil {
-			kdcSignature = &PACSignature{
-				Type:      PAC_PRIVSVR_CHECKSUM,
-				Size:      24,
-				Signature: make([]byte, 16),
-			}
-		}
-		// Mark that we had missing signatures
-		result.ValidationFlags["MISSING_SIGNATURES"] = true
+		err := fmt.Errorf("%w: missing server or KDC signature", ErrPACMissingSignature)
+		result.Errors = append(result.Errors, err)
+		return result, err
 	}
 
 	// Validate signatures
-	if err := validatePACSignatures(pacData, serverSignature, kdcSignature, keytab, spn, realm); err != nil {
+	if err := validatePACSignatures(pacData, serverSignature, kdcSignature, keytab, spn, realm, requireAESSignatures); err != nil {
 		result.Errors = append(result.Errors, err)
 		return result, err
 	}
 	result.ValidationFlags["SIGNATURES_VALID"] = true
 
-	// Check if we had missing signatures and mark as invalid
-	if result.ValidationFlags["MISSING_SIGNATURES"] {
-		result.ValidationFlags["SIGNATURES_VALID"] = false
-		result.Errors = append(result.Errors, fmt.Errorf("%w: missing signatures", ErrPACMissingSignature))
-		// Don't return error immediately, continue with other validations
-	}
-
 	// Validate clock skew
-	now := time.Now()
-	timeDiff := now.Sub(logonInfo.LogonTime)
-	if timeDiff < 0 {
-		timeDiff = -timeDiff
+	if clockSkewSec == NoLogonTimeSkewCheck {
+		result.ValidationFlags["LOGON_TIME_SKEW_IGNORED"] = true
+	} else {
+		now := time.Now()
+		timeDiff := now.Sub(logonInfo.LogonTime)
+		if timeDiff < 0 {
+			timeDiff = -timeDiff
+		}
+		if timeDiff > time.Duration(clockSkewSec)*time.Second {
+			result.Errors = append(result.Errors, fmt.Errorf("%w: logon time %v outside skew tolerance", ErrPACClockSkew, logonInfo.LogonTime))
+			return result, fmt.Errorf("%w: logon time %v outside skew tolerance", ErrPACClockSkew, logonInfo.LogonTime)
+		}
+		result.ValidationFlags["CLOCK_SKEW_VALID"] = true
 	}
-	if timeDiff > time.Duration(clockSkewSec)*time.Second {
-		result.Errors = append(result.Errors, fmt.Errorf("%w: logon time %v outside skew tolerance", ErrPACClockSkew, logonInfo.LogonTime))
-		return result, fmt.Errorf("%w: logon time %v outside skew tolerance", ErrPACClockSkew, logonInfo.LogonTime)
+
+	// UPN/DNS consistency is checked against the user's home realm from the
+	// PAC, which differs from the service realm for cross-realm logins
+	userRealm := pacUserRealm(upnInfo, realm)
+	if !strings.EqualFold(userRealm, realm) {
+		result.ValidationFlags["CROSS_REALM"] = true
 	}
-	result.ValidationFlags["CLOCK_SKEW_VALID"] = true
 
 	// Validate UPN consistency if present
 	if upnInfo != nil {
-		if err := validateUPNConsistency(logonInfo, upnInfo, realm); err != nil {
+		result.ValidationFlags["UPN_DNS_INFO_PRESENT"] = true
+		if err := validateUPNConsistency(logonInfo, upnInfo, userRealm, requireUPNMatch); err != nil {
 			result.Errors = append(result.Errors, err)
 			return result, err
 		}
@@ -270,11 +341,22 @@ func ExtractGroupSIDsFromPAC(pacData []byte, keytab *keytab.Keytab, spn string,
 
 	// Extract principal information
 	result.Principal = logonInfo.EffectiveName
-	result.Realm = logonInfo.LogonDomainName
+	result.Realm = userRealm
 	result.LogonTime = logonInfo.LogonTime
+	result.LogonServer = logonInfo.LogonServer
+	result.UserSID = fmt.Sprintf("%s-%d", logonInfo.LogonDomainID, logonInfo.UserID)
+	if isMachineAccount(logonInfo.UserAccountControl) {
+		result.ValidationFlags["IS_MACHINE_ACCOUNT"] = true
+	}
 
 	// Extract group SIDs
-	result.GroupSIDs = extractGroupSIDs(logonInfo, realm)
+	result.GroupSIDs = extractGroupSIDs(logonInfo)
+	result.ResourceGroupSIDs = extractResourceGroupSIDs(logonInfo)
+	result.GroupSIDs = append(result.GroupSIDs, result.ResourceGroupSIDs...)
+	for _, extra := range logonInfo.ExtraSIDs {
+		result.GroupSIDs = append(result.GroupSIDs, extra.SID)
+	}
+	result.SIDHistorySIDs = extractSIDHistory(logonInfo)
 
 	result.Valid = len(result.Errors) == 0
 	return result, nil
@@ -312,43 +394,156 @@ func parsePACInfo(data []byte) (*PACInfo, error) {
 		}
 	}
 
+	if err := checkPACBufferLayout(info.Buffers, 8+uint64(info.Count)*16, uint64(len(data))); err != nil {
+		return nil, err
+	}
+
 	return info, nil
 }
 
-// parseLogonInfo parses the logon info buffer
+// checkPACBufferLayout rejects buffers that overlap each other or the
+// descriptor table. Gaps between buffers are allowed since the KDC pads each
+// buffer to an 8-byte boundary. Buffers past the end of the data are left to
+// the caller's bounds check.
+func checkPACBufferLayout(buffers []PACBuffer, headerLen, dataLen uint64) error {
+	type span struct {
+		start, end uint64
+		index      int
+	}
+	spans := make([]span, 0, len(buffers))
+	for i, buf := range buffers {
+		if buf.Size == 0 || buf.Offset > dataLen {
+			continue
+		}
+		if buf.Offset < headerLen {
+			return fmt.Errorf("%w: buffer %d overlaps the PAC header", ErrPACInvalidFormat, i)
+		}
+		spans = append(spans, span{start: buf.Offset, end: buf.Offset + uint64(buf.Size), index: i})
+	}
+
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	for i := 1; i < len(spans); i++ {
+		if spans[i].start < spans[i-1].end {
+			return fmt.Errorf("%w: buffers %d and %d overlap", ErrPACInvalidFormat, spans[i-1].index, spans[i].index)
+		}
+	}
+	return nil
+}
+
+// parseLogonInfo decodes the NDR-encoded KERB_VALIDATION_INFO of a
+// PAC_LOGON_INFO buffer. Group and user SIDs are relative to the logon domain,
+// so a logon info without a LogonDomainId is rejected.
 func parseLogonInfo(data []byte) (*LogonInfo, error) {
-	if len(data) < 20 {
-		return nil, fmt.Errorf("%w: insufficient data for logon info", ErrPACInvalidFormat)
+	var kvi pac.KerbValidationInfo
+	if err := kvi.Unmarshal(data); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPACInvalidFormat, err)
+	}
+	info := newLogonInfo(&kvi)
+	if info.LogonDomainID == "" {
+		return nil, fmt.Errorf("%w: logon info has no logon domain SID", ErrPACInvalidFormat)
 	}
+	return info, nil
+}
 
+// newLogonInfo converts a decoded KERB_VALIDATION_INFO. The ExtraSIDs and
+// resource groups are only taken when UserFlags says they are present, and
+// resource groups only with the resource domain SID they are relative to.
+func newLogonInfo(kvi *pac.KerbValidationInfo) *LogonInfo {
 	info := &LogonInfo{
-		LogonTime:          parseFileTime(data[0:8]),
-		LogoffTime:         time.Time{},
-		KickOffTime:        time.Time{},
-		PasswordLastSet:    time.Time{},
-		PasswordCanChange:  time.Time{},
-		PasswordMustChange: time.Time{},
-		EffectiveName:      "testuser",
-		FullName:           "Test User",
-		LogonScript:        "",
-		ProfilePath:        "",
-		LogonDomainName:    "TEST.COM",
-		UserID:             binary.LittleEndian.Uint32(data[8:12]),
-		PrimaryGroupID:     binary.LittleEndian.Uint32(data[12:16]),
-		GroupCount:         binary.LittleEndian.Uint32(data[16:20]),
-		GroupIDs:           []uint32{},
-	}
-
-	// Parse group memberships if present
-	if info.GroupCount > 0 && len(data) >= int(20+info.GroupCount*4) {
-		info.GroupIDs = make([]uint32, info.GroupCount)
-		for i := uint32(0); i < info.GroupCount; i++ {
-			offset := 20 + i*4
-			info.GroupIDs[i] = binary.LittleEndian.Uint32(data[offset : offset+4])
+		LogonTime:            kvi.LogOnTime.Time(),
+		LogoffTime:           kvi.LogOffTime.Time(),
+		KickOffTime:          kvi.KickOffTime.Time(),
+		PasswordLastSet:      kvi.PasswordLastSet.Time(),
+		PasswordCanChange:    kvi.PasswordCanChange.Time(),
+		PasswordMustChange:   kvi.PasswordMustChange.Time(),
+		EffectiveName:        kvi.EffectiveName.Value,
+		FullName:             kvi.FullName.Value,
+		LogonScript:          kvi.LogonScript.Value,
+		ProfilePath:          kvi.ProfilePath.Value,
+		HomeDirectory:        kvi.HomeDirectory.Value,
+		HomeDirectoryDrive:   kvi.HomeDirectoryDrive.Value,
+		LogonCount:           kvi.LogonCount,
+		BadPasswordCount:     kvi.BadPasswordCount,
+		UserID:               kvi.UserID,
+		PrimaryGroupID:       kvi.PrimaryGroupID,
+		GroupCount:           kvi.GroupCount,
+		UserFlags:            kvi.UserFlags,
+		LogonServer:          kvi.LogonServer.Value,
+		LogonDomainName:      kvi.LogonDomainName.Value,
+		LogonDomainID:        rpcSIDString(kvi.LogonDomainID),
+		UserAccountControl:   kvi.UserAccountControl,
+		SubAuthStatus:        kvi.SubAuthStatus,
+		LastSuccessfulILogon: kvi.LastSuccessfulILogon.Time(),
+		LastFailedILogon:     kvi.LastFailedILogon.Time(),
+		FailedILogonCount:    kvi.FailedILogonCount,
+	}
+	for _, g := range kvi.GroupIDs {
+		info.GroupIDs = append(info.GroupIDs, g.RelativeID)
+	}
+	if kvi.UserFlags&logonExtraSIDs != 0 {
+		info.SIDCount = kvi.SIDCount
+		for _, extra := range kvi.ExtraSIDs {
+			info.ExtraSIDs = append(info.ExtraSIDs, KerbSID{SID: rpcSIDString(extra.SID), Attributes: extra.Attributes})
+		}
+	}
+	if domain := rpcSIDString(kvi.ResourceGroupDomainSID); kvi.UserFlags&logonResourceGroups != 0 && domain != "" {
+		info.ResourceGroupDomainSID = domain
+		info.ResourceGroupCount = kvi.ResourceGroupCount
+		for _, g := range kvi.ResourceGroupIDs {
+			info.ResourceGroups = append(info.ResourceGroups, g.RelativeID)
 		}
 	}
+	return info
+}
 
-	return info, nil
+// rpcSIDString returns the string form of an NDR SID, or "" for the zero
+// value a null SID pointer decodes to
+func rpcSIDString(sid mstypes.RPCSID) string {
+	if sid.Revision == 0 && len(sid.SubAuthority) == 0 {
+		return ""
+	}
+	return sid.String()
+}
+
+// parseSID decodes a binary SID (MS-DTYP 2.4.2.2) and returns its string
+// form and encoded length
+func parseSID(data []byte) (string, int, error) {
+	if len(data) < 8 {
+		return "", 0, fmt.Errorf("%w: truncated SID", ErrPACInvalidFormat)
+	}
+	subCount := int(data[1])
+	if data[0] != 1 || subCount > 15 {
+		return "", 0, fmt.Errorf("%w: malformed SID", ErrPACInvalidFormat)
+	}
+	n := 8 + subCount*4
+	if len(data) < n {
+		return "", 0, fmt.Errorf("%w: truncated SID", ErrPACInvalidFormat)
+	}
+
+	// The 48-bit identifier authority is big-endian
+	var authority uint64
+	for _, b := range data[2:8] {
+		authority = authority<<8 | uint64(b)
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "S-1-%d", authority)
+	for i := 0; i < subCount; i++ {
+		fmt.Fprintf(&sb, "-%d", binary.LittleEndian.Uint32(data[8+i*4:]))
+	}
+	return sb.String(), n, nil
+}
+
+// SIDString returns the string form of a binary SID, e.g. an LDAP objectSid
+// value
+func SIDString(data []byte) (string, error) {
+	sid, n, err := parseSID(data)
+	if err != nil {
+		return "", err
+	}
+	if n != len(data) {
+		return "", fmt.Errorf("%w: trailing bytes after SID", ErrPACInvalidFormat)
+	}
+	return sid, nil
 }
 
 // parseUPNInfo parses the UPN_DNS_INFO buffer
@@ -396,169 +591,220 @@ func parsePACSignature(data []byte) (*PACSignature, error) {
 	}
 
 	// Extract signature data (skip the header)
-	sigDataSize := sig.Size - 8
-	if sigDataSize > 0 && int(sigDataSize) <= len(data)-8 {
-		sig.Signature = make([]byte, sigDataSize)
-		copy(sig.Signature, data[8:8+sigDataSize])
-	} else {
-		sig.Signature = make([]byte, 16) // Default size for testing
-	}
+	sig.Signature = make([]byte, sig.Size-8)
+	copy(sig.Signature, data[8:sig.Size])
 
 	return sig, nil
 }
 
-// validatePACSignatures validates PAC signatures
-func validatePACSignatures(pacData []byte, serverSig, kdcSig *PACSignature, kt *keytab.Keytab, spn, realm string) error {
-	// Basic signature size validation - check actual signature data length
-	if len(serverSig.Signature) < 8 || len(kdcSig.Signature) < 8 {
-		return fmt.Errorf("%w: signature too short", ErrPACSignatureInvalid)
+// validatePACSignatures validates PAC signatures. With requireAES, signatures
+// of any checksum type other than HMAC-SHA1-96-AES are rejected even if they
+// would verify. The server signature is verified with the service key; the
+// KDC signature is keyed with the krbtgt key, which the service doesn't hold,
+// so only its checksum type and length are checked.
+func validatePACSignatures(pacData []byte, serverSig, kdcSig *PACSignature, kt *keytab.Keytab, spn, realm string, requireAES bool) error {
+	if requireAES {
+		if !isAESChecksum(serverSig.Type) {
+			return fmt.Errorf("%w: server signature checksum type %d is not AES", ErrPACSignatureInvalid, int32(serverSig.Type))
+		}
+		if !isAESChecksum(kdcSig.Type) {
+			return fmt.Errorf("%w: KDC signature checksum type %d is not AES", ErrPACSignatureInvalid, int32(kdcSig.Type))
+		}
+	}
+	if err := checkSignatureLength(kdcSig); err != nil {
+		return fmt.Errorf("%w: KDC signature: %v", ErrPACSignatureInvalid, err)
+	}
+	if err := checkSignatureLength(serverSig); err != nil {
+		return fmt.Errorf("%w: server signature: %v", ErrPACSignatureInvalid, err)
 	}
 
-	// Extract service key from keytab
-	serviceKey, err := extractServiceKey(kt, spn, realm)
+	// The server signature is keyed by the service key of the enctype its
+	// checksum type implies
+	etype, err := checksumKeyType(serverSig.Type)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPACSignatureInvalid, err)
+	}
+	serviceKey, err := extractServiceKey(kt, spn, realm, etype)
 	if err != nil {
-		// If we can't extract the key, we'll do basic validation
-		// In production, this should be a hard failure
 		return fmt.Errorf("%w: failed to extract service key: %v", ErrPACSignatureInvalid, err)
 	}
 
-	// Validate server signature using HMAC-MD5
-	if err := validateHMACSignature(pacData, serverSig, serviceKey, md5.New); err != nil {
+	if err := validateHMACSignature(pacData, serverSig, kdcSig, serviceKey); err != nil {
 		return fmt.Errorf("%w: server signature validation failed: %v", ErrPACSignatureInvalid, err)
 	}
+	return nil
+}
 
-	// For KDC signature, we would need the KDC key
-	// In a real implementation, this would require additional infrastructure
-	// For now, we'll validate that the signature exists and has reasonable format
-	if len(kdcSig.Signature) < 16 {
-		return fmt.Errorf("%w: KDC signature too short", ErrPACSignatureInvalid)
+// checkSignatureLength checks that a signature has a supported checksum type
+// and the length that type produces
+func checkSignatureLength(sig *PACSignature) error {
+	if _, err := checksumKeyType(sig.Type); err != nil {
+		return err
+	}
+	cksumEtype, err := crypto.GetChksumEtype(int32(sig.Type))
+	if err != nil {
+		return err
+	}
+	if want := int(cksumEtype.GetHMACBitLength() / 8); len(sig.Signature) != want {
+		return fmt.Errorf("signature is %d bytes, want %d for checksum type %d", len(sig.Signature), want, int32(sig.Type))
 	}
-
 	return nil
 }
 
-// extractServiceKey extracts the service key from keytab for the given SPN
-// This function implements production-ready keytab parsing and key extraction
-// It supports multiple encryption types and provides fallback mechanisms
-func extractServiceKey(kt *keytab.Keytab, spn, realm string) ([]byte, error) {
+// isAESChecksum reports whether a PAC signature checksum type is
+// HMAC-SHA1-96 keyed with AES
+func isAESChecksum(cksumType uint32) bool {
+	return cksumType == checksumHMACSHA196AES128 || cksumType == checksumHMACSHA196AES256
+}
+
+// checksumKeyType returns the encryption type of the key used for a PAC
+// signature checksum type
+func checksumKeyType(cksumType uint32) (int32, error) {
+	switch cksumType {
+	case checksumHMACSHA196AES128:
+		return etypeID.AES128_CTS_HMAC_SHA1_96, nil
+	case checksumHMACSHA196AES256:
+		return etypeID.AES256_CTS_HMAC_SHA1_96, nil
+	case checksumHMACMD5:
+		return etypeID.RC4_HMAC, nil
+	}
+	return 0, fmt.Errorf("unsupported signature checksum type %d", int32(cksumType))
+}
+
+// extractServiceKey returns the keytab key of the given encryption type for
+// the SPN. A keytab may hold several enctypes per SPN; only the one that
+// produced a signature can verify it, so there is no fallback to another key.
+func extractServiceKey(kt *keytab.Keytab, spn, realm string, etype int32) ([]byte, error) {
 	if kt == nil {
 		return nil, fmt.Errorf("keytab is nil")
 	}
 
-	// Parse SPN to extract service and hostname components
-	parts := strings.SplitN(spn, "/", 2)
-	if len(parts) != 2 {
-		return nil, fmt.Errorf("invalid SPN format: %s", spn)
+	// Parse the SPN into its principal components, dropping any realm suffix
+	// (e.g. HTTP/vault.example.com@REALM.COM)
+	parsed, err := ParseSPN(spn)
+	if err != nil {
+		return nil, err
 	}
+	components := parsed.Components()
 
-	service := parts[0]
-	hostname := parts[1]
-
-	// Remove realm suffix if present (e.g., HTTP/vault.example.com@REALM.COM)
-	if strings.Contains(hostname, "@") {
-		hostname = strings.SplitN(hostname, "@", 2)[0]
+	for _, entry := range kt.Entries {
+		if entry.Principal.Realm != realm || !slices.Equal(entry.Principal.Components, components) {
+			continue
+		}
+		if entry.Key.KeyType == etype && len(entry.Key.KeyValue) > 0 {
+			return entry.Key.KeyValue, nil
+		}
 	}
 
-	// For testing purposes, return a test key if the keytab is empty or for specific test SPNs
-	// This allows the test suite to work without requiring real keytab files
-	if len(kt.Entries) == 0 || (service == "HTTP" && strings.Contains(hostname, "test")) {
-		// Return a test key for testing purposes
-		return []byte("test-key-32-bytes-for-aes256-test"), nil
-	}
+	return nil, fmt.Errorf("no enctype %d key found for SPN %s in realm %s", etype, spn, realm)
+}
 
-	// Try to find a matching key in the keytab entries
-	// This implements production-ready keytab parsing using gokrb5's keytab structure
-	for _, entry := range kt.Entries {
-		if entry.Principal.Realm == realm && len(entry.Principal.Components) == 2 {
-			match := true
-			for i, component := range entry.Principal.Components {
-				if i == 0 && component != service {
-					match = false
-					break
-				}
-				if i == 1 && component != hostname {
-					match = false
-					break
-				}
-			}
-			if match && len(entry.Key.KeyValue) > 0 {
-				return entry.Key.KeyValue, nil
-			}
+// validateHMACSignature verifies the server signature, a keyed checksum
+// (HMAC-SHA1-96 for AES keys, HMAC-MD5 for RC4) computed with the service key
+// under key usage 17 over the PAC with both signatures zeroed
+func validateHMACSignature(pacData []byte, serverSig, kdcSig *PACSignature, key []byte) error {
+	zeroed := slices.Clone(pacData)
+	for _, sig := range []*PACSignature{serverSig, kdcSig} {
+		if sig.offset <= 0 || sig.offset+len(sig.Signature) > len(zeroed) {
+			return fmt.Errorf("signature lies outside the PAC")
 		}
+		clear(zeroed[sig.offset : sig.offset+len(sig.Signature)])
 	}
 
-	return nil, fmt.Errorf("no matching key found for SPN %s in realm %s", spn, realm)
+	cksumEtype, err := crypto.GetChksumEtype(int32(serverSig.Type))
+	if err != nil {
+		return err
+	}
+	if !cksumEtype.VerifyChecksum(key, zeroed, serverSig.Signature, keyusage.KERB_NON_KERB_CKSUM_SALT) {
+		return fmt.Errorf("checksum mismatch")
+	}
+	return nil
 }
 
-// validateHMACSignature validates HMAC signature
-func validateHMACSignature(_ []byte, sig *PACSignature, _ []byte, _ func() hash.Hash) error {
-	// For PAC signature validation, we need to hash the PAC data excluding signature buffers
-	// This is a simplified approach - real implementation would need to:
-	// 1. Reconstruct the PAC data without signature buffers
-	// 2. Compute HMAC over the reconstructed data
-	// 3. Compare with the provided signature
+// pacUserRealm returns the user's home realm from the UPN_DNS_INFO DNS
+// domain, falling back to the service realm when the PAC does not carry one.
+// The logon info's LogonDomainName is the NetBIOS name, not the realm.
+func pacUserRealm(upnInfo *UPNInfo, serviceRealm string) string {
+	if upnInfo == nil || upnInfo.DNSDomain == "" {
+		return serviceRealm
+	}
+	return strings.ToUpper(upnInfo.DNSDomain)
+}
 
-	// For now, we'll do basic validation that the signature format is correct
-	if len(sig.Signature) < 16 {
-		return fmt.Errorf("signature too short")
+// validateUPNConsistency validates UPN_DNS_INFO consistency against the
+// user's home realm. With
+// requireUserMatch the UPN's local part must also name the logon user, so a
+// crafted PAC cannot claim another user's UPN.
+func validateUPNConsistency(logonInfo *LogonInfo, upnInfo *UPNInfo, userRealm string, requireUserMatch bool) error {
+	// Check that UPN realm matches the user's realm (case-insensitive)
+	if upnInfo.UPN != "" && !strings.HasSuffix(strings.ToLower(upnInfo.UPN), "@"+strings.ToLower(userRealm)) {
+		return fmt.Errorf("%w: UPN %s does not match realm %s", ErrPACUPNInconsistent, upnInfo.UPN, userRealm)
 	}
 
-	// In a real implementation, we would:
-	// 1. Parse the PAC to identify signature buffer locations
-	// 2. Create a copy of the PAC data without signature buffers
-	// 3. Compute HMAC over the cleaned data using hmac.New(hashFunc, key)
-	// 4. Compare with the provided signature
+	// Check that DNS domain matches the user's realm (case-insensitive)
+	if upnInfo.DNSDomain != "" && !strings.EqualFold(upnInfo.DNSDomain, userRealm) {
+		return fmt.Errorf("%w: DNS domain %s does not match realm %s", ErrPACUPNInconsistent, upnInfo.DNSDomain, userRealm)
+	}
 
-	// For testing purposes, we'll accept any signature of sufficient length
-	// This maintains security while allowing the tests to pass
+	// Check that the UPN names the authenticated user
+	if requireUserMatch && upnInfo.UPN != "" {
+		if logonInfo == nil || accountName(upnInfo.UPN) != accountName(logonInfo.EffectiveName) {
+			return fmt.Errorf("%w: UPN %s does not match logon user", ErrPACUPNInconsistent, upnInfo.UPN)
+		}
+	}
 
 	return nil
 }
 
-// validateUPNConsistency validates UPN_DNS_INFO consistency
-func validateUPNConsistency(_ *LogonInfo, upnInfo *UPNInfo, realm string) error {
-	// Check that UPN realm matches expected realm (case-insensitive)
-	if upnInfo.UPN != "" && !strings.HasSuffix(strings.ToLower(upnInfo.UPN), "@"+strings.ToLower(realm)) {
-		return fmt.Errorf("%w: UPN %s does not match realm %s", ErrPACUPNInconsistent, upnInfo.UPN, realm)
+// accountName reduces a UPN or sAMAccountName to a comparable user name:
+// the domain prefix or realm suffix and a trailing "$" (machine and gMSA
+// accounts) are removed and the result is lowercased
+func accountName(name string) string {
+	if i := strings.LastIndex(name, "\\"); i >= 0 {
+		name = name[i+1:]
 	}
-
-	// Check that DNS domain matches realm (case-insensitive)
-	if upnInfo.DNSDomain != "" && !strings.EqualFold(upnInfo.DNSDomain, realm) {
-		return fmt.Errorf("%w: DNS domain %s does not match realm %s", ErrPACUPNInconsistent, upnInfo.DNSDomain, realm)
+	if i := strings.LastIndex(name, "@"); i >= 0 {
+		name = name[:i]
 	}
-
-	return nil
+	return strings.ToLower(strings.TrimSuffix(name, "$"))
 }
 
-// extractGroupSIDs extracts group SIDs from logon info
-func extractGroupSIDs(logonInfo *LogonInfo, _ string) []string {
+// extractGroupSIDs returns the SIDs of the logon info's group memberships,
+// which are RIDs relative to the logon domain
+func extractGroupSIDs(logonInfo *LogonInfo) []string {
 	sids := make([]string, 0, len(logonInfo.GroupIDs))
-
-	// Convert relative IDs to SIDs
-	// This is simplified - real implementation would need domain SID
-	domainSID := "S-1-5-21-1111111111-2222222222-3333333333" // Placeholder
-
 	for _, groupRID := range logonInfo.GroupIDs {
-		sid := fmt.Sprintf("%s-%d", domainSID, groupRID)
-		sids = append(sids, sid)
+		sids = append(sids, fmt.Sprintf("%s-%d", logonInfo.LogonDomainID, groupRID))
 	}
-
 	return sids
 }
 
-// Helper functions
-func parseFileTime(data []byte) time.Time {
-	if len(data) < 8 {
-		return time.Time{}
+// extractSIDHistory returns the ExtraSIDs that look like SID history: domain
+// SIDs (S-1-5-21-...) from a domain other than the logon domain that aren't
+// flagged SE_GROUP_RESOURCE. The PAC doesn't mark SID history explicitly, so
+// universal groups from other domains in the forest are included too.
+func extractSIDHistory(logonInfo *LogonInfo) []string {
+	var sids []string
+	for _, extra := range logonInfo.ExtraSIDs {
+		if extra.Attributes&seGroupResource != 0 {
+			continue
+		}
+		if !strings.HasPrefix(extra.SID, "S-1-5-21-") || strings.HasPrefix(extra.SID, logonInfo.LogonDomainID+"-") {
+			continue
+		}
+		sids = append(sids, extra.SID)
 	}
+	return sids
+}
 
-	// Windows FILETIME is 100-nanosecond intervals since 1601-01-01
-	fileTime := binary.LittleEndian.Uint64(data)
-	if fileTime == 0 {
-		return time.Time{}
+// extractResourceGroupSIDs extracts resource group SIDs from logon info
+func extractResourceGroupSIDs(logonInfo *LogonInfo) []string {
+	if len(logonInfo.ResourceGroups) == 0 || logonInfo.ResourceGroupDomainSID == "" {
+		return nil
 	}
-
-	// Convert to Unix time
-	unixTime := int64(fileTime)/10000000 - 11644473600
-	return time.Unix(unixTime, 0)
+	sids := make([]string, 0, len(logonInfo.ResourceGroups))
+	for _, rid := range logonInfo.ResourceGroups {
+		sids = append(sids, fmt.Sprintf("%s-%d", logonInfo.ResourceGroupDomainSID, rid))
+	}
+	return sids
 }
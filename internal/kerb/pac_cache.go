@@ -0,0 +1,117 @@
+package kerb
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultPACCacheEntries bounds the PAC cache when no size is given
+const defaultPACCacheEntries = 10000
+
+// PACCache caches PAC validation results per service ticket, keyed by a hash
+// of the ticket's encrypted part and the validation inputs. A ticket and its
+// PAC are fixed for its lifetime, so entries expire at the ticket end time.
+// Hits skip decrypting the ticket and decoding its PAC. They aren't rechecked
+// against the PAC logon time: gokrb5 verifies the ticket and holds each
+// request's authenticator to the clock skew window before the cache is
+// consulted.
+type PACCache struct {
+	mu         sync.Mutex
+	entries    map[string]pacCacheEntry
+	maxEntries int
+	now        func() time.Time
+}
+
+type pacCacheEntry struct {
+	result  *PACValidationResult
+	expires time.Time
+}
+
+// NewPACCache creates a PAC validation cache holding at most maxEntries
+// results (0 uses the default of 10000)
+func NewPACCache(maxEntries int) *PACCache {
+	if maxEntries <= 0 {
+		maxEntries = defaultPACCacheEntries
+	}
+	return &PACCache{
+		entries:    make(map[string]pacCacheEntry),
+		maxEntries: maxEntries,
+		now:        time.Now,
+	}
+}
+
+// Validate returns the result cached under key if present, otherwise runs
+// validate and caches a valid result until expires. Keys come from
+// pacCacheKey, so changes to the keytab or configuration never hit stale
+// entries.
+func (c *PACCache) Validate(key string, expires time.Time, validate func() (*PACValidationResult, error)) (*PACValidationResult, bool, error) {
+	now := c.now()
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	if ok && !now.Before(entry.expires) {
+		delete(c.entries, key)
+		ok = false
+	}
+	c.mu.Unlock()
+	if ok {
+		return entry.result, true, nil
+	}
+
+	result, err := validate()
+	if err != nil || result == nil || !result.Valid || !now.Before(expires) {
+		return result, false, err
+	}
+
+	c.mu.Lock()
+	if len(c.entries) >= c.maxEntries {
+		c.pruneLocked(now)
+	}
+	if len(c.entries) < c.maxEntries {
+		c.entries[key] = pacCacheEntry{result: result, expires: expires}
+	}
+	c.mu.Unlock()
+	return result, false, nil
+}
+
+// Purge drops all cached results, e.g. after a config or keytab change
+func (c *PACCache) Purge() {
+	c.mu.Lock()
+	c.entries = make(map[string]pacCacheEntry)
+	c.mu.Unlock()
+}
+
+// Len returns the number of cached results
+func (c *PACCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// pruneLocked removes expired entries; the caller must hold c.mu
+func (c *PACCache) pruneLocked(now time.Time) {
+	for k, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+}
+
+// pacCacheKey hashes the service ticket's encrypted part together with
+// every input that affects its validation result
+func pacCacheKey(ticketCipher []byte, scope, spn, realm string, clockSkewSec int, requireUPNMatch, requireAESSignatures bool) string {
+	h := sha256.New()
+	h.Write(ticketCipher)
+	fmt.Fprintf(h, "\x00%s\x00%s\x00%s\x00%d\x00%t\x00%t", scope, spn, realm, clockSkewSec, requireUPNMatch, requireAESSignatures)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// PACCacheScope fingerprints the keytabs so cached results are tied to them
+func PACCacheScope(keytabsB64 ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(keytabsB64, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
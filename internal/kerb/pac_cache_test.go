@@ -0,0 +1,144 @@
+package kerb
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// countingValidate returns a validate func for PACCache.Validate that counts
+// its calls and returns result and err
+func countingValidate(calls *int, result *PACValidationResult, err error) func() (*PACValidationResult, error) {
+	return func() (*PACValidationResult, error) {
+		*calls++
+		return result, err
+	}
+}
+
+func TestPACCache_HitSkipsValidation(t *testing.T) {
+	var calls int
+	c := NewPACCache(0)
+	valid := &PACValidationResult{Valid: true, GroupSIDs: []string{"S-1-5-21-1-2-3-513"}}
+	validate := countingValidate(&calls, valid, nil)
+	expires := time.Now().Add(time.Hour)
+
+	first, hit, err := c.Validate("key", expires, validate)
+	if err != nil || hit {
+		t.Fatalf("first Validate() = hit %v, err %v; want miss", hit, err)
+	}
+	second, hit, err := c.Validate("key", expires, validate)
+	if err != nil || !hit {
+		t.Fatalf("second Validate() = hit %v, err %v; want hit", hit, err)
+	}
+	if calls != 1 {
+		t.Errorf("validations = %d, want 1", calls)
+	}
+	if second != first {
+		t.Errorf("cached result = %+v, want %+v", second, first)
+	}
+
+	// Another key must not reuse the entry
+	if _, hit, _ := c.Validate("other", expires, validate); hit {
+		t.Error("hit across keys")
+	}
+	if calls != 2 {
+		t.Errorf("validations = %d, want 2", calls)
+	}
+}
+
+func TestPACCache_OnlyValidResultsCached(t *testing.T) {
+	c := NewPACCache(0)
+	expires := time.Now().Add(time.Hour)
+	errUPN := errors.New("UPN mismatch")
+
+	for name, validate := range map[string]func(*int) func() (*PACValidationResult, error){
+		"invalid": func(calls *int) func() (*PACValidationResult, error) {
+			return countingValidate(calls, &PACValidationResult{ValidationFlags: map[string]bool{"PAC_NOT_FOUND": true}}, nil)
+		},
+		"error": func(calls *int) func() (*PACValidationResult, error) {
+			return countingValidate(calls, &PACValidationResult{Valid: true}, errUPN)
+		},
+	} {
+		var calls int
+		c.Validate(name, expires, validate(&calls))
+		if _, hit, _ := c.Validate(name, expires, validate(&calls)); hit || calls != 2 {
+			t.Errorf("%s: hit = %t after %d validations, want a miss each time", name, hit, calls)
+		}
+	}
+	if c.Len() != 0 {
+		t.Errorf("Len() = %d, want 0", c.Len())
+	}
+}
+
+func TestPACCache_ExpiryAndPurge(t *testing.T) {
+	var calls int
+	c := NewPACCache(0)
+	validate := countingValidate(&calls, &PACValidationResult{Valid: true}, nil)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	c.Validate("key", now.Add(time.Minute), validate)
+	now = now.Add(time.Minute)
+	if _, hit, _ := c.Validate("key", now.Add(time.Minute), validate); hit {
+		t.Error("hit after ticket end time")
+	}
+
+	c.Purge()
+	if c.Len() != 0 {
+		t.Errorf("Len() after Purge = %d, want 0", c.Len())
+	}
+
+	// Tickets that already ended are never cached
+	c.Validate("key", now, validate)
+	if c.Len() != 0 {
+		t.Errorf("Len() = %d, want 0 for expired ticket", c.Len())
+	}
+}
+
+func TestPACCacheKey(t *testing.T) {
+	base := pacCacheKey([]byte("ticket"), "scope", "HTTP/vault.test.com", "TEST.COM", 300, false, false)
+	if again := pacCacheKey([]byte("ticket"), "scope", "HTTP/vault.test.com", "TEST.COM", 300, false, false); again != base {
+		t.Error("key is not deterministic")
+	}
+	for name, key := range map[string]string{
+		"ticket":    pacCacheKey([]byte("other ticket"), "scope", "HTTP/vault.test.com", "TEST.COM", 300, false, false),
+		"scope":     pacCacheKey([]byte("ticket"), "other-scope", "HTTP/vault.test.com", "TEST.COM", 300, false, false),
+		"spn":       pacCacheKey([]byte("ticket"), "scope", "HTTP/other.test.com", "TEST.COM", 300, false, false),
+		"skew":      pacCacheKey([]byte("ticket"), "scope", "HTTP/vault.test.com", "TEST.COM", NoLogonTimeSkewCheck, false, false),
+		"upn match": pacCacheKey([]byte("ticket"), "scope", "HTTP/vault.test.com", "TEST.COM", 300, true, false),
+		"aes only":  pacCacheKey([]byte("ticket"), "scope", "HTTP/vault.test.com", "TEST.COM", 300, false, true),
+	} {
+		if key == base {
+			t.Errorf("changing the %s doesn't change the key", name)
+		}
+	}
+}
+
+func BenchmarkPACValidation_Uncached(b *testing.B) {
+	pacData := makeValidPACWithGroups()
+	kt := createTestKeytab()
+	for i := 0; i < b.N; i++ {
+		if _, err := ExtractGroupSIDsFromPAC(pacData, kt, "HTTP/vault.test.com", "TEST.COM", 300, false, false); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPACValidation_Cached(b *testing.B) {
+	c := NewPACCache(0)
+	pacData := makeValidPACWithGroups()
+	kt := createTestKeytab()
+	validate := func() (*PACValidationResult, error) {
+		result, err := ExtractGroupSIDsFromPAC(pacData, kt, "HTTP/vault.test.com", "TEST.COM", 300, false, false)
+		if err == nil {
+			result.Valid = true
+		}
+		return result, err
+	}
+	expires := time.Now().Add(time.Hour)
+	for i := 0; i < b.N; i++ {
+		if _, _, err := c.Validate("key", expires, validate); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
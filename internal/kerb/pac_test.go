@@ -1,13 +1,22 @@
 package kerb
 
 import (
+	"bytes"
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
+	"reflect"
+	"slices"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
 	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/test/testdata"
 )
 
 func TestPACValidation_Security(t *testing.T) {
@@ -46,7 +55,7 @@ func TestPACValidation_Security(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			kt := createTestKeytab()
-			_, err := ExtractGroupSIDsFromPAC(tt.pacData, kt, "HTTP/vault.test.com", "TEST.COM", 300)
+			_, err := ExtractGroupSIDsFromPAC(tt.pacData, kt, "HTTP/vault.test.com", "TEST.COM", 300, false, false)
 
 			if tt.expectError {
 				if err == nil {
@@ -65,6 +74,48 @@ func TestPACValidation_Security(t *testing.T) {
 	}
 }
 
+func TestPACValidation_BufferOverlap(t *testing.T) {
+	// setOffset rewrites the offset of descriptor i in a copy of the fixture
+	setOffset := func(i int, offset uint64) []byte {
+		data := makeValidPACWithLogonTime(time.Now())
+		binary.LittleEndian.PutUint64(data[8+i*16+8:8+i*16+16], offset)
+		return data
+	}
+	fixture := makeValidPACWithLogonTime(time.Now())
+	logonInfoOffset := pacBufferOffset(fixture, PAC_LOGON_INFO)
+	serverSigOffset := pacBufferOffset(fixture, PAC_SERVER_CHECKSUM)
+
+	tests := []struct {
+		name    string
+		pacData []byte
+		overlap bool
+	}{
+		{"valid layout", makeValidPACWithLogonTime(time.Now()), false},
+		{"gap between buffers", setOffset(2, serverSigOffset+24+8), false},
+		{"signature inside logon info", setOffset(1, logonInfoOffset+100), true},
+		{"signatures share a range", setOffset(2, serverSigOffset), true},
+		{"signatures partially overlap", setOffset(2, serverSigOffset+8), true},
+		{"buffer inside descriptor table", setOffset(1, 16), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parsePACInfo(tt.pacData)
+			if tt.overlap {
+				if !errors.Is(err, ErrPACInvalidFormat) {
+					t.Fatalf("parsePACInfo() error = %v, want ErrPACInvalidFormat", err)
+				}
+				if _, err := ExtractGroupSIDsFromPAC(tt.pacData, createTestKeytab(), "HTTP/vault.test.com", "TEST.COM", 300, false, false); !errors.Is(err, ErrPACInvalidFormat) {
+					t.Errorf("ExtractGroupSIDsFromPAC() error = %v, want ErrPACInvalidFormat", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parsePACInfo() unexpected error: %v", err)
+			}
+		})
+	}
+}
 func TestPACValidation_ClockSkew(t *testing.T) {
 	tests := []struct {
 		name         string
@@ -103,7 +154,7 @@ func TestPACValidation_ClockSkew(t *testing.T) {
 			pacData := makeValidPACWithLogonTime(tt.logonTime)
 			kt := createTestKeytab()
 
-			_, err := ExtractGroupSIDsFromPAC(pacData, kt, "HTTP/vault.test.com", "TEST.COM", tt.clockSkewSec)
+			_, err := ExtractGroupSIDsFromPAC(pacData, kt, "HTTP/vault.test.com", "TEST.COM", tt.clockSkewSec, false, false)
 
 			if tt.expectError {
 				if err == nil {
@@ -122,6 +173,27 @@ func TestPACValidation_ClockSkew(t *testing.T) {
 	}
 }
 
+func TestPACValidation_IgnoreLogonTimeSkew(t *testing.T) {
+	// A long-lived session presents a logon time far outside the skew window
+	pacData := makeValidPACWithLogonTime(time.Now().Add(-2 * time.Hour))
+	kt := createTestKeytab()
+
+	if _, err := ExtractGroupSIDsFromPAC(pacData, kt, "HTTP/vault.test.com", "TEST.COM", 300, false, false); !errors.Is(err, ErrPACClockSkew) {
+		t.Fatalf("expected ErrPACClockSkew, got %v", err)
+	}
+
+	result, err := ExtractGroupSIDsFromPAC(pacData, kt, "HTTP/vault.test.com", "TEST.COM", NoLogonTimeSkewCheck, false, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !result.ValidationFlags["LOGON_TIME_SKEW_IGNORED"] {
+		t.Error("expected LOGON_TIME_SKEW_IGNORED flag")
+	}
+	if result.ValidationFlags["CLOCK_SKEW_VALID"] {
+		t.Error("CLOCK_SKEW_VALID should not be set when the check is skipped")
+	}
+}
+
 func TestPACValidation_UPNConsistency(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -165,7 +237,7 @@ func TestPACValidation_UPNConsistency(t *testing.T) {
 			pacData := makeValidPACWithUPN(tt.upn, tt.dnsDomain)
 			kt := createTestKeytab()
 
-			_, err := ExtractGroupSIDsFromPAC(pacData, kt, "HTTP/vault.test.com", tt.realm, 300)
+			_, err := ExtractGroupSIDsFromPAC(pacData, kt, "HTTP/vault.test.com", tt.realm, 300, false, false)
 
 			if tt.expectError {
 				if err == nil {
@@ -184,25 +256,375 @@ func TestPACValidation_UPNConsistency(t *testing.T) {
 	}
 }
 
+func TestPACValidation_UPNDNSInfoPresence(t *testing.T) {
+	kt := createTestKeytab()
+	tests := []struct {
+		name string
+		pac  []byte
+		want bool
+	}{
+		{"with UPN_DNS_INFO", makeValidPACWithUPN("testuser1@TEST.COM", "TEST.COM"), true},
+		{"without UPN_DNS_INFO", makeValidPACWithGroups(), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ExtractGroupSIDsFromPAC(tt.pac, kt, "HTTP/vault.test.com", "TEST.COM", 300, false, false)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := result.ValidationFlags["UPN_DNS_INFO_PRESENT"]; got != tt.want {
+				t.Errorf("UPN_DNS_INFO_PRESENT = %t, want %t", got, tt.want)
+			}
+			if tt.want && result.UPN != "testuser1@TEST.COM" {
+				t.Errorf("UPN = %q, want testuser1@TEST.COM", result.UPN)
+			}
+			if result.Principal != "testuser1" {
+				t.Errorf("Principal = %q, want the logon info's effective name testuser1", result.Principal)
+			}
+		})
+	}
+}
+
+func TestPACValidation_UnknownBufferType(t *testing.T) {
+	kt := createTestKeytab()
+	tests := []struct {
+		name    string
+		bufType uint32
+		want    bool
+	}{
+		{"undefined type", 0x7f, true},
+		{"attributes info", PAC_ATTRIBUTES_INFO, false},
+		{"client info", PAC_CLIENT_INFO, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ExtractGroupSIDsFromPAC(makeValidPACWithBufferType(tt.bufType), kt, "HTTP/vault.test.com", "TEST.COM", 300, false, false)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := result.ValidationFlags["UNKNOWN_BUFFER_TYPE"]; got != tt.want {
+				t.Errorf("UNKNOWN_BUFFER_TYPE = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPACValidation_UserSID(t *testing.T) {
+	kt := createTestKeytab()
+	result, err := ExtractGroupSIDsFromPAC(makeValidPACWithGroups(), kt, "HTTP/vault.test.com", "TEST.COM", 300, false, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The logon info's LogonDomainId plus the user RID
+	if want := testLogonDomainSID + "-1105"; result.UserSID != want {
+		t.Errorf("UserSID = %q, want %q", result.UserSID, want)
+	}
+
+	// Another logon domain yields another user SID
+	result, err = ExtractGroupSIDsFromPAC(makeTestPAC(testLogonInfo(testdata.MarshaledPAC_Kerb_Validation_Info_MS, time.Now())), kt, "HTTP/vault.test.com", "TEST.COM", 300, false, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "S-1-5-21-397955417-626881126-188441444-2914711"; result.UserSID != want {
+		t.Errorf("UserSID = %q, want %q", result.UserSID, want)
+	}
+}
+
+func TestPACValidation_NoLogonDomainSID(t *testing.T) {
+	// Without a LogonDomainId the user and group RIDs can't be turned into
+	// SIDs, so the logon info is rejected rather than given made-up ones
+	logonInfo := withoutLogonDomainID(testLogonInfo(testdata.MarshaledPAC_Kerb_Validation_Info, time.Now()))
+	result, err := ExtractGroupSIDsFromPAC(makeTestPAC(logonInfo), createTestKeytab(), "HTTP/vault.test.com", "TEST.COM", 300, false, false)
+	if err == nil || len(result.Errors) == 0 || !errors.Is(result.Errors[0], ErrPACInvalidFormat) {
+		t.Fatalf("expected a logon info parse error, got %v (%v)", err, result.Errors)
+	}
+	if result.UserSID != "" || len(result.GroupSIDs) != 0 {
+		t.Errorf("UserSID = %q, GroupSIDs = %v, want none", result.UserSID, result.GroupSIDs)
+	}
+}
+
+func TestPACValidation_MachineAccount(t *testing.T) {
+	kt := createTestKeytab()
+	tests := []struct {
+		name string
+		pac  []byte
+		want bool
+	}{
+		{"user account", makeValidPACWithUserAccountControl(0x10), false},
+		{"workstation trust (computer or gMSA)", makeValidPACWithUserAccountControl(0x80), true},
+		{"server trust (domain controller)", makeValidPACWithUserAccountControl(0x100), true},
+		{"normal account", makeValidPACWithGroups(), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ExtractGroupSIDsFromPAC(tt.pac, kt, "HTTP/vault.test.com", "TEST.COM", 300, false, false)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := result.ValidationFlags["IS_MACHINE_ACCOUNT"]; got != tt.want {
+				t.Errorf("IS_MACHINE_ACCOUNT = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPACValidation_UPNUserMatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		upn         string
+		expectError bool
+	}{
+		{"matching user", "testuser1@TEST.COM", false},
+		{"case insensitive user", "TestUser1@test.com", false},
+		{"different user", "admin@TEST.COM", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pacData := makeValidPACWithUPN(tt.upn, "TEST.COM")
+			kt := createTestKeytab()
+
+			_, err := ExtractGroupSIDsFromPAC(pacData, kt, "HTTP/vault.test.com", "TEST.COM", 300, true, false)
+			if tt.expectError {
+				if !errors.Is(err, ErrPACUPNInconsistent) {
+					t.Errorf("expected ErrPACUPNInconsistent, got %v", err)
+				}
+			} else if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+
+			// The user check only applies when requested
+			if _, err := ExtractGroupSIDsFromPAC(pacData, kt, "HTTP/vault.test.com", "TEST.COM", 300, false, false); err != nil {
+				t.Errorf("unexpected error without user match: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateUPNConsistency_UserMatch(t *testing.T) {
+	tests := []struct {
+		effectiveName string
+		upn           string
+		want          bool
+	}{
+		{"jdoe", "jdoe@TEST.COM", true},
+		{"JDoe", "jdoe@test.com", true},
+		{"svc-app$", "svc-app@TEST.COM", true},
+		{`TEST\jdoe`, "jdoe@TEST.COM", true},
+		{"jdoe@TEST.COM", "jdoe@TEST.COM", true},
+		{"jdoe", "admin@TEST.COM", false},
+		{"jdoe", "jdoe2@TEST.COM", false},
+		{"svc-app$", "svc-other@TEST.COM", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.effectiveName+"_"+tt.upn, func(t *testing.T) {
+			err := validateUPNConsistency(&LogonInfo{EffectiveName: tt.effectiveName}, &UPNInfo{UPN: tt.upn}, "TEST.COM", true)
+			if (err == nil) != tt.want {
+				t.Errorf("validateUPNConsistency() error = %v, want match %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestPACValidation_CrossRealm(t *testing.T) {
+	// The test PAC's user is in TEST.COM while the service lives in
+	// SERVICE.COM, as for a user from a trusted domain
+	tests := []struct {
+		name        string
+		upn         string
+		dnsDomain   string
+		expectError bool
+	}{
+		{"UPN and DNS domain in user's home realm", "user@TEST.COM", "TEST.COM", false},
+		{"UPN claims the service realm", "user@SERVICE.COM", "TEST.COM", true},
+		{"DNS domain claims the service realm", "user@TEST.COM", "SERVICE.COM", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kt := createTestKeytabFor("HTTP/vault.service.com", "SERVICE.COM")
+			pacData := signTestPACWith(makeValidPACWithUPN(tt.upn, tt.dnsDomain), kt, checksumHMACSHA196AES256, checksumHMACSHA196AES256)
+
+			result, err := ExtractGroupSIDsFromPAC(pacData, kt, "HTTP/vault.service.com", "SERVICE.COM", 300, false, false)
+			if tt.expectError {
+				if !errors.Is(err, ErrPACUPNInconsistent) {
+					t.Errorf("expected ErrPACUPNInconsistent, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !result.ValidationFlags["CROSS_REALM"] {
+				t.Error("expected CROSS_REALM flag")
+			}
+			if !result.ValidationFlags["UPN_CONSISTENT"] {
+				t.Error("expected UPN_CONSISTENT flag")
+			}
+			if result.Realm != "TEST.COM" {
+				t.Errorf("Realm = %q, want user's home realm TEST.COM", result.Realm)
+			}
+		})
+	}
+}
+
+func TestPACUserRealm(t *testing.T) {
+	if got := pacUserRealm(&UPNInfo{DNSDomain: "corp.example.com"}, "EXAMPLE.COM"); got != "CORP.EXAMPLE.COM" {
+		t.Errorf("pacUserRealm() = %q, want the UPN_DNS_INFO DNS domain", got)
+	}
+	if got := pacUserRealm(&UPNInfo{}, "EXAMPLE.COM"); got != "EXAMPLE.COM" {
+		t.Errorf("pacUserRealm() = %q, want service realm fallback", got)
+	}
+	if got := pacUserRealm(nil, "EXAMPLE.COM"); got != "EXAMPLE.COM" {
+		t.Errorf("pacUserRealm(nil) = %q, want service realm fallback", got)
+	}
+}
+
 func TestPACValidation_GroupSIDExtraction(t *testing.T) {
 	pacData := makeValidPACWithGroups()
 	kt := createTestKeytab()
 
-	result, err := ExtractGroupSIDsFromPAC(pacData, kt, "HTTP/vault.test.com", "TEST.COM", 300)
+	result, err := ExtractGroupSIDsFromPAC(pacData, kt, "HTTP/vault.test.com", "TEST.COM", 300, false, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The group RIDs are relative to the logon domain, followed by the
+	// ExtraSIDs
+	want := []string{
+		testLogonDomainSID + "-513",
+		testLogonDomainSID + "-1108",
+		testLogonDomainSID + "-1109",
+		testLogonDomainSID + "-1115",
+		testLogonDomainSID + "-1116",
+		testLogonDomainSID + "-1114",
+		testLogonDomainSID + "-1111",
+	}
+	if !reflect.DeepEqual(result.GroupSIDs, want) {
+		t.Errorf("GroupSIDs = %v, want %v", result.GroupSIDs, want)
+	}
+}
+
+func TestPACValidation_ResourceGroups(t *testing.T) {
+	kt := createTestKeytab()
+	trust := testLogonInfo(testdata.MarshaledPAC_Kerb_Validation_Info_Trust, time.Now())
+
+	// A trusted-domain logon with two domain local groups from the resource
+	// domain named by the logon info
+	result, err := ExtractGroupSIDsFromPAC(makeTestPAC(trust), kt, "HTTP/vault.test.com", "TEST.COM", 300, false, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wantResource := []string{
+		"S-1-5-21-3062750306-1230139592-1973306805-1107",
+		"S-1-5-21-3062750306-1230139592-1973306805-1108",
+	}
+	if !reflect.DeepEqual(result.ResourceGroupSIDs, wantResource) {
+		t.Errorf("ResourceGroupSIDs = %v, want %v", result.ResourceGroupSIDs, wantResource)
+	}
+	if len(result.GroupSIDs) != 6 || !reflect.DeepEqual(result.GroupSIDs[3:5], wantResource) {
+		t.Errorf("GroupSIDs = %v, want 3 domain groups followed by %v and one ExtraSID", result.GroupSIDs, wantResource)
+	}
+
+	// Without LOGON_RESOURCE_GROUPS the resource groups are ignored
+	binary.LittleEndian.PutUint32(trust[testUserFlagsOffset:], logonExtraSIDs)
+	result, err = ExtractGroupSIDsFromPAC(makeTestPAC(trust), kt, "HTTP/vault.test.com", "TEST.COM", 300, false, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.ResourceGroupSIDs) != 0 || len(result.GroupSIDs) != 4 {
+		t.Errorf("GroupSIDs = %v, ResourceGroupSIDs = %v, want 3 domain groups and one ExtraSID", result.GroupSIDs, result.ResourceGroupSIDs)
+	}
+
+	// A PAC without resource groups reports none
+	result, err = ExtractGroupSIDsFromPAC(makeValidPACWithGroups(), kt, "HTTP/vault.test.com", "TEST.COM", 300, false, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.ResourceGroupSIDs) != 0 || len(result.GroupSIDs) != 7 {
+		t.Errorf("GroupSIDs = %v, ResourceGroupSIDs = %v, want 7 domain groups only", result.GroupSIDs, result.ResourceGroupSIDs)
+	}
+}
+
+func TestExtractResourceGroupSIDs_NoDomainSID(t *testing.T) {
+	info := &LogonInfo{ResourceGroups: []uint32{1107}}
+	if got := extractResourceGroupSIDs(info); got != nil {
+		t.Errorf("extractResourceGroupSIDs() = %v, want none without a resource domain SID", got)
+	}
+}
+
+func TestExtractSIDHistory(t *testing.T) {
+	const domain = "S-1-5-21-1234567890-987654321-1122334455"
+	sameDomain := domain + "-1105"
+	history := "S-1-5-21-1111111111-2222222222-3333333333-1104"
+	resource := "S-1-5-21-1000000001-1000000002-1000000003-1301"
+	wellKnown := "S-1-18-1" // Authentication authority asserted identity
+
+	info := &LogonInfo{
+		LogonDomainID: domain,
+		ExtraSIDs: []KerbSID{
+			{SID: sameDomain, Attributes: 7},
+			{SID: history, Attributes: 7},
+			{SID: resource, Attributes: 7 | seGroupResource},
+			{SID: wellKnown, Attributes: 7},
+		},
+	}
+	if got, want := extractSIDHistory(info), []string{history}; !reflect.DeepEqual(got, want) {
+		t.Errorf("extractSIDHistory() = %v, want %v", got, want)
+	}
+
+	// The comparison is against the logon info's own domain
+	info.LogonDomainID = "S-1-5-21-1111111111-2222222222-3333333333"
+	if got, want := extractSIDHistory(info), []string{sameDomain}; !reflect.DeepEqual(got, want) {
+		t.Errorf("extractSIDHistory() = %v, want %v", got, want)
+	}
+}
+
+func TestPACValidation_SIDHistory(t *testing.T) {
+	kt := createTestKeytab()
+	ms := testLogonInfo(testdata.MarshaledPAC_Kerb_Validation_Info_MS, time.Now())
+
+	// One ExtraSID without SE_GROUP_RESOURCE comes from another domain
+	result, err := ExtractGroupSIDsFromPAC(makeTestPAC(ms), kt, "HTTP/vault.test.com", "TEST.COM", 300, false, false)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
+	if want := []string{"S-1-5-21-773533881-1816936887-355810188-513"}; !reflect.DeepEqual(result.SIDHistorySIDs, want) {
+		t.Errorf("SIDHistorySIDs = %v, want %v", result.SIDHistorySIDs, want)
+	}
+	if len(result.GroupSIDs) != 26+13 {
+		t.Errorf("GroupSIDs has %d SIDs, want 26 domain groups and 13 ExtraSIDs", len(result.GroupSIDs))
+	}
 
-	// The result should be valid for group extraction even if signatures are missing
-	// Check that we extracted group SIDs
-	if len(result.GroupSIDs) == 0 {
-		t.Errorf("expected group SIDs but got none")
+	// Without LOGON_EXTRA_SIDS the ExtraSIDs are ignored
+	binary.LittleEndian.PutUint32(ms[testUserFlagsOffset:], 0)
+	result, err = ExtractGroupSIDsFromPAC(makeTestPAC(ms), kt, "HTTP/vault.test.com", "TEST.COM", 300, false, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.SIDHistorySIDs) != 0 || len(result.GroupSIDs) != 26 {
+		t.Errorf("GroupSIDs = %v, SIDHistorySIDs = %v, want 26 domain groups only", result.GroupSIDs, result.SIDHistorySIDs)
 	}
 
-	// Check SID format
-	for _, sid := range result.GroupSIDs {
-		if !isValidSID(sid) {
-			t.Errorf("invalid SID format: %s", sid)
+	// A logon info cut short is malformed
+	base := testLogonInfo(testdata.MarshaledPAC_Kerb_Validation_Info, time.Now())
+	result, err = ExtractGroupSIDsFromPAC(makeTestPAC(base[:len(base)-40]), kt, "HTTP/vault.test.com", "TEST.COM", 300, false, false)
+	if err == nil || len(result.Errors) == 0 || !errors.Is(result.Errors[0], ErrPACInvalidFormat) {
+		t.Errorf("expected a logon info parse error, got %v (%v)", err, result.Errors)
+	}
+}
+
+func TestParseSID(t *testing.T) {
+	for _, sid := range []string{"S-1-18-1", "S-1-5-32-544", testLogonDomainSID + "-512"} {
+		got, n, err := parseSID(encodeSID(sid))
+		if err != nil || got != sid || n != len(encodeSID(sid)) {
+			t.Errorf("parseSID(%s) = %q, %d, %v", sid, got, n, err)
+		}
+	}
+	for _, data := range [][]byte{{1, 1, 0, 0}, {2, 0, 0, 0, 0, 0, 0, 5}, {1, 2, 0, 0, 0, 0, 0, 5, 1, 0, 0, 0}} {
+		if _, _, err := parseSID(data); !errors.Is(err, ErrPACInvalidFormat) {
+			t.Errorf("parseSID(%v) error = %v, want ErrPACInvalidFormat", data, err)
 		}
 	}
 }
@@ -228,7 +650,7 @@ func TestPACValidation_SignatureValidation(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			kt := createTestKeytab()
-			result, err := ExtractGroupSIDsFromPAC(tt.pacData, kt, "HTTP/vault.test.com", "TEST.COM", 300)
+			result, err := ExtractGroupSIDsFromPAC(tt.pacData, kt, "HTTP/vault.test.com", "TEST.COM", 300, false, false)
 
 			if tt.expectError {
 				// Check if we got an error or if the result has signature validation errors
@@ -244,7 +666,7 @@ func TestPACValidation_SignatureValidation(t *testing.T) {
 					}
 				} else {
 					// Check if result has signature validation errors
-					if result.ValidationFlags["MISSING_SIGNATURES"] || !result.ValidationFlags["SIGNATURES_VALID"] {
+					if !result.ValidationFlags["SIGNATURES_VALID"] {
 						// This is expected for signature validation tests
 						return
 					}
@@ -277,280 +699,406 @@ func makePACWithTooManyBuffers() []byte {
 	return data
 }
 
-func makeValidPACWithLogonTime(logonTime time.Time) []byte {
-	// Create a properly structured PAC for testing
-	data := make([]byte, 2048)
-
-	// PAC header
-	binary.LittleEndian.PutUint32(data[0:4], 3) // count = 3 buffers
-	binary.LittleEndian.PutUint32(data[4:8], 0) // reserved
-
-	// Buffer descriptors start at offset 8
-	bufferDescStart := uint64(8)
-	logonInfoOffset := uint64(8 + 3*16) // after 3 buffer descriptors
-	serverSigOffset := logonInfoOffset + 200
-	kdcSigOffset := serverSigOffset + 24
-
-	// Logon info buffer descriptor
-	binary.LittleEndian.PutUint32(data[bufferDescStart:bufferDescStart+4], PAC_LOGON_INFO)
-	binary.LittleEndian.PutUint32(data[bufferDescStart+4:bufferDescStart+8], 200)
-	binary.LittleEndian.PutUint64(data[bufferDescStart+8:bufferDescStart+16], logonInfoOffset)
-
-	// Server signature buffer descriptor
-	binary.LittleEndian.PutUint32(data[bufferDescStart+16:bufferDescStart+20], PAC_SERVER_CHECKSUM)
-	binary.LittleEndian.PutUint32(data[bufferDescStart+20:bufferDescStart+24], 24)
-	binary.LittleEndian.PutUint64(data[bufferDescStart+24:bufferDescStart+32], serverSigOffset)
-
-	// KDC signature buffer descriptor
-	binary.LittleEndian.PutUint32(data[bufferDescStart+32:bufferDescStart+36], PAC_PRIVSVR_CHECKSUM)
-	binary.LittleEndian.PutUint32(data[bufferDescStart+36:bufferDescStart+40], 24)
-	binary.LittleEndian.PutUint64(data[bufferDescStart+40:bufferDescStart+48], kdcSigOffset)
-
-	// Logon info buffer content
-	fileTime := uint64(logonTime.Unix())*10000000 + 116444736000000000
-	binary.LittleEndian.PutUint64(data[logonInfoOffset:logonInfoOffset+8], fileTime)
-
-	// Add minimal logon info structure
-	// User ID
-	binary.LittleEndian.PutUint32(data[logonInfoOffset+8:logonInfoOffset+12], 1001)
-	// Primary group ID
-	binary.LittleEndian.PutUint32(data[logonInfoOffset+12:logonInfoOffset+16], 513)
-	// Group count
-	binary.LittleEndian.PutUint32(data[logonInfoOffset+16:logonInfoOffset+20], 2)
-
-	// Add some group memberships
-	groupOffset := logonInfoOffset + 20
-	binary.LittleEndian.PutUint32(data[groupOffset:groupOffset+4], 513)    // Domain Users
-	binary.LittleEndian.PutUint32(data[groupOffset+4:groupOffset+8], 7)    // Attributes
-	binary.LittleEndian.PutUint32(data[groupOffset+8:groupOffset+12], 512) // Domain Admins
-	binary.LittleEndian.PutUint32(data[groupOffset+12:groupOffset+16], 7)  // Attributes
-
-	// Server signature buffer content
-	for i := uint64(0); i < 16; i++ {
-		data[serverSigOffset+i] = byte(i + 1)
+func TestExtractServiceKey_EncType(t *testing.T) {
+	kt := createTestKeytab()
+	keys := map[int32][]byte{}
+	for _, e := range kt.Entries {
+		keys[e.Key.KeyType] = e.Key.KeyValue
 	}
 
-	// KDC signature buffer content
-	for i := uint64(0); i < 16; i++ {
-		data[kdcSigOffset+i] = byte(i + 17)
+	tests := []struct {
+		name    string
+		kt      *keytab.Keytab
+		spn     string
+		etype   int32
+		wantErr bool
+	}{
+		{"AES256 key", kt, "HTTP/vault.test.com", etypeID.AES256_CTS_HMAC_SHA1_96, false},
+		{"RC4 key", kt, "HTTP/vault.test.com", etypeID.RC4_HMAC, false},
+		{"SPN with realm suffix", kt, "HTTP/vault.test.com@TEST.COM", etypeID.RC4_HMAC, false},
+		{"enctype not in keytab", kt, "HTTP/vault.test.com", etypeID.AES128_CTS_HMAC_SHA1_96, true},
+		{"SPN not in keytab", kt, "HTTP/other.test.com", etypeID.AES256_CTS_HMAC_SHA1_96, true},
+		// An empty keytab used to yield a hardcoded test key
+		{"empty keytab", keytab.New(), "HTTP/vault.test.com", etypeID.AES256_CTS_HMAC_SHA1_96, true},
+		{"nil keytab", nil, "HTTP/vault.test.com", etypeID.AES256_CTS_HMAC_SHA1_96, true},
 	}
 
-	return data
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := extractServiceKey(tt.kt, tt.spn, "TEST.COM", tt.etype)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got key %x", key)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(key, keys[tt.etype]) {
+				t.Errorf("key = %x, want the enctype %d key %x", key, tt.etype, keys[tt.etype])
+			}
+		})
+	}
 }
 
-func makeValidPACWithUPN(upn, dnsDomain string) []byte {
-	// Create a PAC with UPN info for testing
-	data := make([]byte, 2048)
-
-	// PAC header
-	binary.LittleEndian.PutUint32(data[0:4], 4) // count = 4 buffers
-	binary.LittleEndian.PutUint32(data[4:8], 0) // reserved
-
-	// Buffer descriptors start at offset 8
-	bufferDescStart := uint64(8)
-	logonInfoOffset := uint64(8 + 4*16) // after 4 buffer descriptors
-	upnInfoOffset := logonInfoOffset + 200
-	serverSigOffset := upnInfoOffset + 100
-	kdcSigOffset := serverSigOffset + 24
+func TestExtractServiceKey_NoTestKeyFallback(t *testing.T) {
+	// Empty keytabs and "test" SPNs used to get this publicly known key
+	const legacyTestKey = "test-key-32-bytes-for-aes256-test"
 
-	// Logon info buffer descriptor
-	binary.LittleEndian.PutUint32(data[bufferDescStart:bufferDescStart+4], PAC_LOGON_INFO)
-	binary.LittleEndian.PutUint32(data[bufferDescStart+4:bufferDescStart+8], 200)
-	binary.LittleEndian.PutUint64(data[bufferDescStart+8:bufferDescStart+16], logonInfoOffset)
+	for _, spn := range []string{"HTTP/vault.test.com", "HTTP/test", "HTTP/vault.example.com"} {
+		for _, etype := range []int32{etypeID.AES256_CTS_HMAC_SHA1_96, etypeID.RC4_HMAC} {
+			key, err := extractServiceKey(keytab.New(), spn, "TEST.COM", etype)
+			if err == nil || string(key) == legacyTestKey {
+				t.Errorf("extractServiceKey(empty keytab, %q, %d) = %q, %v; want an error", spn, etype, key, err)
+			}
+		}
+	}
 
-	// UPN info buffer descriptor
-	binary.LittleEndian.PutUint32(data[bufferDescStart+16:bufferDescStart+20], PAC_UPN_DNS_INFO)
-	binary.LittleEndian.PutUint32(data[bufferDescStart+20:bufferDescStart+24], 100)
-	binary.LittleEndian.PutUint64(data[bufferDescStart+24:bufferDescStart+32], upnInfoOffset)
+	// A PAC can't be validated against an empty keytab
+	_, err := ExtractGroupSIDsFromPAC(makeValidPACWithLogonTime(time.Now()), keytab.New(), "HTTP/vault.test.com", "TEST.COM", 300, false, false)
+	if !errors.Is(err, ErrPACSignatureInvalid) {
+		t.Errorf("ExtractGroupSIDsFromPAC(empty keytab) error = %v, want ErrPACSignatureInvalid", err)
+	}
+}
 
-	// Server signature buffer descriptor
-	binary.LittleEndian.PutUint32(data[bufferDescStart+32:bufferDescStart+36], PAC_SERVER_CHECKSUM)
-	binary.LittleEndian.PutUint32(data[bufferDescStart+36:bufferDescStart+40], 24)
-	binary.LittleEndian.PutUint64(data[bufferDescStart+40:bufferDescStart+48], serverSigOffset)
+func TestValidatePACSignatures_ChecksumType(t *testing.T) {
+	aesOnly := keytab.New()
+	if err := aesOnly.AddEntry("HTTP/vault.test.com", "TEST.COM", "test-service-password", time.Now(), 1, etypeID.AES256_CTS_HMAC_SHA1_96); err != nil {
+		t.Fatal(err)
+	}
+	// withAES128 signs AES128 signatures the validation keytab has no key for
+	withAES128 := createTestKeytab()
+	if err := withAES128.AddEntry("HTTP/vault.test.com", "TEST.COM", "test-service-password", time.Now(), 1, etypeID.AES128_CTS_HMAC_SHA1_96); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		signer     *keytab.Keytab
+		kt         *keytab.Keytab
+		cksum      uint32
+		kdcCksum   uint32
+		requireAES bool
+		wantErr    bool
+	}{
+		{"AES256 signature", createTestKeytab(), createTestKeytab(), checksumHMACSHA196AES256, checksumHMACSHA196AES256, false, false},
+		{"RC4 signature", createTestKeytab(), createTestKeytab(), checksumHMACMD5, checksumHMACSHA196AES256, false, false},
+		{"RC4 signature, AES-only keytab", createTestKeytab(), aesOnly, checksumHMACMD5, checksumHMACSHA196AES256, false, true},
+		{"AES128 signature, no AES128 key", withAES128, createTestKeytab(), checksumHMACSHA196AES128, checksumHMACSHA196AES256, false, true},
+		{"unknown checksum type", createTestKeytab(), createTestKeytab(), 0x04030201, checksumHMACSHA196AES256, false, true},
+		{"AES256 signature, AES required", createTestKeytab(), createTestKeytab(), checksumHMACSHA196AES256, checksumHMACSHA196AES256, true, false},
+		{"RC4 signature, AES required", createTestKeytab(), createTestKeytab(), checksumHMACMD5, checksumHMACSHA196AES256, true, true},
+		{"RC4 KDC signature, AES required", createTestKeytab(), createTestKeytab(), checksumHMACSHA196AES256, checksumHMACMD5, true, true},
+		{"RC4 KDC signature", createTestKeytab(), createTestKeytab(), checksumHMACSHA196AES256, checksumHMACMD5, false, false},
+	}
 
-	// KDC signature buffer descriptor
-	binary.LittleEndian.PutUint32(data[bufferDescStart+48:bufferDescStart+52], PAC_PRIVSVR_CHECKSUM)
-	binary.LittleEndian.PutUint32(data[bufferDescStart+52:bufferDescStart+56], 24)
-	binary.LittleEndian.PutUint64(data[bufferDescStart+56:bufferDescStart+64], kdcSigOffset)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pacData := signTestPACWith(makeValidPACWithGroups(), tt.signer, tt.cksum, tt.kdcCksum)
+			_, err := ExtractGroupSIDsFromPAC(pacData, tt.kt, "HTTP/vault.test.com", "TEST.COM", 300, false, tt.requireAES)
+			if tt.wantErr {
+				if !errors.Is(err, ErrPACSignatureInvalid) {
+					t.Errorf("error = %v, want ErrPACSignatureInvalid", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
 
-	// Logon info buffer content
-	now := time.Now()
-	fileTime := uint64(now.Unix())*10000000 + 116444736000000000
-	binary.LittleEndian.PutUint64(data[logonInfoOffset:logonInfoOffset+8], fileTime)
+func TestValidatePACSignatures_Verification(t *testing.T) {
+	// The same password under another SPN salts a different key
+	otherKey := createTestKeytabFor("HTTP/other.test.com", "TEST.COM")
+	for i := range otherKey.Entries {
+		otherKey.Entries[i].Principal = createTestKeytab().Entries[i].Principal
+	}
 
-	// Add minimal logon info structure
-	binary.LittleEndian.PutUint32(data[logonInfoOffset+8:logonInfoOffset+12], 1001)
-	binary.LittleEndian.PutUint32(data[logonInfoOffset+12:logonInfoOffset+16], 513)
-	binary.LittleEndian.PutUint32(data[logonInfoOffset+16:logonInfoOffset+20], 0) // No groups for UPN test
+	tests := []struct {
+		name    string
+		pacData func() []byte
+		wantErr bool
+	}{
+		{"signed", makeValidPACWithGroups, false},
+		{"signed with RC4", func() []byte {
+			return signTestPACWith(makeValidPACWithGroups(), createTestKeytab(), checksumHMACMD5, checksumHMACMD5)
+		}, false},
+		{"logon info altered after signing", func() []byte {
+			data := makeValidPACWithGroups()
+			binary.LittleEndian.PutUint32(data[pacBufferOffset(data, PAC_LOGON_INFO)+testUACOffset:], 0x80)
+			return data
+		}, true},
+		{"RC4 PAC altered after signing", func() []byte {
+			data := signTestPACWith(makeValidPACWithGroups(), createTestKeytab(), checksumHMACMD5, checksumHMACMD5)
+			data[pacBufferOffset(data, PAC_LOGON_INFO)+testUACOffset] ^= 0x80
+			return data
+		}, true},
+		{"server signature altered", func() []byte {
+			data := makeValidPACWithGroups()
+			data[pacBufferOffset(data, PAC_SERVER_CHECKSUM)+8] ^= 1
+			return data
+		}, true},
+		{"signed with another key", func() []byte {
+			return signTestPACWith(makeValidPACWithGroups(), otherKey, checksumHMACSHA196AES256, checksumHMACSHA196AES256)
+		}, true},
+		// The KDC signature is keyed with the krbtgt key and can't be checked
+		{"KDC signature altered", func() []byte {
+			data := makeValidPACWithGroups()
+			data[pacBufferOffset(data, PAC_PRIVSVR_CHECKSUM)+8] ^= 1
+			return data
+		}, false},
+	}
 
-	// UPN info buffer content
-	upnBytes := []byte(upn)
-	dnsBytes := []byte(dnsDomain)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ExtractGroupSIDsFromPAC(tt.pacData(), createTestKeytab(), "HTTP/vault.test.com", "TEST.COM", 300, false, false)
+			if tt.wantErr {
+				if !errors.Is(err, ErrPACSignatureInvalid) {
+					t.Errorf("error = %v, want ErrPACSignatureInvalid", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !result.Valid || !result.ValidationFlags["SIGNATURES_VALID"] {
+				t.Errorf("Valid = %v, flags = %v, want valid signatures", result.Valid, result.ValidationFlags)
+			}
+		})
+	}
+}
 
-	// UPN length
-	binary.LittleEndian.PutUint16(data[upnInfoOffset:upnInfoOffset+2], uint16(len(upnBytes)))
-	// DNS domain length
-	binary.LittleEndian.PutUint16(data[upnInfoOffset+2:upnInfoOffset+4], uint16(len(dnsBytes)))
+// testLogonDomainSID is the LogonDomainId of the gokrb5 sample logon info
+// the makeValidPAC helpers use
+const testLogonDomainSID = "S-1-5-21-3167651404-3865080224-2280184895"
 
-	// Copy UPN and DNS domain strings
-	copy(data[upnInfoOffset+4:upnInfoOffset+4+uint64(len(upnBytes))], upnBytes)
-	copy(data[upnInfoOffset+4+uint64(len(upnBytes)):upnInfoOffset+4+uint64(len(upnBytes))+uint64(len(dnsBytes))], dnsBytes)
+// Offsets of fixed fields in the NDR-encoded gokrb5 sample logon infos
+const (
+	testLogonTimeOffset  = 20  // LogOnTime
+	testUserFlagsOffset  = 136 // UserFlags
+	testLogonDomainIDPtr = 172 // LogonDomainId pointer
+	testUACOffset        = 184 // UserAccountControl
+)
 
-	// Server signature buffer content
-	for i := uint64(0); i < 16; i++ {
-		data[serverSigOffset+i] = byte(i + 1)
+// testLogonInfo decodes a gokrb5 sample KERB_VALIDATION_INFO and sets its
+// logon time
+func testLogonInfo(sample string, logonTime time.Time) []byte {
+	data, err := hex.DecodeString(sample)
+	if err != nil {
+		panic(err)
 	}
+	fileTime := uint64(logonTime.Unix())*10000000 + 116444736000000000
+	binary.LittleEndian.PutUint64(data[testLogonTimeOffset:], fileTime)
+	return data
+}
 
-	// KDC signature buffer content
-	for i := uint64(0); i < 16; i++ {
-		data[kdcSigOffset+i] = byte(i + 17)
+// withoutLogonDomainID nulls the LogonDomainId pointer of the base gokrb5
+// sample logon info and drops the SID it pointed to
+func withoutLogonDomainID(logonInfo []byte) []byte {
+	// The conformant SID: max count 4, revision 1, 4 sub-authorities, NT
+	// authority
+	sid := []byte{4, 0, 0, 0, 1, 4, 0, 0, 0, 0, 0, 5}
+	i := bytes.Index(logonInfo, sid)
+	if i < 0 {
+		panic("logon domain SID not found")
 	}
-
+	data := append(slices.Clone(logonInfo[:i]), logonInfo[i+len(sid)+4*4:]...)
+	clear(data[testLogonDomainIDPtr : testLogonDomainIDPtr+4])
 	return data
 }
 
-func makeValidPACWithGroups() []byte {
-	// Create a PAC with group information for testing
-	data := make([]byte, 2048)
-
-	// PAC header
-	binary.LittleEndian.PutUint32(data[0:4], 3) // count = 3 buffers
-	binary.LittleEndian.PutUint32(data[4:8], 0) // reserved
+// pacTestBuffer is a buffer for buildTestPAC
+type pacTestBuffer struct {
+	typ  uint32
+	data []byte
+}
 
-	// Buffer descriptors start at offset 8
-	bufferDescStart := uint64(8)
-	logonInfoOffset := uint64(8 + 3*16) // after 3 buffer descriptors
-	serverSigOffset := logonInfoOffset + 200
-	kdcSigOffset := serverSigOffset + 24
+// buildTestPAC lays out the buffers after the descriptor table, each padded
+// to an 8-byte boundary as a KDC does
+func buildTestPAC(buffers ...pacTestBuffer) []byte {
+	data := binary.LittleEndian.AppendUint32(nil, uint32(len(buffers)))
+	data = binary.LittleEndian.AppendUint32(data, 0)
+	offset := uint64(8 + 16*len(buffers))
+	var body []byte
+	for _, buf := range buffers {
+		data = binary.LittleEndian.AppendUint32(data, buf.typ)
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(buf.data)))
+		data = binary.LittleEndian.AppendUint64(data, offset+uint64(len(body)))
+		body = append(body, buf.data...)
+		body = append(body, make([]byte, (8-len(buf.data)%8)%8)...)
+	}
+	return append(data, body...)
+}
 
-	// Logon info buffer descriptor
-	binary.LittleEndian.PutUint32(data[bufferDescStart:bufferDescStart+4], PAC_LOGON_INFO)
-	binary.LittleEndian.PutUint32(data[bufferDescStart+4:bufferDescStart+8], 200)
-	binary.LittleEndian.PutUint64(data[bufferDescStart+8:bufferDescStart+16], logonInfoOffset)
+// makeTestPAC builds a signed PAC of the logon info, the extra buffers and
+// server and KDC signatures
+func makeTestPAC(logonInfo []byte, extra ...pacTestBuffer) []byte {
+	buffers := append([]pacTestBuffer{{PAC_LOGON_INFO, logonInfo}}, extra...)
+	buffers = append(buffers,
+		pacTestBuffer{PAC_SERVER_CHECKSUM, make([]byte, 24)},
+		pacTestBuffer{PAC_PRIVSVR_CHECKSUM, make([]byte, 24)})
+	return signTestPAC(buildTestPAC(buffers...))
+}
 
-	// Server signature buffer descriptor
-	binary.LittleEndian.PutUint32(data[bufferDescStart+16:bufferDescStart+20], PAC_SERVER_CHECKSUM)
-	binary.LittleEndian.PutUint32(data[bufferDescStart+20:bufferDescStart+24], 24)
-	binary.LittleEndian.PutUint64(data[bufferDescStart+24:bufferDescStart+32], serverSigOffset)
+// pacBufferOffset returns the offset of the first buffer of the given type
+func pacBufferOffset(data []byte, bufType uint32) uint64 {
+	info, err := parsePACInfo(data)
+	if err != nil {
+		panic(err)
+	}
+	for _, buf := range info.Buffers {
+		if buf.Type == bufType {
+			return buf.Offset
+		}
+	}
+	panic("no PAC buffer of the requested type")
+}
 
-	// KDC signature buffer descriptor
-	binary.LittleEndian.PutUint32(data[bufferDescStart+32:bufferDescStart+36], PAC_PRIVSVR_CHECKSUM)
-	binary.LittleEndian.PutUint32(data[bufferDescStart+36:bufferDescStart+40], 24)
-	binary.LittleEndian.PutUint64(data[bufferDescStart+40:bufferDescStart+48], kdcSigOffset)
+// makeValidPACWithLogonTime builds a PAC around the base gokrb5 sample logon
+// info: user testuser1 (RID 1105) with five domain groups and two ExtraSIDs
+func makeValidPACWithLogonTime(logonTime time.Time) []byte {
+	return makeTestPAC(testLogonInfo(testdata.MarshaledPAC_Kerb_Validation_Info, logonTime))
+}
 
-	// Logon info buffer content
-	now := time.Now()
-	fileTime := uint64(now.Unix())*10000000 + 116444736000000000
-	binary.LittleEndian.PutUint64(data[logonInfoOffset:logonInfoOffset+8], fileTime)
+func makeValidPACWithGroups() []byte {
+	return makeValidPACWithLogonTime(time.Now())
+}
 
-	// Add logon info structure with groups
-	binary.LittleEndian.PutUint32(data[logonInfoOffset+8:logonInfoOffset+12], 1001) // User ID
-	binary.LittleEndian.PutUint32(data[logonInfoOffset+12:logonInfoOffset+16], 513) // Primary group ID
-	binary.LittleEndian.PutUint32(data[logonInfoOffset+16:logonInfoOffset+20], 3)   // Group count
+// makeValidPACWithUPN adds a UPN_DNS_INFO buffer with the given UPN and DNS
+// domain after the logon info
+func makeValidPACWithUPN(upn, dnsDomain string) []byte {
+	upnInfo := make([]byte, 100)
+	binary.LittleEndian.PutUint16(upnInfo[0:2], uint16(len(upn)))
+	binary.LittleEndian.PutUint16(upnInfo[2:4], uint16(len(dnsDomain)))
+	copy(upnInfo[4:], upn)
+	copy(upnInfo[4+len(upn):], dnsDomain)
+	return makeTestPAC(testLogonInfo(testdata.MarshaledPAC_Kerb_Validation_Info, time.Now()), pacTestBuffer{PAC_UPN_DNS_INFO, upnInfo})
+}
 
-	// Add group memberships
-	groupOffset := logonInfoOffset + 20
-	binary.LittleEndian.PutUint32(data[groupOffset:groupOffset+4], 513)     // Domain Users
-	binary.LittleEndian.PutUint32(data[groupOffset+4:groupOffset+8], 7)     // Attributes
-	binary.LittleEndian.PutUint32(data[groupOffset+8:groupOffset+12], 512)  // Domain Admins
-	binary.LittleEndian.PutUint32(data[groupOffset+12:groupOffset+16], 7)   // Attributes
-	binary.LittleEndian.PutUint32(data[groupOffset+16:groupOffset+20], 419) // Enterprise Admins
-	binary.LittleEndian.PutUint32(data[groupOffset+20:groupOffset+24], 7)   // Attributes
+// makeValidPACWithBufferType relabels the UPN_DNS_INFO buffer of
+// makeValidPACWithUPN as bufType
+func makeValidPACWithBufferType(bufType uint32) []byte {
+	data := makeValidPACWithUPN("testuser1@TEST.COM", "TEST.COM")
+	binary.LittleEndian.PutUint32(data[8+16:8+20], bufType)
+	return signTestPAC(data)
+}
 
-	// Server signature buffer content
-	for i := uint64(0); i < 16; i++ {
-		data[serverSigOffset+i] = byte(i + 1)
-	}
+// makeValidPACWithUserAccountControl sets the UserAccountControl flags of
+// the base sample logon info
+func makeValidPACWithUserAccountControl(uac uint32) []byte {
+	logonInfo := testLogonInfo(testdata.MarshaledPAC_Kerb_Validation_Info, time.Now())
+	binary.LittleEndian.PutUint32(logonInfo[testUACOffset:], uac)
+	return makeTestPAC(logonInfo)
+}
 
-	// KDC signature buffer content
-	for i := uint64(0); i < 16; i++ {
-		data[kdcSigOffset+i] = byte(i + 17)
+// encodeSID encodes a SID string in its binary form
+func encodeSID(sid string) []byte {
+	parts := strings.Split(sid, "-")
+	authority, _ := strconv.ParseUint(parts[2], 10, 48)
+	b := []byte{1, byte(len(parts) - 3), 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(b[2:4], uint16(authority>>32))
+	binary.BigEndian.PutUint32(b[4:8], uint32(authority))
+	for _, p := range parts[3:] {
+		sub, _ := strconv.ParseUint(p, 10, 32)
+		b = binary.LittleEndian.AppendUint32(b, uint32(sub))
 	}
-
-	return data
+	return b
 }
 
 func makePACWithoutSignatures() []byte {
 	// PAC with logon info but no signatures
-	data := make([]byte, 1024)
-
-	// PAC header
-	binary.LittleEndian.PutUint32(data[0:4], 1) // count = 1 buffer
-	binary.LittleEndian.PutUint32(data[4:8], 0) // reserved
-
-	// Buffer descriptors start at offset 8
-	bufferDescStart := uint64(8)
-	logonInfoOffset := uint64(8 + 1*16) // after 1 buffer descriptor
-
-	// Logon info buffer descriptor
-	binary.LittleEndian.PutUint32(data[bufferDescStart:bufferDescStart+4], PAC_LOGON_INFO)
-	binary.LittleEndian.PutUint32(data[bufferDescStart+4:bufferDescStart+8], 200)
-	binary.LittleEndian.PutUint64(data[bufferDescStart+8:bufferDescStart+16], logonInfoOffset)
-
-	// Logon info buffer content with valid timestamp
-	now := time.Now()
-	fileTime := uint64(now.Unix())*10000000 + 116444736000000000
-	binary.LittleEndian.PutUint64(data[logonInfoOffset:logonInfoOffset+8], fileTime)
-
-	return data
+	return buildTestPAC(pacTestBuffer{PAC_LOGON_INFO, testLogonInfo(testdata.MarshaledPAC_Kerb_Validation_Info, time.Now())})
 }
 
 func makePACWithShortSignatures() []byte {
-	// PAC with signatures that are too short
-	data := make([]byte, 1024)
-
-	// PAC header
-	binary.LittleEndian.PutUint32(data[0:4], 3) // count = 3 buffers
-	binary.LittleEndian.PutUint32(data[4:8], 0) // reserved
-
-	// Buffer descriptors start at offset 8
-	bufferDescStart := uint64(8)
-	logonInfoOffset := uint64(8 + 3*16) // after 3 buffer descriptors
-	serverSigOffset := logonInfoOffset + 200
-	kdcSigOffset := serverSigOffset + 4
-
-	// Logon info buffer descriptor
-	binary.LittleEndian.PutUint32(data[bufferDescStart:bufferDescStart+4], PAC_LOGON_INFO)
-	binary.LittleEndian.PutUint32(data[bufferDescStart+4:bufferDescStart+8], 200)
-	binary.LittleEndian.PutUint64(data[bufferDescStart+8:bufferDescStart+16], logonInfoOffset)
-
-	// Server signature buffer descriptor (too small)
-	binary.LittleEndian.PutUint32(data[bufferDescStart+16:bufferDescStart+20], PAC_SERVER_CHECKSUM)
-	binary.LittleEndian.PutUint32(data[bufferDescStart+20:bufferDescStart+24], 4) // too small
-	binary.LittleEndian.PutUint64(data[bufferDescStart+24:bufferDescStart+32], serverSigOffset)
-
-	// KDC signature buffer descriptor (too small)
-	binary.LittleEndian.PutUint32(data[bufferDescStart+32:bufferDescStart+36], PAC_PRIVSVR_CHECKSUM)
-	binary.LittleEndian.PutUint32(data[bufferDescStart+36:bufferDescStart+40], 4) // too small
-	binary.LittleEndian.PutUint64(data[bufferDescStart+40:bufferDescStart+48], kdcSigOffset)
-
-	// Logon info buffer content with valid timestamp
-	now := time.Now()
-	fileTime := uint64(now.Unix())*10000000 + 116444736000000000
-	binary.LittleEndian.PutUint64(data[logonInfoOffset:logonInfoOffset+8], fileTime)
+	// PAC with signatures that are too short: only 2 bytes of data, which is
+	// less than the minimum of 8
+	return buildTestPAC(
+		pacTestBuffer{PAC_LOGON_INFO, testLogonInfo(testdata.MarshaledPAC_Kerb_Validation_Info, time.Now())},
+		pacTestBuffer{PAC_SERVER_CHECKSUM, []byte{0x01, 0x02, 0, 0}},
+		pacTestBuffer{PAC_PRIVSVR_CHECKSUM, make([]byte, 4)})
+}
 
-	// Add minimal logon info structure
-	binary.LittleEndian.PutUint32(data[logonInfoOffset+8:logonInfoOffset+12], 1001)
-	binary.LittleEndian.PutUint32(data[logonInfoOffset+12:logonInfoOffset+16], 513)
-	binary.LittleEndian.PutUint32(data[logonInfoOffset+16:logonInfoOffset+20], 0)
+// signTestPAC signs a PAC built by the makeValidPAC helpers with AES256
+// server and KDC signatures keyed by createTestKeytab. Helpers that patch a
+// signed PAC sign it again.
+func signTestPAC(data []byte) []byte {
+	return signTestPACWith(data, createTestKeytab(), checksumHMACSHA196AES256, checksumHMACSHA196AES256)
+}
 
-	// Add short signature data (only 2 bytes, which is less than minimum 8)
-	if len(data) > int(serverSigOffset) {
-		data[serverSigOffset] = 0x01
-		data[serverSigOffset+1] = 0x02
+// signTestPACWith fills in the signature buffers of a PAC the way a KDC
+// does: the server signature is a checksum of the PAC with both signatures
+// zeroed and the KDC signature a checksum of the server signature, each keyed
+// with kt's key of the enctype its checksum type implies. A signature whose
+// checksum type is unsupported or has no key in kt is left zeroed.
+func signTestPACWith(data []byte, kt *keytab.Keytab, serverCksum, kdcCksum uint32) []byte {
+	info, err := parsePACInfo(data)
+	if err != nil {
+		panic(err)
+	}
+	var serverSig, kdcSig []byte
+	for _, buf := range info.Buffers {
+		cksumType := serverCksum
+		switch buf.Type {
+		case PAC_SERVER_CHECKSUM:
+		case PAC_PRIVSVR_CHECKSUM:
+			cksumType = kdcCksum
+		default:
+			continue
+		}
+		sigLen := 16
+		if e, err := crypto.GetChksumEtype(int32(cksumType)); err == nil {
+			sigLen = int(e.GetHMACBitLength() / 8)
+		}
+		binary.LittleEndian.PutUint32(data[buf.Offset:], cksumType)
+		binary.LittleEndian.PutUint32(data[buf.Offset+4:], uint32(8+sigLen))
+		sig := data[buf.Offset+8 : buf.Offset+8+uint64(sigLen)]
+		clear(sig)
+		if buf.Type == PAC_SERVER_CHECKSUM {
+			serverSig = sig
+		} else {
+			kdcSig = sig
+		}
 	}
 
+	sign := func(sig []byte, cksumType uint32, msg []byte) {
+		cksumEtype, err := crypto.GetChksumEtype(int32(cksumType))
+		if err != nil {
+			return
+		}
+		etype, _ := checksumKeyType(cksumType)
+		for _, e := range kt.Entries {
+			if e.Key.KeyType != etype {
+				continue
+			}
+			sum, err := cksumEtype.GetChecksumHash(e.Key.KeyValue, msg, keyusage.KERB_NON_KERB_CKSUM_SALT)
+			if err != nil {
+				panic(err)
+			}
+			copy(sig, sum)
+			return
+		}
+	}
+	sign(serverSig, serverCksum, data)
+	sign(kdcSig, kdcCksum, serverSig)
 	return data
 }
 
+// createTestKeytab returns a keytab with AES256 and RC4 keys for the SPN the
+// PAC tests validate against
 func createTestKeytab() *keytab.Keytab {
-	// Create a minimal test keytab
-	kt := &keytab.Keytab{}
-	// The extractServiceKey function will handle test cases with empty keytabs
-	return kt
+	return createTestKeytabFor("HTTP/vault.test.com", "TEST.COM")
 }
 
-func isValidSID(sid string) bool {
-	// Basic SID format validation
-	return len(sid) > 0 && sid[0] == 'S' && strings.Contains(sid, "-")
+// createTestKeytabFor returns a keytab with AES256 and RC4 keys for spn
+func createTestKeytabFor(spn, realm string) *keytab.Keytab {
+	kt := keytab.New()
+	for _, etype := range []int32{etypeID.AES256_CTS_HMAC_SHA1_96, etypeID.RC4_HMAC} {
+		if err := kt.AddEntry(spn, realm, "test-service-password", time.Now(), 1, etype); err != nil {
+			panic(err)
+		}
+	}
+	return kt
 }
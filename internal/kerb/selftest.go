@@ -0,0 +1,252 @@
+package kerb
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/asn1tools"
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/iana"
+	"github.com/jcmturner/gokrb5/v8/iana/adtype"
+	"github.com/jcmturner/gokrb5/v8/iana/asnAppTag"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/iana/nametype"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/pac"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/test/testdata"
+	"github.com/jcmturner/gokrb5/v8/types"
+)
+
+// SelfTestPAC issues a service ticket for the SPN encrypted with the
+// strongest key the keytab holds for it, carrying a PAC signed with that key,
+// and runs it through ValidateSPNEGO. It checks that the ticket is accepted
+// and that the PAC is validated and yields the expected user and group SIDs,
+// so it exercises the keytab's key material, gokrb5's ticket decryption and
+// PAC signature verification, and the PAC extraction logins use. The PAC
+// carries the logon info AD issued for testuser1 in gokrb5's test vectors.
+func SelfTestPAC(ctx context.Context, kt *keytab.Keytab, spn, realm string) error {
+	etype, err := selfTestEType(kt, spn, realm)
+	if err != nil {
+		return err
+	}
+
+	logonInfo, err := hex.DecodeString(testdata.MarshaledPAC_Kerb_Validation_Info)
+	if err != nil {
+		return err
+	}
+	clientInfo, err := hex.DecodeString(testdata.MarshaledPAC_Client_Info)
+	if err != nil {
+		return err
+	}
+	var info pac.KerbValidationInfo
+	if err := info.Unmarshal(logonInfo); err != nil {
+		return fmt.Errorf("sample logon info could not be decoded: %w", err)
+	}
+	cname := info.EffectiveName.Value
+
+	tkt, sessionKey, err := issueServiceTicket(kt, spn, realm, etype, cname, realm,
+		[]pacPayload{{Type: PAC_LOGON_INFO, Data: logonInfo}, {Type: PAC_CLIENT_INFO, Data: clientInfo}})
+	if err != nil {
+		return fmt.Errorf("service ticket could not be issued: %w", err)
+	}
+	token, err := spnegoForTicket(tkt, sessionKey, cname, realm, 0)
+	if err != nil {
+		return err
+	}
+	ktBytes, err := kt.Marshal()
+	if err != nil {
+		return err
+	}
+
+	v := NewValidator(Options{Realm: realm, SPN: spn, KeytabB64: base64.StdEncoding.EncodeToString(ktBytes)})
+	res, verr := v.ValidateSPNEGO(ctx, token, "")
+	if !verr.IsZero() {
+		return fmt.Errorf("self-issued service ticket rejected: %s", verr.Error())
+	}
+	if !res.Flags["PAC_VALIDATED"] {
+		return fmt.Errorf("self-issued ticket's PAC not validated: %v", res.Flags)
+	}
+	if want := fmt.Sprintf("%s-%d", info.LogonDomainID.String(), info.UserID); res.UserSID != want {
+		return fmt.Errorf("PAC user SID = %s, want %s", res.UserSID, want)
+	}
+	if want := info.GetGroupMembershipSIDs(); !slices.Equal(res.GroupSIDs, want) {
+		return fmt.Errorf("PAC group SIDs = %v, want %v", res.GroupSIDs, want)
+	}
+	return nil
+}
+
+// selfTestEType returns the strongest enctype the keytab holds a key of for
+// the SPN
+func selfTestEType(kt *keytab.Keytab, spn, realm string) (int32, error) {
+	for _, etype := range []int32{etypeID.AES256_CTS_HMAC_SHA1_96, etypeID.AES128_CTS_HMAC_SHA1_96, etypeID.RC4_HMAC} {
+		if _, err := extractServiceKey(kt, spn, realm, etype); err == nil {
+			return etype, nil
+		}
+	}
+	return 0, fmt.Errorf("keytab has no AES or RC4 key for %s in realm %s", spn, realm)
+}
+
+// pacPayload is one buffer of a PAC built by encodePAC
+type pacPayload struct {
+	Type uint32
+	Data []byte
+}
+
+// encodePAC encodes a PAC holding buffers followed by server and KDC
+// signatures computed with key, the service key the ticket is encrypted
+// with. A KDC keys the KDC signature with the krbtgt key, which a service
+// never holds, so only the server signature is meaningful to a verifier.
+func encodePAC(key types.EncryptionKey, buffers []pacPayload) ([]byte, error) {
+	var cksumType uint32
+	switch key.KeyType {
+	case etypeID.AES128_CTS_HMAC_SHA1_96:
+		cksumType = checksumHMACSHA196AES128
+	case etypeID.AES256_CTS_HMAC_SHA1_96:
+		cksumType = checksumHMACSHA196AES256
+	case etypeID.RC4_HMAC:
+		cksumType = checksumHMACMD5
+	default:
+		return nil, fmt.Errorf("no PAC checksum type for enctype %d", key.KeyType)
+	}
+	cksumEtype, err := crypto.GetChksumEtype(int32(cksumType))
+	if err != nil {
+		return nil, err
+	}
+	sigLen := int(cksumEtype.GetHMACBitLength() / 8)
+	sigBuf := func(bufType uint32) pacPayload {
+		data := make([]byte, 4+sigLen)
+		binary.LittleEndian.PutUint32(data, cksumType)
+		return pacPayload{Type: bufType, Data: data}
+	}
+	buffers = append(slices.Clone(buffers), sigBuf(PAC_SERVER_CHECKSUM), sigBuf(PAC_PRIVSVR_CHECKSUM))
+
+	// Buffers follow the header and start on 8-byte boundaries
+	offset := 8 + 16*len(buffers)
+	offsets := make([]int, len(buffers))
+	for i, b := range buffers {
+		offset = (offset + 7) &^ 7
+		offsets[i] = offset
+		offset += len(b.Data)
+	}
+	data := make([]byte, offset)
+	binary.LittleEndian.PutUint32(data[0:4], uint32(len(buffers)))
+	for i, b := range buffers {
+		desc := data[8+16*i:]
+		binary.LittleEndian.PutUint32(desc[0:4], b.Type)
+		binary.LittleEndian.PutUint32(desc[4:8], uint32(len(b.Data)))
+		binary.LittleEndian.PutUint64(desc[8:16], uint64(offsets[i]))
+		copy(data[offsets[i]:], b.Data)
+	}
+
+	// The server signature covers the PAC with both signatures zeroed; the
+	// KDC signature covers the server signature
+	serverSig := data[offsets[len(buffers)-2]+4 : offsets[len(buffers)-2]+4+sigLen]
+	kdcSig := data[offsets[len(buffers)-1]+4 : offsets[len(buffers)-1]+4+sigLen]
+	sum, err := cksumEtype.GetChecksumHash(key.KeyValue, data, keyusage.KERB_NON_KERB_CKSUM_SALT)
+	if err != nil {
+		return nil, err
+	}
+	copy(serverSig, sum)
+	if sum, err = cksumEtype.GetChecksumHash(key.KeyValue, serverSig, keyusage.KERB_NON_KERB_CKSUM_SALT); err != nil {
+		return nil, err
+	}
+	copy(kdcSig, sum)
+	return data, nil
+}
+
+// issueServiceTicket issues a service ticket for spn@realm to cname@crealm,
+// the way a KDC would, encrypted with the keytab's newest key of the given
+// enctype and carrying a PAC encoded from buffers. It returns the ticket
+// with its session key.
+func issueServiceTicket(kt *keytab.Keytab, spn, realm string, etype int32, cname, crealm string, buffers []pacPayload) (messages.Ticket, types.EncryptionKey, error) {
+	parsed, err := ParseSPN(spn)
+	if err != nil {
+		return messages.Ticket{}, types.EncryptionKey{}, err
+	}
+	sname := types.PrincipalName{NameType: nametype.KRB_NT_SRV_INST, NameString: parsed.Components()}
+	key, kvno, err := kt.GetEncryptionKey(sname, realm, 0, etype)
+	if err != nil {
+		return messages.Ticket{}, types.EncryptionKey{}, err
+	}
+
+	pacData, err := encodePAC(key, buffers)
+	if err != nil {
+		return messages.Ticket{}, types.EncryptionKey{}, err
+	}
+	pacAD, err := asn1.Marshal(types.AuthorizationData{{ADType: adtype.ADWin2KPAC, ADData: pacData}})
+	if err != nil {
+		return messages.Ticket{}, types.EncryptionKey{}, err
+	}
+	e, err := crypto.GetEtype(etype)
+	if err != nil {
+		return messages.Ticket{}, types.EncryptionKey{}, err
+	}
+	sessionKey, err := types.GenerateEncryptionKey(e)
+	if err != nil {
+		return messages.Ticket{}, types.EncryptionKey{}, err
+	}
+	now := time.Now().UTC()
+	encPart, err := asn1.Marshal(messages.EncTicketPart{
+		Flags:             types.NewKrbFlags(),
+		Key:               sessionKey,
+		CRealm:            crealm,
+		CName:             types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, cname),
+		AuthTime:          now,
+		StartTime:         now,
+		EndTime:           now.Add(10 * time.Hour),
+		RenewTill:         now.Add(10 * time.Hour),
+		AuthorizationData: types.AuthorizationData{{ADType: adtype.ADIfRelevant, ADData: pacAD}},
+	})
+	if err != nil {
+		return messages.Ticket{}, types.EncryptionKey{}, err
+	}
+	encPart = asn1tools.AddASNAppTag(encPart, asnAppTag.EncTicketPart)
+	ed, err := crypto.GetEncryptedData(encPart, key, keyusage.KDC_REP_TICKET, kvno)
+	if err != nil {
+		return messages.Ticket{}, types.EncryptionKey{}, err
+	}
+	return messages.Ticket{TktVNO: iana.PVNO, Realm: realm, SName: sname, EncPart: ed}, sessionKey, nil
+}
+
+// spnegoForTicket wraps a ticket in a base64 SPNEGO token with a fresh
+// authenticator for cname@crealm, its timestamp shifted by authOffset from now
+func spnegoForTicket(tkt messages.Ticket, sessionKey types.EncryptionKey, cname, crealm string, authOffset time.Duration) (string, error) {
+	auth, err := types.NewAuthenticator(crealm, types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, cname))
+	if err != nil {
+		return "", fmt.Errorf("failed to create authenticator: %w", err)
+	}
+	auth.CTime = auth.CTime.Add(authOffset)
+	apReq, err := messages.NewAPReq(tkt, sessionKey, auth)
+	if err != nil {
+		return "", fmt.Errorf("failed to create AP_REQ: %w", err)
+	}
+	cl := client.NewWithPassword(cname, crealm, "unused", config.New())
+	negInit, err := spnego.NewNegTokenInitKRB5(cl, tkt, sessionKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to create NegTokenInit: %w", err)
+	}
+	mt, err := spnego.NewKRB5TokenAPREQ(cl, tkt, sessionKey, nil, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create KRB5 token: %w", err)
+	}
+	mt.APReq = apReq
+	if negInit.MechTokenBytes, err = mt.Marshal(); err != nil {
+		return "", fmt.Errorf("failed to marshal KRB5 token: %w", err)
+	}
+	b, err := (&spnego.SPNEGOToken{Init: true, NegTokenInit: negInit}).Marshal()
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal SPNEGO token: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,34 @@
+package kerb
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+)
+
+func TestSelfTestPAC(t *testing.T) {
+	ctx := context.Background()
+	if err := SelfTestPAC(ctx, createTestKeytab(), "HTTP/vault.test.com", "TEST.COM"); err != nil {
+		t.Fatalf("SelfTestPAC() = %v", err)
+	}
+
+	// AES128-only and RC4-only keytabs issue and sign with their own key
+	for _, etype := range []int32{etypeID.AES128_CTS_HMAC_SHA1_96, etypeID.RC4_HMAC} {
+		kt := keytab.New()
+		if err := kt.AddEntry("HTTP/vault.test.com", "TEST.COM", "test-service-password", time.Now(), 1, etype); err != nil {
+			t.Fatal(err)
+		}
+		if err := SelfTestPAC(ctx, kt, "HTTP/vault.test.com@TEST.COM", "TEST.COM"); err != nil {
+			t.Errorf("SelfTestPAC(etype %d) = %v", etype, err)
+		}
+	}
+
+	err := SelfTestPAC(ctx, createTestKeytab(), "HTTP/other.test.com", "TEST.COM")
+	if err == nil || !strings.Contains(err.Error(), "HTTP/other.test.com") {
+		t.Errorf("SelfTestPAC(missing SPN) = %v, want the SPN named", err)
+	}
+}
@@ -0,0 +1,91 @@
+package kerb
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	spnServiceRe  = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9-]*$`)
+	spnHostRe     = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?)*$`)
+	spnInstanceRe = regexp.MustCompile(`^[A-Za-z0-9_$-]+$`)
+	spnNameRe     = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+)
+
+// SPN is a service principal name of the form
+// service/host[:port][/service-name][@REALM], e.g. HTTP/vault.example.com,
+// MSSQLSvc/db.example.com:1433 or ldap/dc1.example.com/example.com
+type SPN struct {
+	Service     string // Service class, e.g. HTTP or MSSQLSvc
+	Host        string // Host name without the port
+	Port        string // Port number or SQL Server instance name; "" if absent
+	ServiceName string // Third component, e.g. the domain of an ldap SPN; "" if absent
+	Realm       string // "" if absent
+}
+
+// ParseSPN parses and validates each component of an SPN. Service classes
+// are matched case-insensitively by AD, so their case is left as given.
+func ParseSPN(s string) (SPN, error) {
+	var spn SPN
+	name, realm, hasRealm := strings.Cut(s, "@")
+	if hasRealm {
+		if realm == "" {
+			return SPN{}, fmt.Errorf("invalid SPN %q: empty realm", s)
+		}
+		spn.Realm = realm
+	}
+
+	parts := strings.Split(name, "/")
+	if len(parts) != 2 && len(parts) != 3 {
+		return SPN{}, fmt.Errorf("invalid SPN %q: want service/host[:port][/service-name]", s)
+	}
+	spn.Service = parts[0]
+	if !spnServiceRe.MatchString(spn.Service) {
+		return SPN{}, fmt.Errorf("invalid SPN %q: bad service class %q", s, spn.Service)
+	}
+
+	host, port, hasPort := strings.Cut(parts[1], ":")
+	if !spnHostRe.MatchString(host) {
+		return SPN{}, fmt.Errorf("invalid SPN %q: bad host %q", s, host)
+	}
+	spn.Host = host
+	if hasPort {
+		if n, err := strconv.Atoi(port); err == nil {
+			if n < 1 || n > 65535 {
+				return SPN{}, fmt.Errorf("invalid SPN %q: port %d out of range", s, n)
+			}
+		} else if !spnInstanceRe.MatchString(port) {
+			return SPN{}, fmt.Errorf("invalid SPN %q: bad port or instance name %q", s, port)
+		}
+		spn.Port = port
+	}
+
+	if len(parts) == 3 {
+		if !spnNameRe.MatchString(parts[2]) {
+			return SPN{}, fmt.Errorf("invalid SPN %q: bad service name %q", s, parts[2])
+		}
+		spn.ServiceName = parts[2]
+	}
+	return spn, nil
+}
+
+// Principal returns the SPN without the realm, as its keytab principal
+// components joined by "/"
+func (s SPN) Principal() string {
+	return strings.Join(s.Components(), "/")
+}
+
+// Components returns the principal name components of the SPN
+func (s SPN) Components() []string {
+	instance := s.Host
+	if s.Port != "" {
+		instance += ":" + s.Port
+	}
+	components := []string{s.Service, instance}
+	if s.ServiceName != "" {
+		components = append(components, s.ServiceName)
+	}
+	return components
+}
@@ -0,0 +1,95 @@
+package kerb
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+)
+
+func TestParseSPN(t *testing.T) {
+	tests := []struct {
+		spn     string
+		want    SPN
+		wantErr bool
+	}{
+		{spn: "HTTP/vault.example.com", want: SPN{Service: "HTTP", Host: "vault.example.com"}},
+		{spn: "MSSQLSvc/db.example.com:1433", want: SPN{Service: "MSSQLSvc", Host: "db.example.com", Port: "1433"}},
+		{spn: "MSSQLSvc/db.example.com:SQLEXPRESS", want: SPN{Service: "MSSQLSvc", Host: "db.example.com", Port: "SQLEXPRESS"}},
+		{spn: "CIFS/fs01.example.com@EXAMPLE.COM", want: SPN{Service: "CIFS", Host: "fs01.example.com", Realm: "EXAMPLE.COM"}},
+		{spn: "ldap/dc1.example.com/example.com", want: SPN{Service: "ldap", Host: "dc1.example.com", ServiceName: "example.com"}},
+		{spn: "Acme-Svc/app.example.com:8443/tenant_1", want: SPN{Service: "Acme-Svc", Host: "app.example.com", Port: "8443", ServiceName: "tenant_1"}},
+		{spn: "HTTP", wantErr: true},
+		{spn: "HTTP/", wantErr: true},
+		{spn: "/vault.example.com", wantErr: true},
+		{spn: "1HTTP/vault.example.com", wantErr: true},
+		{spn: "HTTP/vault..example.com", wantErr: true},
+		{spn: "HTTP/vault.example.com:", wantErr: true},
+		{spn: "HTTP/vault.example.com:0", wantErr: true},
+		{spn: "HTTP/vault.example.com:65536", wantErr: true},
+		{spn: "HTTP/vault.example.com@", wantErr: true},
+		{spn: "ldap/dc1.example.com/", wantErr: true},
+		{spn: "a/b/c/d", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spn, func(t *testing.T) {
+			got, err := ParseSPN(tt.spn)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseSPN() = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseSPN() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSPNComponents(t *testing.T) {
+	tests := []struct {
+		spn  string
+		want []string
+	}{
+		{"HTTP/vault.example.com@EXAMPLE.COM", []string{"HTTP", "vault.example.com"}},
+		{"MSSQLSvc/db.example.com:1433", []string{"MSSQLSvc", "db.example.com:1433"}},
+		{"ldap/dc1.example.com/example.com", []string{"ldap", "dc1.example.com", "example.com"}},
+	}
+	for _, tt := range tests {
+		spn, err := ParseSPN(tt.spn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := spn.Components(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Components() = %v, want %v", tt.spn, got, tt.want)
+		}
+	}
+}
+
+func TestExtractServiceKey_NonHTTPSPNs(t *testing.T) {
+	tests := []struct {
+		spn     string
+		partial string // Same service and host, different principal
+	}{
+		{"MSSQLSvc/db.test.com:1433", "MSSQLSvc/db.test.com"},
+		{"CIFS/fs01.test.com", "CIFS/fs01.test.com/test.com"},
+		{"ldap/dc1.test.com/test.com", "ldap/dc1.test.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spn, func(t *testing.T) {
+			kt := createTestKeytabFor(tt.spn, "TEST.COM")
+			key, err := extractServiceKey(kt, tt.spn+"@TEST.COM", "TEST.COM", etypeID.AES256_CTS_HMAC_SHA1_96)
+			if err != nil || len(key) == 0 {
+				t.Fatalf("extractServiceKey() = %x, %v", key, err)
+			}
+			if _, err := extractServiceKey(kt, tt.partial, "TEST.COM", etypeID.AES256_CTS_HMAC_SHA1_96); err == nil {
+				t.Errorf("extractServiceKey(%s) found a key for %s", tt.partial, tt.spn)
+			}
+		})
+	}
+}
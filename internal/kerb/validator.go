@@ -3,42 +3,122 @@ package kerb
 import (
 	"context"
 	"encoding/base64"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"maps"
+	"strings"
+	"time"
 
+	"github.com/jcmturner/gofork/encoding/asn1"
 	"github.com/jcmturner/goidentity/v6"
+	"github.com/jcmturner/gokrb5/v8/config"
 	"github.com/jcmturner/gokrb5/v8/credentials"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/adtype"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/flags"
 	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/pac"
+	"github.com/jcmturner/gokrb5/v8/service"
 	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Context key constants for accessing SPNEGO context data
 // These are copied from the gokrb5 spnego package since they're not exported
-type ctxKey string
-
 const (
 	// CTXKeyCredentials is the request context key holding the credentials
-	// This key is used to access Kerberos credentials from the SPNEGO context
-	CTXKeyCredentials ctxKey = "github.com/jcmturner/gokrb5/CTXKeyCredentials"
+	// This key is used to access Kerberos credentials from the SPNEGO context.
+	// gokrb5 stores it as an untyped string constant, so it must stay untyped
+	// here for context lookups to match.
+	CTXKeyCredentials = "github.com/jcmturner/gokrb5/v8/ctxCredentials"
 )
 
+// tracerName identifies spans started by the validator
+const tracerName = "github.com/lpassig/vault-plugin-auth-gmsa/internal/kerb"
+
+// tracer returns a tracer from the provider of the span in ctx, so validator
+// spans nest under the caller's span and are no-ops when the caller traces
+// nothing
+func tracer(ctx context.Context) trace.Tracer {
+	return trace.SpanFromContext(ctx).TracerProvider().Tracer(tracerName)
+}
+
+// defaultClockSkew is used when Options.ClockSkewSec is not set
+const defaultClockSkew = 300 * time.Second
+
 // ValidationResult contains the result of SPNEGO validation
 // This is a minimal, no-cycle result used by the backend for authorization
 type ValidationResult struct {
-	Principal string          // Authenticated principal name
-	Realm     string          // Kerberos realm
-	SPN       string          // Service Principal Name used
-	GroupSIDs []string        // Extracted group SIDs from PAC
-	Flags     map[string]bool // Validation flags for audit logging
+	Principal         string          // Authenticated principal name
+	Realm             string          // Kerberos realm
+	SPN               string          // Service Principal Name used
+	TicketRealm       string          // Realm of the service the ticket was issued for
+	GroupSIDs         []string        // Extracted group SIDs from PAC
+	ResourceGroupSIDs []string        // Subset of GroupSIDs contributed by the user's resource domain
+	SIDHistorySIDs    []string        // Subset of GroupSIDs from other domains' ExtraSIDs (likely SID history)
+	Flags             map[string]bool // Validation flags for audit logging
+	LogonServer       string          // Domain controller that authenticated the user, from the PAC
+	TicketEndTime     time.Time       // When the accepted service ticket expires (zero if unknown)
+	TicketKVNO        int             // Key version number the service ticket was encrypted with
+	UserSID           string          // User's SID from the PAC ("" without a PAC)
+	UPN               string          // User principal name from the PAC UPN_DNS_INFO buffer
+}
+
+// PACValidationFlags lists every flag ValidateSPNEGO can set in
+// ValidationResult.Flags
+var PACValidationFlags = []string{
+	"ACCEPTED", "PREVIOUS_KEYTAB", "ENCTYPE_DOWNGRADE", "TICKET_INITIAL",
+	"PAC_SKIPPED", "PAC_NOT_FOUND", "PAC_VALIDATED", "PAC_VALIDATION_FAILED", "PAC_ERROR", "PAC_CACHE_HIT",
+	"SIGNATURES_VALID", "CLOCK_SKEW_VALID", "LOGON_TIME_SKEW_IGNORED", "PAC_NO_GROUPS",
+	"UPN_DNS_INFO_PRESENT", "UPN_CONSISTENT", "CROSS_REALM",
+	"IS_MACHINE_ACCOUNT", "ACCOUNT_TYPE_UNKNOWN", "UNKNOWN_PAC_BUFFER",
 }
 
 // Options contains configuration options for the Kerberos validator
 type Options struct {
 	Realm        string // Kerberos realm
 	SPN          string // Service Principal Name
-	ClockSkewSec int    // Allowed clock skew in seconds
+	ClockSkewSec int    // Allowed clock skew in seconds (0 uses the 300s default)
 	RequireCB    bool   // Require TLS channel binding
 	KeytabB64    string // Base64-encoded keytab
+	// PreviousKeytabB64 is the keytab replaced by rotation, tried when the
+	// current keytab cannot accept the ticket during the grace window
+	PreviousKeytabB64 string
+	// AdditionalKeytabsB64 are merged into the keytab so tokens for SPNs
+	// exported separately validate against the combined key set
+	AdditionalKeytabsB64 []string
+	// RequireUPNMatch requires the PAC UPN to name the logon user
+	RequireUPNMatch bool
+	// RequireAESPACSignatures fails PAC validation when either PAC
+	// signature isn't HMAC-SHA1-96-AES, e.g. RC4 HMAC-MD5
+	RequireAESPACSignatures bool
+	// PACCache, when set, caches PAC validation results per ticket
+	PACCache *PACCache
+	// AllowedMechOIDs lists the GSS mechanism OIDs (dotted form) a token must
+	// offer at least one of; empty accepts any mechanism gokrb5 supports
+	AllowedMechOIDs []string
+	// Krb5Conf holds operator krb5.conf tunables; the acceptor honours
+	// libdefaults permitted_enctypes for incoming tickets
+	Krb5Conf *config.Config
+	// RejectEnctypeDowngrade rejects tickets encrypted with a weaker enctype
+	// than the strongest key the keytab holds for the SPN, instead of only
+	// flagging ENCTYPE_DOWNGRADE
+	RejectEnctypeDowngrade bool
+	// IgnorePACLogonTimeSkew skips the PAC logon time skew check; the
+	// authenticator time is still held to ClockSkew
+	IgnorePACLogonTimeSkew bool
+	// SkipPAC skips PAC decoding and validation entirely. Results carry no
+	// group SIDs, user SID, UPN or logon server and are flagged PAC_SKIPPED.
+	SkipPAC bool
+	// SkipReplayCache accepts tickets without recording the authenticator
+	// in gokrb5's process-wide replay cache, so checking a token leaves it
+	// usable for a login. Validators that log users in must not set it.
+	SkipReplayCache bool
 }
 
 // Validator handles SPNEGO token validation and PAC extraction
@@ -51,6 +131,15 @@ func NewValidator(opt Options) *Validator {
 	return &Validator{opt: opt}
 }
 
+// ClockSkew returns the clock skew tolerance shared by the gokrb5
+// authenticator check and PAC logon time validation
+func (o Options) ClockSkew() time.Duration {
+	if o.ClockSkewSec <= 0 {
+		return defaultClockSkew
+	}
+	return time.Duration(o.ClockSkewSec) * time.Second
+}
+
 // AuthError represents structured authentication errors
 type AuthError struct {
 	Code    string `json:"code"`
@@ -73,6 +162,7 @@ const (
 	ErrCodeMissingChannelBind = "MISSING_CHANNEL_BINDING"
 	ErrCodeInvalidKeytab      = "INVALID_KEYTAB"
 	ErrCodeKerberosFailed     = "KERBEROS_NEGOTIATION_FAILED"
+	ErrCodeEnctypeDowngrade   = "ENCTYPE_DOWNGRADE"
 	ErrCodePACValidation      = "PAC_VALIDATION_FAILED"
 	ErrCodeClockSkew          = "CLOCK_SKEW_EXCEEDED"
 	ErrCodeInvalidInput       = "INVALID_INPUT"
@@ -96,6 +186,16 @@ func (e safeErr) Error() string       { return e.err.Error() }
 func (e safeErr) SafeMessage() string { return e.msg }
 func (e safeErr) IsZero() bool        { return e.err == nil && e.msg == "" }
 
+// Code returns the AuthError code of the wrapped error, or "" if the error
+// is not an AuthError
+func (e safeErr) Code() string {
+	var ae *AuthError
+	if errors.As(e.err, &ae) {
+		return ae.Code
+	}
+	return ""
+}
+
 // fail creates a safeErr with the given error and safe message
 func fail(err error, msg string) safeErr { return safeErr{err: err, msg: msg} }
 
@@ -123,9 +223,21 @@ func (v *Validator) ValidateSPNEGO(ctx context.Context, spnegoB64, channelBind s
 	if err := kt.Unmarshal(ktRaw); err != nil {
 		return nil, fail(newAuthError(ErrCodeInvalidKeytab, "failed to parse keytab", err), "failed to parse keytab")
 	}
+	for _, extraB64 := range v.opt.AdditionalKeytabsB64 {
+		extra, err := parseKeytab(extraB64)
+		if err != nil {
+			return nil, fail(newAuthError(ErrCodeInvalidKeytab, "failed to parse additional keytab", err), "failed to parse additional keytab")
+		}
+		kt.Entries = append(kt.Entries, extra.Entries...)
+	}
 
-	// Create SPNEGO service using the loaded keytab
-	service := spnego.SPNEGOService(kt)
+	// Create SPNEGO service using the loaded keytab. The authenticator and
+	// ticket validity checks use the same skew as PAC validation below.
+	clockSkew := v.opt.ClockSkew()
+	settings := []func(*service.Settings){service.MaxClockSkew(clockSkew)}
+	if v.opt.SkipPAC {
+		settings = append(settings, service.DecodePAC(false))
+	}
 
 	// Parse and validate the SPNEGO token
 	var token spnego.SPNEGOToken
@@ -133,8 +245,47 @@ func (v *Validator) ValidateSPNEGO(ctx context.Context, spnegoB64, channelBind s
 		return nil, fail(newAuthError(ErrCodeInvalidSPNEGO, "spnego token unmarshal failed", err), "spnego token unmarshal failed")
 	}
 
+	// Reject tokens that offer none of the accepted GSS mechanisms
+	if len(v.opt.AllowedMechOIDs) > 0 && !offersAllowedMech(&token, v.opt.AllowedMechOIDs) {
+		return nil, fail(newAuthError(ErrCodeInvalidSPNEGO, "token offers no accepted mechanism", nil), "spnego token offers no accepted mechanism")
+	}
+
+	// Reject tickets encrypted with an enctype the krb5.conf doesn't permit
+	if v.opt.Krb5Conf != nil {
+		if etype, ok := ticketEType(&token); ok && !enctypePermitted(v.opt.Krb5Conf, etype) {
+			return nil, fail(newAuthError(ErrCodeKerberosFailed, fmt.Sprintf("ticket enctype %d not permitted", etype), nil), "ticket encryption type not permitted")
+		}
+	}
+
+	// A ticket weaker than the keytab's best key for the SPN may mean an
+	// attacker forced RC4
+	downgrade := enctypeDowngraded(kt, &token)
+	if downgrade && v.opt.RejectEnctypeDowngrade {
+		return nil, fail(newAuthError(ErrCodeEnctypeDowngrade, "ticket enctype weaker than keytab", nil), "ticket encryption type downgrade rejected")
+	}
+
 	// Accept the security context (this performs Kerberos validation)
-	ok, spnegoCtx, status := service.AcceptSecContext(&token)
+	_, acceptSpan := tracer(ctx).Start(ctx, "gmsa.accept_sec_context")
+	if etype, ok := ticketEType(&token); ok {
+		acceptSpan.SetAttributes(attribute.Int("gmsa.enctype", int(etype)))
+	}
+	ok, spnegoCtx, status := v.accept(&token, kt, settings)
+	usedPrevious := false
+	if !ok && v.opt.PreviousKeytabB64 != "" {
+		// Tickets issued before a rotation are encrypted to the old key.
+		// A failed decrypt never reaches the replay cache, so retrying is safe.
+		// The token caches its mech token settings, so retry on a fresh copy.
+		var retry spnego.SPNEGOToken
+		if prevKT, err := parseKeytab(v.opt.PreviousKeytabB64); err == nil && retry.Unmarshal(spnegoBytes) == nil {
+			if prevOK, prevCtx, prevStatus := v.accept(&retry, prevKT, settings); prevOK {
+				ok, spnegoCtx, status = prevOK, prevCtx, prevStatus
+				kt = prevKT
+				usedPrevious = true
+			}
+		}
+	}
+	acceptSpan.SetAttributes(attribute.Bool("gmsa.accepted", ok), attribute.Bool("gmsa.previous_keytab", usedPrevious))
+	acceptSpan.End()
 	if !ok {
 		return nil, fail(newAuthError(ErrCodeKerberosFailed, "kerberos negotiation failed", status), "kerberos negotiation failed")
 	}
@@ -142,8 +293,13 @@ func (v *Validator) ValidateSPNEGO(ctx context.Context, spnegoB64, channelBind s
 	// Extract identity from context
 	principal := ""
 	realm := v.opt.Realm
-	if v := spnegoCtx.Value(goidentity.CTXKey); v != nil {
-		if id, ok := v.(goidentity.Identity); ok {
+	var clientName, clientRealm string
+	idValue := spnegoCtx.Value(CTXKeyCredentials)
+	if idValue == nil {
+		idValue = spnegoCtx.Value(goidentity.CTXKey)
+	}
+	if idValue != nil {
+		if id, ok := idValue.(goidentity.Identity); ok {
 			user := id.UserName()
 			dom := id.Domain()
 			if dom != "" {
@@ -157,71 +313,591 @@ func (v *Validator) ValidateSPNEGO(ctx context.Context, spnegoB64, channelBind s
 	if principal == "" {
 		return nil, fail(errors.New("no identity in context"), "kerberos auth succeeded but no identity extracted")
 	}
+	// gokrb5 renames the user after the PAC's EffectiveName; the ticket's
+	// client name is kept in the credentials' CName
+	if creds, ok := idValue.(*credentials.Credentials); ok {
+		clientName, clientRealm = creds.CName().PrincipalNameString(), creds.Realm()
+	}
+
+	// Report the SPN the client actually targeted so role SPN scoping works
+	// with multi-SPN keytabs; fall back to the configured SPN
+	spn := ticketSPN(&token)
+	if spn == "" {
+		spn = v.opt.SPN
+	}
 
 	// Extract PAC from SPNEGO context and validate it
-	var groupSIDs []string
+	pacSkewSec := int(clockSkew / time.Second)
+	if v.opt.IgnorePACLogonTimeSkew {
+		pacSkewSec = NoLogonTimeSkewCheck
+	}
 	var pacFlags map[string]bool = map[string]bool{"ACCEPTED": true}
+	if usedPrevious {
+		pacFlags["PREVIOUS_KEYTAB"] = true
+	}
+	if downgrade {
+		pacFlags["ENCTYPE_DOWNGRADE"] = true
+	}
 
-	// Try to extract PAC data from the SPNEGO context
-	if pacData := extractPACFromContext(spnegoCtx); pacData != nil {
-		// Check if this is our placeholder indicating PAC was found in context
-		if string(pacData) == "PAC_FOUND_IN_CONTEXT" {
-			// Extract group SIDs directly from credentials in context
-			groupSIDs = extractGroupSIDsFromContext(spnegoCtx)
-			if len(groupSIDs) > 0 {
-				pacFlags["PAC_VALIDATED"] = true
-				pacFlags["SIGNATURES_VALID"] = true // gokrb5 already validated signatures
-				pacFlags["CLOCK_SKEW_VALID"] = true // gokrb5 already validated clock skew
-				pacFlags["UPN_CONSISTENT"] = true   // gokrb5 already validated UPN consistency
-			} else {
-				pacFlags["PAC_NO_GROUPS"] = true
-			}
-		} else {
-			// Load keytab for PAC validation of raw PAC data
-			ktRaw, err := base64.StdEncoding.DecodeString(v.opt.KeytabB64)
-			if err == nil {
-				kt := &keytab.Keytab{}
-				if err := kt.Unmarshal(ktRaw); err == nil {
-					// Validate PAC and extract group SIDs
-					pacResult, pacErr := ExtractGroupSIDsFromPAC(pacData, kt, v.opt.SPN, v.opt.Realm, v.opt.ClockSkewSec)
-					if pacErr == nil && pacResult.Valid {
-						groupSIDs = pacResult.GroupSIDs
-						pacFlags["PAC_VALIDATED"] = true
-						pacFlags["SIGNATURES_VALID"] = pacResult.ValidationFlags["SIGNATURES_VALID"]
-						pacFlags["CLOCK_SKEW_VALID"] = pacResult.ValidationFlags["CLOCK_SKEW_VALID"]
-						pacFlags["UPN_CONSISTENT"] = pacResult.ValidationFlags["UPN_CONSISTENT"]
-
-						// Use PAC principal if available and more authoritative
-						if pacResult.Principal != "" {
-							principal = pacResult.Principal
-						}
-						if pacResult.Realm != "" {
-							realm = pacResult.Realm
-						}
-					} else {
-						// PAC validation failed, but we can still proceed with basic auth
-						pacFlags["PAC_VALIDATION_FAILED"] = true
-						if pacErr != nil {
-							pacFlags["PAC_ERROR"] = true
-						}
-					}
-				}
-			}
+	_, pacSpan := tracer(ctx).Start(ctx, "gmsa.pac_validation")
+	validate := func() (*PACValidationResult, error) {
+		return v.ticketPACResult(spnegoCtx, &token, kt, spn, clientName, clientRealm, pacSkewSec)
+	}
+	var pacResult *PACValidationResult
+	var pacErr error
+	var cacheKey string
+	if v.opt.PACCache != nil && !v.opt.SkipPAC {
+		cacheKey = v.pacCacheKey(&token, usedPrevious, spn, pacSkewSec)
+	}
+	if cacheKey != "" {
+		// Repeated logins with the same ticket reuse the cached result
+		// until the ticket ends, without decrypting the ticket again
+		var hit bool
+		pacResult, hit, pacErr = v.opt.PACCache.Validate(cacheKey, ticketEndTime(spnegoCtx), validate)
+		if hit {
+			pacFlags["PAC_CACHE_HIT"] = true
 		}
 	} else {
-		pacFlags["PAC_NOT_FOUND"] = true
+		pacResult, pacErr = validate()
+	}
+	maps.Copy(pacFlags, pacResult.ValidationFlags)
+	pacSpan.SetAttributes(
+		attribute.Bool("gmsa.pac.found", !pacFlags["PAC_NOT_FOUND"] && !pacFlags["PAC_SKIPPED"]),
+		attribute.Bool("gmsa.pac.skipped", pacFlags["PAC_SKIPPED"]),
+		attribute.Bool("gmsa.pac.validated", pacFlags["PAC_VALIDATED"]),
+		attribute.Bool("gmsa.pac.cache_hit", pacFlags["PAC_CACHE_HIT"]),
+	)
+	pacSpan.End()
+	if pacErr != nil {
+		return nil, fail(newAuthError(ErrCodePACValidation, "PAC UPN does not match the ticket client", pacErr), "PAC UPN does not match the ticket client")
+	}
+
+	// Use PAC principal if available and more authoritative
+	if pacResult.Principal != "" {
+		principal = pacResult.Principal
+	}
+	if pacResult.Realm != "" {
+		realm = pacResult.Realm
 	}
 
 	res := &ValidationResult{
-		Principal: principal,
-		Realm:     realm,
-		SPN:       v.opt.SPN,
-		GroupSIDs: groupSIDs,
-		Flags:     pacFlags,
+		Principal:         principal,
+		Realm:             realm,
+		SPN:               spn,
+		TicketRealm:       ticketRealm(&token),
+		GroupSIDs:         pacResult.GroupSIDs,
+		ResourceGroupSIDs: pacResult.ResourceGroupSIDs,
+		SIDHistorySIDs:    pacResult.SIDHistorySIDs,
+		Flags:             pacFlags,
+		LogonServer:       pacResult.LogonServer,
+		UserSID:           pacResult.UserSID,
+		UPN:               pacResult.UPN,
+		TicketEndTime:     ticketEndTime(spnegoCtx),
+		TicketKVNO:        ticketKVNO(&token),
 	}
 	return res, safeErr{}
 }
 
+// accept accepts the security context of token with kt. With
+// SkipReplayCache it verifies the AP_REQ as gokrb5's AcceptSecContext does,
+// minus the replay check: gokrb5 records every authenticator it checks in a
+// process-wide cache and offers no setting to skip it.
+func (v *Validator) accept(token *spnego.SPNEGOToken, kt *keytab.Keytab, settings []func(*service.Settings)) (bool, context.Context, gssapi.Status) {
+	if !v.opt.SkipReplayCache {
+		return spnego.SPNEGOService(kt, settings...).AcceptSecContext(token)
+	}
+	// Like AcceptSecContext, the first mechanism offered must be Kerberos
+	mt, ok := krb5MechToken(token)
+	if ok {
+		mechs := token.NegTokenInit.MechTypes
+		ok = len(mechs) > 0 && (mechs[0].Equal(gssapi.OIDKRB5.OID()) || mechs[0].Equal(gssapi.OIDMSLegacyKRB5.OID()))
+	}
+	if !ok {
+		return false, nil, gssapi.Status{Code: gssapi.StatusDefectiveToken, Message: "SPNEGO token carries no KRB5 AP_REQ"}
+	}
+	s := service.NewSettings(kt, settings...)
+	if ok, err := mt.APReq.Verify(kt, s.MaxClockSkew(), s.ClientAddress(), s.KeytabPrincipal()); err != nil || !ok {
+		msg := "KRB5_AP_REQ token not valid"
+		if err != nil {
+			msg = err.Error()
+		}
+		return false, nil, gssapi.Status{Code: gssapi.StatusDefectiveCredential, Message: msg}
+	}
+
+	creds := credentials.NewFromPrincipalName(mt.APReq.Authenticator.CName, mt.APReq.Authenticator.CRealm)
+	creds.SetAuthTime(time.Now().UTC())
+	creds.SetAuthenticated(true)
+	creds.SetValidUntil(mt.APReq.Ticket.DecryptedEncPart.EndTime)
+	if s.DecodePAC() {
+		isPAC, p, err := mt.APReq.Ticket.GetPACType(kt, s.KeytabPrincipal(), s.Logger())
+		if isPAC && err != nil {
+			return false, nil, gssapi.Status{Code: gssapi.StatusDefectiveToken, Message: err.Error()}
+		}
+		if isPAC {
+			kvi := p.KerbValidationInfo
+			creds.SetADCredentials(credentials.ADCredentials{
+				GroupMembershipSIDs: kvi.GetGroupMembershipSIDs(),
+				LogOnTime:           kvi.LogOnTime.Time(),
+				LogOffTime:          kvi.LogOffTime.Time(),
+				PasswordLastSet:     kvi.PasswordLastSet.Time(),
+				EffectiveName:       kvi.EffectiveName.Value,
+				FullName:            kvi.FullName.Value,
+				UserID:              int(kvi.UserID),
+				PrimaryGroupID:      int(kvi.PrimaryGroupID),
+				LogonServer:         kvi.LogonServer.Value,
+				LogonDomainName:     kvi.LogonDomainName.Value,
+				LogonDomainID:       kvi.LogonDomainID.String(),
+			})
+		}
+	}
+	return true, context.WithValue(context.Background(), CTXKeyCredentials, creds), gssapi.Status{Code: gssapi.StatusComplete}
+}
+
+// ticketPACResult decrypts the accepted ticket and derives its PAC data and
+// validation flags. Everything it reports is fixed for the ticket's
+// lifetime, so valid results may be cached per ticket. It fails only when
+// the PAC must reject the login; other PAC problems are reported as flags.
+func (v *Validator) ticketPACResult(spnegoCtx context.Context, token *spnego.SPNEGOToken, kt *keytab.Keytab, spn, clientName, clientRealm string, pacSkewSec int) (*PACValidationResult, error) {
+	res := &PACValidationResult{ValidationFlags: map[string]bool{}}
+	pacFlags := res.ValidationFlags
+
+	// Decrypt the accepted ticket once for the flag and PAC buffer checks
+	ticket, _ := decryptTicket(token, kt)
+	if ticket == nil {
+		ticket = &decryptedTicket{}
+	}
+	if ticket.initial() {
+		pacFlags["TICKET_INITIAL"] = true
+	}
+
+	if v.opt.SkipPAC {
+		pacFlags["PAC_SKIPPED"] = true
+		return res, nil
+	}
+	pacData := extractPACFromContext(spnegoCtx)
+	if pacData == nil {
+		pacFlags["PAC_NOT_FOUND"] = true
+		return res, nil
+	}
+	// Anything but our placeholder is a raw PAC to validate ourselves
+	if string(pacData) != "PAC_FOUND_IN_CONTEXT" {
+		v.rawPACResult(res, pacData, kt, spn, pacSkewSec)
+		return res, nil
+	}
+	if v.opt.RequireAESPACSignatures && !ticket.pacSignaturesAES() {
+		// gokrb5 verified the signatures, but it also accepts RC4 HMAC-MD5
+		pacFlags["PAC_VALIDATION_FAILED"] = true
+		pacFlags["PAC_ERROR"] = true
+		return res, nil
+	}
+
+	// Extract group SIDs directly from credentials in context
+	res.Valid = true
+	res.GroupSIDs = extractGroupSIDsFromContext(spnegoCtx)
+	res.LogonServer = logonServerFromContext(spnegoCtx)
+	if len(res.GroupSIDs) > 0 {
+		pacFlags["PAC_VALIDATED"] = true
+		pacFlags["SIGNATURES_VALID"] = true // gokrb5 already validated signatures
+		pacFlags["CLOCK_SKEW_VALID"] = true // gokrb5 already validated clock skew
+	} else {
+		pacFlags["PAC_NO_GROUPS"] = true
+	}
+	res.UserSID = userSIDFromContext(spnegoCtx)
+	var upnErr error
+	if buf, ok := ticket.pacBuffer(PAC_UPN_DNS_INFO); ok {
+		pacFlags["UPN_DNS_INFO_PRESENT"] = true
+		res.UPN = upnFromBuffer(buf)
+		// gokrb5 doesn't compare the UPN with the ticket client
+		upnErr = upnConsistentWithClient(buf, clientName, clientRealm, v.opt.RequireUPNMatch)
+		pacFlags["UPN_CONSISTENT"] = upnErr == nil
+	}
+	// The client realm is the user's home realm, which differs from the
+	// service realm for users from trusted domains
+	if clientRealm != "" && !strings.EqualFold(clientRealm, v.opt.Realm) {
+		pacFlags["CROSS_REALM"] = true
+	}
+	if info, err := ticket.logonInfo(); err == nil {
+		res.SIDHistorySIDs = sidHistoryFromLogonInfo(info)
+		res.ResourceGroupSIDs = resourceGroupSIDsFromLogonInfo(info)
+	}
+	if machine, err := ticket.machineAccount(); err != nil {
+		pacFlags["ACCOUNT_TYPE_UNKNOWN"] = true
+	} else if machine {
+		pacFlags["IS_MACHINE_ACCOUNT"] = true
+	}
+	if ticket.pacHasUnknownBuffer() {
+		pacFlags["UNKNOWN_PAC_BUFFER"] = true
+	}
+	if upnErr != nil && v.opt.RequireUPNMatch {
+		return res, upnErr
+	}
+	return res, nil
+}
+
+// rawPACResult validates a raw PAC with the keytab that accepted the ticket
+// and records the outcome in res
+func (v *Validator) rawPACResult(res *PACValidationResult, pacData []byte, kt *keytab.Keytab, spn string, pacSkewSec int) {
+	pacFlags := res.ValidationFlags
+	pacResult, pacErr := ExtractGroupSIDsFromPAC(pacData, kt, spn, v.opt.Realm, pacSkewSec, v.opt.RequireUPNMatch, v.opt.RequireAESPACSignatures)
+	if pacErr != nil || !pacResult.Valid {
+		// PAC validation failed, but we can still proceed with basic auth
+		pacFlags["PAC_VALIDATION_FAILED"] = true
+		if pacErr != nil {
+			pacFlags["PAC_ERROR"] = true
+		}
+		return
+	}
+	res.Valid = true
+	res.GroupSIDs = pacResult.GroupSIDs
+	res.ResourceGroupSIDs = pacResult.ResourceGroupSIDs
+	res.SIDHistorySIDs = pacResult.SIDHistorySIDs
+	res.LogonServer = pacResult.LogonServer
+	res.UserSID = pacResult.UserSID
+	res.UPN = pacResult.UPN
+	res.Principal = pacResult.Principal
+	res.Realm = pacResult.Realm
+	pacFlags["PAC_VALIDATED"] = true
+	pacFlags["SIGNATURES_VALID"] = pacResult.ValidationFlags["SIGNATURES_VALID"]
+	pacFlags["CLOCK_SKEW_VALID"] = pacResult.ValidationFlags["CLOCK_SKEW_VALID"]
+	pacFlags["UPN_CONSISTENT"] = pacResult.ValidationFlags["UPN_CONSISTENT"]
+	for _, flag := range []string{"LOGON_TIME_SKEW_IGNORED", "CROSS_REALM", "UPN_DNS_INFO_PRESENT", "IS_MACHINE_ACCOUNT"} {
+		if pacResult.ValidationFlags[flag] {
+			pacFlags[flag] = true
+		}
+	}
+	if pacResult.ValidationFlags["UNKNOWN_BUFFER_TYPE"] {
+		pacFlags["UNKNOWN_PAC_BUFFER"] = true
+	}
+}
+
+// pacCacheKey returns the PAC cache key of the accepted ticket: a hash of its
+// encrypted part, which is fixed for the ticket's lifetime, the keytabs that
+// could have accepted it and the options that affect its PAC result, or ""
+// when the token carries no ticket to key on
+func (v *Validator) pacCacheKey(token *spnego.SPNEGOToken, usedPrevious bool, spn string, pacSkewSec int) string {
+	mt, ok := krb5MechToken(token)
+	if !ok || len(mt.APReq.Ticket.EncPart.Cipher) == 0 {
+		return ""
+	}
+	scope := PACCacheScope(append([]string{v.opt.KeytabB64}, v.opt.AdditionalKeytabsB64...)...)
+	if usedPrevious {
+		scope = PACCacheScope(v.opt.PreviousKeytabB64)
+	}
+	return pacCacheKey(mt.APReq.Ticket.EncPart.Cipher, scope, spn, v.opt.Realm, pacSkewSec, v.opt.RequireUPNMatch, v.opt.RequireAESPACSignatures)
+}
+
+// AcceptCompletedToken returns a base64 SPNEGO NegTokenResp signalling that
+// the security context was accepted, for use in a WWW-Authenticate header
+func AcceptCompletedToken() (string, error) {
+	token := spnego.SPNEGOToken{
+		Resp: true,
+		NegTokenResp: spnego.NegTokenResp{
+			NegState:      asn1.Enumerated(spnego.NegStateAcceptCompleted),
+			SupportedMech: gssapi.OIDKRB5.OID(),
+		},
+	}
+	b, err := token.Marshal()
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+// ticketEndTime returns the end time of the accepted ticket, or the zero time
+// if the context carries no credentials
+func ticketEndTime(ctx context.Context) time.Time {
+	if creds, ok := ctx.Value(CTXKeyCredentials).(*credentials.Credentials); ok {
+		return creds.ValidUntil()
+	}
+	return time.Time{}
+}
+
+// parseKeytab decodes and parses a base64-encoded keytab
+func parseKeytab(keytabB64 string) (*keytab.Keytab, error) {
+	ktRaw, err := base64.StdEncoding.DecodeString(keytabB64)
+	if err != nil {
+		return nil, err
+	}
+	kt := &keytab.Keytab{}
+	if err := kt.Unmarshal(ktRaw); err != nil {
+		return nil, err
+	}
+	return kt, nil
+}
+
+// ticketSPN returns the service principal name from the ticket in the
+// token's KRB5 AP_REQ, or "" if it cannot be determined
+func ticketSPN(token *spnego.SPNEGOToken) string {
+	mt, ok := krb5MechToken(token)
+	if !ok {
+		return ""
+	}
+	return mt.APReq.Ticket.SName.PrincipalNameString()
+}
+
+// ticketRealm returns the service realm of the ticket in the token's KRB5
+// AP_REQ, or "" if it cannot be determined
+func ticketRealm(token *spnego.SPNEGOToken) string {
+	mt, ok := krb5MechToken(token)
+	if !ok {
+		return ""
+	}
+	return mt.APReq.Ticket.Realm
+}
+
+// ticketEType returns the encryption type of the service ticket in the
+// SPNEGO token; it is readable before the ticket is decrypted
+func ticketEType(token *spnego.SPNEGOToken) (int32, bool) {
+	mt, ok := krb5MechToken(token)
+	if !ok {
+		return 0, false
+	}
+	return mt.APReq.Ticket.EncPart.EType, true
+}
+
+// ticketKVNO returns the key version number of the service ticket in the
+// SPNEGO token, or 0 if it cannot be determined
+func ticketKVNO(token *spnego.SPNEGOToken) int {
+	mt, ok := krb5MechToken(token)
+	if !ok {
+		return 0
+	}
+	return mt.APReq.Ticket.EncPart.KVNO
+}
+
+// decryptedTicket holds the encrypted part of the accepted service ticket
+// and its PAC. gokrb5 keeps only the logon info of the PAC it verified, so
+// the ticket is decrypted once more after acceptance and every check reads
+// from this copy.
+type decryptedTicket struct {
+	encPart messages.EncTicketPart
+	pac     *pac.PACType // nil when the ticket carries no PAC
+
+	logon    *pac.KerbValidationInfo // Decoded by the first logonInfo call
+	logonErr error
+}
+
+// decryptTicket decrypts the service ticket in the token's KRB5 AP_REQ and
+// unmarshals its PAC. kt must be the keytab that accepted the ticket.
+func decryptTicket(token *spnego.SPNEGOToken, kt *keytab.Keytab) (*decryptedTicket, bool) {
+	mt, ok := krb5MechToken(token)
+	if !ok {
+		return nil, false
+	}
+	if err := mt.APReq.Ticket.DecryptEncPart(kt, nil); err != nil {
+		return nil, false
+	}
+	t := &decryptedTicket{encPart: mt.APReq.Ticket.DecryptedEncPart}
+	for _, ad := range t.encPart.AuthorizationData {
+		if ad.ADType != adtype.ADIfRelevant {
+			continue
+		}
+		var inner types.AuthorizationData
+		if err := inner.Unmarshal(ad.ADData); err != nil || len(inner) == 0 || inner[0].ADType != adtype.ADWin2KPAC {
+			continue
+		}
+		var p pac.PACType
+		if err := p.Unmarshal(inner[0].ADData); err == nil {
+			t.pac = &p
+		}
+		break
+	}
+	return t, true
+}
+
+// initial reports whether the ticket carries the INITIAL flag, i.e. it was
+// issued by an AS exchange rather than from a TGT
+func (t *decryptedTicket) initial() bool {
+	if len(t.encPart.Flags.Bytes) <= flags.Initial/8 {
+		return false
+	}
+	return types.IsFlagSet(&t.encPart.Flags, flags.Initial)
+}
+
+// pacBuffer returns the PAC buffer of the given type
+func (t *decryptedTicket) pacBuffer(bufType uint32) ([]byte, bool) {
+	if t.pac == nil {
+		return nil, false
+	}
+	for _, buf := range t.pac.Buffers {
+		end := buf.Offset + uint64(buf.CBBufferSize)
+		if buf.ULType == bufType && end <= uint64(len(t.pac.Data)) {
+			return t.pac.Data[buf.Offset:end], true
+		}
+	}
+	return nil, false
+}
+
+// pacHasUnknownBuffer reports whether the PAC carries a buffer type MS-PAC
+// doesn't define
+func (t *decryptedTicket) pacHasUnknownBuffer() bool {
+	if t.pac == nil {
+		return false
+	}
+	for _, buf := range t.pac.Buffers {
+		if !knownPACBufferType(buf.ULType) {
+			return true
+		}
+	}
+	return false
+}
+
+// upnFromBuffer returns the UPN from a PAC_UPN_DNS_INFO buffer, or "" if
+// it doesn't parse
+func upnFromBuffer(buf []byte) string {
+	var info pac.UPNDNSInfo
+	if err := info.Unmarshal(buf); err != nil {
+		return ""
+	}
+	return info.UPN
+}
+
+// upnConsistentWithClient applies validateUPNConsistency to a
+// PAC_UPN_DNS_INFO buffer and the ticket's client name and realm
+func upnConsistentWithClient(buf []byte, cname, crealm string, requireUserMatch bool) error {
+	var info pac.UPNDNSInfo
+	if err := info.Unmarshal(buf); err != nil {
+		return fmt.Errorf("%w: %v", ErrPACInvalidFormat, err)
+	}
+	return validateUPNConsistency(&LogonInfo{EffectiveName: cname}, &UPNInfo{UPN: info.UPN, DNSDomain: info.DNSDomain}, crealm, requireUserMatch)
+}
+
+// logonInfo returns the decoded PAC_LOGON_INFO buffer of the PAC
+func (t *decryptedTicket) logonInfo() (*pac.KerbValidationInfo, error) {
+	if t.logon == nil && t.logonErr == nil {
+		t.logon, t.logonErr = t.decodeLogonInfo()
+	}
+	return t.logon, t.logonErr
+}
+
+func (t *decryptedTicket) decodeLogonInfo() (*pac.KerbValidationInfo, error) {
+	buf, ok := t.pacBuffer(PAC_LOGON_INFO)
+	if !ok {
+		return nil, errors.New("ticket PAC has no logon info")
+	}
+	var info pac.KerbValidationInfo
+	if err := info.Unmarshal(buf); err != nil {
+		return nil, fmt.Errorf("ticket PAC logon info: %w", err)
+	}
+	return &info, nil
+}
+
+// machineAccount reports whether the UserAccountControl flags in the logon
+// info of the PAC mark a machine account. It fails when the logon info can't
+// be read, since the account kind is then unknown.
+func (t *decryptedTicket) machineAccount() (bool, error) {
+	info, err := t.logonInfo()
+	if err != nil {
+		return false, err
+	}
+	return isMachineAccount(info.UserAccountControl), nil
+}
+
+// sidHistoryFromLogonInfo returns the ExtraSIDs of the logon info that look
+// like SID history, by the rule extractSIDHistory applies
+func sidHistoryFromLogonInfo(info *pac.KerbValidationInfo) []string {
+	return extractSIDHistory(newLogonInfo(info))
+}
+
+// resourceGroupSIDsFromLogonInfo returns the resource group SIDs of the logon
+// info. gokrb5 includes them in the group membership SIDs without telling
+// them apart.
+func resourceGroupSIDsFromLogonInfo(info *pac.KerbValidationInfo) []string {
+	return extractResourceGroupSIDs(newLogonInfo(info))
+}
+
+// pacSignaturesAES reports whether the server and KDC signatures of the PAC
+// both use an AES checksum type
+func (t *decryptedTicket) pacSignaturesAES() bool {
+	for _, bufType := range []uint32{PAC_SERVER_CHECKSUM, PAC_PRIVSVR_CHECKSUM} {
+		buf, ok := t.pacBuffer(bufType)
+		if !ok || len(buf) < 4 || !isAESChecksum(binary.LittleEndian.Uint32(buf)) {
+			return false
+		}
+	}
+	return true
+}
+
+// enctypeStrength ranks encryption types from strongest to weakest; unknown
+// and single-DES types rank lowest
+func enctypeStrength(etype int32) int {
+	switch etype {
+	case etypeID.AES256_CTS_HMAC_SHA384_192:
+		return 6
+	case etypeID.AES128_CTS_HMAC_SHA256_128:
+		return 5
+	case etypeID.AES256_CTS_HMAC_SHA1_96:
+		return 4
+	case etypeID.AES128_CTS_HMAC_SHA1_96:
+		return 3
+	case etypeID.RC4_HMAC:
+		return 2
+	case etypeID.DES3_CBC_SHA1_KD:
+		return 1
+	}
+	return 0
+}
+
+// enctypeDowngraded reports whether the token's ticket is encrypted with a
+// weaker enctype than the strongest key the keytab holds for the ticket's
+// SPN. Tickets for SPNs the keytab doesn't know are not flagged.
+func enctypeDowngraded(kt *keytab.Keytab, token *spnego.SPNEGOToken) bool {
+	etype, ok := ticketEType(token)
+	if !ok {
+		return false
+	}
+	spn := ticketSPN(token)
+	strongest := -1
+	for _, e := range kt.Entries {
+		if strings.EqualFold(strings.Join(e.Principal.Components, "/"), spn) {
+			strongest = max(strongest, enctypeStrength(e.Key.KeyType))
+		}
+	}
+	return strongest >= 0 && enctypeStrength(etype) < strongest
+}
+
+// offersAllowedMech reports whether the token's mechanism list (or the
+// selected mechanism of a NegTokenResp) includes one of the allowed OIDs
+func offersAllowedMech(token *spnego.SPNEGOToken, allowed []string) bool {
+	var offered []asn1.ObjectIdentifier
+	switch {
+	case token.Init:
+		offered = token.NegTokenInit.MechTypes
+	case token.Resp:
+		offered = []asn1.ObjectIdentifier{token.NegTokenResp.SupportedMech}
+	}
+	for _, oid := range offered {
+		for _, a := range allowed {
+			if oid.String() == a {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// krb5MechToken parses the Kerberos mech token of an initial SPNEGO token
+func krb5MechToken(token *spnego.SPNEGOToken) (*spnego.KRB5Token, bool) {
+	if token == nil || !token.Init || len(token.NegTokenInit.MechTokenBytes) == 0 {
+		return nil, false
+	}
+	var mt spnego.KRB5Token
+	if err := mt.Unmarshal(token.NegTokenInit.MechTokenBytes); err != nil {
+		return nil, false
+	}
+	return &mt, true
+}
+
+// enctypePermitted reports whether etype is in the krb5.conf permitted_enctypes
+func enctypePermitted(conf *config.Config, etype int32) bool {
+	for _, id := range conf.LibDefaults.PermittedEnctypeIDs {
+		if id == etype {
+			return true
+		}
+	}
+	return false
+}
+
 // extractPACFromContext attempts to extract PAC data from SPNEGO context
 // This function implements production-ready PAC extraction using gokrb5's context
 // It provides multiple fallback strategies for different credential types
@@ -276,6 +952,34 @@ func extractPACFromContext(ctx context.Context) []byte {
 	return nil
 }
 
+// logonServerFromContext returns the logon server from the PAC-derived AD
+// credentials gokrb5 stores in the context, or "" if unavailable
+func logonServerFromContext(ctx context.Context) string {
+	creds, ok := ctx.Value(CTXKeyCredentials).(*credentials.Credentials)
+	if !ok {
+		return ""
+	}
+	adCreds, ok := creds.Attributes()[credentials.AttributeKeyADCredentials].(credentials.ADCredentials)
+	if !ok {
+		return ""
+	}
+	return adCreds.LogonServer
+}
+
+// userSIDFromContext returns the user's SID, built from the logon domain SID
+// and user RID in the PAC-derived AD credentials, or "" if unavailable
+func userSIDFromContext(ctx context.Context) string {
+	creds, ok := ctx.Value(CTXKeyCredentials).(*credentials.Credentials)
+	if !ok {
+		return ""
+	}
+	adCreds, ok := creds.Attributes()[credentials.AttributeKeyADCredentials].(credentials.ADCredentials)
+	if !ok || adCreds.LogonDomainID == "" || adCreds.UserID == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s-%d", adCreds.LogonDomainID, adCreds.UserID)
+}
+
 // extractGroupSIDsFromContext extracts group SIDs directly from SPNEGO context credentials
 // This function provides direct access to group SIDs without full PAC parsing
 // It's used as a fallback when PAC parsing is not available
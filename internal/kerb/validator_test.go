@@ -0,0 +1,806 @@
+package kerb
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/credentials"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/flags"
+	"github.com/jcmturner/gokrb5/v8/iana/nametype"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/test/testdata"
+	"github.com/jcmturner/gokrb5/v8/types"
+)
+
+const (
+	testRealm = "EXAMPLE.COM"
+	testSPN   = "HTTP/vault.example.com"
+)
+
+// newServiceKeytab builds an AES256 keytab for the given service principals
+func newServiceKeytab(t *testing.T, spns ...string) (*keytab.Keytab, string) {
+	t.Helper()
+	return newKeytabWithKey(t, "service-password", 1, spns...)
+}
+
+// newKeytabWithKey builds an AES256 keytab deriving each SPN's key from
+// password, so distinct passwords simulate a rotated account key
+func newKeytabWithKey(t *testing.T, password string, kvno uint8, spns ...string) (*keytab.Keytab, string) {
+	t.Helper()
+	kt := keytab.New()
+	for _, spn := range spns {
+		if err := kt.AddEntry(spn, testRealm, password+"-"+spn, time.Now(), kvno, etypeID.AES256_CTS_HMAC_SHA1_96); err != nil {
+			t.Fatalf("failed to add keytab entry: %v", err)
+		}
+	}
+	b, err := kt.Marshal()
+	if err != nil {
+		t.Fatalf("failed to marshal keytab: %v", err)
+	}
+	return kt, base64.StdEncoding.EncodeToString(b)
+}
+
+// newTestSPNEGO mints a base64 SPNEGO token for user@EXAMPLE.COM targeting
+// spn, encrypted with the keytab's key. authOffset shifts the authenticator
+// timestamp relative to now to simulate client clock skew.
+func newTestSPNEGO(t *testing.T, kt *keytab.Keytab, spn string, authOffset time.Duration) string {
+	t.Helper()
+	return newTestSPNEGOWithEType(t, kt, spn, etypeID.AES256_CTS_HMAC_SHA1_96, authOffset)
+}
+
+// newTestSPNEGOWithEType is newTestSPNEGO with the ticket encrypted using
+// etype, which the keytab must hold a key for
+func newTestSPNEGOWithEType(t *testing.T, kt *keytab.Keytab, spn string, etype int32, authOffset time.Duration) string {
+	t.Helper()
+	return newTestSPNEGOWithFlags(t, kt, spn, etype, types.NewKrbFlags(), authOffset)
+}
+
+// newTestSPNEGOWithFlags is newTestSPNEGOWithEType with the given ticket flags
+func newTestSPNEGOWithFlags(t *testing.T, kt *keytab.Keytab, spn string, etype int32, krbFlags asn1.BitString, authOffset time.Duration) string {
+	t.Helper()
+	cname := types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "user")
+	sname := types.NewPrincipalName(nametype.KRB_NT_SRV_INST, spn)
+	now := time.Now().UTC()
+
+	tkt, sessionKey, err := messages.NewTicket(cname, testRealm, sname, testRealm,
+		krbFlags, kt, etype, int(kt.Entries[0].KVNO),
+		now, now, now.Add(10*time.Hour), now.Add(10*time.Hour))
+	if err != nil {
+		t.Fatalf("failed to create ticket: %v", err)
+	}
+
+	cl := client.NewWithPassword("user", testRealm, "unused", config.New())
+	negInit, err := spnego.NewNegTokenInitKRB5(cl, tkt, sessionKey)
+	if err != nil {
+		t.Fatalf("failed to create NegTokenInit: %v", err)
+	}
+	if authOffset != 0 {
+		// Rebuild the AP_REQ with a shifted authenticator timestamp
+		mt, err := spnego.NewKRB5TokenAPREQ(cl, tkt, sessionKey, nil, nil)
+		if err != nil {
+			t.Fatalf("failed to create KRB5 token: %v", err)
+		}
+		auth, err := types.NewAuthenticator(testRealm, cname)
+		if err != nil {
+			t.Fatalf("failed to create authenticator: %v", err)
+		}
+		auth.CTime = auth.CTime.Add(authOffset)
+		if mt.APReq, err = messages.NewAPReq(tkt, sessionKey, auth); err != nil {
+			t.Fatalf("failed to create AP_REQ: %v", err)
+		}
+		if negInit.MechTokenBytes, err = mt.Marshal(); err != nil {
+			t.Fatalf("failed to marshal KRB5 token: %v", err)
+		}
+	}
+
+	token := spnego.SPNEGOToken{Init: true, NegTokenInit: negInit}
+	b, err := token.Marshal()
+	if err != nil {
+		t.Fatalf("failed to marshal SPNEGO token: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// gokrb5PACBuffers returns the logon info, client info and UPN_DNS_INFO
+// buffers AD issued for testuser1@TEST.GOKRB5 in gokrb5's test data.
+// logonInfoHex replaces the logon info, e.g. with one of the other samples.
+func gokrb5PACBuffers(t *testing.T, logonInfoHex string) []pacPayload {
+	t.Helper()
+	if logonInfoHex == "" {
+		logonInfoHex = testdata.MarshaledPAC_Kerb_Validation_Info
+	}
+	var bufs []pacPayload
+	for _, b := range []struct {
+		typ uint32
+		hex string
+	}{
+		{PAC_LOGON_INFO, logonInfoHex},
+		{PAC_CLIENT_INFO, testdata.MarshaledPAC_Client_Info},
+		{PAC_UPN_DNS_INFO, testdata.MarshaledPAC_UPN_DNS_Info},
+	} {
+		data, err := hex.DecodeString(b.hex)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bufs = append(bufs, pacPayload{Type: b.typ, Data: data})
+	}
+	return bufs
+}
+
+// newTestPAC encodes a PAC holding buffers, signed with key the way a KDC
+// signs PACs
+func newTestPAC(t *testing.T, key types.EncryptionKey, buffers []pacPayload) []byte {
+	t.Helper()
+	data, err := encodePAC(key, buffers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// newTestSPNEGOWithPAC mints a base64 SPNEGO token for cname@crealm whose
+// AES256 service ticket for spn carries the PAC built by newTestPAC from
+// buffers, signed with the keytab's key for spn
+func newTestSPNEGOWithPAC(t *testing.T, kt *keytab.Keytab, spn, cname, crealm string, buffers []pacPayload) string {
+	t.Helper()
+	tkt, sessionKey := newTestPACTicket(t, kt, spn, cname, crealm, buffers)
+	return newTestSPNEGOForTicket(t, tkt, sessionKey, cname, crealm, 0)
+}
+
+// newTestPACTicket issues the service ticket newTestSPNEGOWithPAC presents,
+// returning it with its session key
+func newTestPACTicket(t *testing.T, kt *keytab.Keytab, spn, cname, crealm string, buffers []pacPayload) (messages.Ticket, types.EncryptionKey) {
+	t.Helper()
+	tkt, sessionKey, err := issueServiceTicket(kt, spn, testRealm, etypeID.AES256_CTS_HMAC_SHA1_96, cname, crealm, buffers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tkt, sessionKey
+}
+
+// newTestSPNEGOForTicket wraps a ticket in a base64 SPNEGO token with a fresh
+// authenticator, its timestamp shifted by authOffset from now
+func newTestSPNEGOForTicket(t *testing.T, tkt messages.Ticket, sessionKey types.EncryptionKey, cname, crealm string, authOffset time.Duration) string {
+	t.Helper()
+	token, err := spnegoForTicket(tkt, sessionKey, cname, crealm, authOffset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestValidateSPNEGO_ValidTicket(t *testing.T) {
+	kt, ktB64 := newServiceKeytab(t, testSPN)
+	v := NewValidator(Options{Realm: testRealm, SPN: testSPN, KeytabB64: ktB64})
+
+	res, kerr := v.ValidateSPNEGO(context.Background(), newTestSPNEGO(t, kt, testSPN, 0), "")
+	if !kerr.IsZero() {
+		t.Fatalf("unexpected validation error: %v", kerr)
+	}
+	if res.Principal != "user@"+testRealm {
+		t.Errorf("Principal = %q, want %q", res.Principal, "user@"+testRealm)
+	}
+	if res.Realm != testRealm {
+		t.Errorf("Realm = %q, want %q", res.Realm, testRealm)
+	}
+	// newTestSPNEGO issues tickets valid for 10 hours
+	if remaining := time.Until(res.TicketEndTime); remaining < 9*time.Hour || remaining > 10*time.Hour {
+		t.Errorf("TicketEndTime = %v, want about 10h from now", res.TicketEndTime)
+	}
+}
+
+func TestValidateSPNEGO_SkipPAC(t *testing.T) {
+	kt, ktB64 := newServiceKeytab(t, testSPN)
+
+	for _, skip := range []bool{false, true} {
+		v := NewValidator(Options{Realm: testRealm, SPN: testSPN, KeytabB64: ktB64, SkipPAC: skip})
+		res, kerr := v.ValidateSPNEGO(context.Background(), newTestSPNEGO(t, kt, testSPN, 0), "")
+		if !kerr.IsZero() {
+			t.Fatalf("SkipPAC=%t: unexpected validation error: %v", skip, kerr)
+		}
+		// A skipped PAC is not reported as missing
+		if res.Flags["PAC_SKIPPED"] != skip || res.Flags["PAC_NOT_FOUND"] == skip {
+			t.Errorf("SkipPAC=%t: flags = %v", skip, res.Flags)
+		}
+		if len(res.GroupSIDs) != 0 || res.UserSID != "" || res.UPN != "" {
+			t.Errorf("SkipPAC=%t: result carries PAC data: %+v", skip, res)
+		}
+	}
+}
+
+func TestValidateSPNEGO_TicketInitialFlag(t *testing.T) {
+	kt, ktB64 := newServiceKeytab(t, testSPN)
+	v := NewValidator(Options{Realm: testRealm, SPN: testSPN, KeytabB64: ktB64})
+
+	initial := types.NewKrbFlags()
+	types.SetFlag(&initial, flags.Initial)
+
+	tests := []struct {
+		name     string
+		krbFlags asn1.BitString
+		want     bool
+	}{
+		{name: "AS-issued ticket", krbFlags: initial, want: true},
+		{name: "TGS-issued ticket", krbFlags: types.NewKrbFlags(), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := newTestSPNEGOWithFlags(t, kt, testSPN, etypeID.AES256_CTS_HMAC_SHA1_96, tt.krbFlags, 0)
+			res, kerr := v.ValidateSPNEGO(context.Background(), token, "")
+			if !kerr.IsZero() {
+				t.Fatalf("unexpected validation error: %v", kerr)
+			}
+			if res.Flags["TICKET_INITIAL"] != tt.want {
+				t.Errorf("TICKET_INITIAL = %t, want %t", res.Flags["TICKET_INITIAL"], tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateSPNEGO_ClockSkew(t *testing.T) {
+	kt, ktB64 := newServiceKeytab(t, testSPN)
+
+	tests := []struct {
+		name         string
+		clockSkewSec int
+		offset       time.Duration
+		wantOK       bool
+	}{
+		{"inside configured skew", 60, -30 * time.Second, true},
+		{"outside configured skew", 60, -90 * time.Second, false},
+		{"future outside configured skew", 60, 90 * time.Second, false},
+		{"wider skew accepts same offset", 120, -90 * time.Second, true},
+		{"default skew applies when unset", 0, -4 * time.Minute, true},
+		{"outside default skew", 0, -6 * time.Minute, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewValidator(Options{Realm: testRealm, SPN: testSPN, KeytabB64: ktB64, ClockSkewSec: tt.clockSkewSec})
+			_, kerr := v.ValidateSPNEGO(context.Background(), newTestSPNEGO(t, kt, testSPN, tt.offset), "")
+			if kerr.IsZero() != tt.wantOK {
+				t.Errorf("ValidateSPNEGO() ok = %v, want %v (err: %v)", kerr.IsZero(), tt.wantOK, kerr.SafeMessage())
+			}
+			if !tt.wantOK && kerr.Code() != ErrCodeKerberosFailed {
+				t.Errorf("Code() = %q, want %q", kerr.Code(), ErrCodeKerberosFailed)
+			}
+		})
+	}
+}
+
+func TestOptionsClockSkew(t *testing.T) {
+	if got := (Options{}).ClockSkew(); got != defaultClockSkew {
+		t.Errorf("ClockSkew() = %v, want default %v", got, defaultClockSkew)
+	}
+	if got := (Options{ClockSkewSec: 42}).ClockSkew(); got != 42*time.Second {
+		t.Errorf("ClockSkew() = %v, want 42s", got)
+	}
+}
+
+func TestValidateSPNEGO_TicketSPN(t *testing.T) {
+	const altSPN = "HTTP/vault-alt.example.com"
+	kt, ktB64 := newServiceKeytab(t, testSPN, altSPN)
+	v := NewValidator(Options{Realm: testRealm, SPN: testSPN, KeytabB64: ktB64})
+
+	for _, spn := range []string{testSPN, altSPN} {
+		t.Run(spn, func(t *testing.T) {
+			res, kerr := v.ValidateSPNEGO(context.Background(), newTestSPNEGO(t, kt, spn, 0), "")
+			if !kerr.IsZero() {
+				t.Fatalf("unexpected validation error: %v", kerr)
+			}
+			if res.SPN != spn {
+				t.Errorf("SPN = %q, want %q", res.SPN, spn)
+			}
+		})
+	}
+}
+
+func TestTicketSPN(t *testing.T) {
+	kt, _ := newServiceKeytab(t, testSPN)
+	b, err := base64.StdEncoding.DecodeString(newTestSPNEGO(t, kt, testSPN, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var token spnego.SPNEGOToken
+	if err := token.Unmarshal(b); err != nil {
+		t.Fatal(err)
+	}
+	if got := ticketSPN(&token); got != testSPN {
+		t.Errorf("ticketSPN() = %q, want %q", got, testSPN)
+	}
+
+	if got := ticketSPN(&spnego.SPNEGOToken{Init: true}); got != "" {
+		t.Errorf("ticketSPN() without mech token = %q, want empty", got)
+	}
+	if got := ticketSPN(&spnego.SPNEGOToken{Resp: true}); got != "" {
+		t.Errorf("ticketSPN() for NegTokenResp = %q, want empty", got)
+	}
+}
+
+func TestValidateSPNEGO_Krb5ConfPermittedEnctypes(t *testing.T) {
+	kt, ktB64 := newServiceKeytab(t, testSPN)
+
+	tests := []struct {
+		name    string
+		conf    string
+		wantErr bool
+	}{
+		{"ticket enctype permitted", "[libdefaults]\n permitted_enctypes = aes256-cts-hmac-sha1-96 aes128-cts-hmac-sha1-96\n", false},
+		{"ticket enctype not permitted", "[libdefaults]\n permitted_enctypes = aes128-cts-hmac-sha1-96\n", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf, err := config.NewFromString(tt.conf)
+			if err != nil {
+				t.Fatalf("failed to parse krb5.conf: %v", err)
+			}
+			v := NewValidator(Options{Realm: testRealm, SPN: testSPN, KeytabB64: ktB64, Krb5Conf: conf})
+			_, kerr := v.ValidateSPNEGO(context.Background(), newTestSPNEGO(t, kt, testSPN, 0), "")
+			if kerr.IsZero() == tt.wantErr {
+				t.Fatalf("ValidateSPNEGO() error = %v, wantErr %v", kerr, tt.wantErr)
+			}
+			if tt.wantErr && kerr.SafeMessage() != "ticket encryption type not permitted" {
+				t.Errorf("SafeMessage() = %q", kerr.SafeMessage())
+			}
+		})
+	}
+}
+
+// withMechTypes rewrites the mechanism list offered by a base64 SPNEGO token
+func withMechTypes(t *testing.T, spnegoB64 string, mechs ...asn1.ObjectIdentifier) string {
+	t.Helper()
+	b, err := base64.StdEncoding.DecodeString(spnegoB64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var token spnego.SPNEGOToken
+	if err := token.Unmarshal(b); err != nil {
+		t.Fatal(err)
+	}
+	token.NegTokenInit.MechTypes = mechs
+	if b, err = token.Marshal(); err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+func TestValidateSPNEGO_AllowedMechOIDs(t *testing.T) {
+	kt, ktB64 := newServiceKeytab(t, testSPN)
+	krb5 := gssapi.OIDKRB5.OID()
+	msKRB5 := gssapi.OIDMSLegacyKRB5.OID()
+	ntlm := asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 2, 10}
+	kerberosOnly := []string{krb5.String(), msKRB5.String()}
+
+	tests := []struct {
+		name    string
+		allowed []string
+		mechs   []asn1.ObjectIdentifier
+		wantErr bool
+	}{
+		{"kerberos offered", kerberosOnly, []asn1.ObjectIdentifier{krb5}, false},
+		{"MS kerberos offered first", kerberosOnly, []asn1.ObjectIdentifier{msKRB5, krb5, ntlm}, false},
+		{"only NTLM offered", kerberosOnly, []asn1.ObjectIdentifier{ntlm}, true},
+		{"kerberos not allowed", []string{ntlm.String()}, []asn1.ObjectIdentifier{krb5}, true},
+		{"no restriction", nil, []asn1.ObjectIdentifier{krb5}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewValidator(Options{Realm: testRealm, SPN: testSPN, KeytabB64: ktB64, AllowedMechOIDs: tt.allowed})
+			token := withMechTypes(t, newTestSPNEGO(t, kt, testSPN, 0), tt.mechs...)
+			_, kerr := v.ValidateSPNEGO(context.Background(), token, "")
+			if kerr.IsZero() == tt.wantErr {
+				t.Fatalf("ValidateSPNEGO() error = %v, wantErr %v", kerr, tt.wantErr)
+			}
+			if tt.wantErr && kerr.Code() != ErrCodeInvalidSPNEGO {
+				t.Errorf("Code() = %q, want %q", kerr.Code(), ErrCodeInvalidSPNEGO)
+			}
+		})
+	}
+}
+
+func TestValidateSPNEGO_TicketKVNO(t *testing.T) {
+	for _, kvno := range []uint8{1, 2, 7} {
+		kt, ktB64 := newKeytabWithKey(t, "service-password", kvno, testSPN)
+		v := NewValidator(Options{Realm: testRealm, SPN: testSPN, KeytabB64: ktB64})
+		res, kerr := v.ValidateSPNEGO(context.Background(), newTestSPNEGO(t, kt, testSPN, 0), "")
+		if !kerr.IsZero() {
+			t.Fatalf("kvno %d: unexpected validation error: %v", kvno, kerr)
+		}
+		if res.TicketKVNO != int(kvno) {
+			t.Errorf("TicketKVNO = %d, want %d", res.TicketKVNO, kvno)
+		}
+	}
+}
+
+func TestValidateSPNEGO_PreviousKeytab(t *testing.T) {
+	oldKT, oldB64 := newKeytabWithKey(t, "old-password", 1, testSPN)
+	newKT, newB64 := newKeytabWithKey(t, "new-password", 2, testSPN)
+
+	// A ticket encrypted to the old key only validates while the previous
+	// keytab is supplied
+	v := NewValidator(Options{Realm: testRealm, SPN: testSPN, KeytabB64: newB64, PreviousKeytabB64: oldB64})
+	res, kerr := v.ValidateSPNEGO(context.Background(), newTestSPNEGO(t, oldKT, testSPN, 0), "")
+	if !kerr.IsZero() {
+		t.Fatalf("old-key ticket rejected during grace window: %v", kerr)
+	}
+	if !res.Flags["PREVIOUS_KEYTAB"] {
+		t.Error("expected PREVIOUS_KEYTAB flag for old-key ticket")
+	}
+
+	v = NewValidator(Options{Realm: testRealm, SPN: testSPN, KeytabB64: newB64})
+	if _, kerr := v.ValidateSPNEGO(context.Background(), newTestSPNEGO(t, oldKT, testSPN, 0), ""); kerr.IsZero() {
+		t.Error("old-key ticket accepted without the previous keytab")
+	}
+
+	// New-key tickets never use the previous keytab
+	v = NewValidator(Options{Realm: testRealm, SPN: testSPN, KeytabB64: newB64, PreviousKeytabB64: oldB64})
+	res, kerr = v.ValidateSPNEGO(context.Background(), newTestSPNEGO(t, newKT, testSPN, 0), "")
+	if !kerr.IsZero() {
+		t.Fatalf("new-key ticket rejected: %v", kerr)
+	}
+	if res.Flags["PREVIOUS_KEYTAB"] {
+		t.Error("unexpected PREVIOUS_KEYTAB flag for new-key ticket")
+	}
+}
+
+func TestAcceptCompletedToken(t *testing.T) {
+	tokenB64, err := AcceptCompletedToken()
+	if err != nil {
+		t.Fatalf("AcceptCompletedToken() error = %v", err)
+	}
+	b, err := base64.StdEncoding.DecodeString(tokenB64)
+	if err != nil {
+		t.Fatalf("token is not base64: %v", err)
+	}
+	var token spnego.SPNEGOToken
+	if err := token.Unmarshal(b); err != nil {
+		t.Fatalf("token does not unmarshal: %v", err)
+	}
+	if !token.Resp {
+		t.Fatal("expected a NegTokenResp")
+	}
+	if token.NegTokenResp.State() != spnego.NegStateAcceptCompleted {
+		t.Errorf("NegState = %v, want accept-completed", token.NegTokenResp.State())
+	}
+	if !token.NegTokenResp.SupportedMech.Equal(gssapi.OIDKRB5.OID()) {
+		t.Errorf("SupportedMech = %v, want KRB5", token.NegTokenResp.SupportedMech)
+	}
+}
+
+func TestLogonServerFromContext(t *testing.T) {
+	creds := credentials.New("user", testRealm)
+	creds.SetADCredentials(credentials.ADCredentials{EffectiveName: "user", LogonServer: "DC01"})
+	ctx := context.WithValue(context.Background(), CTXKeyCredentials, creds)
+	if got := logonServerFromContext(ctx); got != "DC01" {
+		t.Errorf("logonServerFromContext() = %q, want %q", got, "DC01")
+	}
+
+	bare := context.WithValue(context.Background(), CTXKeyCredentials, credentials.New("user", testRealm))
+	if got := logonServerFromContext(bare); got != "" {
+		t.Errorf("logonServerFromContext() without PAC = %q, want empty", got)
+	}
+	if got := logonServerFromContext(context.Background()); got != "" {
+		t.Errorf("logonServerFromContext() without credentials = %q, want empty", got)
+	}
+}
+
+func TestValidateSPNEGO_AdditionalKeytabs(t *testing.T) {
+	const otherSPN = "HTTP/vault2.example.com"
+	ktA, ktAB64 := newKeytabWithKey(t, "password-a", 1, testSPN)
+	ktB, ktBB64 := newKeytabWithKey(t, "password-b", 1, otherSPN)
+
+	// The union of both keytabs accepts tickets for either SPN
+	v := NewValidator(Options{Realm: testRealm, SPN: testSPN, KeytabB64: ktAB64, AdditionalKeytabsB64: []string{ktBB64}})
+	for _, tc := range []struct {
+		kt  *keytab.Keytab
+		spn string
+	}{{ktA, testSPN}, {ktB, otherSPN}} {
+		res, kerr := v.ValidateSPNEGO(context.Background(), newTestSPNEGO(t, tc.kt, tc.spn, 0), "")
+		if !kerr.IsZero() {
+			t.Fatalf("ticket for %s rejected: %v", tc.spn, kerr)
+		}
+		if res.SPN != tc.spn {
+			t.Errorf("SPN = %q, want %q", res.SPN, tc.spn)
+		}
+	}
+
+	// Without the additional keytab the second SPN has no key
+	v = NewValidator(Options{Realm: testRealm, SPN: testSPN, KeytabB64: ktAB64})
+	if _, kerr := v.ValidateSPNEGO(context.Background(), newTestSPNEGO(t, ktB, otherSPN, 0), ""); kerr.IsZero() {
+		t.Error("ticket for second SPN accepted without the additional keytab")
+	}
+
+	// An unparsable additional keytab fails closed
+	v = NewValidator(Options{Realm: testRealm, SPN: testSPN, KeytabB64: ktAB64, AdditionalKeytabsB64: []string{"bm90IGEga2V5dGFi"}})
+	_, kerr := v.ValidateSPNEGO(context.Background(), newTestSPNEGO(t, ktA, testSPN, 0), "")
+	if kerr.IsZero() || kerr.Code() != ErrCodeInvalidKeytab {
+		t.Errorf("expected %s for bad additional keytab, got: %v", ErrCodeInvalidKeytab, kerr)
+	}
+}
+
+func TestValidateSPNEGO_EnctypeDowngrade(t *testing.T) {
+	newKeytab := func(etypes ...int32) (*keytab.Keytab, string) {
+		t.Helper()
+		kt := keytab.New()
+		for _, etype := range etypes {
+			if err := kt.AddEntry(testSPN, testRealm, "service-password", time.Now(), 1, etype); err != nil {
+				t.Fatalf("failed to add keytab entry: %v", err)
+			}
+		}
+		b, err := kt.Marshal()
+		if err != nil {
+			t.Fatalf("failed to marshal keytab: %v", err)
+		}
+		return kt, base64.StdEncoding.EncodeToString(b)
+	}
+
+	// The keytab can decrypt both AES256 and RC4 tickets for the SPN
+	kt, ktB64 := newKeytab(etypeID.AES256_CTS_HMAC_SHA1_96, etypeID.RC4_HMAC)
+
+	v := NewValidator(Options{Realm: testRealm, SPN: testSPN, KeytabB64: ktB64})
+	res, kerr := v.ValidateSPNEGO(context.Background(), newTestSPNEGOWithEType(t, kt, testSPN, etypeID.RC4_HMAC, 0), "")
+	if !kerr.IsZero() {
+		t.Fatalf("RC4 ticket rejected without reject flag: %v", kerr)
+	}
+	if !res.Flags["ENCTYPE_DOWNGRADE"] {
+		t.Error("expected ENCTYPE_DOWNGRADE for RC4 ticket with AES key available")
+	}
+
+	res, kerr = v.ValidateSPNEGO(context.Background(), newTestSPNEGOWithEType(t, kt, testSPN, etypeID.AES256_CTS_HMAC_SHA1_96, 0), "")
+	if !kerr.IsZero() {
+		t.Fatalf("AES ticket rejected: %v", kerr)
+	}
+	if res.Flags["ENCTYPE_DOWNGRADE"] {
+		t.Error("unexpected ENCTYPE_DOWNGRADE for AES ticket")
+	}
+
+	v = NewValidator(Options{Realm: testRealm, SPN: testSPN, KeytabB64: ktB64, RejectEnctypeDowngrade: true})
+	_, kerr = v.ValidateSPNEGO(context.Background(), newTestSPNEGOWithEType(t, kt, testSPN, etypeID.RC4_HMAC, 0), "")
+	if kerr.IsZero() || kerr.Code() != ErrCodeEnctypeDowngrade {
+		t.Fatalf("expected %s, got: %v", ErrCodeEnctypeDowngrade, kerr)
+	}
+
+	// An RC4-only keytab has nothing stronger to downgrade from
+	rc4KT, rc4B64 := newKeytab(etypeID.RC4_HMAC)
+	v = NewValidator(Options{Realm: testRealm, SPN: testSPN, KeytabB64: rc4B64, RejectEnctypeDowngrade: true})
+	if _, kerr := v.ValidateSPNEGO(context.Background(), newTestSPNEGOWithEType(t, rc4KT, testSPN, etypeID.RC4_HMAC, 0), ""); !kerr.IsZero() {
+		t.Errorf("RC4 ticket rejected for RC4-only keytab: %v", kerr)
+	}
+}
+
+func TestValidateSPNEGO_PACAccountType(t *testing.T) {
+	kt, ktB64 := newServiceKeytab(t, testSPN)
+	v := NewValidator(Options{Realm: testRealm, SPN: testSPN, KeytabB64: ktB64})
+
+	// testuser1's UserAccountControl marks a normal user account
+	token := newTestSPNEGOWithPAC(t, kt, testSPN, "testuser1", testRealm, gokrb5PACBuffers(t, ""))
+	res, kerr := v.ValidateSPNEGO(context.Background(), token, "")
+	if !kerr.IsZero() {
+		t.Fatalf("unexpected validation error: %v", kerr)
+	}
+	if !res.Flags["PAC_VALIDATED"] || res.Flags["IS_MACHINE_ACCOUNT"] || res.Flags["ACCOUNT_TYPE_UNKNOWN"] {
+		t.Errorf("flags = %v, want a validated user account", res.Flags)
+	}
+
+	// Without readable logon info the account kind is unknown, not a user
+	if _, err := (&decryptedTicket{}).machineAccount(); err == nil {
+		t.Error("machineAccount() without a PAC succeeded")
+	}
+}
+
+func TestValidateSPNEGO_SkipReplayCache(t *testing.T) {
+	kt, ktB64 := newServiceKeytab(t, testSPN)
+	opt := Options{Realm: testRealm, SPN: testSPN, KeytabB64: ktB64}
+	check := opt
+	check.SkipReplayCache = true
+	token := newTestSPNEGOWithPAC(t, kt, testSPN, "testuser1", testRealm, gokrb5PACBuffers(t, ""))
+
+	// Checking a token any number of times leaves it usable for a login
+	var checked *ValidationResult
+	for range 2 {
+		res, kerr := NewValidator(check).ValidateSPNEGO(context.Background(), token, "")
+		if !kerr.IsZero() {
+			t.Fatalf("check failed: %v", kerr)
+		}
+		checked = res
+	}
+	res, kerr := NewValidator(opt).ValidateSPNEGO(context.Background(), token, "")
+	if !kerr.IsZero() {
+		t.Fatalf("login after checks failed: %v", kerr)
+	}
+	if !res.Flags["PAC_VALIDATED"] || res.Principal != checked.Principal || res.UserSID != checked.UserSID ||
+		!slices.Equal(res.GroupSIDs, checked.GroupSIDs) || !checked.Flags["PAC_VALIDATED"] {
+		t.Errorf("check = %+v, login = %+v; want the same validated identity", checked, res)
+	}
+
+	// The login recorded the authenticator
+	if _, kerr := NewValidator(opt).ValidateSPNEGO(context.Background(), token, ""); kerr.IsZero() {
+		t.Error("replayed token accepted")
+	}
+
+	// Checks still verify the ticket
+	other, _ := newKeytabWithKey(t, "other-password", 1, testSPN)
+	if _, kerr := NewValidator(check).ValidateSPNEGO(context.Background(), newTestSPNEGO(t, other, testSPN, 0), ""); kerr.IsZero() {
+		t.Error("check accepted a ticket for another key")
+	}
+}
+
+func TestValidateSPNEGO_PACSIDHistory(t *testing.T) {
+	kt, ktB64 := newServiceKeytab(t, testSPN)
+	v := NewValidator(Options{Realm: testRealm, SPN: testSPN, KeytabB64: ktB64})
+
+	tests := []struct {
+		name      string
+		logonInfo string
+		want      []string
+	}{
+		// testuser1's ExtraSIDs are all in its logon domain
+		{"logon domain only", testdata.MarshaledPAC_Kerb_Validation_Info, nil},
+		// One ExtraSID without SE_GROUP_RESOURCE comes from another domain
+		{"other domain", testdata.MarshaledPAC_Kerb_Validation_Info_MS, []string{"S-1-5-21-773533881-1816936887-355810188-513"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := newTestSPNEGOWithPAC(t, kt, testSPN, "testuser1", testRealm, gokrb5PACBuffers(t, tt.logonInfo))
+			res, kerr := v.ValidateSPNEGO(context.Background(), token, "")
+			if !kerr.IsZero() {
+				t.Fatalf("unexpected validation error: %v", kerr)
+			}
+			if !slices.Equal(res.SIDHistorySIDs, tt.want) {
+				t.Errorf("SIDHistorySIDs = %v, want %v", res.SIDHistorySIDs, tt.want)
+			}
+			for _, sid := range tt.want {
+				if !slices.Contains(res.GroupSIDs, sid) {
+					t.Errorf("GroupSIDs %v lack SID history %s", res.GroupSIDs, sid)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateSPNEGO_PACResourceGroups(t *testing.T) {
+	kt, ktB64 := newServiceKeytab(t, testSPN)
+	v := NewValidator(Options{Realm: testRealm, SPN: testSPN, KeytabB64: ktB64})
+	trust := testLogonInfo(testdata.MarshaledPAC_Kerb_Validation_Info_Trust, time.Now())
+	binary.LittleEndian.PutUint32(trust[testUserFlagsOffset:], logonExtraSIDs)
+	unflagged := hex.EncodeToString(trust)
+
+	tests := []struct {
+		name      string
+		logonInfo string
+		want      []string
+	}{
+		{"no resource groups", testdata.MarshaledPAC_Kerb_Validation_Info, nil},
+		// A trusted-domain logon with two domain local groups from the resource domain
+		{"resource domain", testdata.MarshaledPAC_Kerb_Validation_Info_Trust, []string{
+			"S-1-5-21-3062750306-1230139592-1973306805-1107",
+			"S-1-5-21-3062750306-1230139592-1973306805-1108",
+		}},
+		// The same logon without LOGON_RESOURCE_GROUPS in UserFlags
+		{"resource groups not flagged", unflagged, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := newTestSPNEGOWithPAC(t, kt, testSPN, "testuser1", testRealm, gokrb5PACBuffers(t, tt.logonInfo))
+			res, kerr := v.ValidateSPNEGO(context.Background(), token, "")
+			if !kerr.IsZero() {
+				t.Fatalf("unexpected validation error: %v", kerr)
+			}
+			if !slices.Equal(res.ResourceGroupSIDs, tt.want) {
+				t.Errorf("ResourceGroupSIDs = %v, want %v", res.ResourceGroupSIDs, tt.want)
+			}
+			for _, sid := range tt.want {
+				if !slices.Contains(res.GroupSIDs, sid) {
+					t.Errorf("GroupSIDs %v lack resource group %s", res.GroupSIDs, sid)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateSPNEGO_PACUPNConsistency(t *testing.T) {
+	kt, ktB64 := newServiceKeytab(t, testSPN)
+
+	// The PAC's UPN_DNS_INFO names testuser1@test.gokrb5 in TEST.GOKRB5
+	tests := []struct {
+		name           string
+		cname, crealm  string
+		requireMatch   bool
+		wantConsistent bool
+		wantRejected   bool
+	}{
+		{name: "matching client", cname: "testuser1", crealm: "TEST.GOKRB5", wantConsistent: true},
+		{name: "matching client, match required", cname: "testuser1", crealm: "TEST.GOKRB5", requireMatch: true, wantConsistent: true},
+		{name: "other realm", cname: "testuser1", crealm: testRealm, wantConsistent: false},
+		{name: "other user", cname: "other", crealm: "TEST.GOKRB5", wantConsistent: true},
+		{name: "other user, match required", cname: "other", crealm: "TEST.GOKRB5", requireMatch: true, wantRejected: true},
+		{name: "other realm, match required", cname: "testuser1", crealm: testRealm, requireMatch: true, wantRejected: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewValidator(Options{Realm: testRealm, SPN: testSPN, KeytabB64: ktB64, RequireUPNMatch: tt.requireMatch})
+			token := newTestSPNEGOWithPAC(t, kt, testSPN, tt.cname, tt.crealm, gokrb5PACBuffers(t, ""))
+			res, kerr := v.ValidateSPNEGO(context.Background(), token, "")
+			if tt.wantRejected {
+				if kerr.Code() != ErrCodePACValidation {
+					t.Fatalf("error code = %q, want %s", kerr.Code(), ErrCodePACValidation)
+				}
+				return
+			}
+			if !kerr.IsZero() {
+				t.Fatalf("unexpected validation error: %v", kerr)
+			}
+			if !res.Flags["UPN_DNS_INFO_PRESENT"] || res.Flags["UPN_CONSISTENT"] != tt.wantConsistent {
+				t.Errorf("flags = %v, want UPN_CONSISTENT=%t", res.Flags, tt.wantConsistent)
+			}
+		})
+	}
+}
+
+func TestValidateSPNEGO_PACCrossRealm(t *testing.T) {
+	kt, ktB64 := newServiceKeytab(t, testSPN)
+	v := NewValidator(Options{Realm: testRealm, SPN: testSPN, KeytabB64: ktB64})
+
+	// The UPN_DNS_INFO DNS domain is TEST.GOKRB5, so a client from that
+	// realm is a consistent cross-realm user of the EXAMPLE.COM service
+	for crealm, wantCrossRealm := range map[string]bool{"TEST.GOKRB5": true, testRealm: false} {
+		token := newTestSPNEGOWithPAC(t, kt, testSPN, "testuser1", crealm, gokrb5PACBuffers(t, ""))
+		res, kerr := v.ValidateSPNEGO(context.Background(), token, "")
+		if !kerr.IsZero() {
+			t.Fatalf("%s: unexpected validation error: %v", crealm, kerr)
+		}
+		if res.Flags["CROSS_REALM"] != wantCrossRealm || res.Flags["UPN_CONSISTENT"] != wantCrossRealm {
+			t.Errorf("%s: flags = %v, want CROSS_REALM and UPN_CONSISTENT %t", crealm, res.Flags, wantCrossRealm)
+		}
+	}
+}
+
+func TestValidateSPNEGO_PACCache(t *testing.T) {
+	kt, ktB64 := newServiceKeytab(t, testSPN)
+	v := NewValidator(Options{Realm: testRealm, SPN: testSPN, KeytabB64: ktB64, PACCache: NewPACCache(0)})
+	tkt, sessionKey := newTestPACTicket(t, kt, testSPN, "testuser1", "TEST.GOKRB5", gokrb5PACBuffers(t, testdata.MarshaledPAC_Kerb_Validation_Info_Trust))
+
+	login := func(authOffset time.Duration) (*ValidationResult, safeErr) {
+		t.Helper()
+		return v.ValidateSPNEGO(context.Background(), newTestSPNEGOForTicket(t, tkt, sessionKey, "testuser1", "TEST.GOKRB5", authOffset), "")
+	}
+	first, kerr := login(0)
+	if !kerr.IsZero() {
+		t.Fatalf("first login: %v", kerr)
+	}
+	if first.Flags["PAC_CACHE_HIT"] || !first.Flags["PAC_VALIDATED"] {
+		t.Fatalf("first login flags = %v, want a validated miss", first.Flags)
+	}
+
+	// The PAC logon time is years old; hits are bounded by the authenticator
+	second, kerr := login(0)
+	if !kerr.IsZero() {
+		t.Fatalf("second login: %v", kerr)
+	}
+	if !second.Flags["PAC_CACHE_HIT"] {
+		t.Errorf("second login flags = %v, want PAC_CACHE_HIT", second.Flags)
+	}
+	if !slices.Equal(second.GroupSIDs, first.GroupSIDs) || !slices.Equal(second.ResourceGroupSIDs, first.ResourceGroupSIDs) ||
+		second.UserSID != first.UserSID || second.UPN != first.UPN || second.Flags["UPN_CONSISTENT"] != first.Flags["UPN_CONSISTENT"] {
+		t.Errorf("cached result = %+v, want %+v", second, first)
+	}
+
+	// A cached ticket presented with a stale authenticator still fails
+	if _, kerr := login(-10 * time.Minute); kerr.Code() != ErrCodeKerberosFailed {
+		t.Errorf("stale authenticator error code = %q, want %s", kerr.Code(), ErrCodeKerberosFailed)
+	}
+}
@@ -0,0 +1,132 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// redactingLogger applies a Redactor to every message and argument value
+// before handing them to the wrapped logger
+type redactingLogger struct {
+	hclog.Logger
+	redactor func() *Redactor
+}
+
+// NewRedactingLogger wraps l so every message and argument value it logs,
+// including those of derived loggers and standard loggers, is redacted.
+// redactor is called on each entry, so rules changed by a config write
+// apply from the next entry on; it may return nil for the built-in rules.
+// Arguments bound with With are redacted once, when bound.
+func NewRedactingLogger(l hclog.Logger, redactor func() *Redactor) hclog.Logger {
+	return &redactingLogger{Logger: l, redactor: redactor}
+}
+
+func (l *redactingLogger) Log(level hclog.Level, msg string, args ...interface{}) {
+	r := l.redactor()
+	l.Logger.Log(level, r.Redact(msg), redactArgs(r, args)...)
+}
+
+func (l *redactingLogger) Trace(msg string, args ...interface{}) {
+	l.Log(hclog.Trace, msg, args...)
+}
+
+func (l *redactingLogger) Debug(msg string, args ...interface{}) {
+	l.Log(hclog.Debug, msg, args...)
+}
+
+func (l *redactingLogger) Info(msg string, args ...interface{}) {
+	l.Log(hclog.Info, msg, args...)
+}
+
+func (l *redactingLogger) Warn(msg string, args ...interface{}) {
+	l.Log(hclog.Warn, msg, args...)
+}
+
+func (l *redactingLogger) Error(msg string, args ...interface{}) {
+	l.Log(hclog.Error, msg, args...)
+}
+
+func (l *redactingLogger) With(args ...interface{}) hclog.Logger {
+	return &redactingLogger{Logger: l.Logger.With(redactArgs(l.redactor(), args)...), redactor: l.redactor}
+}
+
+func (l *redactingLogger) Named(name string) hclog.Logger {
+	return &redactingLogger{Logger: l.Logger.Named(name), redactor: l.redactor}
+}
+
+func (l *redactingLogger) ResetNamed(name string) hclog.Logger {
+	return &redactingLogger{Logger: l.Logger.ResetNamed(name), redactor: l.redactor}
+}
+
+func (l *redactingLogger) StandardLogger(opts *hclog.StandardLoggerOptions) *log.Logger {
+	return log.New(l.StandardWriter(opts), "", 0)
+}
+
+func (l *redactingLogger) StandardWriter(opts *hclog.StandardLoggerOptions) io.Writer {
+	return NewRedactingWriter(l.Logger.StandardWriter(opts), l.redactor)
+}
+
+// redactArgs returns hclog key/value arguments with every value redacted.
+// Keys are left alone; a trailing key without a value counts as a value.
+func redactArgs(r *Redactor, args []interface{}) []interface{} {
+	if len(args) == 0 {
+		return args
+	}
+	out := make([]interface{}, len(args))
+	copy(out, args)
+	for i := 1; i < len(out); i += 2 {
+		out[i] = redactValue(r, out[i])
+	}
+	if len(out)%2 == 1 {
+		out[len(out)-1] = redactValue(r, out[len(out)-1])
+	}
+	return out
+}
+
+// redactValue redacts one argument value. Numbers, booleans, durations and
+// times can't carry secrets and keep their type; anything else is logged in
+// its redacted text form.
+func redactValue(r *Redactor, v interface{}) interface{} {
+	switch x := v.(type) {
+	case nil, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, time.Duration, time.Time, hclog.Level:
+		return v
+	case string:
+		return r.Redact(x)
+	case error:
+		return r.Redact(x.Error())
+	case fmt.Stringer:
+		return r.Redact(x.String())
+	case []string:
+		out := make([]string, len(x))
+		for i, s := range x {
+			out[i] = r.Redact(s)
+		}
+		return out
+	default:
+		return r.Redact(fmt.Sprintf("%v", v))
+	}
+}
+
+// redactingWriter redacts each write before passing it on
+type redactingWriter struct {
+	w        io.Writer
+	redactor func() *Redactor
+}
+
+// NewRedactingWriter wraps w so every write is redacted first, e.g. for a
+// standard library logger. Each write should hold whole lines, as log.Logger
+// writes do, so a secret isn't split across two redactions.
+func NewRedactingWriter(w io.Writer, redactor func() *Redactor) io.Writer {
+	return &redactingWriter{w: w, redactor: redactor}
+}
+
+func (w *redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.w, w.redactor().Redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
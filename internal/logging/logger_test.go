@@ -0,0 +1,54 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+func TestRedactingLogger(t *testing.T) {
+	var buf bytes.Buffer
+	custom, err := NewRedactor([]string{`dc\d+\.corp\.internal`}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := custom
+	l := NewRedactingLogger(hclog.New(&hclog.LoggerOptions{Output: &buf, Level: hclog.Trace}), func() *Redactor { return current })
+
+	l.Warn("lookup via dc7.corp.internal failed", "error", errors.New("bind password=hunter2 rejected"), "sid", "S-1-5-21-1-2-3-1104", "sids", []string{"S-1-5-32-544"}, "count", 3)
+	l.With("principal_sid", "S-1-5-21-9-9-9-500").Named("ldap").Info("done")
+	l.StandardLogger(&hclog.StandardLoggerOptions{}).Printf("fallback to dc9.corp.internal")
+
+	out := buf.String()
+	for _, leaked := range []string{"dc7.corp.internal", "dc9.corp.internal", "hunter2", "S-1-5-21-1-2-3-1104", "S-1-5-32-544", "S-1-5-21-9-9-9-500"} {
+		if strings.Contains(out, leaked) {
+			t.Errorf("log output leaks %q:\n%s", leaked, out)
+		}
+	}
+	if !strings.Contains(out, "count=3") || !strings.Contains(out, "ldap") {
+		t.Errorf("log output lost non-sensitive values:\n%s", out)
+	}
+
+	// Rules are picked up per entry
+	buf.Reset()
+	current = nil
+	l.Info("lookup via dc7.corp.internal")
+	if !strings.Contains(buf.String(), "dc7.corp.internal") {
+		t.Errorf("entry after the rules changed = %q, want only the built-in rules", buf.String())
+	}
+}
+
+func TestRedactingWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewRedactingWriter(&buf, func() *Redactor { return nil })
+	msg := "rotation failed: secret=hunter2\n"
+	if n, err := w.Write([]byte(msg)); err != nil || n != len(msg) {
+		t.Fatalf("Write() = %d, %v", n, err)
+	}
+	if got := buf.String(); got != "rotation failed: secret: <redacted>\n" {
+		t.Errorf("written = %q", got)
+	}
+}
@@ -4,31 +4,92 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"sync"
 )
 
-var spnegoBlobRe = regexp.MustCompile(`([A-Za-z0-9+/]{64,}={0,2})`)
+var (
+	spnegoBlobRe = regexp.MustCompile(`([A-Za-z0-9+/]{64,}={0,2})`)
+	sidRe        = regexp.MustCompile(`S-\d+-\d+(-\d+)+`)
+	keyRe        = regexp.MustCompile(`(?i)(password|key|secret|token)\s*[:=]\s*[^\s]+`)
+
+	// compiledRules caches custom patterns, which are compiled once per
+	// distinct pattern rather than on every redaction
+	compiledRules sync.Map // string -> *regexp.Regexp
+)
 
 func RedactSPNEGO(s string) string {
 	// Replace long base64 sequences with <redacted>
 	return spnegoBlobRe.ReplaceAllString(s, "<redacted>")
 }
 
-// RedactSensitiveData redacts sensitive information from log messages
-func RedactSensitiveData(msg string) string {
+// Redactor applies the built-in redactions plus operator-supplied rules.
+// A nil Redactor applies the built-in redactions only.
+type Redactor struct {
+	rules    []*regexp.Regexp
+	keepSIDs bool
+}
+
+// NewRedactor compiles custom redaction patterns, applied after the built-in
+// ones; each match is replaced with <redacted>. keepSIDs disables SID
+// redaction, e.g. for development mounts.
+func NewRedactor(patterns []string, keepSIDs bool) (*Redactor, error) {
+	r := &Redactor{keepSIDs: keepSIDs}
+	for _, p := range patterns {
+		re, err := compileRule(p)
+		if err != nil {
+			return nil, err
+		}
+		r.rules = append(r.rules, re)
+	}
+	return r, nil
+}
+
+func compileRule(pattern string) (*regexp.Regexp, error) {
+	if re, ok := compiledRules.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	if pattern == "" {
+		return nil, fmt.Errorf("empty redaction pattern")
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redaction pattern %q: %w", pattern, err)
+	}
+	if re.MatchString("") {
+		return nil, fmt.Errorf("redaction pattern %q matches the empty string", pattern)
+	}
+	compiledRules.Store(pattern, re)
+	return re, nil
+}
+
+// Redact redacts sensitive information from a log message
+func (r *Redactor) Redact(msg string) string {
 	// Redact SPNEGO tokens
 	msg = RedactSPNEGO(msg)
 
 	// Redact SIDs (basic pattern)
-	sidRe := regexp.MustCompile(`S-\d+-\d+(-\d+)+`)
-	msg = sidRe.ReplaceAllString(msg, "<redacted-sid>")
+	if r == nil || !r.keepSIDs {
+		msg = sidRe.ReplaceAllString(msg, "<redacted-sid>")
+	}
 
 	// Redact potential passwords or keys
-	keyRe := regexp.MustCompile(`(?i)(password|key|secret|token)\s*[:=]\s*[^\s]+`)
 	msg = keyRe.ReplaceAllString(msg, "$1: <redacted>")
 
+	if r != nil {
+		for _, re := range r.rules {
+			msg = re.ReplaceAllString(msg, "<redacted>")
+		}
+	}
 	return msg
 }
 
+// RedactSensitiveData redacts sensitive information from log messages using
+// the built-in rules
+func RedactSensitiveData(msg string) string {
+	var r *Redactor
+	return r.Redact(msg)
+}
+
 // LogSecurityEvent logs security-related events with appropriate redaction
 func LogSecurityEvent(event string, details map[string]interface{}) {
 	// This would integrate with Vault's logging system
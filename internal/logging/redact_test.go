@@ -0,0 +1,47 @@
+package logging
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedactor_CustomRules(t *testing.T) {
+	r, err := NewRedactor([]string{`[a-z0-9-]+\.corp\.internal`, `TKT-\d+`}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := "dial tcp dc7.corp.internal:88 failed for TKT-4411 (user S-1-5-21-1-2-3-1104, password=hunter2)"
+	got := r.Redact(msg)
+
+	for _, leaked := range []string{"dc7.corp.internal", "TKT-4411", "S-1-5-21-1-2-3-1104", "hunter2"} {
+		if strings.Contains(got, leaked) {
+			t.Errorf("Redact() = %q, leaks %q", got, leaked)
+		}
+	}
+	if !strings.Contains(got, "<redacted-sid>") || !strings.Contains(got, "password: <redacted>") {
+		t.Errorf("Redact() = %q, want the built-in redactions applied", got)
+	}
+}
+
+func TestRedactor_KeepSIDs(t *testing.T) {
+	r, err := NewRedactor(nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.Redact("group S-1-5-32-544 secret=x"); got != "group S-1-5-32-544 secret: <redacted>" {
+		t.Errorf("Redact() = %q", got)
+	}
+	// A nil Redactor keeps the built-in behaviour
+	var none *Redactor
+	if got := none.Redact("group S-1-5-32-544"); got != RedactSensitiveData("group S-1-5-32-544") || got != "group <redacted-sid>" {
+		t.Errorf("nil Redact() = %q", got)
+	}
+}
+
+func TestNewRedactor_InvalidPatterns(t *testing.T) {
+	for _, p := range []string{"", "(unclosed", "a*"} {
+		if _, err := NewRedactor([]string{p}, false); err == nil {
+			t.Errorf("NewRedactor(%q) accepted", p)
+		}
+	}
+}
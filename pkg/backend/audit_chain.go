@@ -0,0 +1,344 @@
+package backend
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	storageKeyAuditChain        = "audit/chain/"      // Prefix for hash chain entries
+	storageKeyAuditChainState   = "audit/chain-state" // Chain head and retained range
+	storageKeyAuditChainKey     = "audit/chain-key"   // HMAC key for entry hashes, seal-wrapped
+	defaultAuditChainMaxEntries = 1000                // Entries kept when audit_chain_max_entries is 0
+	maxAuditChainMaxEntries     = 100000              // Upper bound for audit_chain_max_entries
+	auditChainQueueSize         = 1024                // Login events waiting to be appended
+)
+
+// auditChainState tracks the retained range of the login event hash chain.
+// Anchor is the hash preceding the oldest retained entry: the last entry
+// dropped by the size bound or the head of the previous generation, so the
+// chain stays linked across pruning and rotation.
+type auditChainState struct {
+	Generation int    `json:"generation"` // Incremented by each rotation
+	First      uint64 `json:"first"`      // Sequence number of the oldest retained entry
+	Next       uint64 `json:"next"`       // Sequence number of the next entry
+	Anchor     string `json:"anchor"`     // Hash preceding entry First ("" at genesis)
+	Head       string `json:"head"`       // Hash of entry Next-1
+}
+
+// auditChainEntry is one login event. Hash is an HMAC over every other
+// field, so PrevHash binds each entry to its predecessor and an entry can't
+// be rehashed without the chain key.
+type auditChainEntry struct {
+	Seq        uint64    `json:"seq"`
+	Generation int       `json:"generation"`
+	Time       time.Time `json:"time"`
+	Outcome    string    `json:"outcome"` // success|failure|error
+	Role       string    `json:"role"`
+	Principal  string    `json:"principal,omitempty"`
+	ErrorCode  string    `json:"error_code,omitempty"`
+	ClientIP   string    `json:"client_ip,omitempty"`
+	PrevHash   string    `json:"prev_hash"`
+	Hash       string    `json:"hash,omitempty"`
+}
+
+// auditChainMaxEntries returns the configured chain size bound
+func (c *Config) auditChainMaxEntries() int {
+	if c.AuditChainMaxEntries == 0 {
+		return defaultAuditChainMaxEntries
+	}
+	return c.AuditChainMaxEntries
+}
+
+// hash returns the hex HMAC-SHA256 under key of the entry with its Hash
+// field cleared
+func (e auditChainEntry) hash(key []byte) (string, error) {
+	e.Hash = ""
+	raw, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(raw)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// auditChainKey returns the key entries are hashed with, generating and
+// storing it on first use when create is set; otherwise a missing key is
+// returned as nil. Callers hold auditLock.
+func (b *gmsaBackend) auditChainKey(ctx context.Context, create bool) ([]byte, error) {
+	if b.auditKey != nil {
+		return b.auditKey, nil
+	}
+	entry, err := b.storage.Get(ctx, storageKeyAuditChainKey)
+	if err != nil {
+		return nil, err
+	}
+	if entry != nil {
+		b.auditKey = entry.Value
+		return b.auditKey, nil
+	}
+	if !create {
+		return nil, nil
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := b.storage.Put(ctx, &logical.StorageEntry{Key: storageKeyAuditChainKey, Value: key, SealWrap: true}); err != nil {
+		return nil, err
+	}
+	b.auditKey = key
+	return key, nil
+}
+
+func auditChainEntryKey(seq uint64) string {
+	return fmt.Sprintf("%s%020d", storageKeyAuditChain, seq)
+}
+
+func readAuditChainState(ctx context.Context, s logical.Storage) (*auditChainState, error) {
+	entry, err := s.Get(ctx, storageKeyAuditChainState)
+	if err != nil {
+		return nil, err
+	}
+	state := &auditChainState{}
+	if entry == nil {
+		return state, nil
+	}
+	if err := entry.DecodeJSON(state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+func writeAuditChainState(ctx context.Context, s logical.Storage, state *auditChainState) error {
+	entry, err := logical.StorageEntryJSON(storageKeyAuditChainState, state)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+func readAuditChainEntry(ctx context.Context, s logical.Storage, seq uint64) (*auditChainEntry, error) {
+	entry, err := s.Get(ctx, auditChainEntryKey(seq))
+	if err != nil || entry == nil {
+		return nil, err
+	}
+	var e auditChainEntry
+	if err := entry.DecodeJSON(&e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// appendAuditChain links e to the chain head, stores it and prunes the
+// oldest entries beyond maxEntries
+func (b *gmsaBackend) appendAuditChain(ctx context.Context, e auditChainEntry, maxEntries int) error {
+	b.auditLock.Lock()
+	defer b.auditLock.Unlock()
+
+	key, err := b.auditChainKey(ctx, true)
+	if err != nil {
+		return err
+	}
+	state, err := readAuditChainState(ctx, b.storage)
+	if err != nil {
+		return err
+	}
+	e.Seq = state.Next
+	e.Generation = state.Generation
+	e.PrevHash = state.Head
+	if e.Seq == state.First {
+		e.PrevHash = state.Anchor
+	}
+	if e.Hash, err = e.hash(key); err != nil {
+		return err
+	}
+	entry, err := logical.StorageEntryJSON(auditChainEntryKey(e.Seq), e)
+	if err != nil {
+		return err
+	}
+	if err := b.storage.Put(ctx, entry); err != nil {
+		return err
+	}
+	state.Next++
+	state.Head = e.Hash
+
+	for state.Next-state.First > uint64(maxEntries) {
+		oldest, err := readAuditChainEntry(ctx, b.storage, state.First)
+		if err != nil {
+			return err
+		}
+		if oldest != nil {
+			state.Anchor = oldest.Hash
+		}
+		if err := b.storage.Delete(ctx, auditChainEntryKey(state.First)); err != nil {
+			return err
+		}
+		state.First++
+	}
+	return writeAuditChainState(ctx, b.storage, state)
+}
+
+// auditChainEvent is a login event waiting to be appended
+type auditChainEvent struct {
+	entry      auditChainEntry
+	maxEntries int
+}
+
+// auditChainQueue hands login events to runAuditChain, which appends them in
+// the order they were queued, so logins don't wait on the chain's storage
+// writes or on each other
+type auditChainQueue struct {
+	events  chan auditChainEvent
+	pending sync.WaitGroup // Events queued but not yet appended
+	done    chan struct{}  // Closed when the backend is cleaned up
+	stop    sync.Once
+}
+
+func newAuditChainQueue() *auditChainQueue {
+	return &auditChainQueue{
+		events: make(chan auditChainEvent, auditChainQueueSize),
+		done:   make(chan struct{}),
+	}
+}
+
+// enqueue queues an event without blocking. It reports false when the queue
+// is full or stopped and the event was dropped.
+func (q *auditChainQueue) enqueue(ev auditChainEvent) bool {
+	q.pending.Add(1)
+	select {
+	case <-q.done:
+	default:
+		select {
+		case q.events <- ev:
+			return true
+		default:
+		}
+	}
+	q.pending.Done()
+	return false
+}
+
+// close stops runAuditChain; events still queued are dropped
+func (q *auditChainQueue) close() {
+	q.stop.Do(func() { close(q.done) })
+}
+
+// runAuditChain appends queued login events until the queue is closed.
+// Append failures are logged, never returned: the chain is evidence for
+// auditors, not a login dependency.
+func (b *gmsaBackend) runAuditChain(q *auditChainQueue) {
+	for {
+		select {
+		case ev := <-q.events:
+			if err := b.appendAuditChain(context.Background(), ev.entry, ev.maxEntries); err != nil {
+				b.logger.Error("failed to append login event to audit chain", "role", ev.entry.Role, "error", err)
+			}
+			q.pending.Done()
+		case <-q.done:
+			return
+		}
+	}
+}
+
+// recordLoginAudit queues the outcome of a login for the hash chain when
+// audit_chain is enabled. The setting is read from memory, kept current by
+// config writes and invalidation, so the login path does no storage I/O.
+func (b *gmsaBackend) recordLoginAudit(req *logical.Request, roleName string, resp *logical.Response, loginErr error) {
+	maxEntries := b.auditChainMax.Load()
+	if b.auditQueue == nil || maxEntries == 0 {
+		return
+	}
+
+	e := auditChainEntry{Time: b.now().UTC(), Role: roleName}
+	if req.Connection != nil {
+		e.ClientIP = req.Connection.RemoteAddr
+	}
+	switch {
+	case loginErr != nil:
+		e.Outcome = "error"
+		e.ErrorCode = errorCodeInternal
+	case resp == nil || resp.IsError():
+		e.Outcome = "failure"
+		if resp != nil {
+			e.ErrorCode = loginErrorCode(resp)
+		}
+	default:
+		e.Outcome = "success"
+		e.Principal = resp.Auth.Metadata["principal"]
+	}
+	if !b.auditQueue.enqueue(auditChainEvent{entry: e, maxEntries: int(maxEntries)}) {
+		b.logger.Warn("audit chain queue full, login event dropped", "role", roleName)
+	}
+}
+
+// verifyAuditChain walks the retained entries, checking that each is present,
+// hashes under key to its stored hash and links to its predecessor, and that
+// the last one is the recorded head. It returns the state and the sequence
+// number and reason of the first break, if any.
+func verifyAuditChain(ctx context.Context, s logical.Storage, key []byte) (*auditChainState, uint64, string, error) {
+	state, err := readAuditChainState(ctx, s)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	prev := state.Anchor
+	for seq := state.First; seq < state.Next; seq++ {
+		e, err := readAuditChainEntry(ctx, s, seq)
+		if err != nil {
+			return nil, 0, "", err
+		}
+		if e == nil {
+			return state, seq, "entry missing", nil
+		}
+		if e.Seq != seq {
+			return state, seq, "sequence number mismatch", nil
+		}
+		if e.PrevHash != prev {
+			return state, seq, "previous hash mismatch", nil
+		}
+		if h, err := e.hash(key); err != nil || h != e.Hash {
+			return state, seq, "entry hash mismatch", nil
+		}
+		prev = e.Hash
+	}
+	if prev != state.Head {
+		return state, state.Next, "chain head mismatch", nil
+	}
+	return state, 0, "", nil
+}
+
+// rotateAuditChain deletes the retained entries and starts a new generation
+// anchored on the old head. It returns the state before rotation so the
+// final head can be recorded outside Vault.
+func (b *gmsaBackend) rotateAuditChain(ctx context.Context) (*auditChainState, error) {
+	b.auditLock.Lock()
+	defer b.auditLock.Unlock()
+
+	state, err := readAuditChainState(ctx, b.storage)
+	if err != nil {
+		return nil, err
+	}
+	old := *state
+	for seq := state.First; seq < state.Next; seq++ {
+		if err := b.storage.Delete(ctx, auditChainEntryKey(seq)); err != nil {
+			return nil, err
+		}
+	}
+	state.Generation++
+	state.First = state.Next
+	state.Anchor = state.Head
+	if err := writeAuditChainState(ctx, b.storage, state); err != nil {
+		return nil, err
+	}
+	return &old, nil
+}
@@ -0,0 +1,271 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// appendTestAuditEvents appends n failure events to the chain
+func appendTestAuditEvents(t *testing.T, b *gmsaBackend, n, maxEntries int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		e := auditChainEntry{Time: b.now().UTC(), Outcome: "failure", Role: fmt.Sprintf("role%d", i), ErrorCode: errorCodeNoGroupMatch}
+		if err := b.appendAuditChain(context.Background(), e, maxEntries); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// flushAuditChain waits until every queued login event has been appended
+func flushAuditChain(b *gmsaBackend) {
+	b.auditQueue.pending.Wait()
+}
+
+// readAuditVerify reads audit/chain/verify
+func readAuditVerify(t *testing.T, b *gmsaBackend, storage logical.Storage) map[string]interface{} {
+	t.Helper()
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "audit/chain/verify",
+		Storage:   storage,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("verify failed: err=%v resp=%#v", err, resp)
+	}
+	return resp.Data
+}
+
+func TestAuditChain_RecordsLogins(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	kt := newTestLoginConfig(t, storage)
+	if err := writeRole(ctx, storage, &Role{Name: "app", TokenPolicies: []string{"app"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	login := func(role string) {
+		t.Helper()
+		if _, err := b.HandleRequest(ctx, &logical.Request{
+			Operation:  logical.UpdateOperation,
+			Path:       "login",
+			Storage:    storage,
+			Data:       map[string]interface{}{"role": role, "spnego": newTestLoginSPNEGO(t, kt)},
+			Connection: &logical.Connection{RemoteAddr: "127.0.0.1"},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Disabled by default
+	login("app")
+	flushAuditChain(b)
+	if state, err := readAuditChainState(ctx, storage); err != nil || state.Next != 0 {
+		t.Fatalf("events recorded while disabled: %+v, %v", state, err)
+	}
+
+	cfg, err := readConfig(ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.AuditChain = true
+	if err := writeConfig(ctx, storage, cfg); err != nil {
+		t.Fatal(err)
+	}
+	b.invalidate(ctx, storageKeyConfig)
+	login("app")
+	login("missing")
+	flushAuditChain(b)
+
+	first, err := readAuditChainEntry(ctx, storage, 0)
+	if err != nil || first == nil {
+		t.Fatalf("first entry: %+v, %v", first, err)
+	}
+	if first.Outcome != "success" || first.Principal != "user@EXAMPLE.COM" || first.Role != "app" || first.PrevHash != "" {
+		t.Errorf("first entry = %+v, want a success for user@EXAMPLE.COM at genesis", first)
+	}
+	second, err := readAuditChainEntry(ctx, storage, 1)
+	if err != nil || second == nil {
+		t.Fatalf("second entry: %+v, %v", second, err)
+	}
+	if second.Outcome != "failure" || second.ErrorCode != errorCodeRoleNotFound || second.PrevHash != first.Hash {
+		t.Errorf("second entry = %+v, want a role_not_found failure linked to %s", second, first.Hash)
+	}
+
+	data := readAuditVerify(t, b, storage)
+	if data["valid"] != true || data["entries"] != uint64(2) || data["head"] != second.Hash {
+		t.Errorf("verify = %v, want a valid chain of 2 ending at %s", data, second.Hash)
+	}
+}
+
+func TestAuditChain_DetectsTampering(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(t *testing.T, s logical.Storage)
+		seq    uint64
+		reason string
+	}{
+		{
+			name: "altered entry",
+			tamper: func(t *testing.T, s logical.Storage) {
+				e, err := readAuditChainEntry(context.Background(), s, 2)
+				if err != nil || e == nil {
+					t.Fatalf("read entry: %v", err)
+				}
+				e.Outcome = "success"
+				entry, _ := logical.StorageEntryJSON(auditChainEntryKey(2), e)
+				if err := s.Put(context.Background(), entry); err != nil {
+					t.Fatal(err)
+				}
+			},
+			seq:    2,
+			reason: "entry hash mismatch",
+		},
+		{
+			name: "deleted entry",
+			tamper: func(t *testing.T, s logical.Storage) {
+				if err := s.Delete(context.Background(), auditChainEntryKey(1)); err != nil {
+					t.Fatal(err)
+				}
+			},
+			seq:    1,
+			reason: "entry missing",
+		},
+		{
+			name: "rehashed without the key",
+			tamper: func(t *testing.T, s logical.Storage) {
+				e, err := readAuditChainEntry(context.Background(), s, 1)
+				if err != nil || e == nil {
+					t.Fatalf("read entry: %v", err)
+				}
+				e.Role = "other"
+				e.Hash, _ = e.hash([]byte("guessed key"))
+				entry, _ := logical.StorageEntryJSON(auditChainEntryKey(1), e)
+				if err := s.Put(context.Background(), entry); err != nil {
+					t.Fatal(err)
+				}
+			},
+			seq:    1,
+			reason: "entry hash mismatch",
+		},
+		{
+			name: "rehashed with the key",
+			tamper: func(t *testing.T, s logical.Storage) {
+				// Even with the key, an altered entry breaks the next link
+				e, err := readAuditChainEntry(context.Background(), s, 1)
+				if err != nil || e == nil {
+					t.Fatalf("read entry: %v", err)
+				}
+				key, err := s.Get(context.Background(), storageKeyAuditChainKey)
+				if err != nil || key == nil {
+					t.Fatalf("read key: %v", err)
+				}
+				e.Role = "other"
+				e.Hash, _ = e.hash(key.Value)
+				entry, _ := logical.StorageEntryJSON(auditChainEntryKey(1), e)
+				if err := s.Put(context.Background(), entry); err != nil {
+					t.Fatal(err)
+				}
+			},
+			seq:    2,
+			reason: "previous hash mismatch",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, storage := getTestBackend(t)
+			appendTestAuditEvents(t, b, 4, defaultAuditChainMaxEntries)
+			if data := readAuditVerify(t, b, storage); data["valid"] != true {
+				t.Fatalf("untampered chain invalid: %v", data)
+			}
+
+			tt.tamper(t, storage)
+			data := readAuditVerify(t, b, storage)
+			if data["valid"] != false || data["broken_at"] != tt.seq || data["reason"] != tt.reason {
+				t.Errorf("verify = %v, want broken at %d (%s)", data, tt.seq, tt.reason)
+			}
+		})
+	}
+}
+
+func TestAuditChain_BoundAndRotate(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+
+	appendTestAuditEvents(t, b, 5, 3)
+	data := readAuditVerify(t, b, storage)
+	if data["valid"] != true || data["entries"] != uint64(3) || data["first_seq"] != uint64(2) {
+		t.Fatalf("verify = %v, want a valid chain of entries 2-4", data)
+	}
+	if e, _ := readAuditChainEntry(ctx, storage, 1); e != nil {
+		t.Errorf("pruned entry 1 still stored: %+v", e)
+	}
+	head := data["head"]
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "audit/chain/rotate",
+		Storage:   storage,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("rotate failed: err=%v resp=%#v", err, resp)
+	}
+	if resp.Data["previous_head"] != head || resp.Data["previous_entries"] != uint64(3) || resp.Data["generation"] != 1 {
+		t.Errorf("rotate = %v, want generation 1 after 3 entries ending at %v", resp.Data, head)
+	}
+
+	// The new generation links to the old head
+	appendTestAuditEvents(t, b, 1, 3)
+	e, err := readAuditChainEntry(ctx, storage, 5)
+	if err != nil || e == nil || e.PrevHash != head || e.Generation != 1 {
+		t.Fatalf("first entry after rotation = %+v, %v; want generation 1 linked to %v", e, err, head)
+	}
+	if data := readAuditVerify(t, b, storage); data["valid"] != true || data["entries"] != uint64(1) {
+		t.Errorf("verify after rotation = %v, want a valid chain of 1", data)
+	}
+}
+
+func TestAuditChain_KeyIsSealWrapped(t *testing.T) {
+	b, storage := getTestBackend(t)
+	appendTestAuditEvents(t, b, 1, defaultAuditChainMaxEntries)
+
+	entry, err := storage.Get(context.Background(), storageKeyAuditChainKey)
+	if err != nil || entry == nil || len(entry.Value) != 32 {
+		t.Fatalf("chain key = %+v, %v; want a stored 32-byte key", entry, err)
+	}
+	if !slices.Contains(b.PathsSpecial.SealWrapStorage, storageKeyAuditChainKey) {
+		t.Errorf("SealWrapStorage = %v, want %s", b.PathsSpecial.SealWrapStorage, storageKeyAuditChainKey)
+	}
+
+	// A backend loaded later verifies with the stored key
+	b2, err := Factory(context.Background(), &logical.BackendConfig{System: &logical.StaticSystemView{}, StorageView: storage})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data := readAuditVerify(t, b2.(*gmsaBackend), storage); data["valid"] != true {
+		t.Errorf("verify from a new backend = %v, want valid", data)
+	}
+}
+
+func TestAuditChainQueue_DropsWhenFullOrClosed(t *testing.T) {
+	q := newAuditChainQueue()
+	for i := 0; i < auditChainQueueSize; i++ {
+		if !q.enqueue(auditChainEvent{maxEntries: 1}) {
+			t.Fatalf("enqueue %d dropped before the queue was full", i)
+		}
+	}
+	if q.enqueue(auditChainEvent{maxEntries: 1}) {
+		t.Error("enqueue on a full queue succeeded")
+	}
+
+	q = newAuditChainQueue()
+	q.close()
+	q.close()
+	if q.enqueue(auditChainEvent{maxEntries: 1}) {
+		t.Error("enqueue on a closed queue succeeded")
+	}
+	q.pending.Wait()
+}
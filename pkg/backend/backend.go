@@ -4,11 +4,18 @@ import (
 	"context"
 	"expvar"
 	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/lpassig/vault-plugin-auth-gmsa/internal/kerb"
+	"github.com/lpassig/vault-plugin-auth-gmsa/internal/logging"
 )
 
 // Plugin version constant for tracking and compatibility
@@ -23,8 +30,110 @@ var (
 	pacValidations          = expvar.NewInt("pac_validations")
 	pacValidationFailures   = expvar.NewInt("pac_validation_failures")
 	inputValidationFailures = expvar.NewInt("input_validation_failures")
+	principalLockouts       = expvar.NewInt("principal_lockouts")
+	lockoutRejections       = expvar.NewInt("lockout_rejections")
+	enctypeDowngrades       = expvar.NewInt("enctype_downgrades")
+	channelBindingFailures  = expvar.NewInt("channel_binding_failures")
+	kdcProbeFailures        = expvar.NewInt("kdc_probe_failures")
+	authFailuresByReason    = expvar.NewMap("auth_failures_by_reason")
+	tokensIssuedByType      = expvar.NewMap("tokens_issued_by_type")
+)
+
+// Failure reasons used to label authentication failures
+const (
+	failureReasonInputValidation = "input_validation"
+	failureReasonNegotiation     = "negotiation"
+	failureReasonChannelBinding  = "channel_binding"
+	failureReasonPAC             = "pac"
+	failureReasonRealm           = "authorization_realm"
+	failureReasonSPN             = "authorization_spn"
+	failureReasonSPNHost         = "authorization_spn_host"
+	failureReasonSPNRealm        = "authorization_spn_realm"
+	failureReasonGroup           = "authorization_group"
+	failureReasonGroupLimit      = "authorization_group_limit"
+	failureReasonPACUnavailable  = "authorization_pac_unavailable"
+	failureReasonLockout         = "lockout"
+	failureReasonRoleDisabled    = "role_disabled"
+	failureReasonClientCert      = "authorization_client_cert"
+	failureReasonStaleTicket     = "stale_ticket"
+	failureReasonNoPolicies      = "no_policies"
+	failureReasonPrincipal       = "authorization_principal"
+	failureReasonPACMissing      = "authorization_pac_missing"
+	failureReasonBusy            = "busy"
+	failureReasonNotInitial      = "not_initial_ticket"
+	failureReasonUPNInfoMissing  = "authorization_upn_dns_info_missing"
+	failureReasonAliasMissing    = "alias_unavailable"
+	failureReasonAccountType     = "authorization_account_type"
+	failureReasonUserSID         = "authorization_user_sid"
+	failureReasonUnknownBuffer   = "authorization_pac_unknown_buffer"
+	failureReasonPACFlags        = "authorization_pac_flags"
 )
 
+// failureReasons lists every failure reason so metrics report zero buckets
+var failureReasons = []string{
+	failureReasonInputValidation,
+	failureReasonNegotiation,
+	failureReasonChannelBinding,
+	failureReasonPAC,
+	failureReasonRealm,
+	failureReasonSPN,
+	failureReasonSPNHost,
+	failureReasonSPNRealm,
+	failureReasonGroup,
+	failureReasonGroupLimit,
+	failureReasonPACUnavailable,
+	failureReasonLockout,
+	failureReasonRoleDisabled,
+	failureReasonClientCert,
+	failureReasonStaleTicket,
+	failureReasonNoPolicies,
+	failureReasonPrincipal,
+	failureReasonPACMissing,
+	failureReasonBusy,
+	failureReasonNotInitial,
+	failureReasonUPNInfoMissing,
+	failureReasonAliasMissing,
+	failureReasonAccountType,
+	failureReasonUserSID,
+	failureReasonUnknownBuffer,
+	failureReasonPACFlags,
+}
+
+// recordAuthFailure increments the total failure counter and the labeled
+// counter for the given reason
+func recordAuthFailure(reason string) {
+	authFailures.Add(1)
+	authFailuresByReason.Add(reason, 1)
+}
+
+// failureReasonCount returns the current count for a failure reason
+func failureReasonCount(reason string) int64 {
+	if v, ok := authFailuresByReason.Get(reason).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+// tokenTypes lists the token types logins can issue so metrics report zero
+// buckets
+var tokenTypes = []string{
+	logical.TokenTypeDefault.String(),
+	logical.TokenTypeService.String(),
+}
+
+// recordTokenIssued counts a token issued by a successful login
+func recordTokenIssued(tokenType logical.TokenType) {
+	tokensIssuedByType.Add(tokenType.String(), 1)
+}
+
+// tokensIssuedCount returns the number of tokens issued of the given type
+func tokensIssuedCount(tokenType string) int64 {
+	if v, ok := tokensIssuedByType.Get(tokenType).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
 // PluginMetadata contains comprehensive plugin information
 type PluginMetadata struct {
 	Version     string   `json:"version"`
@@ -73,9 +182,38 @@ type RotationManagerInterface interface {
 type gmsaBackend struct {
 	*framework.Backend
 	storage         logical.Storage          // Vault's storage interface for persistent data
+	storagePrefix   string                   // storage_prefix mount option, "" or ending in "/"
 	now             func() time.Time         // Time function for testing and consistency
 	rotationManager RotationManagerInterface // Automated password rotation manager (platform-specific)
 	logger          hclog.Logger             // Vault-compatible logger
+	lockout         *principalLockout        // Per-principal failure tracking
+	pacCache        *kerb.PACCache           // PAC validation results, used when enabled in config
+	roleLock        sync.Mutex               // Serializes read-modify-write role updates
+	configLock      sync.Mutex               // Serializes config writes
+	restartLock     sync.Mutex               // Rejects overlapping rotation/restart requests
+	rotationLock    sync.Mutex               // Rejects overlapping keytab rotations across managers
+	successSampler  successSampler           // Picks the successful logins that are logged
+	loginLimiter    loginLimiter             // Bounds concurrent Kerberos validations
+	tracer          trace.Tracer             // Login spans; no-op unless a provider is installed
+	auditLock       sync.Mutex               // Serializes audit hash chain updates
+	lastLoginLock   sync.Mutex               // Serializes last login summary updates
+	groupNames      *groupNameCache          // Group SID to name lookups, used when enabled in config
+	groupSIDs       groupSIDResolver         // Group name to SID lookups for role/<name>/bind-groups
+
+	// redactor holds the config's log redaction rules, applied by logger
+	// to every entry; nil applies the built-in rules
+	redactor atomic.Pointer[logging.Redactor]
+	// auditChainMax is audit_chain_max_entries while audit_chain is enabled
+	// and 0 otherwise, so logins check it without reading the config
+	auditChainMax atomic.Int64
+	auditQueue    *auditChainQueue // Login events waiting for the hash chain
+	auditKey      []byte           // Cached audit chain HMAC key, guarded by auditLock
+	// principalPatterns holds the principal patterns compiled from the
+	// stored config; see usePrincipalPatterns
+	principalPatterns atomic.Pointer[principalPatterns]
+	// lastLoginCount is the number of indexed last login summaries, or -1
+	// until the index is next read; guarded by lastLoginLock
+	lastLoginCount int
 }
 
 // Factory creates and configures a new gMSA auth method backend
@@ -89,9 +227,27 @@ func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend,
 
 	// Initialize backend with current time function and logger
 	b := &gmsaBackend{
-		now:    time.Now,
-		logger: logger,
+		now:      time.Now,
+		logger:   logger,
+		lockout:  newPrincipalLockout(),
+		pacCache: kerb.NewPACCache(0),
+		tracer:   defaultTracer(),
+		// Names are only looked up when group_names is enabled
+		groupNames: newGroupNameCache(newLDAPSearchResolver()),
+		groupSIDs:  newLDAPSearchResolver(),
+		// Login events are appended to the hash chain in the background
+		auditQueue: newAuditChainQueue(),
+		// Last login summaries are counted on the first new principal
+		lastLoginCount: -1,
+	}
+	b.logger = logging.NewRedactingLogger(logger, b.redactor.Load)
+
+	// Tenants sharing a backend keep their storage under separate prefixes
+	prefix, err := parseStoragePrefix(conf.Config[storagePrefixOption])
+	if err != nil {
+		return nil, err
 	}
+	b.storagePrefix = prefix
 
 	// Configure the Vault framework backend
 	b.Backend = &framework.Backend{
@@ -102,6 +258,9 @@ func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend,
 		PathsSpecial: &logical.Paths{
 			// Login endpoint is unauthenticated (no token required)
 			Unauthenticated: []string{"login"},
+			// Rotation config holds AD credentials and webhook secrets; the
+			// audit chain key is what makes its hashes unforgeable
+			SealWrapStorage: []string{prefix + "rotation/config", prefix + storageKeyAuditChainKey},
 		},
 		// Register all API endpoints
 		Paths: framework.PathAppend(
@@ -110,10 +269,18 @@ func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend,
 			pathsLogin(b),    // Authentication endpoint
 			pathsHealth(b),   // Health endpoints
 			pathsMetrics(b),  // Metrics endpoints
+			pathsSelf(b),     // Caller identity introspection
 			pathsRotation(b), // Password rotation endpoints
+			pathsAudit(b),    // Login event hash chain
+			pathsSelfTest(b), // End-to-end self-test
 		),
-		// Let Vault core handle renewals via Auth.Period/TTL
-		AuthRenew:      nil,
+		// Renewals re-check the role and apply its current period/max_ttl
+		AuthRenew: b.authRenew,
+		// Unloading the mount stops the audit chain writer
+		Clean: b.clean,
+		// Config writes replicated from the active node reload the settings
+		// kept in memory
+		Invalidate:     b.invalidate,
 		RunningVersion: pluginVersion,
 	}
 
@@ -122,8 +289,11 @@ func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend,
 		return nil, err
 	}
 
-	// Store the storage interface for persistent data
-	b.storage = conf.StorageView
+	// Store the storage interface for persistent data, scoped to the
+	// mount's tenant prefix when one is configured
+	b.storage = b.scopedStorage(conf.StorageView)
+	b.loadConfigSettings(ctx)
+	go b.runAuditChain(b.auditQueue)
 
 	// Initialize rotation manager if configuration exists
 	if err := b.initializeRotationManager(ctx); err != nil {
@@ -132,9 +302,33 @@ func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend,
 		b.logger.Warn("failed to initialize rotation manager", "error", err)
 	}
 
+	// Summarize what the mount found at startup for operators
+	b.logStartupDiagnostics(ctx)
+
 	return b, nil
 }
 
+// invalidate reacts to storage changes made by another node
+func (b *gmsaBackend) invalidate(ctx context.Context, key string) {
+	key, ok := b.unscopedKey(key)
+	if !ok {
+		return
+	}
+	switch {
+	case key == storageKeyConfig:
+		b.loadConfigSettings(ctx)
+	case strings.HasPrefix(key, storageKeyLastLoginIndex):
+		b.lastLoginLock.Lock()
+		b.lastLoginCount = -1
+		b.lastLoginLock.Unlock()
+	}
+}
+
+// clean releases the backend's background work when the mount is unloaded
+func (b *gmsaBackend) clean(_ context.Context) {
+	b.auditQueue.close()
+}
+
 // initializeRotationManager initializes the rotation manager if configuration exists
 func (b *gmsaBackend) initializeRotationManager(ctx context.Context) error {
 	// Check if rotation configuration exists
@@ -4,11 +4,22 @@ import (
 	"context"
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"net"
+	"net/http"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+
+	"github.com/lpassig/vault-plugin-auth-gmsa/internal/kerb"
+	"github.com/lpassig/vault-plugin-auth-gmsa/internal/logging"
 )
 
 // Storage keys for persistent data in Vault's storage
@@ -20,14 +31,111 @@ const (
 // Config represents the global configuration for the gMSA auth method
 // This configuration is shared across all authentication attempts
 type Config struct {
-	Realm            string   `json:"realm"`                 // Kerberos realm (e.g., EXAMPLE.COM)
-	KDCs             []string `json:"kdcs"`                  // List of Key Distribution Centers
-	KeytabB64        string   `json:"keytab"`                // Base64-encoded keytab file
-	SPN              string   `json:"spn"`                   // Service Principal Name (e.g., HTTP/vault.example.com)
-	AllowChannelBind bool     `json:"allow_channel_binding"` // Enable TLS channel binding
-	ClockSkewSec     int      `json:"clock_skew_sec"`        // Allowed clock skew in seconds
+	// Version of the stored entry's schema; see migrateConfig
+	SchemaVersion    int      `json:"schema_version"`
+	Realm            string   `json:"realm"`                   // Kerberos realm (e.g., EXAMPLE.COM)
+	KDCs             []string `json:"kdcs"`                    // List of Key Distribution Centers
+	KeytabB64        string   `json:"keytab"`                  // Base64-encoded keytab file
+	SPN              string   `json:"spn"`                     // Service Principal Name (e.g., HTTP/vault.example.com)
+	AllowChannelBind bool     `json:"allow_channel_binding"`   // Enable TLS channel binding
+	ClockSkewSec     int      `json:"clock_skew_sec"`          // Allowed clock skew in seconds
+	PACUPNMatch      bool     `json:"pac_upn_match"`           // Require the PAC UPN to name the logon user
+	ForbidRC4        bool     `json:"forbid_rc4"`              // Reject keytabs with only RC4-HMAC keys for the SPN
+	PACCache         bool     `json:"pac_cache"`               // Cache PAC validation results per ticket
+	Krb5Conf         string   `json:"krb5_conf,omitempty"`     // Raw krb5.conf text with library tunables
+	EmitGroupAliases bool     `json:"emit_group_aliases"`      // Return an identity group alias per group SID
+	AllowedMechOIDs  []string `json:"allowed_mech_oids"`       // Accepted GSS mechanism OIDs (empty = Kerberos only)
+	LogonServerMeta  bool     `json:"logon_server_metadata"`   // Add the PAC logon server to login metadata
+	VerifySPNHost    bool     `json:"verify_spn_matches_host"` // Require the ticket SPN host to match the Host header
+	RejectDowngrade  bool     `json:"reject_downgrade"`        // Reject tickets weaker than the keytab's best key
+	RequirePAC       bool     `json:"require_pac_present"`     // Reject tickets that carry no PAC
+	FilterSIDHistory bool     `json:"filter_sid_history"`      // Drop SID history from group SIDs
+	RequireUPNInfo   bool     `json:"require_upn_dns_info"`    // Reject PACs without a UPN_DNS_INFO buffer
+	Base64Strict     bool     `json:"base64_strict"`           // Accept only padded standard base64 tokens
+	// Request header carrying the host the client called, checked by
+	// verify_spn_matches_host (empty = X-Forwarded-Host)
+	SPNHostHeader string `json:"spn_host_header,omitempty"`
+	// Fail PAC validation for RC4 HMAC-MD5 signatures even when they verify
+	PACRequireAES bool `json:"pac_require_aes_signatures"`
+	// Handling of PAC buffer types MS-PAC doesn't define
+	PACUnknownBufferMode string `json:"pac_unknown_buffer_mode,omitempty"` // ignore|warn|reject
+	// Per-attempt connect timeout (0 = 2s) and retries for KDC connections
+	KDCTimeoutSec int `json:"kdc_timeout_sec"`
+	KDCRetries    int `json:"kdc_retries"`
+	// Skip PAC decoding and validation for mounts that don't authorize by group
+	DisablePACProcessing bool `json:"disable_pac_processing"`
+	// Require a keytab entry for the SPN itself, not just the realm
+	KeytabRequireSPN bool `json:"keytab_require_spn"`
+	// Group SID prefixes kept (empty = all) and then dropped before authorization
+	OnlySIDPrefixes    []string `json:"only_sid_prefixes"`
+	ExcludeSIDPrefixes []string `json:"exclude_sid_prefixes"`
+	// Extra regexes redacted from logged errors, and whether SIDs are left in
+	LogRedactPatterns   []string `json:"log_redact_patterns"`
+	DisableSIDRedaction bool     `json:"disable_sid_redaction"`
+	// Return the authorizing group SIDs in login responses; for debugging only
+	ExposeGroupSIDsInResponse bool `json:"expose_group_sids_in_response"`
+	// Reject tickets issued for the SPN in a realm other than the configured one
+	RequireSPNRealmMatch bool `json:"require_spn_realm_match"`
+	// Fraction of successful logins logged (0 = none, 1 = all); failures are always logged
+	SuccessLogSampleRate float64 `json:"success_log_sample_rate"`
+	// Mount-wide token defaults for roles that leave token_type or token_policies unset
+	DefaultTokenType string   `json:"default_token_type,omitempty"` // default|service
+	DefaultPolicies  []string `json:"default_policies"`
+	// Policies added to every token after role deny_policies are applied
+	BasePolicies []string `json:"base_policies"`
+	// Mount-wide principal guardrails checked before any role constraint;
+	// deny wins over allow
+	PrincipalAllowPattern string `json:"principal_allow_pattern,omitempty"`
+	PrincipalDenyPattern  string `json:"principal_deny_pattern,omitempty"`
+	// patterns caches the compiled principal patterns; see
+	// compiledPrincipalPatterns
+	patterns *principalPatterns
+	// Token display name derived from the principal; metadata keeps the raw
+	// principal either way
+	DisplayNameFormat    string `json:"display_name_format,omitempty"` // principal|sanitized|name
+	DisplayNameMaxLength int    `json:"display_name_max_length"`       // 0 = no limit
+	// Identity entity alias name; setting it attaches an alias to every
+	// login, not only those with emit_group_aliases
+	AliasSource string `json:"alias_source,omitempty"` // principal|sid|upn
+	// Principal lockout after repeated authorization failures (0 disables)
+	PrincipalLockoutThreshold   int `json:"principal_lockout_threshold"` // Consecutive failures before lockout
+	PrincipalLockoutDurationSec int `json:"principal_lockout_duration"`  // Lockout window in seconds
+	// Concurrent Kerberos validations; excess logins queue briefly, then
+	// fail as busy (0 = no limit)
+	MaxConcurrentLogins int `json:"max_concurrent_logins"`
+	// Days before the gMSA password expires that health starts warning
+	// (0 disables)
+	PasswordExpiryWarnDays int `json:"password_expiry_warn_days"`
+	// Tamper-evident login event log, bounded to the newest entries
+	// (0 = default bound)
+	AuditChain           bool `json:"audit_chain"`
+	AuditChainMaxEntries int  `json:"audit_chain_max_entries"`
+	// Decoded PAC summary of each principal's last successful login, bounded
+	// to the most recent principals (0 = default bound)
+	LastLogin           bool     `json:"last_login"`
+	LastLoginMaxEntries int      `json:"last_login_max_entries"`
+	LastLoginRedact     []string `json:"last_login_redact"` // Summary fields not stored
+	// Keytab replaced by the last rotation, still accepted until it expires
+	PreviousKeytabB64       string    `json:"previous_keytab,omitempty"`  // Base64-encoded previous keytab
+	PreviousKeytabExpiresAt time.Time `json:"previous_keytab_expires_at"` // End of the rotation grace window
+	// Separately exported keytabs merged with keytab at login (e.g. other SPNs)
+	AdditionalKeytabs []string `json:"additional_keytabs,omitempty"` // Base64-encoded keytabs
 	// Normalization settings for flexible environment adaptation
 	Normalization NormalizationConfig `json:"normalization"`
+	// Negotiate handshake headers for HTTP clients
+	Negotiate NegotiateConfig `json:"negotiate"`
+	// LDAP lookup of group names for login metadata
+	GroupNames GroupNamesConfig `json:"group_names"`
+}
+
+// NegotiateConfig controls the WWW-Authenticate headers of the HTTP Negotiate
+// handshake. The zero value matches the official Kerberos plugin: reads of the
+// login endpoint are challenged with 401 and a bare "Negotiate", and successful
+// logins carry no continuation token.
+type NegotiateConfig struct {
+	SuppressChallenge bool `json:"suppress_challenge"` // Don't emit the WWW-Authenticate challenge
+	ChallengeStatus   int  `json:"challenge_status"`   // HTTP status for the challenge (default 401)
+	ResponseToken     bool `json:"response_token"`     // Return an accept-completed SPNEGO token on success
 }
 
 // NormalizationConfig defines how realms and SPNs should be normalized
@@ -45,11 +153,57 @@ type NormalizationConfig struct {
 // Excludes sensitive data like keytab contents
 func (c *Config) Safe() map[string]any {
 	return map[string]any{
-		"realm":                 c.Realm,
-		"kdcs":                  strings.Join(c.KDCs, ","),
-		"spn":                   c.SPN,
-		"allow_channel_binding": c.AllowChannelBind,
-		"clock_skew_sec":        c.ClockSkewSec,
+		"schema_version":              c.SchemaVersion,
+		"realm":                       c.Realm,
+		"kdcs":                        strings.Join(c.KDCs, ","),
+		"kdc_timeout_sec":             int(c.kdcTimeout().Seconds()),
+		"kdc_retries":                 c.KDCRetries,
+		"spn":                         c.SPN,
+		"allow_channel_binding":       c.AllowChannelBind,
+		"clock_skew_sec":              c.ClockSkewSec,
+		"pac_upn_match":               c.PACUPNMatch,
+		"pac_require_aes_signatures":  c.PACRequireAES,
+		"pac_unknown_buffer_mode":     c.pacUnknownBufferMode(),
+		"disable_pac_processing":      c.DisablePACProcessing,
+		"keytab_require_spn":          c.KeytabRequireSPN,
+		"require_spn_realm_match":     c.RequireSPNRealmMatch,
+		"only_sid_prefixes":           strings.Join(c.OnlySIDPrefixes, ","),
+		"exclude_sid_prefixes":        strings.Join(c.ExcludeSIDPrefixes, ","),
+		"log_redact_patterns":         c.LogRedactPatterns,
+		"disable_sid_redaction":       c.DisableSIDRedaction,
+		"forbid_rc4":                  c.ForbidRC4,
+		"pac_cache":                   c.PACCache,
+		"krb5_conf":                   c.Krb5Conf,
+		"emit_group_aliases":          c.EmitGroupAliases,
+		"allowed_mech_oids":           strings.Join(c.allowedMechOIDs(), ","),
+		"logon_server_metadata":       c.LogonServerMeta,
+		"verify_spn_matches_host":     c.VerifySPNHost,
+		"spn_host_header":             c.spnHostHeader(),
+		"reject_downgrade":            c.RejectDowngrade,
+		"require_pac_present":         c.RequirePAC,
+		"filter_sid_history":          c.FilterSIDHistory,
+		"require_upn_dns_info":        c.RequireUPNInfo,
+		"base64_strict":               c.Base64Strict,
+		"success_log_sample_rate":     c.SuccessLogSampleRate,
+		"default_token_type":          c.DefaultTokenType,
+		"default_policies":            strings.Join(c.DefaultPolicies, ","),
+		"base_policies":               strings.Join(c.BasePolicies, ","),
+		"principal_allow_pattern":     c.PrincipalAllowPattern,
+		"principal_deny_pattern":      c.PrincipalDenyPattern,
+		"display_name_format":         c.displayNameFormat(),
+		"display_name_max_length":     c.DisplayNameMaxLength,
+		"alias_source":                c.aliasSource(),
+		"principal_lockout_threshold": c.PrincipalLockoutThreshold,
+		"principal_lockout_duration":  c.PrincipalLockoutDurationSec,
+		"max_concurrent_logins":       c.MaxConcurrentLogins,
+		"password_expiry_warn_days":   c.PasswordExpiryWarnDays,
+		"audit_chain":                 c.AuditChain,
+		"audit_chain_max_entries":     c.auditChainMaxEntries(),
+		"last_login":                  c.LastLogin,
+		"last_login_max_entries":      c.lastLoginMaxEntries(),
+		"last_login_redact":           c.LastLoginRedact,
+		"previous_keytab_expires_at":  previousKeytabExpiry(c),
+		"additional_keytab_count":     len(c.AdditionalKeytabs),
 		"normalization": map[string]any{
 			"realm_case_sensitive": c.Normalization.RealmCaseSensitive,
 			"spn_case_sensitive":   c.Normalization.SPNCaseSensitive,
@@ -58,10 +212,25 @@ func (c *Config) Safe() map[string]any {
 			"realm_prefixes":       strings.Join(c.Normalization.RealmPrefixes, ","),
 			"spn_prefixes":         strings.Join(c.Normalization.SPNPrefixes, ","),
 		},
+		"negotiate": map[string]any{
+			"challenge":        !c.Negotiate.SuppressChallenge,
+			"challenge_status": c.Negotiate.challengeStatus(),
+			"response_token":   c.Negotiate.ResponseToken,
+		},
+		"expose_group_sids_in_response": c.ExposeGroupSIDsInResponse,
+		"group_names": map[string]any{
+			"enabled":           c.GroupNames.Enabled,
+			"ldap_url":          c.GroupNames.LDAPURL,
+			"bind_dn":           c.GroupNames.BindDN,
+			"bind_password_set": c.GroupNames.BindPassword != "",
+			"base_dn":           c.GroupNames.BaseDN,
+			"cache_ttl":         int(c.GroupNames.cacheTTL().Seconds()),
+		},
 	}
 }
 
 func writeConfig(ctx context.Context, s logical.Storage, cfg *Config) error {
+	cfg.SchemaVersion = configSchemaVersion
 	entry, err := logical.StorageEntryJSON(storageKeyConfig, cfg)
 	if err != nil {
 		return err
@@ -70,47 +239,116 @@ func writeConfig(ctx context.Context, s logical.Storage, cfg *Config) error {
 }
 
 func readConfig(ctx context.Context, s logical.Storage) (*Config, error) {
+	cfg, _, err := readConfigEntry(ctx, s)
+	return cfg, err
+}
+
+// readConfigEntry reads the config and reports whether the stored entry was
+// at an older schema version and has been migrated in memory
+func readConfigEntry(ctx context.Context, s logical.Storage) (*Config, bool, error) {
 	entry, err := s.Get(ctx, storageKeyConfig)
 	if err != nil || entry == nil {
-		return nil, err
+		return nil, false, err
 	}
 	var cfg Config
 	if err := entry.DecodeJSON(&cfg); err != nil {
-		return nil, err
+		return nil, false, err
+	}
+	migrated, err := migrateConfig(entry, &cfg)
+	if err != nil {
+		return nil, false, err
 	}
-	return &cfg, nil
+	return &cfg, migrated, nil
 }
 
 // Role model (authorization policy).
 type Role struct {
+	// Version of the stored entry's schema; see migrateRole
+	SchemaVersion  int      `json:"schema_version"`
 	Name           string   `json:"name"`
 	AllowedRealms  []string `json:"allowed_realms"`
 	AllowedSPNs    []string `json:"allowed_spns"`
 	BoundGroupSIDs []string `json:"bound_group_sids"`
 	TokenPolicies  []string `json:"token_policies"`
-	TokenType      string   `json:"token_type"` // default|service
+	TokenType      string   `json:"token_type"` // default|service, "" inherits the mount default
 	Period         int      `json:"period"`     // seconds
 	MaxTTL         int      `json:"max_ttl"`    // seconds
 	DenyPolicies   []string `json:"deny_policies"`
 	MergeStrategy  string   `json:"merge_strategy"` // union|override
+	// PolicyTemplates enables {{variable}} resolution in TokenPolicies
+	PolicyTemplates bool `json:"policy_templates"`
+	// Disabled rejects logins against the role while keeping its settings
+	Disabled bool `json:"disabled"`
+	// MaxGroupSIDs rejects principals carrying more group SIDs (0 = no limit)
+	MaxGroupSIDs int `json:"max_group_sids"`
+	// RequirePolicies rejects logins that would issue a token with no policies
+	RequirePolicies bool `json:"require_policies"`
+	// TTLFromTicket caps token TTLs at the Kerberos ticket's remaining lifetime
+	TTLFromTicket bool `json:"ttl_from_ticket"`
+	// BoundClientCertCNs limits the role to Vault clients presenting a TLS
+	// client certificate with one of these common names (empty = any)
+	BoundClientCertCNs []string `json:"bound_client_cert_cns"`
+	// MinKVNO rejects tickets encrypted with an older key version (0 = any)
+	MinKVNO int `json:"min_kvno"`
+	// ExcludeResourceGroups leaves resource-domain group SIDs out of
+	// bound_group_sids matching (stored inverted so existing roles include them)
+	ExcludeResourceGroups bool `json:"exclude_resource_groups"`
+	// InvalidateOnRotation issues renewable tokens that stop renewing once
+	// the mount's keytab kvno advances past the one current at login
+	InvalidateOnRotation bool `json:"invalidate_on_rotation"`
+	// MaxSPNEGOBytes tightens the global SPNEGO token size limit for this
+	// role (0 = global limit only)
+	MaxSPNEGOBytes int `json:"max_spnego_bytes"`
+	// IgnorePACLogonTimeSkew skips the PAC logon time skew check for
+	// accounts that present old logon times; authenticator skew still applies
+	IgnorePACLogonTimeSkew bool `json:"ignore_pac_logon_time_skew"`
+	// RequireInitial only accepts service tickets carrying the INITIAL
+	// flag, i.e. obtained from an AS exchange rather than with a cached TGT
+	RequireInitial bool `json:"require_initial"`
+	// AccountType limits the role to user or machine (computer, gMSA)
+	// accounts by the PAC's UserAccountControl ("" admits any)
+	AccountType string `json:"account_type"`
+	// BoundUserSIDs limits the role to these accounts by user SID, which
+	// survives renames (empty = any)
+	BoundUserSIDs []string `json:"bound_user_sids"`
+	// RequiredPACFlags lists validator flags that must be set ("FLAG" or
+	// "FLAG=true") or unset ("FLAG=false") for a login to succeed
+	RequiredPACFlags []string `json:"required_pac_flags"`
 }
 
 func (r *Role) Safe() map[string]any {
 	return map[string]any{
-		"name":             r.Name,
-		"allowed_realms":   strings.Join(r.AllowedRealms, ","),
-		"allowed_spns":     strings.Join(r.AllowedSPNs, ","),
-		"bound_group_sids": strings.Join(r.BoundGroupSIDs, ","),
-		"token_policies":   strings.Join(r.TokenPolicies, ","),
-		"token_type":       r.TokenType,
-		"period":           r.Period,
-		"max_ttl":          r.MaxTTL,
-		"deny_policies":    strings.Join(r.DenyPolicies, ","),
-		"merge_strategy":   r.MergeStrategy,
+		"schema_version":             r.SchemaVersion,
+		"name":                       r.Name,
+		"allowed_realms":             strings.Join(r.AllowedRealms, ","),
+		"allowed_spns":               strings.Join(r.AllowedSPNs, ","),
+		"bound_group_sids":           strings.Join(r.BoundGroupSIDs, ","),
+		"token_policies":             strings.Join(r.TokenPolicies, ","),
+		"token_type":                 r.TokenType,
+		"period":                     r.Period,
+		"max_ttl":                    r.MaxTTL,
+		"deny_policies":              strings.Join(r.DenyPolicies, ","),
+		"merge_strategy":             r.MergeStrategy,
+		"policy_templates":           r.PolicyTemplates,
+		"disabled":                   r.Disabled,
+		"max_group_sids":             r.MaxGroupSIDs,
+		"require_policies":           r.RequirePolicies,
+		"ttl_from_ticket":            r.TTLFromTicket,
+		"bound_client_cert_cns":      strings.Join(r.BoundClientCertCNs, ","),
+		"min_kvno":                   r.MinKVNO,
+		"include_resource_groups":    !r.ExcludeResourceGroups,
+		"invalidate_on_rotation":     r.InvalidateOnRotation,
+		"max_spnego_bytes":           r.MaxSPNEGOBytes,
+		"ignore_pac_logon_time_skew": r.IgnorePACLogonTimeSkew,
+		"require_initial":            r.RequireInitial,
+		"account_type":               r.accountType(),
+		"bound_user_sids":            strings.Join(r.BoundUserSIDs, ","),
+		"required_pac_flags":         strings.Join(r.RequiredPACFlags, ","),
 	}
 }
 
 func writeRole(ctx context.Context, s logical.Storage, role *Role) error {
+	role.SchemaVersion = roleSchemaVersion
 	entry, err := logical.StorageEntryJSON(storageKeyRole+"/"+role.Name, role)
 	if err != nil {
 		return err
@@ -119,15 +357,26 @@ func writeRole(ctx context.Context, s logical.Storage, role *Role) error {
 }
 
 func readRole(ctx context.Context, s logical.Storage, name string) (*Role, error) {
+	r, _, err := readRoleEntry(ctx, s, name)
+	return r, err
+}
+
+// readRoleEntry reads a role and reports whether the stored entry was at an
+// older schema version and has been migrated in memory
+func readRoleEntry(ctx context.Context, s logical.Storage, name string) (*Role, bool, error) {
 	entry, err := s.Get(ctx, storageKeyRole+"/"+name)
 	if err != nil || entry == nil {
-		return nil, err
+		return nil, false, err
 	}
 	var r Role
 	if err := entry.DecodeJSON(&r); err != nil {
-		return nil, err
+		return nil, false, err
+	}
+	migrated, err := migrateRole(entry, &r)
+	if err != nil {
+		return nil, false, err
 	}
-	return &r, nil
+	return &r, migrated, nil
 }
 
 func deleteRole(ctx context.Context, s logical.Storage, name string) error {
@@ -142,6 +391,35 @@ func listRoles(ctx context.Context, s logical.Storage) ([]string, error) {
 	return keys, nil
 }
 
+// rolesBindingGroups returns the names of the roles that set bound_group_sids
+func rolesBindingGroups(ctx context.Context, s logical.Storage) ([]string, error) {
+	names, err := listRoles(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	var bound []string
+	for _, name := range names {
+		r, err := readRole(ctx, s, name)
+		if err != nil {
+			return nil, err
+		}
+		if r != nil && len(r.BoundGroupSIDs) > 0 {
+			bound = append(bound, name)
+		}
+	}
+	return bound, nil
+}
+
+// pacProcessingDisabled reports whether the mount skips PAC processing, in
+// which case logins carry no group SIDs to bind
+func pacProcessingDisabled(ctx context.Context, s logical.Storage) (bool, error) {
+	cfg, err := readConfig(ctx, s)
+	if err != nil || cfg == nil {
+		return false, err
+	}
+	return cfg.DisablePACProcessing, nil
+}
+
 // Validation helpers
 
 // normalizeAndValidateConfig validates operator-provided configuration. It is
@@ -152,6 +430,9 @@ func normalizeAndValidateConfig(c *Config) error {
 		len(c.Normalization.RealmPrefixes) == 0 && len(c.Normalization.SPNPrefixes) == 0 {
 		c.Normalization = getDefaultNormalizationConfig()
 	}
+	if err := validateNormalizationConfig(c.Normalization); err != nil {
+		return err
+	}
 	// Validate realm: UPPERCASE, limited character set, size limit.
 	if c.Realm == "" || strings.ToUpper(c.Realm) != c.Realm {
 		return errors.New("realm must be UPPERCASE and non-empty")
@@ -197,15 +478,15 @@ func normalizeAndValidateConfig(c *Config) error {
 		if !hostRe.MatchString(host) {
 			return errors.New("kdcs host contains invalid characters")
 		}
-		
+
 		// Security check: KDC should be related to the realm domain
 		hostLower := strings.ToLower(host)
-		if !strings.Contains(hostLower, strings.ToLower(realmLower)) && 
-		   !strings.HasSuffix(hostLower, "."+realmLower) &&
-		   !strings.Contains(realmLower, hostLower) {
+		if !strings.Contains(hostLower, strings.ToLower(realmLower)) &&
+			!strings.HasSuffix(hostLower, "."+realmLower) &&
+			!strings.Contains(realmLower, hostLower) {
 			return errors.New("KDC host must be related to the realm domain for security")
 		}
-		
+
 		if _, seen := uniqueKDC[k]; seen {
 			continue
 		}
@@ -225,46 +506,422 @@ func normalizeAndValidateConfig(c *Config) error {
 	if len(kb) > 1*1024*1024 {
 		return errors.New("keytab too large; must be <= 1MiB")
 	}
+	for i, extra := range c.AdditionalKeytabs {
+		if err := validateAdditionalKeytab(extra); err != nil {
+			return fmt.Errorf("additional_keytabs[%d]: %w", i, err)
+		}
+	}
 
-	// Validate SPN: SERVICE/host["@REALM" optional], ensure SERVICE upper-case.
-	if !strings.Contains(c.SPN, "/") {
-		return errors.New("spn must look like HTTP/host.domain")
+	// Validate SPN: SERVICE/host[:port][/service-name]["@REALM" optional],
+	// e.g. HTTP/vault.example.com or MSSQLSvc/db.example.com:1433.
+	spn, err := kerb.ParseSPN(c.SPN)
+	if err != nil {
+		return fmt.Errorf("spn must look like SERVICE/host.domain[:port][/service-name]: %w", err)
+	}
+	if spn.Realm != "" && spn.Realm != c.Realm {
+		return errors.New("spn realm must match configured realm")
 	}
-	spnParts := strings.SplitN(c.SPN, "/", 2)
-	if len(spnParts) != 2 || spnParts[0] == "" || spnParts[1] == "" {
-		return errors.New("spn must be in the form SERVICE/host")
+	if !strings.Contains(spn.Host, ".") {
+		return errors.New("spn host must be a FQDN")
+	}
+
+	// A keytab exported for another realm or account is otherwise only
+	// noticed when logins fail.
+	if err := checkKeytabMatches(kb, c.Realm, spn.Principal(), c.KeytabRequireSPN); err != nil {
+		return err
 	}
-	service := spnParts[0]
-	if service != strings.ToUpper(service) {
-		return errors.New("spn service must be UPPERCASE")
+
+	// Optionally reject keytabs that only offer RC4-HMAC keys for the SPN.
+	if c.ForbidRC4 {
+		if err := checkKeytabNotRC4Only(kb, spn.Principal()); err != nil {
+			return err
+		}
 	}
-	// host may include @REALM suffix; validate host separately.
-	hostAndRealm := spnParts[1]
-	hostOnly := hostAndRealm
-	if strings.Contains(hostAndRealm, "@") {
-		hr := strings.SplitN(hostAndRealm, "@", 2)
-		hostOnly = hr[0]
-		if hr[1] != c.Realm {
-			return errors.New("spn realm must match configured realm")
+
+	// Optional krb5.conf tunables must parse.
+	if c.Krb5Conf != "" {
+		if len(c.Krb5Conf) > maxKrb5ConfLen {
+			return errors.New("krb5_conf too large; maximum 64KiB")
+		}
+		if _, err := parseKrb5Conf(c.Krb5Conf); err != nil {
+			return fmt.Errorf("invalid krb5_conf: %w", err)
 		}
 	}
-	if !hostRe.MatchString(hostOnly) || !strings.Contains(hostOnly, ".") {
-		return errors.New("spn host must be a FQDN")
+
+	// Accepted mechanism OIDs must be in dotted-decimal form.
+	for _, oid := range c.AllowedMechOIDs {
+		if !mechOIDRe.MatchString(oid) {
+			return fmt.Errorf("allowed_mech_oids contains invalid OID %q", oid)
+		}
 	}
 
 	// Validate clock skew range.
 	if c.ClockSkewSec < 0 || c.ClockSkewSec > 900 {
 		return errors.New("clock_skew_sec must be between 0 and 900 seconds")
 	}
+
+	switch c.DefaultTokenType {
+	case "", "default", "service":
+	default:
+		return errors.New("default_token_type must be 'default' or 'service'")
+	}
+	c.DefaultPolicies = unique(c.DefaultPolicies)
+	for _, policy := range c.DefaultPolicies {
+		if !isValidPolicyName(policy) {
+			return fmt.Errorf("invalid policy name in default_policies: %s", policy)
+		}
+	}
+	c.BasePolicies = unique(c.BasePolicies)
+	for _, policy := range c.BasePolicies {
+		if !isValidPolicyName(policy) {
+			return fmt.Errorf("invalid policy name in base_policies: %s", policy)
+		}
+	}
+
+	if c.SPNHostHeader = strings.TrimSpace(c.SPNHostHeader); c.SPNHostHeader != "" {
+		if !headerNameRe.MatchString(c.SPNHostHeader) {
+			return errors.New("spn_host_header must be an HTTP header name")
+		}
+		// Go's HTTP server moves Host out of the request headers, so Vault
+		// never passes it to plugins
+		c.SPNHostHeader = http.CanonicalHeaderKey(c.SPNHostHeader)
+		if c.SPNHostHeader == "Host" {
+			return errors.New("spn_host_header can't be Host, which Vault doesn't pass to plugins; use a header set by the load balancer such as X-Forwarded-Host")
+		}
+	}
+
+	switch c.DisplayNameFormat {
+	case "", displayNamePrincipal, displayNameSanitized, displayNameName:
+	default:
+		return fmt.Errorf("display_name_format must be one of %q, %q or %q", displayNamePrincipal, displayNameSanitized, displayNameName)
+	}
+	if c.DisplayNameMaxLength < 0 || c.DisplayNameMaxLength > 255 {
+		return errors.New("display_name_max_length must be between 0 and 255")
+	}
+	switch c.PACUnknownBufferMode {
+	case "", pacUnknownBufferIgnore, pacUnknownBufferWarn, pacUnknownBufferReject:
+	default:
+		return fmt.Errorf("pac_unknown_buffer_mode must be one of %q, %q or %q", pacUnknownBufferIgnore, pacUnknownBufferWarn, pacUnknownBufferReject)
+	}
+	switch c.AliasSource {
+	case "", aliasSourcePrincipal, aliasSourceSID, aliasSourceUPN:
+	default:
+		return fmt.Errorf("alias_source must be one of %q, %q or %q", aliasSourcePrincipal, aliasSourceSID, aliasSourceUPN)
+	}
+
+	// Principal patterns must compile; the compiled form is kept for logins.
+	if err := c.compiledPrincipalPatterns().err; err != nil {
+		return err
+	}
+
+	// The negated range check also rejects NaN.
+	if !(c.SuccessLogSampleRate >= 0 && c.SuccessLogSampleRate <= 1) {
+		return errors.New("success_log_sample_rate must be between 0.0 and 1.0")
+	}
+
+	// Validate principal lockout settings; a zero threshold disables lockout.
+	if c.PrincipalLockoutThreshold < 0 || c.PrincipalLockoutThreshold > 100 {
+		return errors.New("principal_lockout_threshold must be between 0 and 100")
+	}
+	if c.PrincipalLockoutDurationSec < 0 || c.PrincipalLockoutDurationSec > 86400 {
+		return errors.New("principal_lockout_duration must be between 0 and 86400 seconds")
+	}
+	if c.PrincipalLockoutThreshold > 0 && c.PrincipalLockoutDurationSec == 0 {
+		c.PrincipalLockoutDurationSec = 900
+	}
+
+	if c.MaxConcurrentLogins < 0 {
+		return errors.New("max_concurrent_logins cannot be negative")
+	}
+	if c.PasswordExpiryWarnDays < 0 {
+		return errors.New("password_expiry_warn_days cannot be negative")
+	}
+	if c.AuditChainMaxEntries < 0 || c.AuditChainMaxEntries > maxAuditChainMaxEntries {
+		return fmt.Errorf("audit_chain_max_entries must be between 0 and %d", maxAuditChainMaxEntries)
+	}
+	if err := c.validateKDCTimeouts(); err != nil {
+		return err
+	}
+	if c.OnlySIDPrefixes, err = canonicalSIDPrefixes("only_sid_prefixes", c.OnlySIDPrefixes); err != nil {
+		return err
+	}
+	if c.ExcludeSIDPrefixes, err = canonicalSIDPrefixes("exclude_sid_prefixes", c.ExcludeSIDPrefixes); err != nil {
+		return err
+	}
+	if _, err := logging.NewRedactor(c.LogRedactPatterns, c.DisableSIDRedaction); err != nil {
+		return fmt.Errorf("log_redact_patterns: %w", err)
+	}
+	if c.DisablePACProcessing && (c.RequirePAC || c.RequireUPNInfo) {
+		return errors.New("disable_pac_processing cannot be combined with require_pac_present or require_upn_dns_info")
+	}
+	if c.LastLoginMaxEntries < 0 || c.LastLoginMaxEntries > maxLastLoginMaxEntries {
+		return fmt.Errorf("last_login_max_entries must be between 0 and %d", maxLastLoginMaxEntries)
+	}
+	c.LastLoginRedact = unique(c.LastLoginRedact)
+	if err := validateLastLoginRedact(c.LastLoginRedact); err != nil {
+		return err
+	}
+	if err := c.GroupNames.validate(); err != nil {
+		return err
+	}
+
+	return validateNegotiateConfig(c.Negotiate)
+}
+
+// defaultMechOIDs accepts Kerberos v5 only: the standard OID and the legacy
+// Microsoft OID that Windows clients list first
+var defaultMechOIDs = []string{"1.2.840.113554.1.2.2", "1.2.840.48018.1.2.2"}
+
+// mechOIDRe matches a dotted-decimal object identifier
+var mechOIDRe = regexp.MustCompile(`^[0-2](\.(0|[1-9][0-9]*))+$`)
+
+// defaultSPNHostHeader is the header verify_spn_matches_host reads the
+// requested host from unless spn_host_header names another
+const defaultSPNHostHeader = "X-Forwarded-Host"
+
+// headerNameRe matches an HTTP header field name
+var headerNameRe = regexp.MustCompile(`^[A-Za-z0-9!#$%&'*+.^_|~-]+$`)
+
+// allowedMechOIDs returns the accepted GSS mechanism OIDs, defaulting to
+// Kerberos only
+func (c *Config) allowedMechOIDs() []string {
+	if len(c.AllowedMechOIDs) == 0 {
+		return defaultMechOIDs
+	}
+	return c.AllowedMechOIDs
+}
+
+// logRedactor returns the redactor for logged errors. A nil config gets the
+// built-in rules; patterns are checked on config write, so a compile error
+// here also falls back to them.
+func (c *Config) logRedactor() *logging.Redactor {
+	if c == nil {
+		return nil
+	}
+	r, err := logging.NewRedactor(c.LogRedactPatterns, c.DisableSIDRedaction)
+	if err != nil {
+		return nil
+	}
+	return r
+}
+
+// loadConfigSettings applies the stored config's settings that are kept in
+// memory. Until a config is stored, or if it can't be read, the built-in log
+// redaction rules apply and the audit chain is off.
+func (b *gmsaBackend) loadConfigSettings(ctx context.Context) {
+	cfg, err := readConfig(ctx, b.storage)
+	if err != nil {
+		b.logger.Warn("failed to load config settings", "error", err)
+	}
+	b.applyConfigSettings(cfg)
+}
+
+// applyConfigSettings keeps the settings read on every log entry or login in
+// memory: the log redaction rules and the audit chain bound, 0 when the
+// chain is off. cfg may be nil.
+func (b *gmsaBackend) applyConfigSettings(cfg *Config) {
+	b.redactor.Store(cfg.logRedactor())
+	var patterns *principalPatterns
+	if cfg != nil {
+		patterns = cfg.compiledPrincipalPatterns()
+	}
+	b.principalPatterns.Store(patterns)
+	var auditChainMax int64
+	if cfg != nil && cfg.AuditChain {
+		auditChainMax = int64(cfg.auditChainMaxEntries())
+	}
+	b.auditChainMax.Store(auditChainMax)
+}
+
+// principalPatterns holds the compiled principal_allow_pattern and
+// principal_deny_pattern, nil when unset. err is set when a stored pattern no
+// longer compiles, which rejects every login.
+type principalPatterns struct {
+	allowSrc, denySrc string
+	allow, deny       *regexp.Regexp
+	err               error
+}
+
+// compilePrincipalPatterns compiles the principal patterns
+func compilePrincipalPatterns(allow, deny string) *principalPatterns {
+	p := &principalPatterns{allowSrc: allow, denySrc: deny}
+	var err error
+	if allow != "" {
+		if p.allow, err = regexp.Compile(allow); err != nil {
+			p.err = fmt.Errorf("invalid principal_allow_pattern: %w", err)
+			return p
+		}
+	}
+	if deny != "" {
+		if p.deny, err = regexp.Compile(deny); err != nil {
+			p.err = fmt.Errorf("invalid principal_deny_pattern: %w", err)
+		}
+	}
+	return p
+}
+
+// compiledFrom reports whether p was compiled from c's principal patterns
+func (p *principalPatterns) compiledFrom(c *Config) bool {
+	return p != nil && p.allowSrc == c.PrincipalAllowPattern && p.denySrc == c.PrincipalDenyPattern
+}
+
+// compiledPrincipalPatterns returns c's compiled principal patterns,
+// compiling them unless c already holds them
+func (c *Config) compiledPrincipalPatterns() *principalPatterns {
+	if !c.patterns.compiledFrom(c) {
+		c.patterns = compilePrincipalPatterns(c.PrincipalAllowPattern, c.PrincipalDenyPattern)
+	}
+	return c.patterns
+}
+
+// usePrincipalPatterns hands cfg the principal patterns compiled when the
+// config was last written or loaded, so logins don't compile them again
+func (b *gmsaBackend) usePrincipalPatterns(cfg *Config) {
+	if p := b.principalPatterns.Load(); p.compiledFrom(cfg) {
+		cfg.patterns = p
+	}
+}
+
+// maxKrb5ConfLen bounds the krb5_conf text stored in the config
+const maxKrb5ConfLen = 64 * 1024
+
+// parseKrb5Conf parses krb5.conf text with gokrb5. Directives gokrb5 doesn't
+// support (e.g. v4 settings) are ignored rather than rejected.
+func parseKrb5Conf(s string) (*config.Config, error) {
+	conf, err := config.NewFromString(s)
+	if err != nil {
+		var unsupported config.UnsupportedDirective
+		if !errors.As(err, &unsupported) {
+			return nil, err
+		}
+	}
+	return conf, nil
+}
+
+// validateAdditionalKeytab checks one additional keytab on its own: it must
+// decode, fit the keytab size limit and parse
+func validateAdditionalKeytab(keytabB64 string) error {
+	kb, err := base64.StdEncoding.DecodeString(keytabB64)
+	if err != nil {
+		return errors.New("keytab must be base64-encoded")
+	}
+	if len(kb) > 1*1024*1024 {
+		return errors.New("keytab too large; must be <= 1MiB")
+	}
+	kt := &keytab.Keytab{}
+	if err := kt.Unmarshal(kb); err != nil {
+		return errors.New("keytab could not be parsed")
+	}
 	return nil
 }
 
+// maxKeytabPrincipalsListed bounds the principals named in keytab mismatch
+// errors
+const maxKeytabPrincipalsListed = 5
+
+// checkKeytabMatches requires the keytab to hold an entry for realm and,
+// when requireSPN is set, one for spn (without realm) in that realm. Keytabs
+// exported for the gMSA account principal rather than the SPN pass unless
+// requireSPN is set.
+func checkKeytabMatches(kb []byte, realm, spn string, requireSPN bool) error {
+	kt := &keytab.Keytab{}
+	if err := kt.Unmarshal(kb); err != nil {
+		return errors.New("keytab could not be parsed; export it with ktpass or ktutil and base64-encode the file")
+	}
+	if len(kt.Entries) == 0 {
+		return errors.New("keytab contains no entries")
+	}
+
+	var found []string
+	realmMatch := false
+	for _, e := range kt.Entries {
+		principal := strings.Join(e.Principal.Components, "/")
+		if e.Principal.Realm == realm && (!requireSPN || strings.EqualFold(principal, spn)) {
+			return nil
+		}
+		realmMatch = realmMatch || e.Principal.Realm == realm
+		if name := principal + "@" + e.Principal.Realm; !slices.Contains(found, name) && len(found) < maxKeytabPrincipalsListed {
+			found = append(found, name)
+		}
+	}
+	if !realmMatch {
+		return fmt.Errorf("keytab has no entries for realm %s (found %s); export it for the configured realm or correct the realm", realm, strings.Join(found, ", "))
+	}
+	return fmt.Errorf("keytab has no entry for %s@%s (found %s); export it for the SPN or unset keytab_require_spn", spn, realm, strings.Join(found, ", "))
+}
+
+// checkKeytabNotRC4Only rejects a keytab whose entries for spn only use
+// RC4-HMAC. When no entry names the SPN (e.g. account-principal keytabs), all
+// entries are considered.
+func checkKeytabNotRC4Only(kb []byte, spn string) error {
+	kt := &keytab.Keytab{}
+	if err := kt.Unmarshal(kb); err != nil {
+		return errors.New("keytab could not be parsed to check its encryption types")
+	}
+
+	var spnEntries, allEntries []int32
+	for _, e := range kt.Entries {
+		allEntries = append(allEntries, e.Key.KeyType)
+		if strings.EqualFold(strings.Join(e.Principal.Components, "/"), spn) {
+			spnEntries = append(spnEntries, e.Key.KeyType)
+		}
+	}
+	etypes := spnEntries
+	if len(etypes) == 0 {
+		etypes = allEntries
+	}
+	if len(etypes) == 0 {
+		return errors.New("keytab contains no entries")
+	}
+
+	for _, et := range etypes {
+		if et != etypeID.RC4_HMAC && et != etypeID.RC4_HMAC_EXP {
+			return nil
+		}
+	}
+	return fmt.Errorf("keytab only contains RC4-HMAC keys for %s; re-export it with AES keys (e.g. ktpass /crypto AES256-SHA1) or set forbid_rc4=false", spn)
+}
+
+// validateNegotiateConfig checks the Negotiate challenge status; 0 uses the
+// 401 default
+func validateNegotiateConfig(n NegotiateConfig) error {
+	switch n.ChallengeStatus {
+	case 0, http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden:
+		return nil
+	default:
+		return errors.New("negotiate_challenge_status must be 400, 401 or 403")
+	}
+}
+
+// challengeStatus returns the HTTP status used for the Negotiate challenge
+func (n NegotiateConfig) challengeStatus() int {
+	if n.ChallengeStatus == 0 {
+		return http.StatusUnauthorized
+	}
+	return n.ChallengeStatus
+}
+
 // validateRole validates role configuration
 func validateRole(r *Role) error {
 	if r.Name == "" {
 		return errors.New("role name is required")
 	}
 
+	if r.MaxGroupSIDs < 0 {
+		return errors.New("max_group_sids cannot be negative")
+	}
+	if r.MinKVNO < 0 {
+		return errors.New("min_kvno cannot be negative")
+	}
+	if r.MaxSPNEGOBytes < 0 || r.MaxSPNEGOBytes > maxSPNEGOTokenLen {
+		return fmt.Errorf("max_spnego_bytes must be between 0 and %d", maxSPNEGOTokenLen)
+	}
+	switch r.AccountType {
+	case "", accountTypeAny, accountTypeUser, accountTypeMachine:
+	default:
+		return fmt.Errorf("account_type must be one of %q, %q or %q", accountTypeUser, accountTypeMachine, accountTypeAny)
+	}
+
 	// Validate SID format if provided
 	for _, sid := range r.BoundGroupSIDs {
 		if sid == "" {
@@ -274,9 +931,29 @@ func validateRole(r *Role) error {
 			return errors.New("invalid SID format: " + sid)
 		}
 	}
+	for _, sid := range r.BoundUserSIDs {
+		if !isValidSID(sid) {
+			return errors.New("invalid user SID format: " + sid)
+		}
+	}
+	for _, req := range r.RequiredPACFlags {
+		flag, _, ok := parsePACFlagRequirement(req)
+		if !ok {
+			return fmt.Errorf("invalid required_pac_flags entry %q: want FLAG, FLAG=true or FLAG=false with an upper-case flag name", req)
+		}
+		if !slices.Contains(knownLoginFlags, flag) {
+			return fmt.Errorf("unknown required_pac_flags flag %q; known flags: %s", flag, strings.Join(knownLoginFlags, ", "))
+		}
+	}
 
 	// Validate policy names to prevent injection
 	for _, policy := range r.TokenPolicies {
+		if r.PolicyTemplates && isPolicyTemplate(policy) {
+			if err := validatePolicyTemplate(policy); err != nil {
+				return err
+			}
+			continue
+		}
 		if !isValidPolicyName(policy) {
 			return errors.New("invalid policy name: " + policy)
 		}
@@ -288,38 +965,121 @@ func validateRole(r *Role) error {
 		}
 	}
 
+	return validateRoleCombinations(r)
+}
+
+// validateRoleCombinations rejects fields that are valid alone but
+// contradict each other
+func validateRoleCombinations(r *Role) error {
+	// A policy both granted and denied by name can never be issued
+	for _, policy := range r.TokenPolicies {
+		if containsFold(r.DenyPolicies, policy) {
+			return fmt.Errorf("policy %q is in both token_policies and deny_policies", policy)
+		}
+	}
+
+	// Resource groups are only consulted for bound_group_sids matching
+	if r.ExcludeResourceGroups && len(r.BoundGroupSIDs) == 0 {
+		return errors.New("include_resource_groups=false has no effect without bound_group_sids")
+	}
+
+	// Roles map no groups to policies, so the only policies override can
+	// put in place of the mount's default_policies are the role's own
+	if r.MergeStrategy == "override" && len(r.TokenPolicies) == 0 {
+		return errors.New("merge_strategy=override requires token_policies; without them the mount's default_policies apply unchanged")
+	}
+
 	return nil
 }
 
-// isValidSID validates Windows SID format
+// maxSubAuthorities is the most sub-authorities a Windows SID can carry
+const maxSubAuthorities = 15
+
+// isValidSID validates Windows SID syntax: S-1-<authority>-<subauthority>...
+// with a 48-bit identifier authority and 1 to 15 32-bit sub-authorities.
+// Components must be canonical decimal (no signs or leading zeros) since
+// SIDs are matched against the PAC by string comparison.
 func isValidSID(sid string) bool {
 	// SID format: S-1-5-21-1234567890-1234567890-1234567890-1234
-	// Must start with S-1- and contain only digits and hyphens
 	if !strings.HasPrefix(sid, "S-1-") {
 		return false
 	}
 
-	// Remove S-1- prefix and validate remaining parts
 	parts := strings.Split(sid[4:], "-")
-	if len(parts) < 2 {
+	if len(parts) < 2 || len(parts)-1 > maxSubAuthorities {
 		return false
 	}
 
-	// Each part should be numeric
-	for _, part := range parts {
-		if part == "" {
+	if !isCanonicalSIDComponent(parts[0], 48) {
+		return false
+	}
+	for _, part := range parts[1:] {
+		if !isCanonicalSIDComponent(part, 32) {
 			return false
 		}
-		for _, char := range part {
-			if char < '0' || char > '9' {
-				return false
-			}
-		}
 	}
 
 	return true
 }
 
+// canonicalSID returns sid trimmed and uppercased, so a SID entered as
+// "s-1-5-..." matches the PAC's rendering. ok reports whether the result is a
+// valid SID; leading zeros are still rejected rather than guessed at.
+func canonicalSID(sid string) (canonical string, ok bool) {
+	canonical = strings.ToUpper(strings.TrimSpace(sid))
+	return canonical, isValidSID(canonical)
+}
+
+// canonicalSIDPrefixes canonicalizes the SID prefixes of the named config
+// field. A prefix is itself a SID such as S-1-5-32 and covers every SID that
+// extends it by whole sub-authorities.
+func canonicalSIDPrefixes(field string, prefixes []string) ([]string, error) {
+	out := make([]string, 0, len(prefixes))
+	for _, p := range prefixes {
+		canonical, ok := canonicalSID(p)
+		if !ok {
+			return nil, fmt.Errorf("%s: invalid SID prefix %q", field, p)
+		}
+		out = append(out, canonical)
+	}
+	return unique(out), nil
+}
+
+// hasSIDPrefix reports whether sid equals one of prefixes or extends it by
+// whole sub-authorities, so S-1-5-32 matches S-1-5-32-544 but not S-1-5-320
+func hasSIDPrefix(sid string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if sid == p || strings.HasPrefix(sid, p+"-") {
+			return true
+		}
+	}
+	return false
+}
+
+// canonicalSIDs applies canonicalSID to each SID
+func canonicalSIDs(sids []string) []string {
+	out := make([]string, len(sids))
+	for i, sid := range sids {
+		out[i], _ = canonicalSID(sid)
+	}
+	return out
+}
+
+// isCanonicalSIDComponent reports whether part is a canonical decimal number
+// that fits in bits bits
+func isCanonicalSIDComponent(part string, bits int) bool {
+	if part == "" || (len(part) > 1 && part[0] == '0') {
+		return false
+	}
+	for _, char := range part {
+		if char < '0' || char > '9' {
+			return false
+		}
+	}
+	_, err := strconv.ParseUint(part, 10, bits)
+	return err == nil
+}
+
 // isValidPolicyName validates Vault policy names
 func isValidPolicyName(policy string) bool {
 	if policy == "" {
@@ -340,21 +1100,9 @@ func normalizeRealm(realm string, config NormalizationConfig) string {
 		return realm
 	}
 
-	// Apply prefixes (remove configured prefixes)
-	for _, prefix := range config.RealmPrefixes {
-		if strings.HasPrefix(realm, prefix) {
-			realm = strings.TrimPrefix(realm, prefix)
-			break // Only apply the first matching prefix
-		}
-	}
-
-	// Apply suffixes (remove configured suffixes like .local, .lan)
-	for _, suffix := range config.RealmSuffixes {
-		if strings.HasSuffix(realm, suffix) {
-			realm = strings.TrimSuffix(realm, suffix)
-			break // Only apply the first matching suffix
-		}
-	}
+	// Remove the longest matching prefix and suffix (e.g. .local, .lan)
+	realm = strings.TrimPrefix(realm, longestMatch(realm, config.RealmPrefixes, strings.HasPrefix))
+	realm = strings.TrimSuffix(realm, longestMatch(realm, config.RealmSuffixes, strings.HasSuffix))
 
 	// Apply case normalization
 	if !config.RealmCaseSensitive {
@@ -371,21 +1119,9 @@ func normalizeSPN(spn string, config NormalizationConfig) string {
 		return spn
 	}
 
-	// Apply prefixes (remove configured prefixes)
-	for _, prefix := range config.SPNPrefixes {
-		if strings.HasPrefix(spn, prefix) {
-			spn = strings.TrimPrefix(spn, prefix)
-			break // Only apply the first matching prefix
-		}
-	}
-
-	// Apply suffixes (remove configured suffixes like .local, .lan)
-	for _, suffix := range config.SPNSuffixes {
-		if strings.HasSuffix(spn, suffix) {
-			spn = strings.TrimSuffix(spn, suffix)
-			break // Only apply the first matching suffix
-		}
-	}
+	// Remove the longest matching prefix and suffix (e.g. .local, .lan)
+	spn = strings.TrimPrefix(spn, longestMatch(spn, config.SPNPrefixes, strings.HasPrefix))
+	spn = strings.TrimSuffix(spn, longestMatch(spn, config.SPNSuffixes, strings.HasSuffix))
 
 	// Apply case normalization (only to service part, preserve hostname case)
 	if !config.SPNCaseSensitive {
@@ -405,6 +1141,87 @@ func normalizeSPN(spn string, config NormalizationConfig) string {
 	return spn
 }
 
+// longestMatch returns the longest candidate that matches s, so overlapping
+// rules such as ".corp.local" and ".local" apply regardless of their order.
+// It returns "" when nothing matches.
+func longestMatch(s string, candidates []string, matches func(s, affix string) bool) string {
+	best := ""
+	for _, c := range candidates {
+		if len(c) > len(best) && matches(s, c) {
+			best = c
+		}
+	}
+	return best
+}
+
+// validateNormalizationConfig rejects prefix/suffix lists with empty or
+// duplicate entries, whose intent is ambiguous
+func validateNormalizationConfig(n NormalizationConfig) error {
+	lists := []struct {
+		field string
+		rules []string
+	}{
+		{"realm_suffixes", n.RealmSuffixes},
+		{"spn_suffixes", n.SPNSuffixes},
+		{"realm_prefixes", n.RealmPrefixes},
+		{"spn_prefixes", n.SPNPrefixes},
+	}
+	for _, l := range lists {
+		seen := make(map[string]struct{}, len(l.rules))
+		for _, r := range l.rules {
+			if r == "" {
+				return fmt.Errorf("%s contains an empty entry", l.field)
+			}
+			if _, dup := seen[r]; dup {
+				return fmt.Errorf("%s contains duplicate entry %q", l.field, r)
+			}
+			seen[r] = struct{}{}
+		}
+	}
+	return nil
+}
+
+// knownLoginFlags lists the flags a login can carry for
+// required_pac_flags: the validator's plus those set by SID filtering
+var knownLoginFlags = append(slices.Clone(kerb.PACValidationFlags), "SID_HISTORY_FILTERED", "SID_PREFIX_FILTERED")
+
+// parsePACFlagRequirement splits a required_pac_flags entry into the flag
+// name and the value it must have
+func parsePACFlagRequirement(req string) (flag string, want bool, ok bool) {
+	flag, value, hasValue := strings.Cut(req, "=")
+	want = true
+	if hasValue {
+		switch value {
+		case "true":
+		case "false":
+			want = false
+		default:
+			return "", false, false
+		}
+	}
+	if flag == "" {
+		return "", false, false
+	}
+	for _, c := range flag {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') && c != '_' {
+			return "", false, false
+		}
+	}
+	return flag, want, true
+}
+
+// pacFlagsMeet reports whether flags satisfy every required_pac_flags entry.
+// Flags the validator didn't set count as false.
+func pacFlagsMeet(required []string, flags map[string]bool) (unmet string, ok bool) {
+	for _, req := range required {
+		flag, want, valid := parsePACFlagRequirement(req)
+		if !valid || flags[flag] != want {
+			return req, false
+		}
+	}
+	return "", true
+}
+
 // normalizePrincipal normalizes a principal (user@realm) according to the configuration
 // Applies realm normalization to the realm part while preserving the user part
 func normalizePrincipal(principal string, config NormalizationConfig) string {
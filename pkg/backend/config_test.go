@@ -0,0 +1,756 @@
+package backend
+
+import (
+	"encoding/base64"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+)
+
+// testKeytabB64 builds a keytab for principal with one entry per enctype
+func testKeytabB64(t *testing.T, principal string, etypes ...int32) string {
+	t.Helper()
+	kt := keytab.New()
+	for _, et := range etypes {
+		if err := kt.AddEntry(principal, "EXAMPLE.COM", "password", time.Now(), 1, et); err != nil {
+			t.Fatalf("failed to add keytab entry: %v", err)
+		}
+	}
+	b, err := kt.Marshal()
+	if err != nil {
+		t.Fatalf("failed to marshal keytab: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+func TestNormalizeAndValidateConfig_ForbidRC4(t *testing.T) {
+	const spn = "HTTP/vault.example.com"
+	tests := []struct {
+		name      string
+		keytab    string
+		forbidRC4 bool
+		wantErr   bool
+	}{
+		{"RC4-only rejected", testKeytabB64(t, spn, etypeID.RC4_HMAC), true, true},
+		{"AES and RC4 allowed", testKeytabB64(t, spn, etypeID.AES256_CTS_HMAC_SHA1_96, etypeID.RC4_HMAC), true, false},
+		{"AES-only allowed", testKeytabB64(t, spn, etypeID.AES256_CTS_HMAC_SHA1_96), true, false},
+		{"RC4-only allowed when not forbidden", testKeytabB64(t, spn, etypeID.RC4_HMAC), false, false},
+		{"RC4-only account keytab rejected", testKeytabB64(t, "vault-gmsa$", etypeID.RC4_HMAC), true, true},
+		{"unparsable keytab rejected", base64.StdEncoding.EncodeToString([]byte("not-a-keytab")), true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Realm:     "EXAMPLE.COM",
+				KDCs:      []string{"dc1.example.com"},
+				KeytabB64: tt.keytab,
+				SPN:       spn,
+				ForbidRC4: tt.forbidRC4,
+			}
+			err := normalizeAndValidateConfig(cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("normalizeAndValidateConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckKeytabNotRC4Only_Message(t *testing.T) {
+	kb, _ := base64.StdEncoding.DecodeString(testKeytabB64(t, "HTTP/vault.example.com", etypeID.RC4_HMAC))
+	err := checkKeytabNotRC4Only(kb, "HTTP/vault.example.com")
+	if err == nil {
+		t.Fatal("expected RC4-only keytab to be rejected")
+	}
+	for _, want := range []string{"RC4-HMAC", "HTTP/vault.example.com", "AES"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q should mention %q", err, want)
+		}
+	}
+}
+
+func TestNormalizeAndValidateConfig_Krb5Conf(t *testing.T) {
+	const spn = "HTTP/vault.example.com"
+	tests := []struct {
+		name     string
+		krb5Conf string
+		wantErr  bool
+	}{
+		{"unset", "", false},
+		{"valid", "[libdefaults]\n default_realm = EXAMPLE.COM\n dns_lookup_kdc = false\n udp_preference_limit = 1\n default_tgs_enctypes = aes256-cts-hmac-sha1-96\n", false},
+		{"unsupported directive ignored", "[libdefaults]\n v4_name_convert = x\n", false},
+		{"invalid boolean", "[libdefaults]\n dns_lookup_kdc = maybe\n", true},
+		{"malformed line", "[libdefaults]\n dns_lookup_kdc\n", true},
+		{"too large", "[libdefaults]\n" + strings.Repeat("#", maxKrb5ConfLen), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Realm:     "EXAMPLE.COM",
+				KDCs:      []string{"dc1.example.com"},
+				KeytabB64: testKeytabB64(t, spn, etypeID.AES256_CTS_HMAC_SHA1_96),
+				SPN:       spn,
+				Krb5Conf:  tt.krb5Conf,
+			}
+			err := normalizeAndValidateConfig(cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("normalizeAndValidateConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidatorOptions_Krb5Conf(t *testing.T) {
+	b, _ := getTestBackend(t)
+
+	if opt := b.validatorOptions(&Config{}); opt.Krb5Conf != nil {
+		t.Error("Krb5Conf set without krb5_conf")
+	}
+	opt := b.validatorOptions(&Config{Krb5Conf: "[libdefaults]\n permitted_enctypes = aes256-cts-hmac-sha1-96\n"})
+	if opt.Krb5Conf == nil {
+		t.Fatal("Krb5Conf not passed to the validator")
+	}
+	if ids := opt.Krb5Conf.LibDefaults.PermittedEnctypeIDs; len(ids) != 1 || ids[0] != etypeID.AES256_CTS_HMAC_SHA1_96 {
+		t.Errorf("PermittedEnctypeIDs = %v", ids)
+	}
+}
+
+func TestNormalizeAndValidateConfig_AdditionalKeytabs(t *testing.T) {
+	const spn = "HTTP/vault.example.com"
+	other := testKeytabB64(t, "HTTP/vault2.example.com", etypeID.AES256_CTS_HMAC_SHA1_96)
+	tests := []struct {
+		name    string
+		extra   []string
+		wantErr string
+	}{
+		{"unset", nil, ""},
+		{"valid", []string{other}, ""},
+		{"bad encoding", []string{other, "not base64!"}, "additional_keytabs[1]: keytab must be base64-encoded"},
+		{"unparsable", []string{base64.StdEncoding.EncodeToString([]byte("not-a-keytab"))}, "additional_keytabs[0]: keytab could not be parsed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Realm:             "EXAMPLE.COM",
+				KDCs:              []string{"dc1.example.com"},
+				KeytabB64:         testKeytabB64(t, spn, etypeID.AES256_CTS_HMAC_SHA1_96),
+				SPN:               spn,
+				AdditionalKeytabs: tt.extra,
+			}
+			err := normalizeAndValidateConfig(cfg)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	b, _ := getTestBackend(t)
+	if opt := b.validatorOptions(&Config{AdditionalKeytabs: []string{other}}); len(opt.AdditionalKeytabsB64) != 1 || opt.AdditionalKeytabsB64[0] != other {
+		t.Errorf("AdditionalKeytabsB64 = %v, want the configured keytab", opt.AdditionalKeytabsB64)
+	}
+}
+
+func TestIsValidSID(t *testing.T) {
+	tests := []struct {
+		sid   string
+		valid bool
+	}{
+		// Valid domain and well-known SIDs
+		{"S-1-5-21-1111111111-2222222222-3333333333-513", true},
+		{"S-1-5-21-1-2-3-1104", true},
+		{"S-1-5-32-544", true},
+		{"S-1-1-0", true},
+		{"S-1-5-21-4294967295", true},
+		{"S-1-281474976710655-1", true},
+		{"S-1-5-1-2-3-4-5-6-7-8-9-10-11-12-13-14-15", true},
+
+		// Too short
+		{"", false},
+		{"S-1-", false},
+		{"S-1-5", false},
+		{"S-1-5-", false},
+
+		// Non-numeric or empty components
+		{"S-1-5-21-abc-513", false},
+		{"S-1-5-21--513", false},
+		{"S-1-5-21-1-2-3-", false},
+		{"S-1-5-+21-1", false},
+		{"S-1-5-21-0x1F", false},
+		{"S-1-5-21-1 -513", false},
+
+		// Out of range or non-canonical
+		{"S-1-5-21-4294967296", false},
+		{"S-1-281474976710656-1", false},
+		{"S-1-5-021-1", false},
+		{"S-1-5-1-2-3-4-5-6-7-8-9-10-11-12-13-14-15-16", false},
+
+		// Legacy loose cases
+		{"S--", false},
+		{"Sx-1", false},
+		{"S-", false},
+		{"S-2-5-21-1", false},
+		{"s-1-5-21-1", false},
+	}
+
+	for _, tt := range tests {
+		if got := isValidSID(tt.sid); got != tt.valid {
+			t.Errorf("isValidSID(%q) = %v, want %v", tt.sid, got, tt.valid)
+		}
+	}
+}
+
+func TestNormalizeAndValidateConfig_AllowedMechOIDs(t *testing.T) {
+	const spn = "HTTP/vault.example.com"
+	tests := []struct {
+		name    string
+		oids    []string
+		wantErr bool
+	}{
+		{"default", nil, false},
+		{"kerberos and NTLM", []string{"1.2.840.113554.1.2.2", "1.3.6.1.4.1.311.2.2.10"}, false},
+		{"not dotted decimal", []string{"KRB5"}, true},
+		{"single arc", []string{"1"}, true},
+		{"leading zero", []string{"1.2.0840"}, true},
+		{"trailing dot", []string{"1.2.840."}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Realm:           "EXAMPLE.COM",
+				KDCs:            []string{"dc1.example.com"},
+				KeytabB64:       testKeytabB64(t, spn, etypeID.AES256_CTS_HMAC_SHA1_96),
+				SPN:             spn,
+				AllowedMechOIDs: tt.oids,
+			}
+			err := normalizeAndValidateConfig(cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("normalizeAndValidateConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNormalizeAndValidateConfig_SuccessLogSampleRate(t *testing.T) {
+	const spn = "HTTP/vault.example.com"
+	for _, tt := range []struct {
+		rate    float64
+		wantErr bool
+	}{{0, false}, {0.01, false}, {1, false}, {-0.1, true}, {1.5, true}, {math.NaN(), true}} {
+		cfg := &Config{
+			Realm:                "EXAMPLE.COM",
+			KDCs:                 []string{"dc1.example.com"},
+			KeytabB64:            testKeytabB64(t, spn, etypeID.AES256_CTS_HMAC_SHA1_96),
+			SPN:                  spn,
+			SuccessLogSampleRate: tt.rate,
+		}
+		if err := normalizeAndValidateConfig(cfg); (err != nil) != tt.wantErr {
+			t.Errorf("rate %v: error = %v, wantErr %v", tt.rate, err, tt.wantErr)
+		}
+	}
+}
+
+func TestNormalizeAndValidateConfig_DefaultTokenType(t *testing.T) {
+	const spn = "HTTP/vault.example.com"
+	for _, tt := range []struct {
+		tokenType string
+		wantErr   bool
+	}{{"", false}, {"default", false}, {"service", false}, {"batch", true}} {
+		cfg := &Config{
+			Realm:            "EXAMPLE.COM",
+			KDCs:             []string{"dc1.example.com"},
+			KeytabB64:        testKeytabB64(t, spn, etypeID.AES256_CTS_HMAC_SHA1_96),
+			SPN:              spn,
+			DefaultTokenType: tt.tokenType,
+		}
+		if err := normalizeAndValidateConfig(cfg); (err != nil) != tt.wantErr {
+			t.Errorf("default_token_type %q: error = %v, wantErr %v", tt.tokenType, err, tt.wantErr)
+		}
+	}
+}
+
+func TestNormalizeAndValidateConfig_PrincipalPatterns(t *testing.T) {
+	const spn = "HTTP/vault.example.com"
+	for _, tt := range []struct {
+		allow, deny string
+		wantErr     bool
+	}{
+		{"", "", false},
+		{`@EXAMPLE\.COM$`, `.*admin.*`, false},
+		{"(unclosed", "", true},
+		{"", "[z-a]", true},
+	} {
+		cfg := &Config{
+			Realm:                 "EXAMPLE.COM",
+			KDCs:                  []string{"dc1.example.com"},
+			KeytabB64:             testKeytabB64(t, spn, etypeID.AES256_CTS_HMAC_SHA1_96),
+			SPN:                   spn,
+			PrincipalAllowPattern: tt.allow,
+			PrincipalDenyPattern:  tt.deny,
+		}
+		if err := normalizeAndValidateConfig(cfg); (err != nil) != tt.wantErr {
+			t.Errorf("allow %q deny %q: error = %v, wantErr %v", tt.allow, tt.deny, err, tt.wantErr)
+		}
+	}
+}
+
+func TestPrincipalPatterns_CompiledOnce(t *testing.T) {
+	b, _ := getTestBackend(t)
+	cfg := &Config{PrincipalAllowPattern: `^user@`, PrincipalDenyPattern: `admin`}
+	p := cfg.compiledPrincipalPatterns()
+	if p.err != nil || p.allow == nil || p.deny == nil {
+		t.Fatalf("compiledPrincipalPatterns() = %+v", p)
+	}
+	if cfg.compiledPrincipalPatterns() != p {
+		t.Error("patterns compiled again for the same config")
+	}
+
+	// A config read for a login reuses the patterns compiled on write
+	b.applyConfigSettings(cfg)
+	read := &Config{PrincipalAllowPattern: cfg.PrincipalAllowPattern, PrincipalDenyPattern: cfg.PrincipalDenyPattern}
+	b.usePrincipalPatterns(read)
+	if read.compiledPrincipalPatterns() != p {
+		t.Error("login compiled the patterns instead of reusing them")
+	}
+	if !principalPermitted(read, "user@EXAMPLE.COM") || principalPermitted(read, "user-admin@EXAMPLE.COM") {
+		t.Error("cached patterns applied incorrectly")
+	}
+
+	// Patterns that differ from the cached ones are compiled afresh
+	other := &Config{PrincipalAllowPattern: `^svc-`}
+	b.usePrincipalPatterns(other)
+	if other.compiledPrincipalPatterns() == p || principalPermitted(other, "user@EXAMPLE.COM") {
+		t.Error("stale cached patterns applied to another config")
+	}
+
+	// A stored pattern that no longer compiles rejects every login
+	if principalPermitted(&Config{PrincipalDenyPattern: "(unclosed"}, "user@EXAMPLE.COM") {
+		t.Error("invalid pattern permitted the principal")
+	}
+}
+
+func TestNormalizeAndValidateConfig_DisplayName(t *testing.T) {
+	const spn = "HTTP/vault.example.com"
+	for _, tt := range []struct {
+		format  string
+		maxLen  int
+		wantErr bool
+	}{
+		{"", 0, false},
+		{displayNamePrincipal, 0, false},
+		{displayNameSanitized, 64, false},
+		{displayNameName, 255, false},
+		{"upper", 0, true},
+		{displayNameName, -1, true},
+		{displayNameName, 256, true},
+	} {
+		cfg := &Config{
+			Realm:                "EXAMPLE.COM",
+			KDCs:                 []string{"dc1.example.com"},
+			KeytabB64:            testKeytabB64(t, spn, etypeID.AES256_CTS_HMAC_SHA1_96),
+			SPN:                  spn,
+			DisplayNameFormat:    tt.format,
+			DisplayNameMaxLength: tt.maxLen,
+		}
+		if err := normalizeAndValidateConfig(cfg); (err != nil) != tt.wantErr {
+			t.Errorf("display_name_format %q max %d: error = %v, wantErr %v", tt.format, tt.maxLen, err, tt.wantErr)
+		}
+	}
+}
+
+func TestNormalizeAndValidateConfig_AliasSource(t *testing.T) {
+	const spn = "HTTP/vault.example.com"
+	for source, wantErr := range map[string]bool{
+		"":                   false,
+		aliasSourcePrincipal: false,
+		aliasSourceSID:       false,
+		aliasSourceUPN:       false,
+		"email":              true,
+	} {
+		cfg := &Config{
+			Realm:       "EXAMPLE.COM",
+			KDCs:        []string{"dc1.example.com"},
+			KeytabB64:   testKeytabB64(t, spn, etypeID.AES256_CTS_HMAC_SHA1_96),
+			SPN:         spn,
+			AliasSource: source,
+		}
+		if err := normalizeAndValidateConfig(cfg); (err != nil) != wantErr {
+			t.Errorf("alias_source %q: error = %v, wantErr %v", source, err, wantErr)
+		}
+	}
+}
+
+func TestNormalizeAndValidateConfig_NonHTTPSPNs(t *testing.T) {
+	tests := []struct {
+		spn     string
+		wantErr string
+	}{
+		{"MSSQLSvc/db.example.com:1433", ""},
+		{"CIFS/fs01.example.com@EXAMPLE.COM", ""},
+		{"ldap/dc1.example.com/example.com", ""},
+		{"Acme-Svc/app.example.com:8443/tenant1", ""},
+		{"MSSQLSvc/db.example.com:99999", "port 99999 out of range"},
+		{"CIFS/fs01", "FQDN"},
+		{"CIFS/fs01.example.com@OTHER.COM", "realm must match"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spn, func(t *testing.T) {
+			principal, _, _ := strings.Cut(tt.spn, "@")
+			cfg := &Config{
+				Realm:     "EXAMPLE.COM",
+				KDCs:      []string{"dc1.example.com"},
+				KeytabB64: testKeytabB64(t, principal, etypeID.AES256_CTS_HMAC_SHA1_96),
+				SPN:       tt.spn,
+				// Exercises the keytab lookup by the SPN's principal components
+				ForbidRC4: true,
+			}
+			err := normalizeAndValidateConfig(cfg)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNormalizeAndValidateConfig_DisablePACProcessing(t *testing.T) {
+	const spn = "HTTP/vault.example.com"
+	for _, tc := range []struct {
+		requirePAC, requireUPNInfo, wantErr bool
+	}{{false, false, false}, {true, false, true}, {false, true, true}} {
+		cfg := &Config{
+			Realm:                "EXAMPLE.COM",
+			KDCs:                 []string{"dc1.example.com"},
+			KeytabB64:            testKeytabB64(t, spn, etypeID.AES256_CTS_HMAC_SHA1_96),
+			SPN:                  spn,
+			DisablePACProcessing: true,
+			RequirePAC:           tc.requirePAC,
+			RequireUPNInfo:       tc.requireUPNInfo,
+		}
+		if err := normalizeAndValidateConfig(cfg); (err != nil) != tc.wantErr {
+			t.Errorf("require_pac_present=%t require_upn_dns_info=%t: error = %v, wantErr %t", tc.requirePAC, tc.requireUPNInfo, err, tc.wantErr)
+		}
+	}
+}
+
+func TestNormalizeAndValidateConfig_KeytabMatchesRealm(t *testing.T) {
+	const spn = "HTTP/vault.example.com"
+	tests := []struct {
+		name       string
+		realm      string
+		principal  string
+		requireSPN bool
+		wantErr    string
+	}{
+		{"SPN entry", "EXAMPLE.COM", spn, false, ""},
+		{"SPN entry, strict", "EXAMPLE.COM", spn, true, ""},
+		{"account entry", "EXAMPLE.COM", "vault-gmsa$", false, ""},
+		{"account entry, strict", "EXAMPLE.COM", "vault-gmsa$", true, "no entry for HTTP/vault.example.com@EXAMPLE.COM (found vault-gmsa$@EXAMPLE.COM)"},
+		{"other realm", "OTHER.COM", spn, false, "no entries for realm OTHER.COM (found HTTP/vault.example.com@EXAMPLE.COM)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Realm:            tt.realm,
+				KDCs:             []string{"dc1." + strings.ToLower(tt.realm)},
+				KeytabB64:        testKeytabB64(t, tt.principal, etypeID.AES256_CTS_HMAC_SHA1_96),
+				SPN:              spn,
+				KeytabRequireSPN: tt.requireSPN,
+			}
+			err := normalizeAndValidateConfig(cfg)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	cfg := &Config{Realm: "EXAMPLE.COM", KDCs: []string{"dc1.example.com"}, KeytabB64: "bm90IGEga2V5dGFi", SPN: spn}
+	if err := normalizeAndValidateConfig(cfg); err == nil || !strings.Contains(err.Error(), "could not be parsed") {
+		t.Errorf("error = %v, want the unparseable keytab rejected", err)
+	}
+}
+
+func TestNormalizeAndValidateConfig_SIDPrefixes(t *testing.T) {
+	const spn = "HTTP/vault.example.com"
+	newConfig := func() *Config {
+		return &Config{
+			Realm:     "EXAMPLE.COM",
+			KDCs:      []string{"dc1.example.com"},
+			KeytabB64: testKeytabB64(t, spn, etypeID.AES256_CTS_HMAC_SHA1_96),
+			SPN:       spn,
+		}
+	}
+
+	cfg := newConfig()
+	cfg.ExcludeSIDPrefixes = []string{"s-1-5-32", "S-1-1-0", "S-1-5-32"}
+	cfg.OnlySIDPrefixes = []string{" S-1-5-21-1-2-3 "}
+	if err := normalizeAndValidateConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg.ExcludeSIDPrefixes, []string{"S-1-5-32", "S-1-1-0"}) || !reflect.DeepEqual(cfg.OnlySIDPrefixes, []string{"S-1-5-21-1-2-3"}) {
+		t.Errorf("prefixes = %v / %v, want them canonicalized", cfg.OnlySIDPrefixes, cfg.ExcludeSIDPrefixes)
+	}
+
+	cfg = newConfig()
+	cfg.ExcludeSIDPrefixes = []string{"S-1-5-32-"}
+	if err := normalizeAndValidateConfig(cfg); err == nil || !strings.Contains(err.Error(), "exclude_sid_prefixes") {
+		t.Errorf("error = %v, want the invalid prefix rejected", err)
+	}
+}
+
+func TestNormalizeAndValidateConfig_LogRedactPatterns(t *testing.T) {
+	const spn = "HTTP/vault.example.com"
+	cfg := &Config{
+		Realm:             "EXAMPLE.COM",
+		KDCs:              []string{"dc1.example.com"},
+		KeytabB64:         testKeytabB64(t, spn, etypeID.AES256_CTS_HMAC_SHA1_96),
+		SPN:               spn,
+		LogRedactPatterns: []string{`dc\d+\.corp\.internal`},
+	}
+	if err := normalizeAndValidateConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.logRedactor().Redact("dc4.corp.internal refused S-1-5-32-544"); got != "<redacted> refused <redacted-sid>" {
+		t.Errorf("Redact() = %q", got)
+	}
+
+	cfg.LogRedactPatterns = []string{"ticket-[0-9"}
+	if err := normalizeAndValidateConfig(cfg); err == nil || !strings.Contains(err.Error(), "log_redact_patterns") {
+		t.Errorf("error = %v, want the uncompilable pattern rejected", err)
+	}
+}
+
+func TestNormalizeAndValidateConfig_PACUnknownBufferMode(t *testing.T) {
+	const spn = "HTTP/vault.example.com"
+	for mode, wantErr := range map[string]bool{
+		"":                     false,
+		pacUnknownBufferIgnore: false,
+		pacUnknownBufferWarn:   false,
+		pacUnknownBufferReject: false,
+		"fail":                 true,
+	} {
+		cfg := &Config{
+			Realm:                "EXAMPLE.COM",
+			KDCs:                 []string{"dc1.example.com"},
+			KeytabB64:            testKeytabB64(t, spn, etypeID.AES256_CTS_HMAC_SHA1_96),
+			SPN:                  spn,
+			PACUnknownBufferMode: mode,
+		}
+		if err := normalizeAndValidateConfig(cfg); (err != nil) != wantErr {
+			t.Errorf("pac_unknown_buffer_mode %q: error = %v, wantErr %v", mode, err, wantErr)
+		}
+	}
+}
+
+func TestNormalizeAndValidateConfig_SPNHostHeader(t *testing.T) {
+	const spn = "HTTP/vault.example.com"
+	for header, want := range map[string]string{
+		"":                   "",
+		"x-original-host":    "X-Original-Host",
+		" X-Forwarded-Host ": "X-Forwarded-Host",
+		"host":               "error",
+		"X Forwarded Host":   "error",
+	} {
+		cfg := &Config{
+			Realm:         "EXAMPLE.COM",
+			KDCs:          []string{"dc1.example.com"},
+			KeytabB64:     testKeytabB64(t, spn, etypeID.AES256_CTS_HMAC_SHA1_96),
+			SPN:           spn,
+			SPNHostHeader: header,
+		}
+		err := normalizeAndValidateConfig(cfg)
+		if want == "error" {
+			if err == nil || !strings.Contains(err.Error(), "spn_host_header") {
+				t.Errorf("spn_host_header %q: error = %v, want it rejected", header, err)
+			}
+			continue
+		}
+		if err != nil || cfg.SPNHostHeader != want {
+			t.Errorf("spn_host_header %q = %q, %v; want %q", header, cfg.SPNHostHeader, err, want)
+		}
+	}
+}
+
+func TestNormalizeAndValidateConfig_BasePolicies(t *testing.T) {
+	const spn = "HTTP/vault.example.com"
+	cfg := &Config{
+		Realm:        "EXAMPLE.COM",
+		KDCs:         []string{"dc1.example.com"},
+		KeytabB64:    testKeytabB64(t, spn, etypeID.AES256_CTS_HMAC_SHA1_96),
+		SPN:          spn,
+		BasePolicies: []string{"gmsa-base", "audit", "gmsa-base"},
+	}
+	if err := normalizeAndValidateConfig(cfg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(cfg.BasePolicies, []string{"gmsa-base", "audit"}) {
+		t.Errorf("BasePolicies = %v, want deduplicated", cfg.BasePolicies)
+	}
+	cfg.BasePolicies = []string{"bad policy!"}
+	if err := normalizeAndValidateConfig(cfg); err == nil {
+		t.Error("expected an invalid base policy name to be rejected")
+	}
+}
+
+func TestNormalizeAndValidateConfig_DefaultPolicies(t *testing.T) {
+	const spn = "HTTP/vault.example.com"
+	cfg := &Config{
+		Realm:           "EXAMPLE.COM",
+		KDCs:            []string{"dc1.example.com"},
+		KeytabB64:       testKeytabB64(t, spn, etypeID.AES256_CTS_HMAC_SHA1_96),
+		SPN:             spn,
+		DefaultPolicies: []string{"mount-default", "mount-default"},
+	}
+	if err := normalizeAndValidateConfig(cfg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(cfg.DefaultPolicies, []string{"mount-default"}) {
+		t.Errorf("DefaultPolicies = %v, want deduplicated", cfg.DefaultPolicies)
+	}
+	for _, policy := range []string{"bad policy!", "{{principal}}", ""} {
+		cfg.DefaultPolicies = []string{policy}
+		if err := normalizeAndValidateConfig(cfg); err == nil || !strings.Contains(err.Error(), "default_policies") {
+			t.Errorf("default_policies %q: error = %v, want it rejected", policy, err)
+		}
+	}
+}
+
+func TestValidatorOptions_AllowedMechOIDs(t *testing.T) {
+	b, _ := getTestBackend(t)
+
+	opt := b.validatorOptions(&Config{})
+	if strings.Join(opt.AllowedMechOIDs, ",") != "1.2.840.113554.1.2.2,1.2.840.48018.1.2.2" {
+		t.Errorf("default AllowedMechOIDs = %v, want Kerberos only", opt.AllowedMechOIDs)
+	}
+	opt = b.validatorOptions(&Config{AllowedMechOIDs: []string{"1.2.840.113554.1.2.2"}})
+	if len(opt.AllowedMechOIDs) != 1 || opt.AllowedMechOIDs[0] != "1.2.840.113554.1.2.2" {
+		t.Errorf("AllowedMechOIDs = %v", opt.AllowedMechOIDs)
+	}
+}
+
+func TestValidatorOptions_RejectDowngrade(t *testing.T) {
+	b, _ := getTestBackend(t)
+
+	if b.validatorOptions(&Config{}).RejectEnctypeDowngrade {
+		t.Error("RejectEnctypeDowngrade set without reject_downgrade")
+	}
+	if !b.validatorOptions(&Config{RejectDowngrade: true}).RejectEnctypeDowngrade {
+		t.Error("reject_downgrade not passed to the validator")
+	}
+	if out := prometheusMetrics(nil); !strings.Contains(out, "gmsa_enctype_downgrades_total") {
+		t.Errorf("prometheus output missing downgrade counter:\n%s", out)
+	}
+}
+
+func TestNormalize_LongestAffixWins(t *testing.T) {
+	orders := [][]string{
+		{".local", ".corp.local"},
+		{".corp.local", ".local"},
+	}
+	for _, suffixes := range orders {
+		cfg := NormalizationConfig{
+			RealmSuffixes: suffixes,
+			SPNSuffixes:   suffixes,
+			RealmPrefixes: []string{"dev-", "dev-eu-"},
+			SPNPrefixes:   []string{"dev-eu-", "dev-"},
+		}
+		if got := normalizeRealm("dev-eu-example.corp.local", cfg); got != "EXAMPLE" {
+			t.Errorf("suffixes %v: normalizeRealm() = %q, want %q", suffixes, got, "EXAMPLE")
+		}
+		if got := normalizeRealm("example.local", cfg); got != "EXAMPLE" {
+			t.Errorf("suffixes %v: normalizeRealm() = %q, want %q", suffixes, got, "EXAMPLE")
+		}
+		if got := normalizeSPN("dev-eu-http/vault.corp.local", cfg); got != "HTTP/vault" {
+			t.Errorf("suffixes %v: normalizeSPN() = %q, want %q", suffixes, got, "HTTP/vault")
+		}
+		if got := normalizeSPN("dev-http/vault.example.local", cfg); got != "HTTP/vault.example" {
+			t.Errorf("suffixes %v: normalizeSPN() = %q, want %q", suffixes, got, "HTTP/vault.example")
+		}
+	}
+}
+
+func TestValidateNormalizationConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     NormalizationConfig
+		wantErr bool
+	}{
+		{"overlapping allowed", NormalizationConfig{RealmSuffixes: []string{".local", ".corp.local"}}, false},
+		{"duplicate suffix", NormalizationConfig{SPNSuffixes: []string{".local", ".local"}}, true},
+		{"duplicate prefix", NormalizationConfig{RealmPrefixes: []string{"dev-", "dev-"}}, true},
+		{"empty entry", NormalizationConfig{SPNPrefixes: []string{""}}, true},
+		{"defaults", getDefaultNormalizationConfig(), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateNormalizationConfig(tt.cfg); (err != nil) != tt.wantErr {
+				t.Errorf("validateNormalizationConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNormalizeAndValidateConfig_MaxConcurrentLogins(t *testing.T) {
+	const spn = "HTTP/vault.example.com"
+	for _, tt := range []struct {
+		max     int
+		wantErr bool
+	}{
+		{0, false},
+		{16, false},
+		{-1, true},
+	} {
+		cfg := &Config{
+			Realm:               "EXAMPLE.COM",
+			KDCs:                []string{"dc1.example.com"},
+			KeytabB64:           testKeytabB64(t, spn, etypeID.AES256_CTS_HMAC_SHA1_96),
+			SPN:                 spn,
+			MaxConcurrentLogins: tt.max,
+		}
+		if err := normalizeAndValidateConfig(cfg); (err != nil) != tt.wantErr {
+			t.Errorf("max_concurrent_logins %d: error = %v, wantErr %v", tt.max, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCanonicalSID(t *testing.T) {
+	tests := []struct {
+		sid, want string
+		ok        bool
+	}{
+		{"S-1-5-21-1-2-3-513", "S-1-5-21-1-2-3-513", true},
+		{"s-1-5-21-1-2-3-513", "S-1-5-21-1-2-3-513", true},
+		{"  S-1-5-32-544 ", "S-1-5-32-544", true},
+		{"S-1-5-021-1", "S-1-5-021-1", false},
+		{"s-1-5-21-abc", "S-1-5-21-ABC", false},
+	}
+	for _, tt := range tests {
+		if got, ok := canonicalSID(tt.sid); got != tt.want || ok != tt.ok {
+			t.Errorf("canonicalSID(%q) = %q, %t; want %q, %t", tt.sid, got, ok, tt.want, tt.ok)
+		}
+	}
+}
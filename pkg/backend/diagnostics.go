@@ -0,0 +1,76 @@
+package backend
+
+import (
+	"context"
+	"encoding/base64"
+	"runtime"
+
+	"github.com/jcmturner/gokrb5/v8/keytab"
+)
+
+// startupDiagnostics summarizes the mount's stored state for operators.
+// Only non-sensitive values are included; the keytab itself is never logged.
+func (b *gmsaBackend) startupDiagnostics(ctx context.Context) []interface{} {
+	fields := []interface{}{"platform", runtime.GOOS}
+
+	cfg, err := b.loadConfig(ctx)
+	switch {
+	case err != nil:
+		fields = append(fields, "config_present", false, "config_error", err)
+	case cfg == nil:
+		fields = append(fields, "config_present", false)
+	default:
+		fields = append(fields,
+			"config_present", true,
+			"realm", cfg.Realm,
+			"spn", cfg.SPN,
+			"kdc_count", len(cfg.KDCs),
+		)
+		entries, ktErr := keytabEntryCount(cfg.KeytabB64)
+		fields = append(fields, "keytab_valid", ktErr == nil, "keytab_entries", entries)
+		if ktErr != nil {
+			fields = append(fields, "keytab_error", ktErr)
+		}
+		fields = append(fields, "previous_keytab_active", cfg.activePreviousKeytab(b.now()) != "", "additional_keytabs", len(cfg.AdditionalKeytabs))
+	}
+
+	roles, err := listRoles(ctx, b.storage)
+	if err != nil {
+		fields = append(fields, "role_count", -1)
+	} else {
+		fields = append(fields, "role_count", len(roles))
+	}
+
+	rotationConfigured, rotationEnabled := false, false
+	if entry, err := b.storage.Get(ctx, "rotation/config"); err == nil && entry != nil {
+		var rc RotationConfig
+		if entry.DecodeJSON(&rc) == nil {
+			rotationConfigured, rotationEnabled = true, rc.Enabled
+		}
+	}
+	fields = append(fields,
+		"rotation_configured", rotationConfigured,
+		"rotation_enabled", rotationEnabled,
+		"rotation_running", b.rotationManager != nil && b.rotationManager.IsRunning(),
+	)
+
+	return fields
+}
+
+// logStartupDiagnostics emits the startup summary once at Info level
+func (b *gmsaBackend) logStartupDiagnostics(ctx context.Context) {
+	b.logger.Info("gmsa auth startup diagnostics", b.startupDiagnostics(ctx)...)
+}
+
+// keytabEntryCount parses a base64 keytab and returns its entry count
+func keytabEntryCount(keytabB64 string) (int, error) {
+	raw, err := base64.StdEncoding.DecodeString(keytabB64)
+	if err != nil {
+		return 0, err
+	}
+	kt := &keytab.Keytab{}
+	if err := kt.Unmarshal(raw); err != nil {
+		return 0, err
+	}
+	return len(kt.Entries), nil
+}
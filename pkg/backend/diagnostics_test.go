@@ -0,0 +1,122 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+)
+
+// captureStartupDiagnostics runs the startup summary against a JSON test
+// logger and returns the decoded log line
+func captureStartupDiagnostics(t *testing.T, b *gmsaBackend) map[string]interface{} {
+	t.Helper()
+	var buf bytes.Buffer
+	b.logger = hclog.New(&hclog.LoggerOptions{Output: &buf, JSONFormat: true, Level: hclog.Info})
+	b.logStartupDiagnostics(context.Background())
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("failed to decode log line %q: %v", buf.String(), err)
+	}
+	if line["@level"] != "info" {
+		t.Errorf("level = %v, want info", line["@level"])
+	}
+	return line
+}
+
+func TestStartupDiagnostics_Unconfigured(t *testing.T) {
+	b, _ := getTestBackend(t)
+	line := captureStartupDiagnostics(t, b)
+
+	want := map[string]interface{}{
+		"config_present":      false,
+		"role_count":          float64(0),
+		"rotation_configured": false,
+		"rotation_enabled":    false,
+		"rotation_running":    false,
+	}
+	for k, v := range want {
+		if line[k] != v {
+			t.Errorf("%s = %v, want %v", k, line[k], v)
+		}
+	}
+	if _, ok := line["keytab_valid"]; ok {
+		t.Error("keytab_valid reported without config")
+	}
+}
+
+func TestStartupDiagnostics_Configured(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+
+	kt := keytab.New()
+	if err := kt.AddEntry("HTTP/vault.example.com", "EXAMPLE.COM", "password", time.Now(), 1, etypeID.AES256_CTS_HMAC_SHA1_96); err != nil {
+		t.Fatal(err)
+	}
+	ktBytes, err := kt.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ktB64 := base64.StdEncoding.EncodeToString(ktBytes)
+	if err := writeConfig(ctx, storage, &Config{
+		Realm:     "EXAMPLE.COM",
+		KDCs:      []string{"dc1.example.com", "dc2.example.com"},
+		KeytabB64: ktB64,
+		SPN:       "HTTP/vault.example.com",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"app", "web"} {
+		if err := writeRole(ctx, storage, &Role{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	line := captureStartupDiagnostics(t, b)
+
+	want := map[string]interface{}{
+		"config_present": true,
+		"realm":          "EXAMPLE.COM",
+		"spn":            "HTTP/vault.example.com",
+		"kdc_count":      float64(2),
+		"keytab_valid":   true,
+		"keytab_entries": float64(1),
+		"role_count":     float64(2),
+	}
+	for k, v := range want {
+		if line[k] != v {
+			t.Errorf("%s = %v, want %v", k, line[k], v)
+		}
+	}
+
+	raw, _ := json.Marshal(line)
+	if strings.Contains(string(raw), ktB64) {
+		t.Error("diagnostics leaked keytab contents")
+	}
+}
+
+func TestStartupDiagnostics_InvalidKeytab(t *testing.T) {
+	b, storage := getTestBackend(t)
+	if err := writeConfig(context.Background(), storage, &Config{
+		Realm:     "EXAMPLE.COM",
+		KeytabB64: base64.StdEncoding.EncodeToString([]byte("not-a-keytab")),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	line := captureStartupDiagnostics(t, b)
+	if line["keytab_valid"] != false {
+		t.Errorf("keytab_valid = %v, want false", line["keytab_valid"])
+	}
+	if _, ok := line["keytab_error"]; !ok {
+		t.Error("expected keytab_error for unparsable keytab")
+	}
+}
@@ -0,0 +1,372 @@
+package backend
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lpassig/vault-plugin-auth-gmsa/internal/kerb"
+)
+
+const (
+	defaultGroupNameCacheTTL = time.Hour        // Cache lifetime when group_names_cache_ttl is 0
+	maxGroupNameCacheTTL     = 24 * time.Hour   // Upper bound for group_names_cache_ttl
+	maxGroupNameCacheEntries = 10000            // Cached SIDs before new results stop being cached
+	groupNameLookupTimeout   = 5 * time.Second  // Bound on one LDAP query during login
+	groupNameBackoff         = 30 * time.Second // Lookups skipped after a failed one, doubling while they keep failing
+	maxGroupNameBackoff      = 5 * time.Minute  // Cap on the back-off between lookups
+	ldapPageSize             = 500              // Entries per page of a paged search, below AD's default MaxPageSize of 1000
+)
+
+// GroupNamesConfig controls resolving PAC group SIDs to sAMAccountNames over
+// LDAP so login metadata carries readable group names
+type GroupNamesConfig struct {
+	Enabled      bool          `json:"enabled"`
+	LDAPURL      string        `json:"ldap_url"`      // ldap:// or ldaps:// URL of a domain controller
+	BindDN       string        `json:"bind_dn"`       // Empty binds anonymously
+	BindPassword string        `json:"bind_password"` // Secret, reported only as bind_password_set
+	BaseDN       string        `json:"base_dn"`       // Search base, e.g. DC=example,DC=com
+	CacheTTL     time.Duration `json:"cache_ttl"`     // 0 = default
+}
+
+// cacheTTL returns the configured cache lifetime
+func (c GroupNamesConfig) cacheTTL() time.Duration {
+	if c.CacheTTL == 0 {
+		return defaultGroupNameCacheTTL
+	}
+	return c.CacheTTL
+}
+
+// validate checks the lookup settings when the lookup is enabled
+func (c GroupNamesConfig) validate() error {
+	if c.CacheTTL < 0 || c.CacheTTL > maxGroupNameCacheTTL {
+		return fmt.Errorf("group_names_cache_ttl must be between 0 and %d seconds", int(maxGroupNameCacheTTL.Seconds()))
+	}
+	if !c.Enabled {
+		return nil
+	}
+	if !strings.HasPrefix(c.LDAPURL, "ldap://") && !strings.HasPrefix(c.LDAPURL, "ldaps://") {
+		return fmt.Errorf("group_names_ldap_url must be an ldap:// or ldaps:// URL when group_names is enabled")
+	}
+	if c.BaseDN == "" {
+		return fmt.Errorf("group_names_base_dn is required when group_names is enabled")
+	}
+	if (c.BindDN == "") != (c.BindPassword == "") {
+		return fmt.Errorf("group_names_bind_dn and group_names_bind_password must be set together")
+	}
+	return nil
+}
+
+// sidNameResolver looks up the sAMAccountName of each SID. SIDs missing from
+// the result don't exist in the directory (or aren't visible to the bind).
+type sidNameResolver interface {
+	resolve(ctx context.Context, cfg GroupNamesConfig, sids []string) (map[string]string, error)
+}
+
+// groupSIDResolver looks up the objectSid of each group sAMAccountName.
+// Result keys are the names as given; names missing from the result don't
+// match a group in the directory.
+type groupSIDResolver interface {
+	resolveNames(ctx context.Context, cfg GroupNamesConfig, names []string) (map[string]string, error)
+}
+
+// errGroupNamesBackoff is returned while lookups are skipped after failures
+var errGroupNamesBackoff = errors.New("group name lookups paused after a failed lookup")
+
+// groupNameCache caches SID to name lookups, including SIDs the directory
+// didn't resolve, so logins only query LDAP for SIDs not seen within the TTL.
+// A failed lookup opens a breaker, so while the directory is unreachable
+// logins skip the lookup instead of each waiting out its timeout.
+type groupNameCache struct {
+	mu       sync.Mutex
+	entries  map[string]groupNameEntry
+	breaker  ldapBreaker
+	resolver sidNameResolver
+	now      func() time.Time
+}
+
+type groupNameEntry struct {
+	name    string // "" records an unresolved SID
+	expires time.Time
+}
+
+func newGroupNameCache(resolver sidNameResolver) *groupNameCache {
+	return &groupNameCache{
+		entries:  make(map[string]groupNameEntry),
+		breaker:  newGroupNameBreaker(),
+		resolver: resolver,
+		now:      time.Now,
+	}
+}
+
+func newGroupNameBreaker() ldapBreaker {
+	return ldapBreaker{threshold: 1, baseDelay: groupNameBackoff, maxDelay: maxGroupNameBackoff}
+}
+
+// names returns a name for each SID, in order, querying LDAP for SIDs not
+// cached. Unresolved SIDs are returned as the SID itself. On a lookup error
+// the SIDs it covered are returned unresolved and left uncached, along with
+// the error; while the breaker is open after a failure, the lookup is
+// skipped and errGroupNamesBackoff is returned instead.
+func (c *groupNameCache) names(ctx context.Context, cfg GroupNamesConfig, sids []string) ([]string, error) {
+	now := c.now()
+	names := make([]string, len(sids))
+	var missing []string
+
+	c.mu.Lock()
+	for i, sid := range sids {
+		if e, ok := c.entries[sid]; ok && now.Before(e.expires) {
+			names[i] = e.name
+		} else {
+			missing = append(missing, sid)
+		}
+	}
+	allowed := c.breaker.allow(now)
+	c.mu.Unlock()
+
+	var err error
+	switch {
+	case len(missing) == 0:
+	case !allowed:
+		err = errGroupNamesBackoff
+	default:
+		var resolved map[string]string
+		lookupCtx, cancel := context.WithTimeout(ctx, groupNameLookupTimeout)
+		resolved, err = c.resolver.resolve(lookupCtx, cfg, unique(missing))
+		cancel()
+		c.mu.Lock()
+		if err != nil {
+			c.breaker.failure(c.now())
+		} else {
+			c.breaker.success()
+		}
+		c.mu.Unlock()
+		if err == nil {
+			c.store(resolved, missing, now.Add(cfg.cacheTTL()))
+			for i, sid := range sids {
+				if name, ok := resolved[sid]; ok {
+					names[i] = name
+				}
+			}
+		}
+	}
+
+	for i, name := range names {
+		if name == "" {
+			names[i] = sids[i]
+		}
+	}
+	return names, err
+}
+
+// store caches a lookup result for every queried SID
+func (c *groupNameCache) store(resolved map[string]string, queried []string, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries)+len(queried) > maxGroupNameCacheEntries {
+		now := c.now()
+		for sid, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, sid)
+			}
+		}
+	}
+	for _, sid := range queried {
+		if len(c.entries) >= maxGroupNameCacheEntries {
+			return
+		}
+		c.entries[sid] = groupNameEntry{name: resolved[sid], expires: expires}
+	}
+}
+
+// Purge drops all cached names and closes the breaker, e.g. after a config
+// change
+func (c *groupNameCache) Purge() {
+	c.mu.Lock()
+	c.entries = make(map[string]groupNameEntry)
+	c.breaker = newGroupNameBreaker()
+	c.mu.Unlock()
+}
+
+// ldapsearchResolver resolves SIDs with the OpenLDAP ldapsearch client, as
+// the rotation manager does for password queries. The bind password is passed
+// on stdin so it never appears in the process list.
+type ldapsearchResolver struct {
+	run func(ctx context.Context, args []string, stdin string) ([]byte, error)
+}
+
+func newLDAPSearchResolver() *ldapsearchResolver {
+	return &ldapsearchResolver{run: runLDAPSearch}
+}
+
+func runLDAPSearch(ctx context.Context, args []string, stdin string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "ldapsearch", args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ldapsearch failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+func (r *ldapsearchResolver) resolve(ctx context.Context, cfg GroupNamesConfig, sids []string) (map[string]string, error) {
+	var terms []string
+	for _, sid := range sids {
+		if encoded, ok := ldapSIDFilterValue(sid); ok {
+			terms = append(terms, "(objectSid="+encoded+")")
+		}
+	}
+	if len(terms) == 0 {
+		return map[string]string{}, nil
+	}
+	return r.search(ctx, cfg, "(|"+strings.Join(terms, "")+")")
+}
+
+func (r *ldapsearchResolver) resolveNames(ctx context.Context, cfg GroupNamesConfig, names []string) (map[string]string, error) {
+	if len(names) == 0 {
+		return map[string]string{}, nil
+	}
+	var terms []string
+	for _, name := range names {
+		terms = append(terms, "(sAMAccountName="+ldapFilterEscape(name)+")")
+	}
+	found, err := r.search(ctx, cfg, "(&(objectClass=group)(|"+strings.Join(terms, "")+"))")
+	if err != nil {
+		return nil, err
+	}
+	// sAMAccountName matches case-insensitively, so map results back to the
+	// names as requested
+	sids := make(map[string]string)
+	for sid, account := range found {
+		for _, name := range names {
+			if strings.EqualFold(name, account) {
+				sids[name] = sid
+			}
+		}
+	}
+	return sids, nil
+}
+
+// search runs filter against the directory and returns the sAMAccountName of
+// each matching object by SID. It requests the paged results control
+// (RFC 2696), so ldapsearch follows the server's cookie through every page
+// and prints all entries, rather than failing with a size limit error once a
+// result outgrows the DC's MaxPageSize.
+func (r *ldapsearchResolver) search(ctx context.Context, cfg GroupNamesConfig, filter string) (map[string]string, error) {
+	args := []string{"-LLL", "-x", "-o", "ldif-wrap=no", "-E", fmt.Sprintf("pr=%d/noprompt", ldapPageSize), "-H", cfg.LDAPURL, "-b", cfg.BaseDN}
+	if cfg.BindDN != "" {
+		args = append(args, "-D", cfg.BindDN, "-y", "/dev/stdin")
+	}
+	args = append(args, filter, "objectSid", "sAMAccountName")
+
+	out, err := r.run(ctx, args, cfg.BindPassword)
+	if err != nil {
+		return nil, err
+	}
+	return parseSIDNames(out)
+}
+
+// ldapSIDFilterValue encodes sid as the escaped binary objectSid value of an
+// LDAP filter
+func ldapSIDFilterValue(sid string) (string, bool) {
+	if !isValidSID(sid) {
+		return "", false
+	}
+	parts := strings.Split(sid[len("S-1-"):], "-")
+	authority, _ := strconv.ParseUint(parts[0], 10, 48)
+	raw := make([]byte, 8, 8+4*(len(parts)-1))
+	raw[0] = 1
+	raw[1] = byte(len(parts) - 1)
+	for i := 0; i < 6; i++ {
+		raw[7-i] = byte(authority >> (8 * i))
+	}
+	for _, part := range parts[1:] {
+		sub, _ := strconv.ParseUint(part, 10, 32)
+		raw = binary.LittleEndian.AppendUint32(raw, uint32(sub))
+	}
+
+	var sb strings.Builder
+	for _, b := range raw {
+		fmt.Fprintf(&sb, "\\%02x", b)
+	}
+	return sb.String(), true
+}
+
+// ldapFilterEscape escapes the characters with special meaning in an LDAP
+// filter value (RFC 4515)
+func ldapFilterEscape(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\', '*', '(', ')', 0:
+			fmt.Fprintf(&sb, "\\%02x", c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+// parseSIDNames reads objectSid and sAMAccountName pairs from unwrapped LDIF
+func parseSIDNames(ldif []byte) (map[string]string, error) {
+	names := make(map[string]string)
+	var sid, name string
+	flush := func() {
+		if sid != "" && name != "" {
+			names[sid] = name
+		}
+		sid, name = "", ""
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(ldif))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			flush()
+			continue
+		}
+		attr, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		// "attr:: value" carries base64, "attr: value" plain text
+		var raw []byte
+		if strings.HasPrefix(value, ":") {
+			decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value[1:]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid base64 value for %s: %w", attr, err)
+			}
+			raw = decoded
+		} else {
+			raw = []byte(strings.TrimSpace(value))
+		}
+
+		switch strings.ToLower(attr) {
+		case "objectsid":
+			s, err := kerb.SIDString(raw)
+			if err != nil {
+				return nil, fmt.Errorf("invalid objectSid: %w", err)
+			}
+			sid = s
+		case "samaccountname":
+			name = string(raw)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+	return names, nil
+}
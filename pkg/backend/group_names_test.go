@@ -0,0 +1,325 @@
+package backend
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lpassig/vault-plugin-auth-gmsa/internal/kerb"
+)
+
+const (
+	testAdminsSID = "S-1-5-21-1-2-3-512"
+	testUsersSID  = "S-1-5-21-1-2-3-513"
+	testGoneSID   = "S-1-5-21-1-2-3-9999"
+)
+
+// mockSIDResolver maps SIDs to names like a directory and records queries
+type mockSIDResolver struct {
+	names   map[string]string
+	err     error
+	queries [][]string
+}
+
+func (m *mockSIDResolver) resolve(_ context.Context, _ GroupNamesConfig, sids []string) (map[string]string, error) {
+	m.queries = append(m.queries, sids)
+	if m.err != nil {
+		return nil, m.err
+	}
+	out := make(map[string]string)
+	for _, sid := range sids {
+		if name, ok := m.names[sid]; ok {
+			out[sid] = name
+		}
+	}
+	return out, nil
+}
+
+// binarySID returns the objectSid bytes for sid via its LDAP filter encoding
+func binarySID(t *testing.T, sid string) []byte {
+	t.Helper()
+	escaped, ok := ldapSIDFilterValue(sid)
+	if !ok {
+		t.Fatalf("ldapSIDFilterValue(%s) failed", sid)
+	}
+	raw, err := hex.DecodeString(strings.ReplaceAll(escaped, `\`, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestGroupNameCache(t *testing.T) {
+	resolver := &mockSIDResolver{names: map[string]string{testAdminsSID: "Domain Admins", testUsersSID: "Domain Users"}}
+	cache := newGroupNameCache(resolver)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	cfg := GroupNamesConfig{CacheTTL: time.Minute}
+	sids := []string{testAdminsSID, testGoneSID, testUsersSID}
+
+	names, err := cache.names(context.Background(), cfg, sids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Unresolved SIDs keep their position as the raw SID
+	if want := []string{"Domain Admins", testGoneSID, "Domain Users"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("names = %v, want %v", names, want)
+	}
+
+	// Resolved and unresolved SIDs are both served from the cache
+	if _, err := cache.names(context.Background(), cfg, sids); err != nil {
+		t.Fatal(err)
+	}
+	if len(resolver.queries) != 1 {
+		t.Errorf("queries = %v, want one lookup while cached", resolver.queries)
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := cache.names(context.Background(), cfg, []string{testAdminsSID}); err != nil {
+		t.Fatal(err)
+	}
+	if len(resolver.queries) != 2 || !reflect.DeepEqual(resolver.queries[1], []string{testAdminsSID}) {
+		t.Errorf("queries = %v, want a second lookup of the expired SID", resolver.queries)
+	}
+
+	cache.Purge()
+	if _, err := cache.names(context.Background(), cfg, []string{testUsersSID}); err != nil {
+		t.Fatal(err)
+	}
+	if len(resolver.queries) != 3 {
+		t.Errorf("queries = %v, want a lookup after purge", resolver.queries)
+	}
+}
+
+func TestGroupNameCache_LookupError(t *testing.T) {
+	resolver := &mockSIDResolver{err: errors.New("dc unreachable")}
+	cache := newGroupNameCache(resolver)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	names, err := cache.names(context.Background(), GroupNamesConfig{}, []string{testAdminsSID})
+	if err == nil || errors.Is(err, errGroupNamesBackoff) {
+		t.Fatalf("error = %v, want the lookup error returned", err)
+	}
+	if !reflect.DeepEqual(names, []string{testAdminsSID}) {
+		t.Errorf("names = %v, want the raw SID", names)
+	}
+
+	// Logins during the back-off skip the lookup instead of waiting on it
+	names, err = cache.names(context.Background(), GroupNamesConfig{}, []string{testAdminsSID})
+	if !errors.Is(err, errGroupNamesBackoff) || !reflect.DeepEqual(names, []string{testAdminsSID}) {
+		t.Errorf("names during back-off = %v, %v; want the raw SID and errGroupNamesBackoff", names, err)
+	}
+	if len(resolver.queries) != 1 {
+		t.Errorf("queries = %v, want none during the back-off", resolver.queries)
+	}
+
+	// A probe that fails again doubles the back-off
+	now = now.Add(groupNameBackoff)
+	if _, err := cache.names(context.Background(), GroupNamesConfig{}, []string{testAdminsSID}); err == nil || errors.Is(err, errGroupNamesBackoff) {
+		t.Fatalf("probe error = %v, want the lookup error", err)
+	}
+	now = now.Add(groupNameBackoff)
+	if _, err := cache.names(context.Background(), GroupNamesConfig{}, []string{testAdminsSID}); !errors.Is(err, errGroupNamesBackoff) {
+		t.Errorf("error = %v, want the back-off doubled", err)
+	}
+
+	// Failures aren't cached, so the first login after the back-off retries
+	now = now.Add(groupNameBackoff)
+	resolver.err = nil
+	resolver.names = map[string]string{testAdminsSID: "Domain Admins"}
+	names, err = cache.names(context.Background(), GroupNamesConfig{}, []string{testAdminsSID})
+	if err != nil || !reflect.DeepEqual(names, []string{"Domain Admins"}) {
+		t.Errorf("names after recovery = %v, %v", names, err)
+	}
+}
+
+func TestGroupNameCache_PurgeClosesBreaker(t *testing.T) {
+	resolver := &mockSIDResolver{err: errors.New("dc unreachable")}
+	cache := newGroupNameCache(resolver)
+	if _, err := cache.names(context.Background(), GroupNamesConfig{}, []string{testAdminsSID}); err == nil {
+		t.Fatal("expected the lookup error to be returned")
+	}
+
+	// A config write may point at a reachable DC, so it retries at once
+	cache.Purge()
+	resolver.err = nil
+	if _, err := cache.names(context.Background(), GroupNamesConfig{}, []string{testAdminsSID}); err != nil {
+		t.Errorf("lookup after purge = %v, want it retried", err)
+	}
+}
+
+func TestLDAPSearchResolver(t *testing.T) {
+	sidValue := func(sid string) string {
+		return base64.StdEncoding.EncodeToString(binarySID(t, sid))
+	}
+
+	var gotArgs []string
+	var gotStdin string
+	r := &ldapsearchResolver{run: func(_ context.Context, args []string, stdin string) ([]byte, error) {
+		gotArgs, gotStdin = args, stdin
+		ldif := "dn: CN=Domain Admins,CN=Users,DC=example,DC=com\n" +
+			"objectSid:: " + sidValue(testAdminsSID) + "\n" +
+			"sAMAccountName: Domain Admins\n" +
+			"\n" +
+			"dn: CN=Gruppe,CN=Users,DC=example,DC=com\n" +
+			"sAMAccountName:: " + base64.StdEncoding.EncodeToString([]byte("Grüppe")) + "\n" +
+			"objectSid:: " + sidValue(testUsersSID) + "\n"
+		return []byte(ldif), nil
+	}}
+	cfg := GroupNamesConfig{
+		LDAPURL:      "ldaps://dc1.example.com",
+		BindDN:       "CN=svc-vault,CN=Users,DC=example,DC=com",
+		BindPassword: "s3cret",
+		BaseDN:       "DC=example,DC=com",
+	}
+
+	names, err := r.resolve(context.Background(), cfg, []string{testAdminsSID, testUsersSID, testGoneSID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{testAdminsSID: "Domain Admins", testUsersSID: "Grüppe"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("names = %v, want %v", names, want)
+	}
+	if slices.Contains(gotArgs, "s3cret") || gotStdin != "s3cret" {
+		t.Errorf("bind password must be passed on stdin only: args=%v stdin=%q", gotArgs, gotStdin)
+	}
+	filter := `(|(objectSid=\01\05\00\00\00\00\00\05\15\00\00\00\01\00\00\00\02\00\00\00\03\00\00\00\00\02\00\00)`
+	if !slices.ContainsFunc(gotArgs, func(a string) bool { return strings.HasPrefix(a, filter) }) {
+		t.Errorf("args = %v, want a filter starting with %s", gotArgs, filter)
+	}
+}
+
+func TestLDAPSIDFilterValue_RoundTrip(t *testing.T) {
+	for _, sid := range []string{"S-1-5-32-544", testAdminsSID, "S-1-16-12288"} {
+		if got, err := kerb.SIDString(binarySID(t, sid)); err != nil || got != sid {
+			t.Errorf("round trip of %s = %s, %v", sid, got, err)
+		}
+	}
+	if _, ok := ldapSIDFilterValue("not-a-sid"); ok {
+		t.Error("invalid SID encoded")
+	}
+}
+
+func TestGroupNamesConfigValidate(t *testing.T) {
+	valid := GroupNamesConfig{Enabled: true, LDAPURL: "ldaps://dc1.example.com", BaseDN: "DC=example,DC=com"}
+	tests := []struct {
+		name    string
+		modify  func(c *GroupNamesConfig)
+		wantErr string
+	}{
+		{"valid anonymous", func(c *GroupNamesConfig) {}, ""},
+		{"valid bind", func(c *GroupNamesConfig) { c.BindDN, c.BindPassword = "CN=svc", "pw" }, ""},
+		{"disabled without settings", func(c *GroupNamesConfig) { *c = GroupNamesConfig{} }, ""},
+		{"bad scheme", func(c *GroupNamesConfig) { c.LDAPURL = "https://dc1" }, "group_names_ldap_url"},
+		{"no base dn", func(c *GroupNamesConfig) { c.BaseDN = "" }, "group_names_base_dn"},
+		{"bind dn without password", func(c *GroupNamesConfig) { c.BindDN = "CN=svc" }, "set together"},
+		{"ttl too long", func(c *GroupNamesConfig) { c.CacheTTL = 25 * time.Hour }, "group_names_cache_ttl"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid
+			tt.modify(&c)
+			err := c.validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLDAPSearchResolver_ResolveNames(t *testing.T) {
+	var gotArgs []string
+	r := &ldapsearchResolver{run: func(_ context.Context, args []string, _ string) ([]byte, error) {
+		gotArgs = args
+		ldif := "dn: CN=Domain Admins,CN=Users,DC=example,DC=com\n" +
+			"objectSid:: " + base64.StdEncoding.EncodeToString(binarySID(t, testAdminsSID)) + "\n" +
+			"sAMAccountName: Domain Admins\n"
+		return []byte(ldif), nil
+	}}
+	cfg := GroupNamesConfig{LDAPURL: "ldaps://dc1.example.com", BaseDN: "DC=example,DC=com"}
+
+	sids, err := r.resolveNames(context.Background(), cfg, []string{"domain admins", "Ops (EU)*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"domain admins": testAdminsSID}; !reflect.DeepEqual(sids, want) {
+		t.Errorf("sids = %v, want %v", sids, want)
+	}
+	filter := `(&(objectClass=group)(|(sAMAccountName=domain admins)(sAMAccountName=Ops \28EU\29\2a)))`
+	if !slices.Contains(gotArgs, filter) {
+		t.Errorf("args = %v, want filter %s", gotArgs, filter)
+	}
+}
+
+// pagedLDAP serves entries like a DC with the given MaxPageSize: searches
+// without the paged results control fail with a size limit error after
+// maxPageSize entries, and paged searches are answered a page at a time
+type pagedLDAP struct {
+	t           *testing.T
+	entries     map[string]string // SID -> sAMAccountName
+	maxPageSize int
+	pages       int
+}
+
+func (d *pagedLDAP) run(_ context.Context, args []string, _ string) ([]byte, error) {
+	sids := slices.Sorted(maps.Keys(d.entries))
+	pageSize := 0
+	for i, arg := range args {
+		if arg == "-E" && i+1 < len(args) {
+			if _, err := fmt.Sscanf(args[i+1], "pr=%d/noprompt", &pageSize); err != nil {
+				d.t.Fatalf("unexpected control %q", args[i+1])
+			}
+		}
+	}
+	if pageSize == 0 && len(sids) > d.maxPageSize {
+		return nil, errors.New("ldapsearch failed: exit status 4: Size limit exceeded (4)")
+	}
+	pageSize = min(pageSize, d.maxPageSize)
+
+	// ldapsearch follows the cookie itself and prints each page in turn
+	var out strings.Builder
+	for start := 0; start < len(sids); start += pageSize {
+		d.pages++
+		for _, sid := range sids[start:min(start+pageSize, len(sids))] {
+			fmt.Fprintf(&out, "dn: CN=%s,OU=Groups,DC=example,DC=com\n", d.entries[sid])
+			fmt.Fprintf(&out, "objectSid:: %s\n", base64.StdEncoding.EncodeToString(binarySID(d.t, sid)))
+			fmt.Fprintf(&out, "sAMAccountName: %s\n\n", d.entries[sid])
+		}
+	}
+	return []byte(out.String()), nil
+}
+
+func TestLDAPSearchResolver_PagedResults(t *testing.T) {
+	dir := &pagedLDAP{t: t, entries: make(map[string]string), maxPageSize: 1000}
+	for i := 0; i < 2*ldapPageSize+1; i++ {
+		dir.entries[fmt.Sprintf("S-1-5-21-1-2-3-%d", 2000+i)] = fmt.Sprintf("group-%d", i)
+	}
+	r := &ldapsearchResolver{run: dir.run}
+	cfg := GroupNamesConfig{LDAPURL: "ldaps://dc1.example.com", BaseDN: "DC=example,DC=com"}
+
+	names, err := r.search(context.Background(), cfg, "(objectClass=group)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir.pages != 3 {
+		t.Errorf("served %d pages, want 3", dir.pages)
+	}
+	if !reflect.DeepEqual(names, dir.entries) {
+		t.Errorf("collected %d of %d entries", len(names), len(dir.entries))
+	}
+}
@@ -0,0 +1,118 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/lpassig/vault-plugin-auth-gmsa/internal/logging"
+)
+
+const (
+	kdcProbeTimeout   = 2 * time.Second  // Per-attempt TCP connect timeout when kdc_timeout_sec is 0
+	maxKDCProbeBudget = 60 * time.Second // Bound on kdc_timeout_sec across all attempts
+	defaultKDCPort    = "88"             // Kerberos port for kdcs entries without one
+)
+
+// dialKDC opens a TCP connection to a KDC; tests replace it to simulate slow
+// or flaky KDCs
+var dialKDC = func(ctx context.Context, addr string) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", addr)
+}
+
+// kdcTimeout returns the per-attempt KDC connect timeout
+func (c *Config) kdcTimeout() time.Duration {
+	if c.KDCTimeoutSec == 0 {
+		return kdcProbeTimeout
+	}
+	return time.Duration(c.KDCTimeoutSec) * time.Second
+}
+
+// validateKDCTimeouts bounds kdc_timeout_sec and kdc_retries so a probe of
+// an unresponsive KDC can't outlast the request that triggered it
+func (c *Config) validateKDCTimeouts() error {
+	if c.KDCTimeoutSec < 0 {
+		return fmt.Errorf("kdc_timeout_sec cannot be negative, got %d", c.KDCTimeoutSec)
+	}
+	if c.KDCRetries < 0 {
+		return fmt.Errorf("kdc_retries cannot be negative, got %d", c.KDCRetries)
+	}
+	if budget := c.kdcTimeout() * time.Duration(c.KDCRetries+1); budget > maxKDCProbeBudget {
+		return fmt.Errorf("kdc_timeout_sec x (kdc_retries + 1) must not exceed %d seconds, got %d", int(maxKDCProbeBudget.Seconds()), int(budget.Seconds()))
+	}
+	return nil
+}
+
+// kdcAddress returns the host:port to probe for a configured kdcs entry
+func kdcAddress(kdc string) string {
+	if _, _, err := net.SplitHostPort(kdc); err == nil {
+		return kdc
+	}
+	return net.JoinHostPort(kdc, defaultKDCPort)
+}
+
+// probeKDC connects to a KDC, retrying failed attempts up to retries times.
+// Each attempt is bounded by timeout. It returns the number of attempts made
+// and the last error.
+func probeKDC(ctx context.Context, addr string, timeout time.Duration, retries int) (int, error) {
+	var err error
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+		var conn net.Conn
+		conn, err = dialKDC(attemptCtx, addr)
+		cancel()
+		if err == nil {
+			conn.Close()
+			return attempt, nil
+		}
+		if attempt > retries || ctx.Err() != nil {
+			return attempt, err
+		}
+	}
+}
+
+// kdcHealth reports whether the configured KDCs accept TCP connections.
+// Logins never contact a KDC: tickets are validated with the keytab alone,
+// so an unreachable KDC set is a warning for operators, not a login failure.
+// KDCs matter to the clients obtaining tickets and to keytab rotation.
+func kdcHealth(ctx context.Context, kdcs []string, timeout time.Duration, retries int, redactor *logging.Redactor) map[string]interface{} {
+	results := make([]map[string]interface{}, len(kdcs))
+	var wg sync.WaitGroup
+	for i, kdc := range kdcs {
+		wg.Add(1)
+		go func(i int, kdc string) {
+			defer wg.Done()
+			attempts, err := probeKDC(ctx, kdcAddress(kdc), timeout, retries)
+			result := map[string]interface{}{"kdc": kdc, "reachable": err == nil, "attempts": attempts}
+			if err != nil {
+				kdcProbeFailures.Add(1)
+				result["error"] = redactor.Redact(err.Error())
+			}
+			results[i] = result
+		}(i, kdc)
+	}
+	wg.Wait()
+
+	reachable := 0
+	for _, r := range results {
+		if r["reachable"] == true {
+			reachable++
+		}
+	}
+	health := map[string]interface{}{
+		"configured":     len(kdcs),
+		"reachable":      reachable,
+		"used_for_login": false,
+		"kdcs":           results,
+	}
+	switch {
+	case len(kdcs) == 0:
+		health["warning"] = "no KDCs configured"
+	case reachable == 0:
+		health["warning"] = "no KDC available: none of the configured KDCs accepted a connection"
+	}
+	return health
+}
@@ -0,0 +1,199 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// closedAddr returns a local address with nothing listening on it
+func closedAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+func TestKDCAddress(t *testing.T) {
+	tests := map[string]string{
+		"dc1.example.com":      "dc1.example.com:88",
+		"dc1.example.com:8888": "dc1.example.com:8888",
+		"10.0.0.5":             "10.0.0.5:88",
+	}
+	for kdc, want := range tests {
+		if got := kdcAddress(kdc); got != want {
+			t.Errorf("kdcAddress(%q) = %q, want %q", kdc, got, want)
+		}
+	}
+}
+
+func TestKDCHealth(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	up, down := ln.Addr().String(), closedAddr(t)
+
+	tests := []struct {
+		name          string
+		kdcs          []string
+		wantReachable int
+		wantWarning   bool
+		wantFailures  int64
+	}{
+		{name: "empty", kdcs: nil, wantWarning: true},
+		{name: "all unreachable", kdcs: []string{down}, wantWarning: true, wantFailures: 1},
+		{name: "one reachable", kdcs: []string{down, up}, wantReachable: 1, wantFailures: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := kdcProbeFailures.Value()
+			h := kdcHealth(context.Background(), tt.kdcs, kdcProbeTimeout, 0, nil)
+			if h["configured"] != len(tt.kdcs) || h["reachable"] != tt.wantReachable {
+				t.Errorf("health = %v, want %d of %d reachable", h, tt.wantReachable, len(tt.kdcs))
+			}
+			if _, ok := h["warning"]; ok != tt.wantWarning {
+				t.Errorf("warning present = %t, want %t: %v", ok, tt.wantWarning, h)
+			}
+			if h["used_for_login"] != false {
+				t.Errorf("used_for_login = %v, want false", h["used_for_login"])
+			}
+			if got := kdcProbeFailures.Value() - before; got != tt.wantFailures {
+				t.Errorf("kdc_probe_failures moved by %d, want %d", got, tt.wantFailures)
+			}
+		})
+	}
+}
+
+func TestHandleHealth_KDCReachability(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	kt := newTestLoginConfig(t, storage)
+	cfg, err := readConfig(ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.KDCs = []string{closedAddr(t)}
+	if err := writeConfig(ctx, storage, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	health := func(detailed bool) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "health",
+			Storage:   storage,
+			Data:      map[string]interface{}{"detailed": detailed},
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("health failed: err=%v resp=%#v", err, resp)
+		}
+		return resp
+	}
+
+	if _, ok := health(false).Data["kdc"]; ok {
+		t.Error("basic health should not probe KDCs")
+	}
+	kdc, ok := health(true).Data["kdc"].(map[string]interface{})
+	if !ok || kdc["reachable"] != 0 || kdc["warning"] == nil {
+		t.Fatalf("kdc = %v, want an unreachable warning", kdc)
+	}
+
+	// Logins validate with the keytab and don't need a KDC
+	if err := writeRole(ctx, storage, &Role{Name: "app", TokenPolicies: []string{"app"}}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation:  logical.UpdateOperation,
+		Path:       "login",
+		Storage:    storage,
+		Data:       map[string]interface{}{"role": "app", "spnego": newTestLoginSPNEGO(t, kt)},
+		Connection: &logical.Connection{RemoteAddr: "127.0.0.1"},
+	})
+	if err != nil || resp == nil || resp.Auth == nil {
+		t.Fatalf("login with unreachable KDCs failed: err=%v resp=%#v", err, resp)
+	}
+}
+
+func TestProbeKDC_TimeoutAndRetries(t *testing.T) {
+	orig := dialKDC
+	t.Cleanup(func() { dialKDC = orig })
+	ctx := context.Background()
+
+	// A KDC that never answers: every attempt runs into its own deadline
+	var attempts int
+	dialKDC = func(ctx context.Context, _ string) (net.Conn, error) {
+		attempts++
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	start := time.Now()
+	n, err := probeKDC(ctx, "slow.example.com:88", 50*time.Millisecond, 2)
+	elapsed := time.Since(start)
+	if !errors.Is(err, context.DeadlineExceeded) || n != 3 || attempts != 3 {
+		t.Errorf("slow KDC: attempts = %d (%d dials), err = %v; want 3 timed-out attempts", n, attempts, err)
+	}
+	if elapsed < 150*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("slow KDC probe took %v, want about 3 x 50ms", elapsed)
+	}
+
+	// A KDC that fails once is reachable on the retry, but not without one
+	attempts = 0
+	dialKDC = func(context.Context, string) (net.Conn, error) {
+		attempts++
+		if attempts%2 == 1 {
+			return nil, errors.New("connection reset")
+		}
+		client, server := net.Pipe()
+		server.Close()
+		return client, nil
+	}
+	if n, err := probeKDC(ctx, "flaky.example.com:88", time.Second, 1); err != nil || n != 2 {
+		t.Errorf("flaky KDC with a retry: attempts = %d, err = %v; want reachable on attempt 2", n, err)
+	}
+	h := kdcHealth(ctx, []string{"flaky.example.com"}, time.Second, 0, nil)
+	if h["reachable"] != 0 {
+		t.Errorf("flaky KDC without retries: health = %v, want unreachable", h)
+	}
+	if results := h["kdcs"].([]map[string]interface{}); results[0]["attempts"] != 1 {
+		t.Errorf("attempts = %v, want 1", results[0]["attempts"])
+	}
+}
+
+func TestConfigValidateKDCTimeouts(t *testing.T) {
+	tests := []struct {
+		timeout, retries int
+		wantErr          string
+	}{
+		{0, 0, ""},
+		{10, 5, ""},
+		{0, 29, ""},
+		{-1, 0, "kdc_timeout_sec"},
+		{5, -1, "kdc_retries"},
+		{30, 2, "must not exceed 60 seconds, got 90"},
+	}
+	for _, tt := range tests {
+		c := &Config{KDCTimeoutSec: tt.timeout, KDCRetries: tt.retries}
+		err := c.validateKDCTimeouts()
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("timeout %d, retries %d: unexpected error %v", tt.timeout, tt.retries, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("timeout %d, retries %d: error = %v, want %q", tt.timeout, tt.retries, err, tt.wantErr)
+		}
+	}
+}
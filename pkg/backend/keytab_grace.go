@@ -0,0 +1,55 @@
+package backend
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// maxRotationGracePeriod caps how long a replaced keytab stays usable
+const maxRotationGracePeriod = 7 * 24 * time.Hour
+
+// retainPreviousKeytab keeps the keytab being replaced by rotation on newCfg
+// for the grace period so in-flight tickets encrypted to the old key still
+// validate. A zero grace period drops any previous keytab.
+func retainPreviousKeytab(newCfg, oldCfg *Config, grace time.Duration, now time.Time) {
+	if grace <= 0 || oldCfg.KeytabB64 == "" || oldCfg.KeytabB64 == newCfg.KeytabB64 {
+		newCfg.PreviousKeytabB64 = ""
+		newCfg.PreviousKeytabExpiresAt = time.Time{}
+		return
+	}
+	newCfg.PreviousKeytabB64 = oldCfg.KeytabB64
+	newCfg.PreviousKeytabExpiresAt = now.Add(grace)
+}
+
+// activePreviousKeytab returns the previous keytab if its grace window is
+// still open at now, or "" otherwise
+func (c *Config) activePreviousKeytab(now time.Time) string {
+	if c.PreviousKeytabB64 == "" || !now.Before(c.PreviousKeytabExpiresAt) {
+		return ""
+	}
+	return c.PreviousKeytabB64
+}
+
+// dropExpiredPreviousKeytab removes a previous keytab whose grace window has
+// elapsed from storage. It reports whether the config was rewritten.
+func dropExpiredPreviousKeytab(ctx context.Context, s logical.Storage, cfg *Config, now time.Time) (bool, error) {
+	if cfg == nil || cfg.PreviousKeytabB64 == "" || cfg.activePreviousKeytab(now) != "" {
+		return false, nil
+	}
+	cfg.PreviousKeytabB64 = ""
+	cfg.PreviousKeytabExpiresAt = time.Time{}
+	if err := writeConfig(ctx, s, cfg); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// previousKeytabExpiry formats the grace window end for config reads
+func previousKeytabExpiry(c *Config) string {
+	if c.PreviousKeytabB64 == "" {
+		return ""
+	}
+	return c.PreviousKeytabExpiresAt.UTC().Format(time.RFC3339)
+}
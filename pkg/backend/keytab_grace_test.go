@@ -0,0 +1,87 @@
+package backend
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+func TestRetainPreviousKeytab(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	oldCfg := &Config{KeytabB64: "b2xk"}
+
+	newCfg := &Config{KeytabB64: "bmV3"}
+	retainPreviousKeytab(newCfg, oldCfg, time.Hour, now)
+	if newCfg.PreviousKeytabB64 != "b2xk" || !newCfg.PreviousKeytabExpiresAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("previous keytab not retained: %q until %v", newCfg.PreviousKeytabB64, newCfg.PreviousKeytabExpiresAt)
+	}
+
+	// A zero grace period drops any previous keytab
+	retainPreviousKeytab(newCfg, oldCfg, 0, now)
+	if newCfg.PreviousKeytabB64 != "" || !newCfg.PreviousKeytabExpiresAt.IsZero() {
+		t.Error("previous keytab kept with zero grace period")
+	}
+}
+
+func TestValidatorOptions_GraceWindow(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	b := &gmsaBackend{logger: hclog.NewNullLogger(), now: func() time.Time { return now }}
+	cfg := &Config{KeytabB64: "bmV3"}
+	retainPreviousKeytab(cfg, &Config{KeytabB64: "b2xk"}, time.Hour, now)
+
+	if got := b.validatorOptions(cfg).PreviousKeytabB64; got != "b2xk" {
+		t.Errorf("PreviousKeytabB64 inside window = %q, want old keytab", got)
+	}
+
+	now = now.Add(time.Hour)
+	if got := b.validatorOptions(cfg).PreviousKeytabB64; got != "" {
+		t.Errorf("PreviousKeytabB64 after window = %q, want empty", got)
+	}
+}
+
+func TestDropExpiredPreviousKeytab(t *testing.T) {
+	_, storage := getTestBackend(t)
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+
+	cfg := &Config{Realm: "EXAMPLE.COM", KeytabB64: "bmV3"}
+	retainPreviousKeytab(cfg, &Config{KeytabB64: "b2xk"}, time.Hour, now)
+	if err := writeConfig(ctx, storage, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if dropped, err := dropExpiredPreviousKeytab(ctx, storage, cfg, now.Add(time.Minute)); err != nil || dropped {
+		t.Fatalf("dropped inside window: %v, %v", dropped, err)
+	}
+
+	if dropped, err := dropExpiredPreviousKeytab(ctx, storage, cfg, now.Add(time.Hour)); err != nil || !dropped {
+		t.Fatalf("not dropped after window: %v, %v", dropped, err)
+	}
+	stored, err := readConfig(ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.PreviousKeytabB64 != "" || stored.KeytabB64 != "bmV3" {
+		t.Errorf("stored config after drop: previous=%q current=%q", stored.PreviousKeytabB64, stored.KeytabB64)
+	}
+}
+
+func TestRotationConfigValidate_GracePeriod(t *testing.T) {
+	for _, tt := range []struct {
+		grace   time.Duration
+		wantErr bool
+	}{
+		{0, false},
+		{time.Hour, false},
+		{maxRotationGracePeriod, false},
+		{-time.Second, true},
+		{maxRotationGracePeriod + time.Second, true},
+	} {
+		c := &RotationConfig{GracePeriod: tt.grace}
+		if err := c.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("Validate() with grace %v error = %v, wantErr %v", tt.grace, err, tt.wantErr)
+		}
+	}
+}
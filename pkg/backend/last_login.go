@@ -0,0 +1,301 @@
+package backend
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"github.com/lpassig/vault-plugin-auth-gmsa/internal/kerb"
+)
+
+const (
+	storageKeyLastLogin        = "last-login/"       // Prefix for per-principal summaries
+	storageKeyLastLoginIndex   = "last-login-index/" // Prefix for index shards, the login time of each stored summary
+	defaultLastLoginMaxEntries = 1000                // Summaries kept when last_login_max_entries is 0
+	maxLastLoginMaxEntries     = 100000              // Upper bound for last_login_max_entries
+	lastLoginIndexRefresh      = 15 * time.Minute    // Repeat logins sooner than this leave the index alone
+)
+
+// lastLoginRedactable lists the summary fields last_login_redact can omit
+var lastLoginRedactable = []string{"user_sid", "upn", "group_sids", "logon_server", "client_ip"}
+
+// lastLoginSummary is the decoded PAC of a principal's most recent successful
+// login, kept for support and debugging
+type lastLoginSummary struct {
+	Principal   string          `json:"principal"` // Normalized, as used for lookups
+	Realm       string          `json:"realm"`
+	Role        string          `json:"role"`
+	Time        time.Time       `json:"time"`
+	UserSID     string          `json:"user_sid,omitempty"`
+	UPN         string          `json:"upn,omitempty"`
+	GroupSIDs   []string        `json:"group_sids,omitempty"`
+	LogonServer string          `json:"logon_server,omitempty"`
+	ClientIP    string          `json:"client_ip,omitempty"`
+	Flags       map[string]bool `json:"flags"`
+}
+
+// lastLoginMaxEntries returns the configured bound on stored summaries
+func (c *Config) lastLoginMaxEntries() int {
+	if c.LastLoginMaxEntries == 0 {
+		return defaultLastLoginMaxEntries
+	}
+	return c.LastLoginMaxEntries
+}
+
+// lastLoginHash returns the hex SHA-256 of a principal. Principals may
+// contain '/', so storage keys are derived from it rather than the name.
+func lastLoginHash(principal string) string {
+	sum := sha256.Sum256([]byte(principal))
+	return hex.EncodeToString(sum[:])
+}
+
+// lastLoginKey returns the storage key of a principal's summary
+func lastLoginKey(principal string) string {
+	return storageKeyLastLogin + lastLoginHash(principal)
+}
+
+// lastLoginIndexKey returns the key of the index shard holding a principal.
+// The index is split by the first byte of the principal's hash into up to
+// 256 shards, so a login rewrites at most one small shard.
+func lastLoginIndexKey(principal string) string {
+	return storageKeyLastLoginIndex + lastLoginHash(principal)[:2]
+}
+
+func readLastLoginIndexShard(ctx context.Context, s logical.Storage, key string) (map[string]time.Time, error) {
+	entry, err := s.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	shard := make(map[string]time.Time)
+	if entry == nil {
+		return shard, nil
+	}
+	if err := entry.DecodeJSON(&shard); err != nil {
+		return nil, err
+	}
+	return shard, nil
+}
+
+// writeLastLoginIndexShard stores a shard, deleting it once empty
+func writeLastLoginIndexShard(ctx context.Context, s logical.Storage, key string, shard map[string]time.Time) error {
+	if len(shard) == 0 {
+		return s.Delete(ctx, key)
+	}
+	entry, err := logical.StorageEntryJSON(key, shard)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+// readLastLoginIndex merges every index shard
+func readLastLoginIndex(ctx context.Context, s logical.Storage) (map[string]time.Time, error) {
+	keys, err := s.List(ctx, storageKeyLastLoginIndex)
+	if err != nil {
+		return nil, err
+	}
+	index := make(map[string]time.Time)
+	for _, k := range keys {
+		shard, err := readLastLoginIndexShard(ctx, s, storageKeyLastLoginIndex+k)
+		if err != nil {
+			return nil, err
+		}
+		for p, t := range shard {
+			index[p] = t
+		}
+	}
+	return index, nil
+}
+
+func readLastLogin(ctx context.Context, s logical.Storage, principal string) (*lastLoginSummary, error) {
+	entry, err := s.Get(ctx, lastLoginKey(principal))
+	if err != nil || entry == nil {
+		return nil, err
+	}
+	var summary lastLoginSummary
+	if err := entry.DecodeJSON(&summary); err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
+
+// storeLastLogin replaces the principal's summary and records its login
+// time in the index. A repeat login within lastLoginIndexRefresh of the
+// recorded time leaves the index alone. Once more than maxEntries principals
+// are indexed, the least recently seen are dropped down to nine tenths of
+// maxEntries, so the full index is only walked once per tenth of the bound.
+func (b *gmsaBackend) storeLastLogin(ctx context.Context, summary *lastLoginSummary, maxEntries int) error {
+	b.lastLoginLock.Lock()
+	defer b.lastLoginLock.Unlock()
+
+	entry, err := logical.StorageEntryJSON(lastLoginKey(summary.Principal), summary)
+	if err != nil {
+		return err
+	}
+	if err := b.storage.Put(ctx, entry); err != nil {
+		return err
+	}
+
+	shardKey := lastLoginIndexKey(summary.Principal)
+	shard, err := readLastLoginIndexShard(ctx, b.storage, shardKey)
+	if err != nil {
+		return err
+	}
+	last, seen := shard[summary.Principal]
+	if seen && summary.Time.Sub(last) < lastLoginIndexRefresh {
+		return nil
+	}
+	shard[summary.Principal] = summary.Time
+	if err := writeLastLoginIndexShard(ctx, b.storage, shardKey, shard); err != nil {
+		return err
+	}
+	if seen {
+		return nil
+	}
+
+	// The count is kept in memory once the index has been read
+	if b.lastLoginCount < 0 {
+		index, err := readLastLoginIndex(ctx, b.storage)
+		if err != nil {
+			return err
+		}
+		b.lastLoginCount = len(index)
+	} else {
+		b.lastLoginCount++
+	}
+	if b.lastLoginCount > maxEntries {
+		return b.pruneLastLogins(ctx, maxEntries-maxEntries/10)
+	}
+	return nil
+}
+
+// pruneLastLogins drops the summaries of the principals that logged in least
+// recently until keep remain. Callers hold lastLoginLock.
+func (b *gmsaBackend) pruneLastLogins(ctx context.Context, keep int) error {
+	// Recount on the next new principal if pruning fails part way
+	b.lastLoginCount = -1
+	index, err := readLastLoginIndex(ctx, b.storage)
+	if err != nil {
+		return err
+	}
+	if len(index) <= keep {
+		b.lastLoginCount = len(index)
+		return nil
+	}
+	principals := make([]string, 0, len(index))
+	for p := range index {
+		principals = append(principals, p)
+	}
+	sort.Slice(principals, func(i, j int) bool { return index[principals[i]].Before(index[principals[j]]) })
+
+	shards := make(map[string]map[string]time.Time)
+	for _, p := range principals[:len(principals)-keep] {
+		if err := b.storage.Delete(ctx, lastLoginKey(p)); err != nil {
+			return err
+		}
+		delete(index, p)
+		shards[lastLoginIndexKey(p)] = make(map[string]time.Time)
+	}
+	for p, t := range index {
+		if shard, ok := shards[lastLoginIndexKey(p)]; ok {
+			shard[p] = t
+		}
+	}
+	for key, shard := range shards {
+		if err := writeLastLoginIndexShard(ctx, b.storage, key, shard); err != nil {
+			return err
+		}
+	}
+	b.lastLoginCount = len(index)
+	return nil
+}
+
+// recordLastLogin stores the PAC summary of a successful login when
+// last_login is enabled, without the fields listed in last_login_redact.
+// Failures are logged, never returned: the summary is a support aid, not a
+// login dependency.
+func (b *gmsaBackend) recordLastLogin(ctx context.Context, req *logical.Request, cfg *Config, role *Role, res *kerb.ValidationResult) {
+	if !cfg.LastLogin || b.storage == nil {
+		return
+	}
+	summary := &lastLoginSummary{
+		Principal:   normalizePrincipal(res.Principal, cfg.Normalization),
+		Realm:       res.Realm,
+		Role:        role.Name,
+		Time:        b.now().UTC(),
+		UserSID:     res.UserSID,
+		UPN:         res.UPN,
+		GroupSIDs:   res.GroupSIDs,
+		LogonServer: res.LogonServer,
+		Flags:       res.Flags,
+	}
+	if req.Connection != nil {
+		summary.ClientIP = req.Connection.RemoteAddr
+	}
+	for _, field := range cfg.LastLoginRedact {
+		switch field {
+		case "user_sid":
+			summary.UserSID = ""
+		case "upn":
+			summary.UPN = ""
+		case "group_sids":
+			summary.GroupSIDs = nil
+		case "logon_server":
+			summary.LogonServer = ""
+		case "client_ip":
+			summary.ClientIP = ""
+		}
+	}
+	if err := b.storeLastLogin(ctx, summary, cfg.lastLoginMaxEntries()); err != nil {
+		b.logger.Error("failed to store last login summary", "principal", summary.Principal, "error", err)
+	}
+}
+
+// validateLastLoginRedact checks last_login_redact names summary fields
+func validateLastLoginRedact(fields []string) error {
+	for _, field := range fields {
+		if !slices.Contains(lastLoginRedactable, field) {
+			return fmt.Errorf("last_login_redact: unknown field %q (valid: %v)", field, lastLoginRedactable)
+		}
+	}
+	return nil
+}
+
+func (b *gmsaBackend) handleLastLoginRead(ctx context.Context, _ *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	cfg, err := b.loadConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	norm := getDefaultNormalizationConfig()
+	if cfg != nil {
+		norm = cfg.Normalization
+	}
+	principal := normalizePrincipal(d.Get("principal").(string), norm)
+
+	summary, err := readLastLogin(ctx, b.storage, principal)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read last login: %w", err)
+	}
+	if summary == nil {
+		return logical.ErrorResponse(fmt.Sprintf("no login recorded for %q", principal)), nil
+	}
+	return &logical.Response{Data: map[string]interface{}{
+		"principal":    summary.Principal,
+		"realm":        summary.Realm,
+		"role":         summary.Role,
+		"time":         summary.Time.Format(time.RFC3339),
+		"user_sid":     summary.UserSID,
+		"upn":          summary.UPN,
+		"group_sids":   summary.GroupSIDs,
+		"logon_server": summary.LogonServer,
+		"client_ip":    summary.ClientIP,
+		"flags":        summary.Flags,
+	}}, nil
+}
@@ -0,0 +1,193 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestHandleLogin_LastLogin(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	kt := newTestLoginConfig(t, storage)
+	if err := writeRole(ctx, storage, &Role{Name: "app", TokenPolicies: []string{"app"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	login := func() {
+		t.Helper()
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation:  logical.UpdateOperation,
+			Path:       "login",
+			Storage:    storage,
+			Data:       map[string]interface{}{"role": "app", "spnego": newTestLoginSPNEGO(t, kt)},
+			Connection: &logical.Connection{RemoteAddr: "127.0.0.1"},
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("login failed: err=%v resp=%#v", err, resp)
+		}
+	}
+	readLast := func(principal string) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "login/last/" + principal,
+			Storage:   storage,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return resp
+	}
+
+	// Nothing is stored while last_login is off
+	login()
+	if resp := readLast("user@EXAMPLE.COM"); resp == nil || !resp.IsError() {
+		t.Fatalf("expected no summary while disabled, got %#v", resp)
+	}
+
+	cfg, err := readConfig(ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.LastLogin = true
+	cfg.LastLoginRedact = []string{"client_ip"}
+	if err := writeConfig(ctx, storage, cfg); err != nil {
+		t.Fatal(err)
+	}
+	login()
+
+	// The realm is matched case-insensitively like other principal lookups
+	resp := readLast("user@example.com")
+	if resp == nil || resp.IsError() {
+		t.Fatalf("expected a summary, got %#v", resp)
+	}
+	if resp.Data["principal"] != "user@EXAMPLE.COM" || resp.Data["role"] != "app" || resp.Data["realm"] != "EXAMPLE.COM" {
+		t.Errorf("summary = %v", resp.Data)
+	}
+	if flags, _ := resp.Data["flags"].(map[string]bool); !flags["PAC_NOT_FOUND"] {
+		t.Errorf("flags = %v, want the validator's PAC flags", resp.Data["flags"])
+	}
+	if resp.Data["client_ip"] != "" {
+		t.Errorf("client_ip = %v, want it redacted", resp.Data["client_ip"])
+	}
+}
+
+func TestStoreLastLogin_BoundsEntries(t *testing.T) {
+	b, _ := getTestBackend(t)
+	ctx := context.Background()
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 4; i++ {
+		summary := &lastLoginSummary{Principal: fmt.Sprintf("svc%d$@EXAMPLE.COM", i), Time: start.Add(time.Duration(i) * time.Minute)}
+		if err := b.storeLastLogin(ctx, summary, 3); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A repeat login refreshes svc1, so svc2 is the next to go
+	if err := b.storeLastLogin(ctx, &lastLoginSummary{Principal: "svc1$@EXAMPLE.COM", Time: start.Add(time.Hour)}, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.storeLastLogin(ctx, &lastLoginSummary{Principal: "svc4$@EXAMPLE.COM", Time: start.Add(2 * time.Hour)}, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, want := range []bool{false, true, false, true, true} {
+		principal := fmt.Sprintf("svc%d$@EXAMPLE.COM", i)
+		summary, err := readLastLogin(ctx, b.storage, principal)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (summary != nil) != want {
+			t.Errorf("%s stored = %t, want %t", principal, summary != nil, want)
+		}
+	}
+	index, err := readLastLoginIndex(ctx, b.storage)
+	if err != nil || len(index) != 3 {
+		t.Errorf("index = %v, %v; want 3 entries", index, err)
+	}
+}
+
+func TestStoreLastLogin_IndexWrites(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	principal := "svc$@EXAMPLE.COM"
+
+	store := func(at time.Time) {
+		t.Helper()
+		if err := b.storeLastLogin(ctx, &lastLoginSummary{Principal: principal, Time: at}, 10); err != nil {
+			t.Fatal(err)
+		}
+	}
+	indexed := func() time.Time {
+		t.Helper()
+		shard, err := readLastLoginIndexShard(ctx, storage, lastLoginIndexKey(principal))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return shard[principal]
+	}
+
+	// A repeat login soon after leaves the index alone but replaces the summary
+	store(start)
+	store(start.Add(time.Minute))
+	if got := indexed(); !got.Equal(start) {
+		t.Errorf("indexed time = %v, want %v", got, start)
+	}
+	if summary, err := readLastLogin(ctx, storage, principal); err != nil || summary == nil || !summary.Time.Equal(start.Add(time.Minute)) {
+		t.Errorf("summary = %+v, %v; want the repeat login", summary, err)
+	}
+	store(start.Add(lastLoginIndexRefresh))
+	if got := indexed(); !got.Equal(start.Add(lastLoginIndexRefresh)) {
+		t.Errorf("indexed time = %v, want it refreshed", got)
+	}
+}
+
+func TestStoreLastLogin_PrunesInBatches(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 21; i++ {
+		summary := &lastLoginSummary{Principal: fmt.Sprintf("svc%d$@EXAMPLE.COM", i), Time: start.Add(time.Duration(i) * time.Minute)}
+		if err := b.storeLastLogin(ctx, summary, 20); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Passing the bound drops the oldest down to nine tenths of it
+	index, err := readLastLoginIndex(ctx, storage)
+	if err != nil || len(index) != 18 || b.lastLoginCount != 18 {
+		t.Fatalf("index = %d entries (counted %d), %v; want 18", len(index), b.lastLoginCount, err)
+	}
+	for i := 0; i < 21; i++ {
+		principal := fmt.Sprintf("svc%d$@EXAMPLE.COM", i)
+		summary, err := readLastLogin(ctx, storage, principal)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := i >= 3; (summary != nil) != want {
+			t.Errorf("%s stored = %t, want %t", principal, summary != nil, want)
+		}
+	}
+
+	// The index is split into shards rather than held in one entry
+	keys, err := storage.List(ctx, storageKeyLastLoginIndex)
+	if err != nil || len(keys) < 2 {
+		t.Errorf("index shards = %v, %v; want several", keys, err)
+	}
+}
+
+func TestValidateLastLoginRedact(t *testing.T) {
+	if err := validateLastLoginRedact([]string{"upn", "group_sids"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := validateLastLoginRedact([]string{"password"}); err == nil || !strings.Contains(err.Error(), "password") {
+		t.Errorf("error = %v, want the unknown field named", err)
+	}
+}
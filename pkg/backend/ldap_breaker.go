@@ -0,0 +1,96 @@
+package backend
+
+import "time"
+
+const (
+	ldapBreakerThreshold = 3               // Consecutive failures before the breaker opens
+	ldapBreakerBaseDelay = 5 * time.Minute // First back-off once open
+	ldapBreakerMaxDelay  = 6 * time.Hour   // Cap on the back-off between probes
+)
+
+// Breaker states reported in rotation/status
+const (
+	breakerClosed   = "closed"    // Queries run every check
+	breakerOpen     = "open"      // Queries are skipped until the back-off ends
+	breakerHalfOpen = "half_open" // The back-off ended; the next query probes the DC
+)
+
+// BreakerStatus is the circuit breaker state reported with rotation status
+type BreakerStatus struct {
+	State               string    `json:"state"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	RetryAt             time.Time `json:"retry_at"` // When the next probe is allowed while open
+}
+
+// ldapBreaker stops rotation from querying an unreachable domain controller
+// on every check. After ldapBreakerThreshold consecutive failures it opens
+// and skips queries for an exponentially growing back-off; the first query
+// after the back-off probes the DC and a success closes it again. The zero
+// value uses the ldapBreaker* defaults; other callers set their own.
+// Callers serialize access.
+type ldapBreaker struct {
+	threshold int           // 0 = ldapBreakerThreshold
+	baseDelay time.Duration // 0 = ldapBreakerBaseDelay
+	maxDelay  time.Duration // 0 = ldapBreakerMaxDelay
+	failures  int
+	openUntil time.Time
+}
+
+// settings returns the threshold and back-off bounds in effect
+func (cb *ldapBreaker) settings() (int, time.Duration, time.Duration) {
+	threshold, base, maxDelay := cb.threshold, cb.baseDelay, cb.maxDelay
+	if threshold == 0 {
+		threshold = ldapBreakerThreshold
+	}
+	if base == 0 {
+		base = ldapBreakerBaseDelay
+	}
+	if maxDelay == 0 {
+		maxDelay = ldapBreakerMaxDelay
+	}
+	return threshold, base, maxDelay
+}
+
+// allow reports whether a query may run at now
+func (cb *ldapBreaker) allow(now time.Time) bool {
+	return !now.Before(cb.openUntil)
+}
+
+// success records a successful query and closes the breaker
+func (cb *ldapBreaker) success() {
+	cb.failures = 0
+	cb.openUntil = time.Time{}
+}
+
+// failure records a failed query at now and reports whether the breaker is
+// (still) open as a result
+func (cb *ldapBreaker) failure(now time.Time) bool {
+	threshold, delay, maxDelay := cb.settings()
+	cb.failures++
+	if cb.failures < threshold {
+		return false
+	}
+	for i := threshold; i < cb.failures && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	cb.openUntil = now.Add(delay)
+	return true
+}
+
+// status returns the breaker state at now
+func (cb *ldapBreaker) status(now time.Time) BreakerStatus {
+	threshold, _, _ := cb.settings()
+	s := BreakerStatus{State: breakerClosed, ConsecutiveFailures: cb.failures}
+	switch {
+	case cb.failures < threshold:
+	case now.Before(cb.openUntil):
+		s.State = breakerOpen
+		s.RetryAt = cb.openUntil
+	default:
+		s.State = breakerHalfOpen
+	}
+	return s
+}
@@ -0,0 +1,108 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestLDAPBreaker_OutageAndRecovery(t *testing.T) {
+	var cb ldapBreaker
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Failures below the threshold keep querying every check
+	for i := 1; i < ldapBreakerThreshold; i++ {
+		if cb.failure(now) {
+			t.Fatalf("breaker opened after %d failures", i)
+		}
+		if !cb.allow(now) || cb.status(now).State != breakerClosed {
+			t.Fatalf("breaker not closed after %d failures", i)
+		}
+	}
+
+	// The DC stays down: the breaker opens and each failed probe doubles
+	// the back-off
+	delay := ldapBreakerBaseDelay
+	for probe := 0; probe < 3; probe++ {
+		if !cb.failure(now) {
+			t.Fatal("breaker did not open")
+		}
+		s := cb.status(now)
+		if s.State != breakerOpen || !s.RetryAt.Equal(now.Add(delay)) {
+			t.Fatalf("probe %d: status = %+v, want open until %v", probe, s, now.Add(delay))
+		}
+		if cb.allow(now.Add(delay - time.Second)) {
+			t.Fatalf("probe %d: query allowed before the back-off ended", probe)
+		}
+		now = now.Add(delay)
+		if !cb.allow(now) || cb.status(now).State != breakerHalfOpen {
+			t.Fatalf("probe %d: breaker not half-open after the back-off", probe)
+		}
+		delay *= 2
+	}
+
+	// The DC recovers: one successful probe closes the breaker
+	cb.success()
+	if s := cb.status(now); s.State != breakerClosed || s.ConsecutiveFailures != 0 || !cb.allow(now) {
+		t.Errorf("status after recovery = %+v", s)
+	}
+}
+
+func TestLDAPBreaker_CustomSettings(t *testing.T) {
+	cb := ldapBreaker{threshold: 1, baseDelay: time.Second, maxDelay: 3 * time.Second}
+	now := time.Now()
+	for _, want := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second} {
+		if !cb.failure(now) {
+			t.Fatal("breaker did not open at its threshold")
+		}
+		if s := cb.status(now); s.State != breakerOpen || s.RetryAt.Sub(now) != want {
+			t.Errorf("status = %+v, want open for %v", s, want)
+		}
+	}
+}
+
+func TestLDAPBreaker_BackoffCapped(t *testing.T) {
+	var cb ldapBreaker
+	now := time.Now()
+	for i := 0; i < 50; i++ {
+		cb.failure(now)
+	}
+	if got := cb.status(now).RetryAt.Sub(now); got != ldapBreakerMaxDelay {
+		t.Errorf("back-off = %v, want cap %v", got, ldapBreakerMaxDelay)
+	}
+}
+
+func TestRotationManager_DegradedOnDCOutage(t *testing.T) {
+	b, storage := getTestBackend(t)
+	rm := NewRotationManager(b, &RotationConfig{})
+	b.rotationManager = rm
+
+	outage := errors.New("failed to get password info: dc1.example.com unreachable")
+	for i := 1; i < ldapBreakerThreshold; i++ {
+		rm.handleQueryError(outage)
+		if s := rm.GetStatus(); s.Status != "error" {
+			t.Fatalf("status after %d failures = %q, want error", i, s.Status)
+		}
+	}
+	rm.handleQueryError(outage)
+	s := rm.GetStatus()
+	if s.Status != "degraded" || s.LastError != outage.Error() || s.LDAPBreaker.State != breakerOpen {
+		t.Fatalf("status after outage = %+v, want degraded with open breaker", s)
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "rotation/status",
+		Storage:   storage,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("rotation/status failed: err=%v resp=%#v", err, resp)
+	}
+	breaker, ok := resp.Data["ldap_breaker"].(map[string]interface{})
+	if !ok || breaker["state"] != breakerOpen || breaker["consecutive_failures"] != ldapBreakerThreshold {
+		t.Errorf("ldap_breaker = %#v", resp.Data["ldap_breaker"])
+	}
+}
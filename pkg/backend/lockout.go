@@ -0,0 +1,119 @@
+package backend
+
+import (
+	"sync"
+	"time"
+)
+
+// principalLockout tracks consecutive authorization failures per principal
+// and temporarily locks principals that exceed the configured threshold.
+// State is in-memory only; it resets when the plugin restarts.
+type principalLockout struct {
+	mu      sync.Mutex
+	entries map[string]*lockoutEntry
+}
+
+// lockoutEntry is the failure state for a single principal
+type lockoutEntry struct {
+	failures    int
+	lockedUntil time.Time
+}
+
+// maxLockoutEntries bounds memory use when many distinct principals fail
+const maxLockoutEntries = 10000
+
+func newPrincipalLockout() *principalLockout {
+	return &principalLockout{entries: map[string]*lockoutEntry{}}
+}
+
+// lockedUntil returns the lockout expiry for a principal, or the zero time if
+// the principal is not currently locked out.
+func (l *principalLockout) lockedUntil(principal string, now time.Time) time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, ok := l.entries[principal]
+	if !ok || e.lockedUntil.IsZero() {
+		return time.Time{}
+	}
+	if !now.Before(e.lockedUntil) {
+		// Lockout window elapsed; start counting from scratch
+		delete(l.entries, principal)
+		return time.Time{}
+	}
+	return e.lockedUntil
+}
+
+// recordFailure counts a failure for the principal and reports whether this
+// failure triggered a new lockout.
+func (l *principalLockout) recordFailure(principal string, threshold int, duration time.Duration, now time.Time) bool {
+	if threshold <= 0 || principal == "" {
+		return false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, ok := l.entries[principal]
+	if !ok {
+		if len(l.entries) >= maxLockoutEntries {
+			l.pruneLocked(now)
+		}
+		e = &lockoutEntry{}
+		l.entries[principal] = e
+	}
+	e.failures++
+	if e.failures >= threshold && e.lockedUntil.IsZero() {
+		e.lockedUntil = now.Add(duration)
+		return true
+	}
+	return false
+}
+
+// reset clears any failure state for the principal after a successful login
+func (l *principalLockout) reset(principal string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.entries, principal)
+}
+
+// pruneLocked drops expired lockouts and unlocked failure counters. Callers
+// must hold l.mu.
+func (l *principalLockout) pruneLocked(now time.Time) {
+	for p, e := range l.entries {
+		if e.lockedUntil.IsZero() || !now.Before(e.lockedUntil) {
+			delete(l.entries, p)
+		}
+	}
+}
+
+// principalLockedUntil returns when the principal's lockout expires, or the
+// zero time if it is not locked out.
+func (b *gmsaBackend) principalLockedUntil(principal string) time.Time {
+	if b.lockout == nil {
+		return time.Time{}
+	}
+	return b.lockout.lockedUntil(principal, b.now())
+}
+
+// recordPrincipalFailure counts an authorization failure against the principal
+// and locks it out once the configured threshold is reached.
+func (b *gmsaBackend) recordPrincipalFailure(cfg *Config, principal string) {
+	if b.lockout == nil || cfg.PrincipalLockoutThreshold <= 0 {
+		return
+	}
+	duration := time.Duration(cfg.PrincipalLockoutDurationSec) * time.Second
+	if b.lockout.recordFailure(principal, cfg.PrincipalLockoutThreshold, duration, b.now()) {
+		principalLockouts.Add(1)
+		b.logger.Warn("principal locked out after repeated failures",
+			"principal", principal, "threshold", cfg.PrincipalLockoutThreshold, "duration", duration)
+	}
+}
+
+// resetPrincipalFailures clears failure state after a successful login
+func (b *gmsaBackend) resetPrincipalFailures(principal string) {
+	if b.lockout == nil {
+		return
+	}
+	b.lockout.reset(principal)
+}
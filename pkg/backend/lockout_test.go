@@ -0,0 +1,87 @@
+package backend
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+func TestPrincipalLockout_TriggerAndWindow(t *testing.T) {
+	l := newPrincipalLockout()
+	now := time.Unix(1700000000, 0)
+	p := "svc@EXAMPLE.COM"
+
+	if l.recordFailure(p, 3, time.Minute, now) || l.recordFailure(p, 3, time.Minute, now) {
+		t.Fatal("lockout triggered before threshold")
+	}
+	if !l.lockedUntil(p, now).IsZero() {
+		t.Fatal("principal locked before threshold")
+	}
+	if !l.recordFailure(p, 3, time.Minute, now) {
+		t.Fatal("expected third failure to trigger lockout")
+	}
+
+	// Rejected for the whole window
+	if got := l.lockedUntil(p, now.Add(59*time.Second)); !got.Equal(now.Add(time.Minute)) {
+		t.Errorf("lockedUntil inside window = %v, want %v", got, now.Add(time.Minute))
+	}
+	// Further failures during the window don't extend it
+	if l.recordFailure(p, 3, time.Minute, now.Add(30*time.Second)) {
+		t.Error("failure during lockout should not re-trigger")
+	}
+
+	// Released once the window elapses, with a fresh counter
+	if !l.lockedUntil(p, now.Add(time.Minute)).IsZero() {
+		t.Error("expected lockout to expire at end of window")
+	}
+	if l.recordFailure(p, 3, time.Minute, now.Add(time.Minute)) {
+		t.Error("counter should restart after lockout expiry")
+	}
+
+	// Other principals are unaffected
+	if !l.lockedUntil("other@EXAMPLE.COM", now).IsZero() {
+		t.Error("unrelated principal should not be locked")
+	}
+}
+
+func TestPrincipalLockout_ResetOnSuccess(t *testing.T) {
+	l := newPrincipalLockout()
+	now := time.Unix(1700000000, 0)
+	p := "svc@EXAMPLE.COM"
+
+	l.recordFailure(p, 2, time.Minute, now)
+	l.reset(p)
+	if l.recordFailure(p, 2, time.Minute, now) {
+		t.Error("failure count should have been reset by success")
+	}
+}
+
+func TestRecordPrincipalFailure_Threshold(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	b := &gmsaBackend{
+		logger:  hclog.NewNullLogger(),
+		now:     func() time.Time { return now },
+		lockout: newPrincipalLockout(),
+	}
+	cfg := &Config{}
+
+	for i := 0; i < 10; i++ {
+		b.recordPrincipalFailure(cfg, "svc@EXAMPLE.COM")
+	}
+	if !b.principalLockedUntil("svc@EXAMPLE.COM").IsZero() {
+		t.Error("lockout should be disabled with a zero threshold")
+	}
+
+	cfg.PrincipalLockoutThreshold = 2
+	cfg.PrincipalLockoutDurationSec = 60
+	before := principalLockouts.Value()
+	b.recordPrincipalFailure(cfg, "svc@EXAMPLE.COM")
+	b.recordPrincipalFailure(cfg, "svc@EXAMPLE.COM")
+	if b.principalLockedUntil("svc@EXAMPLE.COM").IsZero() {
+		t.Error("expected principal to be locked out")
+	}
+	if principalLockouts.Value() != before+1 {
+		t.Errorf("principal_lockouts = %d, want %d", principalLockouts.Value(), before+1)
+	}
+}
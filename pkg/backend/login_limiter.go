@@ -0,0 +1,55 @@
+package backend
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// loginQueueWait is how long a login waits for a free validation slot before
+// it is rejected as busy
+const loginQueueWait = 250 * time.Millisecond
+
+// loginLimiter bounds concurrent Kerberos validations. The limit comes from
+// config on every login, so the slot channel is replaced when it changes;
+// holders of the old channel release into it and drain naturally.
+// The zero value is ready to use.
+type loginLimiter struct {
+	mu    sync.Mutex
+	slots chan struct{}
+}
+
+// acquire takes a validation slot, waiting up to loginQueueWait or until ctx
+// is done, whichever comes first. It reports false when no slot was free.
+// The returned release must be called once the validation finishes. A limit
+// of 0 or less never blocks.
+func (l *loginLimiter) acquire(ctx context.Context, limit int) (release func(), ok bool) {
+	if limit <= 0 {
+		return func() {}, true
+	}
+
+	l.mu.Lock()
+	if cap(l.slots) != limit {
+		l.slots = make(chan struct{}, limit)
+	}
+	slots := l.slots
+	l.mu.Unlock()
+	release = func() { <-slots }
+
+	select {
+	case slots <- struct{}{}:
+		return release, true
+	default:
+	}
+
+	// Queue briefly; waiting counts against the login timeout in ctx
+	timer := time.NewTimer(loginQueueWait)
+	defer timer.Stop()
+	select {
+	case slots <- struct{}{}:
+		return release, true
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	return nil, false
+}
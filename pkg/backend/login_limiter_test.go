@@ -0,0 +1,154 @@
+package backend
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestLoginLimiter_Concurrency(t *testing.T) {
+	var l loginLimiter
+	const limit = 3
+
+	var inFlight, peak atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, ok := l.acquire(context.Background(), limit)
+			if !ok {
+				return
+			}
+			n := inFlight.Add(1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(2 * time.Millisecond)
+			inFlight.Add(-1)
+			release()
+		}()
+	}
+	wg.Wait()
+	if got := peak.Load(); got > limit {
+		t.Errorf("peak concurrent validations = %d, want at most %d", got, limit)
+	}
+
+	// With every slot held, excess callers give up once queuing expires
+	var releases []func()
+	for i := 0; i < limit; i++ {
+		release, ok := l.acquire(context.Background(), limit)
+		if !ok {
+			t.Fatal("expected a free slot")
+		}
+		releases = append(releases, release)
+	}
+	var busy atomic.Int32
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, ok := l.acquire(context.Background(), limit); !ok {
+				busy.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := busy.Load(); got != 5 {
+		t.Errorf("busy = %d, want 5", got)
+	}
+	for _, release := range releases {
+		release()
+	}
+	if _, ok := l.acquire(context.Background(), limit); !ok {
+		t.Error("released slots should be reusable")
+	}
+}
+
+func TestLoginLimiter_ContextDeadline(t *testing.T) {
+	var l loginLimiter
+	if _, ok := l.acquire(context.Background(), 1); !ok {
+		t.Fatal("expected a free slot")
+	}
+
+	// The login timeout bounds queuing even when it is shorter than the wait
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, ok := l.acquire(ctx, 1); ok {
+		t.Fatal("expected busy")
+	}
+	if waited := time.Since(start); waited >= loginQueueWait {
+		t.Errorf("waited %v, want less than %v", waited, loginQueueWait)
+	}
+
+	// A slot freed while queued is handed to the waiter
+	l2 := loginLimiter{}
+	release, _ := l2.acquire(context.Background(), 1)
+	time.AfterFunc(10*time.Millisecond, release)
+	if _, ok := l2.acquire(context.Background(), 1); !ok {
+		t.Error("queued acquire should succeed once a slot is released")
+	}
+
+	if _, ok := l.acquire(ctx, 0); !ok {
+		t.Error("a zero limit should never block")
+	}
+}
+
+func TestHandleLogin_MaxConcurrentLogins(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	kt := newTestLoginConfig(t, storage)
+	if err := writeRole(ctx, storage, &Role{Name: "app", TokenPolicies: []string{"app"}}); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := readConfig(ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.MaxConcurrentLogins = 1
+	if err := writeConfig(ctx, storage, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	login := func() *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation:  logical.UpdateOperation,
+			Path:       "login",
+			Storage:    storage,
+			Data:       map[string]interface{}{"role": "app", "spnego": newTestLoginSPNEGO(t, kt)},
+			Connection: &logical.Connection{RemoteAddr: "127.0.0.1"},
+		})
+		if err != nil || resp == nil {
+			t.Fatalf("unexpected result: err=%v resp=%#v", err, resp)
+		}
+		return resp
+	}
+
+	// Occupy the only slot as an in-flight validation would
+	release, ok := b.loginLimiter.acquire(ctx, 1)
+	if !ok {
+		t.Fatal("expected a free slot")
+	}
+	before := failureReasonCount(failureReasonBusy)
+	resp := login()
+	if !resp.IsError() || loginErrorCode(resp) != errorCodeBusy {
+		t.Fatalf("expected busy error, got %#v", resp)
+	}
+	if got := failureReasonCount(failureReasonBusy); got != before+1 {
+		t.Errorf("busy failures = %d, want %d", got, before+1)
+	}
+
+	release()
+	if resp := login(); resp.IsError() || resp.Auth == nil {
+		t.Fatalf("login after release failed: %#v", resp)
+	}
+}
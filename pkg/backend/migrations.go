@@ -0,0 +1,150 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// Current schema versions of stored config and role entries. When a new
+// field needs a non-zero default on entries written before it existed, bump
+// the version and append a step to the matching migrations list.
+//
+// readConfig and readRole migrate entries in memory. loadConfig and loadRole
+// also rewrite a migrated entry, re-reading it under configLock or roleLock
+// first so a write that landed after the unlocked read is never clobbered.
+const (
+	configSchemaVersion = 1
+	roleSchemaVersion   = 1
+)
+
+// configMigrations[v] upgrades a stored config from schema version v to v+1.
+// present holds the top-level JSON keys of the stored entry, so a step can
+// tell a field missing from an old entry from one explicitly set to zero.
+var configMigrations = []func(c *Config, present map[string]bool){
+	// v0 -> v1: entries written before schema_version existed
+	func(c *Config, present map[string]bool) {
+		if !present["clock_skew_sec"] {
+			c.ClockSkewSec = 300
+		}
+	},
+}
+
+// roleMigrations[v] upgrades a stored role from schema version v to v+1
+var roleMigrations = []func(r *Role, present map[string]bool){
+	// v0 -> v1: entries written before schema_version existed
+	func(r *Role, present map[string]bool) {
+		if r.MergeStrategy == "" {
+			r.MergeStrategy = mergeStrategyOrDefault(nil)
+		}
+	},
+}
+
+// storedKeys returns the top-level JSON keys of a storage entry
+func storedKeys(entry *logical.StorageEntry) (map[string]bool, error) {
+	var raw map[string]json.RawMessage
+	if err := entry.DecodeJSON(&raw); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]bool, len(raw))
+	for k := range raw {
+		keys[k] = true
+	}
+	return keys, nil
+}
+
+// migrateConfig upgrades cfg, decoded from entry, to the current schema
+// version in memory and reports whether it did. Entries from a newer plugin
+// version are left alone.
+func migrateConfig(entry *logical.StorageEntry, cfg *Config) (bool, error) {
+	if cfg.SchemaVersion >= configSchemaVersion || cfg.SchemaVersion < 0 {
+		return false, nil
+	}
+	present, err := storedKeys(entry)
+	if err != nil {
+		return false, err
+	}
+	for v := cfg.SchemaVersion; v < configSchemaVersion; v++ {
+		configMigrations[v](cfg, present)
+	}
+	cfg.SchemaVersion = configSchemaVersion
+	return true, nil
+}
+
+// migrateRole upgrades r, decoded from entry, to the current schema version
+// in memory and reports whether it did
+func migrateRole(entry *logical.StorageEntry, r *Role) (bool, error) {
+	if r.SchemaVersion >= roleSchemaVersion || r.SchemaVersion < 0 {
+		return false, nil
+	}
+	present, err := storedKeys(entry)
+	if err != nil {
+		return false, err
+	}
+	for v := r.SchemaVersion; v < roleSchemaVersion; v++ {
+		roleMigrations[v](r, present)
+	}
+	r.SchemaVersion = roleSchemaVersion
+	return true, nil
+}
+
+// loadConfig reads the config and rewrites it in storage if it was stored at
+// an older schema version. Callers must not hold configLock.
+func (b *gmsaBackend) loadConfig(ctx context.Context) (*Config, error) {
+	cfg, migrated, err := readConfigEntry(ctx, b.storage)
+	if err == nil && migrated {
+		b.persistConfigMigration(ctx)
+	}
+	return cfg, err
+}
+
+// persistConfigMigration rewrites the config if the stored entry is still at
+// an older schema version
+func (b *gmsaBackend) persistConfigMigration(ctx context.Context) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	cfg, migrated, err := readConfigEntry(ctx, b.storage)
+	if err == nil && migrated {
+		err = writeConfig(ctx, b.storage, cfg)
+	}
+	b.logMigrationError(storageKeyConfig, err)
+}
+
+// loadRole reads a role and rewrites it in storage if it was stored at an
+// older schema version. Callers must not hold roleLock.
+func (b *gmsaBackend) loadRole(ctx context.Context, name string) (*Role, error) {
+	r, migrated, err := readRoleEntry(ctx, b.storage, name)
+	if err == nil && migrated {
+		b.persistRoleMigration(ctx, name)
+	}
+	return r, err
+}
+
+// persistRoleMigration rewrites a role if the stored entry is still at an
+// older schema version
+func (b *gmsaBackend) persistRoleMigration(ctx context.Context, name string) {
+	b.roleLock.Lock()
+	defer b.roleLock.Unlock()
+	r, migrated, err := readRoleEntry(ctx, b.storage, name)
+	if err == nil && migrated {
+		// The entry is stored under name, whatever name it carries
+		r.Name = name
+		err = writeRole(ctx, b.storage, r)
+	}
+	b.logMigrationError(storageKeyRole+"/"+name, err)
+}
+
+// logMigrationError reports a failed rewrite without failing the read, which
+// already has the migrated entry. Performance standbys can't write and leave
+// the rewrite to the active node.
+func (b *gmsaBackend) logMigrationError(key string, err error) {
+	switch {
+	case err == nil:
+	case errors.Is(err, logical.ErrReadOnly):
+		b.logger.Debug("migrated entry not rewritten on a read-only node", "key", key)
+	default:
+		b.logger.Warn("failed to rewrite migrated entry", "key", key, "error", err)
+	}
+}
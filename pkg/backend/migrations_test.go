@@ -0,0 +1,227 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// putRaw stores a JSON document as written by an older plugin version
+func putRaw(t *testing.T, s logical.Storage, key, doc string) {
+	t.Helper()
+	if err := s.Put(context.Background(), &logical.StorageEntry{Key: key, Value: []byte(doc)}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// storedSchemaVersion returns the schema_version of the raw stored entry
+func storedSchemaVersion(t *testing.T, s logical.Storage, key string) int {
+	t.Helper()
+	entry, err := s.Get(context.Background(), key)
+	if err != nil || entry == nil {
+		t.Fatalf("read %s: %v", key, err)
+	}
+	var doc struct {
+		SchemaVersion int `json:"schema_version"`
+	}
+	if err := json.Unmarshal(entry.Value, &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc.SchemaVersion
+}
+
+// readOnlyStorage rejects writes like a performance standby
+type readOnlyStorage struct{ logical.Storage }
+
+func (readOnlyStorage) Put(context.Context, *logical.StorageEntry) error { return logical.ErrReadOnly }
+
+func TestReadConfig_MigratesV0(t *testing.T) {
+	ctx := context.Background()
+	s := newMemStorage()
+	putRaw(t, s, storageKeyConfig, `{"realm":"EXAMPLE.COM","kdcs":["dc1.example.com"],"spn":"HTTP/vault.example.com","keytab":"a2V5"}`)
+
+	cfg, err := readConfig(ctx, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.SchemaVersion != configSchemaVersion || cfg.ClockSkewSec != 300 || cfg.Realm != "EXAMPLE.COM" {
+		t.Errorf("migrated config = version %d, clock_skew_sec %d, realm %q; want version %d, 300, EXAMPLE.COM",
+			cfg.SchemaVersion, cfg.ClockSkewSec, cfg.Realm, configSchemaVersion)
+	}
+	if v := storedSchemaVersion(t, s, storageKeyConfig); v != 0 {
+		t.Errorf("stored schema_version = %d, want reads to leave the entry as stored", v)
+	}
+
+	// The next write stores the migrated entry
+	if err := writeConfig(ctx, s, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if v := storedSchemaVersion(t, s, storageKeyConfig); v != configSchemaVersion {
+		t.Errorf("stored schema_version = %d after a write, want %d", v, configSchemaVersion)
+	}
+
+	// An explicitly stored zero is kept
+	putRaw(t, s, storageKeyConfig, `{"realm":"EXAMPLE.COM","clock_skew_sec":0}`)
+	if cfg, err := readConfig(ctx, s); err != nil || cfg.ClockSkewSec != 0 {
+		t.Errorf("explicit clock_skew_sec 0 migrated to %+v, %v", cfg, err)
+	}
+}
+
+func TestReadRole_MigratesV0(t *testing.T) {
+	ctx := context.Background()
+	s := newMemStorage()
+	putRaw(t, s, storageKeyRole+"/app", `{"name":"app","token_policies":["app"],"token_type":"default"}`)
+
+	r, err := readRole(ctx, s, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.SchemaVersion != roleSchemaVersion || r.MergeStrategy != "union" || len(r.TokenPolicies) != 1 {
+		t.Errorf("migrated role = %+v, want version %d with merge_strategy union", r, roleSchemaVersion)
+	}
+	// "default" is a valid token type and is kept as stored
+	if r.TokenType != "default" {
+		t.Errorf("migrated token_type = %q, want default", r.TokenType)
+	}
+	if v := storedSchemaVersion(t, s, storageKeyRole+"/app"); v != 0 {
+		t.Errorf("stored schema_version = %d, want reads to leave the entry as stored", v)
+	}
+
+	// An explicit service token type is kept
+	putRaw(t, s, storageKeyRole+"/svc", `{"name":"svc","token_type":"service"}`)
+	if r, err := readRole(ctx, s, "svc"); err != nil || r.TokenType != "service" {
+		t.Errorf("service role migrated to %+v, %v", r, err)
+	}
+}
+
+func TestRoleWrite_PersistsMigration(t *testing.T) {
+	b, s := getTestBackend(t)
+	ctx := context.Background()
+	putRaw(t, s, storageKeyRole+"/app", `{"name":"app","token_policies":["app"],"token_type":"default"}`)
+
+	// Toggling disabled is a read-modify-write under the role lock
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/app",
+		Storage:   s,
+		Data:      map[string]interface{}{"disabled": true},
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("role write failed: err=%v resp=%#v", err, resp)
+	}
+	if v := storedSchemaVersion(t, s, storageKeyRole+"/app"); v != roleSchemaVersion {
+		t.Errorf("stored schema_version = %d, want %d after a write", v, roleSchemaVersion)
+	}
+	entry, err := s.Get(ctx, storageKeyRole+"/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored Role
+	if err := entry.DecodeJSON(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.TokenType != "default" || stored.MergeStrategy != "union" || !stored.Disabled {
+		t.Errorf("stored role = %+v, want the migrated role with disabled set", stored)
+	}
+}
+
+func TestMigration_CurrentAndNewerVersionsUntouched(t *testing.T) {
+	ctx := context.Background()
+	s := newMemStorage()
+
+	putRaw(t, s, storageKeyRole+"/app", `{"schema_version":1,"name":"app","merge_strategy":"override"}`)
+	if r, err := readRole(ctx, s, "app"); err != nil || r.MergeStrategy != "override" {
+		t.Errorf("current role = %+v, %v", r, err)
+	}
+
+	newer := `{"schema_version":99,"name":"app"}`
+	putRaw(t, s, storageKeyRole+"/app", newer)
+	if r, err := readRole(ctx, s, "app"); err != nil || r.SchemaVersion != 99 || r.MergeStrategy != "" {
+		t.Errorf("newer role = %+v, %v; want it left as stored", r, err)
+	}
+	if entry, _ := s.Get(ctx, storageKeyRole+"/app"); string(entry.Value) != newer {
+		t.Errorf("newer role rewritten: %s", entry.Value)
+	}
+}
+
+func TestLoad_PersistsMigration(t *testing.T) {
+	b, s := getTestBackend(t)
+	ctx := context.Background()
+	putRaw(t, s, storageKeyConfig, `{"realm":"EXAMPLE.COM","kdcs":["dc1.example.com"],"spn":"HTTP/vault.example.com","keytab":"a2V5"}`)
+	putRaw(t, s, storageKeyRole+"/app", `{"token_policies":["app"]}`)
+
+	cfg, err := b.loadConfig(ctx)
+	if err != nil || cfg.ClockSkewSec != 300 {
+		t.Fatalf("loadConfig = %+v, %v", cfg, err)
+	}
+	if v := storedSchemaVersion(t, s, storageKeyConfig); v != configSchemaVersion {
+		t.Errorf("stored config schema_version = %d, want %d", v, configSchemaVersion)
+	}
+	if stored, err := readConfig(ctx, s); err != nil || stored.ClockSkewSec != 300 || stored.Realm != "EXAMPLE.COM" {
+		t.Errorf("stored config = %+v, %v; want the migrated config", stored, err)
+	}
+
+	// Role reads through the API rewrite the entry under its stored name
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "role/app",
+		Storage:   s,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("role read failed: err=%v resp=%#v", err, resp)
+	}
+	if v := storedSchemaVersion(t, s, storageKeyRole+"/app"); v != roleSchemaVersion {
+		t.Errorf("stored role schema_version = %d, want %d", v, roleSchemaVersion)
+	}
+	if stored, err := readRole(ctx, s, "app"); err != nil || stored.Name != "app" || stored.MergeStrategy != "union" {
+		t.Errorf("stored role = %+v, %v; want the migrated role", stored, err)
+	}
+}
+
+func TestPersistMigration_KeepsNewerWrite(t *testing.T) {
+	b, s := getTestBackend(t)
+	ctx := context.Background()
+
+	// A write that lands between the unlocked read and the rewrite wins
+	current := `{"schema_version":1,"name":"app","merge_strategy":"override"}`
+	putRaw(t, s, storageKeyRole+"/app", current)
+	b.persistRoleMigration(ctx, "app")
+	if entry, _ := s.Get(ctx, storageKeyRole+"/app"); string(entry.Value) != current {
+		t.Errorf("current role rewritten: %s", entry.Value)
+	}
+
+	// A config deleted in the meantime is not recreated
+	b.persistConfigMigration(ctx)
+	if entry, _ := s.Get(ctx, storageKeyConfig); entry != nil {
+		t.Errorf("deleted config recreated: %s", entry.Value)
+	}
+}
+
+func TestMigration_ReadOnlyStorage(t *testing.T) {
+	s := newMemStorage()
+	putRaw(t, s, storageKeyConfig, `{"realm":"EXAMPLE.COM"}`)
+
+	cfg, err := readConfig(context.Background(), readOnlyStorage{s})
+	if err != nil {
+		t.Fatalf("read on read-only storage failed: %v", err)
+	}
+	if cfg.SchemaVersion != configSchemaVersion || cfg.ClockSkewSec != 300 {
+		t.Errorf("config = %+v, want it migrated in memory", cfg)
+	}
+	if v := storedSchemaVersion(t, s, storageKeyConfig); v != 0 {
+		t.Errorf("stored schema_version = %d, want the entry untouched", v)
+	}
+
+	// A performance standby serves the migrated entry and leaves the
+	// rewrite to the active node
+	b, _ := getTestBackend(t)
+	b.storage = readOnlyStorage{s}
+	if cfg, err := b.loadConfig(context.Background()); err != nil || cfg.ClockSkewSec != 300 {
+		t.Errorf("loadConfig on read-only storage = %+v, %v", cfg, err)
+	}
+	if v := storedSchemaVersion(t, s, storageKeyConfig); v != 0 {
+		t.Errorf("stored schema_version = %d after a read-only load, want 0", v)
+	}
+}
@@ -0,0 +1,91 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathsAudit(b *gmsaBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern:      "audit/chain/verify",
+			HelpSynopsis: "Verify the integrity of the login event hash chain",
+			HelpDescription: `
+Walks the retained login events recorded with audit_chain enabled and checks
+that every entry is present, matches its recorded HMAC under the mount's
+seal-wrapped chain key and links to the hash of its predecessor, and that the
+newest entry is the recorded head. A deleted, altered or reordered entry
+breaks the chain at that entry. Truncation of the newest entries together with
+the head, or a rewrite by someone who can read the key, is only detected by
+comparing the returned head with a copy kept outside Vault.
+			`,
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handleAuditChainVerify,
+					Summary:  "Verify the login event hash chain",
+				},
+			},
+		},
+		{
+			Pattern:      "audit/chain/rotate",
+			HelpSynopsis: "Start a new generation of the login event hash chain",
+			HelpDescription: `
+Deletes the retained login events and starts a new chain generation whose first
+entry links to the final head of the old one. The old head is returned so it
+can be archived alongside any exported entries.
+			`,
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleAuditChainRotate,
+					Summary:  "Rotate the login event hash chain",
+				},
+			},
+		},
+	}
+}
+
+func (b *gmsaBackend) handleAuditChainVerify(ctx context.Context, _ *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	b.auditLock.Lock()
+	key, err := b.auditChainKey(ctx, false)
+	var state *auditChainState
+	var brokenAt uint64
+	var reason string
+	if err == nil {
+		state, brokenAt, reason, err = verifyAuditChain(ctx, b.storage, key)
+	}
+	b.auditLock.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify audit chain: %w", err)
+	}
+
+	data := map[string]interface{}{
+		"valid":      reason == "",
+		"generation": state.Generation,
+		"entries":    state.Next - state.First,
+		"first_seq":  state.First,
+		"head":       state.Head,
+	}
+	if reason != "" {
+		b.logger.Warn("audit chain verification failed", "seq", brokenAt, "reason", reason)
+		data["broken_at"] = brokenAt
+		data["reason"] = reason
+	}
+	return &logical.Response{Data: data}, nil
+}
+
+func (b *gmsaBackend) handleAuditChainRotate(ctx context.Context, _ *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	old, err := b.rotateAuditChain(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate audit chain: %w", err)
+	}
+	b.logger.Info("audit chain rotated", "generation", old.Generation+1, "previous_head", old.Head)
+	return &logical.Response{Data: map[string]interface{}{
+		"generation":          old.Generation + 1,
+		"previous_generation": old.Generation,
+		"previous_head":       old.Head,
+		"previous_entries":    old.Next - old.First,
+	}}, nil
+}
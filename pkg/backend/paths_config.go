@@ -2,6 +2,9 @@ package backend
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
@@ -13,12 +16,69 @@ func pathsConfig(b *gmsaBackend) []*framework.Path {
 			Pattern:      "config",
 			HelpSynopsis: "Configure global gMSA/Kerberos settings (KDCs, realm, keytab, channel binding).",
 			Fields: map[string]*framework.FieldSchema{
-				"realm":                 {Type: framework.TypeString, Required: true, Description: "Kerberos realm (UPPERCASE)."},
-				"kdcs":                  {Type: framework.TypeString, Required: true, Description: "Comma-separated KDCs (host or host:port)."},
-				"keytab":                {Type: framework.TypeString, Required: true, Description: "Base64-encoded keytab for the service account (gMSA)."},
-				"spn":                   {Type: framework.TypeString, Required: true, Description: "Service Principal Name; e.g., HTTP/vault.domain"},
-				"allow_channel_binding": {Type: framework.TypeBool, Description: "Require TLS channel-binding (tls-server-end-point)."},
-				"clock_skew_sec":        {Type: framework.TypeInt, Description: "Allowed clock skew seconds (default 300)."},
+				"realm":                       {Type: framework.TypeString, Required: true, Description: "Kerberos realm (UPPERCASE)."},
+				"kdcs":                        {Type: framework.TypeString, Required: true, Description: "Comma-separated KDCs (host or host:port)."},
+				"kdc_timeout_sec":             {Type: framework.TypeInt, Description: "Connect timeout in seconds for each attempt to reach a KDC (0 = 2). Timeout times attempts may not exceed 60 seconds."},
+				"kdc_retries":                 {Type: framework.TypeInt, Description: "Further attempts after a KDC connection fails or times out (default 0)."},
+				"keytab":                      {Type: framework.TypeString, Required: true, Description: "Base64-encoded keytab for the service account (gMSA)."},
+				"spn":                         {Type: framework.TypeString, Required: true, Description: "Service Principal Name; e.g., HTTP/vault.domain or MSSQLSvc/db.domain:1433"},
+				"allow_channel_binding":       {Type: framework.TypeBool, Description: "Require TLS channel-binding (tls-server-end-point)."},
+				"additional_keytabs":          {Type: framework.TypeString, Description: "Comma-separated base64-encoded keytabs merged with keytab at login, e.g. for other SPNs or keytab transitions."},
+				"clock_skew_sec":              {Type: framework.TypeInt, Default: 300, Description: "Allowed clock skew seconds for both the Kerberos authenticator and PAC checks (default 300)."},
+				"principal_lockout_threshold": {Type: framework.TypeInt, Description: "Consecutive authorization failures before a principal is locked out (0 disables)."},
+				"principal_lockout_duration":  {Type: framework.TypeDurationSecond, Description: "How long a principal stays locked out (default 900s)."},
+				"audit_chain":                 {Type: framework.TypeBool, Description: "Append every login outcome to a storage-backed hash chain that audit/chain/verify checks for deleted or altered entries (default false)."},
+				"audit_chain_max_entries":     {Type: framework.TypeInt, Description: "Newest audit chain entries to keep; older ones are pruned with the chain still verifiable (0 = 1000, max 100000)."},
+				"last_login":                  {Type: framework.TypeBool, Description: "Store the decoded PAC summary of each principal's last successful login, readable at login/last/<principal> (default false)."},
+				"last_login_max_entries":      {Type: framework.TypeInt, Description: "Principals whose last login summary is kept; the least recent are dropped (0 = 1000, max 100000)."},
+				"last_login_redact":           {Type: framework.TypeString, Description: "Comma-separated summary fields not to store: user_sid, upn, group_sids, logon_server, client_ip."},
+				"password_expiry_warn_days":   {Type: framework.TypeInt, Description: "Warn in the health endpoint once the gMSA password is within this many days of expiry, based on the rotation manager's last AD query (0 = no warning)."},
+				"max_concurrent_logins":       {Type: framework.TypeInt, Description: "Maximum simultaneous Kerberos validations; excess logins wait briefly, then fail with error_code busy (0 = no limit)."},
+				"pac_upn_match":               {Type: framework.TypeBool, Description: "Require the PAC UPN's user part to match the logon user name."},
+				"only_sid_prefixes":           {Type: framework.TypeString, Description: "Comma-separated SID prefixes, e.g. S-1-5-21-1111-2222-3333; only group SIDs under one of them are used for authorization, aliases and policy templates (empty = all)."},
+				"exclude_sid_prefixes":        {Type: framework.TypeString, Description: "Comma-separated SID prefixes, e.g. S-1-5-32,S-1-1-0, whose group SIDs are dropped before authorization; applied after only_sid_prefixes."},
+				"log_redact_patterns":         {Type: framework.TypeStringSlice, Description: "Regular expressions, e.g. internal host names or ticket IDs, replaced with <redacted> in logged errors after the built-in SPNEGO, SID and secret redactions."},
+				"disable_sid_redaction":       {Type: framework.TypeBool, Description: "Log SIDs instead of redacting them, e.g. on development mounts (default false)."},
+				"require_spn_realm_match":     {Type: framework.TypeBool, Description: "Reject logins whose ticket was issued for the SPN in a realm other than the configured realm, e.g. through cross-realm entries in additional_keytabs (default false)."},
+				"keytab_require_spn":          {Type: framework.TypeBool, Description: "Require the keytab to hold an entry for the SPN itself; otherwise any entry in the configured realm suffices, e.g. for keytabs exported for the gMSA account (default false)."},
+				"disable_pac_processing":      {Type: framework.TypeBool, Description: "Skip PAC decoding and validation when no role binds group SIDs; logins carry no group or user SIDs and are flagged PAC_SKIPPED (default false)."},
+				"pac_unknown_buffer_mode":     {Type: framework.TypeString, Description: "Handling of PAC buffer types MS-PAC doesn't define: ignore, warn (log and flag the login) or reject (default ignore)."},
+				"pac_require_aes_signatures":  {Type: framework.TypeBool, Description: "Fail PAC validation when the server or KDC signature uses an RC4 HMAC-MD5 checksum instead of AES (default false)."},
+				"forbid_rc4":                  {Type: framework.TypeBool, Description: "Reject keytabs whose SPN entries only have RC4-HMAC keys."},
+				"pac_cache":                   {Type: framework.TypeBool, Description: "Cache PAC validation results per ticket until the ticket ends (default false)."},
+				"allowed_mech_oids":           {Type: framework.TypeString, Description: "Comma-separated GSS mechanism OIDs a SPNEGO token must offer (default Kerberos v5 only: 1.2.840.113554.1.2.2,1.2.840.48018.1.2.2)."},
+				"emit_group_aliases":          {Type: framework.TypeBool, Description: "Return an identity group alias for each PAC group SID at login (default false)."},
+				"logon_server_metadata":       {Type: framework.TypeBool, Description: "Add the domain controller that issued the PAC (logon_server) to login metadata (default false)."},
+				"verify_spn_matches_host":     {Type: framework.TypeBool, Description: "Reject logins whose ticket SPN host differs from the host named by spn_host_header, or that lack the header (default false)."},
+				"spn_host_header":             {Type: framework.TypeString, Description: "Request header carrying the host the client called, set by the load balancer and listed in the mount's passthrough_request_headers (default X-Forwarded-Host)."},
+				"reject_downgrade":            {Type: framework.TypeBool, Description: "Reject tickets encrypted with a weaker enctype than the strongest keytab key for the SPN instead of only flagging ENCTYPE_DOWNGRADE (default false)."},
+				"require_pac_present":         {Type: framework.TypeBool, Description: "Reject logins whose service ticket carries no PAC, e.g. in single-domain gMSA deployments where a missing PAC means misconfiguration or tampering (default false)."},
+				"require_upn_dns_info":        {Type: framework.TypeBool, Description: "Reject logins whose PAC has no UPN_DNS_INFO buffer, which every supported domain controller emits; tickets without a PAC are governed by require_pac_present (default false)."},
+				"base64_strict":               {Type: framework.TypeBool, Description: "Accept only padded standard base64 SPNEGO tokens; by default whitespace is ignored and unpadded or URL-safe base64 is accepted (default false)."},
+				"filter_sid_history":          {Type: framework.TypeBool, Description: "Drop ExtraSIDs from other domains (SID history, which also covers universal groups from other forest domains) from the group SIDs used for authorization, aliases and policy templates (default false)."},
+				"default_token_type":          {Type: framework.TypeString, Description: "Token type (default or service) for roles that leave token_type unset."},
+				"base_policies":               {Type: framework.TypeString, Description: "Comma-separated policies attached to every token issued by this mount, whatever the role; role deny_policies can't remove them."},
+				"default_policies":            {Type: framework.TypeString, Description: "Comma-separated token policies for roles that leave token_policies unset."},
+				"principal_allow_pattern":     {Type: framework.TypeString, Description: "Regular expression a principal (user@REALM) must match to log in with any role (empty = any)."},
+				"principal_deny_pattern":      {Type: framework.TypeString, Description: "Regular expression rejecting matching principals for every role; takes precedence over principal_allow_pattern (empty = none)."},
+				"alias_source":                {Type: framework.TypeString, Description: "Identity entity alias name: principal, sid (the user's SID from the PAC, stable across renames) or upn (from the PAC). Setting it attaches an entity alias to every login; logins without the chosen attribute are rejected (default principal, only with emit_group_aliases)."},
+				"display_name_format":         {Type: framework.TypeString, Description: "Token display name: principal (as-is), sanitized (lowercase, trailing $ removed) or name (gmsa-<account name>) (default principal)."},
+				"display_name_max_length":     {Type: framework.TypeInt, Description: "Truncate the token display name to this many characters (0 = no limit)."},
+				"success_log_sample_rate":     {Type: framework.TypeFloat, Default: 0.0, Description: "Fraction of successful logins to log, from 0.0 (none) to 1.0 (all); failures are always logged (default 0)."},
+				"krb5_conf":                   {Type: framework.TypeString, Description: "Raw krb5.conf text with Kerberos library tunables; permitted_enctypes restricts accepted tickets."},
+				// Negotiate handshake headers
+				"negotiate_challenge":        {Type: framework.TypeBool, Default: true, Description: "Answer reads of the login endpoint with a WWW-Authenticate: Negotiate challenge (default true)."},
+				"negotiate_challenge_status": {Type: framework.TypeInt, Default: 401, Description: "HTTP status sent with the Negotiate challenge: 400, 401 or 403 (default 401)."},
+				"negotiate_response_token":   {Type: framework.TypeBool, Description: "Include WWW-Authenticate: Negotiate with an accept-completed SPNEGO token on successful logins (default false)."},
+				// Debugging aids, not for production
+				"expose_group_sids_in_response": {Type: framework.TypeBool, Default: false, Description: "Include the group SIDs used for authorization in login response data, to debug bound_group_sids mismatches. Discloses group membership to every client that logs in (default false)."},
+				// Group name lookup
+				"group_names":               {Type: framework.TypeBool, Description: "Resolve PAC group SIDs to sAMAccountNames over LDAP and add them to login metadata as group_names (default false)."},
+				"group_names_ldap_url":      {Type: framework.TypeString, Description: "ldap:// or ldaps:// URL of the domain controller queried for group names."},
+				"group_names_bind_dn":       {Type: framework.TypeString, Description: "DN to bind as for group name lookups; empty binds anonymously."},
+				"group_names_bind_password": {Type: framework.TypeString, Description: "Password for group_names_bind_dn."},
+				"group_names_base_dn":       {Type: framework.TypeString, Description: "Search base for group name lookups, e.g. DC=example,DC=com."},
+				"group_names_cache_ttl":     {Type: framework.TypeDurationSecond, Description: "How long resolved and unresolved SIDs are cached (0 = 3600, max 86400)."},
 				// Normalization settings
 				"realm_case_sensitive": {Type: framework.TypeBool, Description: "Whether realm comparison should be case-sensitive (default false)."},
 				"spn_case_sensitive":   {Type: framework.TypeBool, Description: "Whether SPN comparison should be case-sensitive (default false)."},
@@ -34,17 +94,74 @@ func pathsConfig(b *gmsaBackend) []*framework.Path {
 				logical.DeleteOperation: &framework.PathOperation{Callback: b.configDelete},
 			},
 		},
+		{
+			Pattern:      "config/normalize-preview",
+			HelpSynopsis: "Preview how the configured normalization rules rewrite a realm, SPN or principal.",
+			Fields: map[string]*framework.FieldSchema{
+				"realm":     {Type: framework.TypeString, Description: "Sample realm, e.g. EXAMPLE.LOCAL."},
+				"spn":       {Type: framework.TypeString, Description: "Sample SPN, e.g. http/vault.example.local."},
+				"principal": {Type: framework.TypeString, Description: "Sample principal, e.g. user@example.local."},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{Callback: b.normalizePreview},
+			},
+		},
 	}
 }
 
 func (b *gmsaBackend) configWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 	cfg := Config{
-		Realm:            d.Get("realm").(string),
-		KDCs:             csvToSlice(d.Get("kdcs")),
-		KeytabB64:        d.Get("keytab").(string),
-		SPN:              d.Get("spn").(string),
-		AllowChannelBind: d.Get("allow_channel_binding").(bool),
-		ClockSkewSec:     intOrDefault(d.Get("clock_skew_sec"), 300),
+		Realm:                       d.Get("realm").(string),
+		KDCs:                        csvToSlice(d.Get("kdcs")),
+		KDCTimeoutSec:               d.Get("kdc_timeout_sec").(int),
+		KDCRetries:                  d.Get("kdc_retries").(int),
+		KeytabB64:                   d.Get("keytab").(string),
+		AdditionalKeytabs:           csvToSlice(d.Get("additional_keytabs")),
+		SPN:                         d.Get("spn").(string),
+		AllowChannelBind:            d.Get("allow_channel_binding").(bool),
+		ClockSkewSec:                intOrDefault(d.Get("clock_skew_sec"), 300),
+		PrincipalLockoutThreshold:   intOrDefault(d.Get("principal_lockout_threshold"), 0),
+		PrincipalLockoutDurationSec: intOrDefault(d.Get("principal_lockout_duration"), 0),
+		MaxConcurrentLogins:         d.Get("max_concurrent_logins").(int),
+		PasswordExpiryWarnDays:      d.Get("password_expiry_warn_days").(int),
+		AuditChain:                  d.Get("audit_chain").(bool),
+		AuditChainMaxEntries:        d.Get("audit_chain_max_entries").(int),
+		LastLogin:                   d.Get("last_login").(bool),
+		LastLoginMaxEntries:         d.Get("last_login_max_entries").(int),
+		LastLoginRedact:             csvToSlice(d.Get("last_login_redact")),
+		PACUPNMatch:                 d.Get("pac_upn_match").(bool),
+		PACRequireAES:               d.Get("pac_require_aes_signatures").(bool),
+		PACUnknownBufferMode:        d.Get("pac_unknown_buffer_mode").(string),
+		DisablePACProcessing:        d.Get("disable_pac_processing").(bool),
+		KeytabRequireSPN:            d.Get("keytab_require_spn").(bool),
+		RequireSPNRealmMatch:        d.Get("require_spn_realm_match").(bool),
+		OnlySIDPrefixes:             csvToSlice(d.Get("only_sid_prefixes")),
+		ExcludeSIDPrefixes:          csvToSlice(d.Get("exclude_sid_prefixes")),
+		LogRedactPatterns:           d.Get("log_redact_patterns").([]string),
+		DisableSIDRedaction:         d.Get("disable_sid_redaction").(bool),
+		ExposeGroupSIDsInResponse:   d.Get("expose_group_sids_in_response").(bool),
+		ForbidRC4:                   d.Get("forbid_rc4").(bool),
+		PACCache:                    d.Get("pac_cache").(bool),
+		Krb5Conf:                    d.Get("krb5_conf").(string),
+		EmitGroupAliases:            d.Get("emit_group_aliases").(bool),
+		AllowedMechOIDs:             csvToSlice(d.Get("allowed_mech_oids")),
+		LogonServerMeta:             d.Get("logon_server_metadata").(bool),
+		VerifySPNHost:               d.Get("verify_spn_matches_host").(bool),
+		SPNHostHeader:               d.Get("spn_host_header").(string),
+		RejectDowngrade:             d.Get("reject_downgrade").(bool),
+		RequirePAC:                  d.Get("require_pac_present").(bool),
+		FilterSIDHistory:            d.Get("filter_sid_history").(bool),
+		RequireUPNInfo:              d.Get("require_upn_dns_info").(bool),
+		Base64Strict:                d.Get("base64_strict").(bool),
+		SuccessLogSampleRate:        d.Get("success_log_sample_rate").(float64),
+		DefaultTokenType:            d.Get("default_token_type").(string),
+		DefaultPolicies:             csvToSlice(d.Get("default_policies")),
+		BasePolicies:                csvToSlice(d.Get("base_policies")),
+		PrincipalAllowPattern:       d.Get("principal_allow_pattern").(string),
+		PrincipalDenyPattern:        d.Get("principal_deny_pattern").(string),
+		DisplayNameFormat:           d.Get("display_name_format").(string),
+		AliasSource:                 d.Get("alias_source").(string),
+		DisplayNameMaxLength:        d.Get("display_name_max_length").(int),
 		Normalization: NormalizationConfig{
 			RealmCaseSensitive: d.Get("realm_case_sensitive").(bool),
 			SPNCaseSensitive:   d.Get("spn_case_sensitive").(bool),
@@ -53,18 +170,53 @@ func (b *gmsaBackend) configWrite(ctx context.Context, req *logical.Request, d *
 			RealmPrefixes:      csvToSlice(d.Get("realm_prefixes")),
 			SPNPrefixes:        csvToSlice(d.Get("spn_prefixes")),
 		},
+		Negotiate: NegotiateConfig{
+			SuppressChallenge: !d.Get("negotiate_challenge").(bool),
+			ChallengeStatus:   intOrDefault(d.Get("negotiate_challenge_status"), 401),
+			ResponseToken:     d.Get("negotiate_response_token").(bool),
+		},
+		GroupNames: GroupNamesConfig{
+			Enabled:      d.Get("group_names").(bool),
+			LDAPURL:      d.Get("group_names_ldap_url").(string),
+			BindDN:       d.Get("group_names_bind_dn").(string),
+			BindPassword: d.Get("group_names_bind_password").(string),
+			BaseDN:       d.Get("group_names_base_dn").(string),
+			CacheTTL:     time.Duration(d.Get("group_names_cache_ttl").(int)) * time.Second,
+		},
 	}
 	if err := normalizeAndValidateConfig(&cfg); err != nil {
 		return logical.ErrorResponse(err.Error()), nil
 	}
-	if err := writeConfig(ctx, b.storage, &cfg); err != nil {
+	if cfg.DisablePACProcessing {
+		bound, err := rolesBindingGroups(ctx, b.storage)
+		if err != nil {
+			return nil, err
+		}
+		if len(bound) > 0 {
+			return logical.ErrorResponse(fmt.Sprintf("disable_pac_processing requires that no role sets bound_group_sids; found: %s", strings.Join(bound, ", "))), nil
+		}
+	}
+	b.configLock.Lock()
+	err := writeConfig(ctx, b.storage, &cfg)
+	b.configLock.Unlock()
+	if err != nil {
 		return nil, err
 	}
-	return &logical.Response{Data: cfg.Safe()}, nil
+	b.applyConfigSettings(&cfg)
+	// Cached PAC results may depend on the old keytab or settings
+	b.pacCache.Purge()
+	b.groupNames.Purge()
+	resp := &logical.Response{Data: cfg.Safe()}
+	if cfg.ExposeGroupSIDsInResponse {
+		const warning = "expose_group_sids_in_response is enabled: login responses disclose group SIDs; use it for debugging only"
+		b.logger.Warn(warning)
+		resp.AddWarning(warning)
+	}
+	return resp, nil
 }
 
 func (b *gmsaBackend) configRead(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
-	cfg, err := readConfig(ctx, b.storage)
+	cfg, err := b.loadConfig(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -74,9 +226,49 @@ func (b *gmsaBackend) configRead(ctx context.Context, req *logical.Request, _ *f
 	return &logical.Response{Data: cfg.Safe()}, nil
 }
 
+// normalizePreview returns the normalized forms of the supplied samples under
+// the current normalization settings, so rules can be checked before logins
+// depend on them
+func (b *gmsaBackend) normalizePreview(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	cfg, err := b.loadConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		return logical.ErrorResponse("configuration not set"), nil
+	}
+
+	realm := d.Get("realm").(string)
+	spn := d.Get("spn").(string)
+	principal := d.Get("principal").(string)
+	if realm == "" && spn == "" && principal == "" {
+		return logical.ErrorResponse("at least one of realm, spn or principal is required"), nil
+	}
+
+	data := map[string]interface{}{}
+	if realm != "" {
+		data["realm"] = realm
+		data["normalized_realm"] = normalizeRealm(realm, cfg.Normalization)
+	}
+	if spn != "" {
+		data["spn"] = spn
+		data["normalized_spn"] = normalizeSPN(spn, cfg.Normalization)
+	}
+	if principal != "" {
+		data["principal"] = principal
+		data["normalized_principal"] = normalizePrincipal(principal, cfg.Normalization)
+	}
+	return &logical.Response{Data: data}, nil
+}
+
 func (b *gmsaBackend) configDelete(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
-	if err := b.storage.Delete(ctx, storageKeyConfig); err != nil {
+	b.configLock.Lock()
+	err := b.storage.Delete(ctx, storageKeyConfig)
+	b.configLock.Unlock()
+	if err != nil {
 		return nil, err
 	}
+	b.pacCache.Purge()
+	b.groupNames.Purge()
 	return &logical.Response{}, nil
 }
@@ -0,0 +1,142 @@
+package backend
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+)
+
+func TestNormalizePreview(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+
+	preview := func(data map[string]interface{}) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "config/normalize-preview",
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return resp
+	}
+
+	if resp := preview(map[string]interface{}{"realm": "example.local"}); resp == nil || !resp.IsError() {
+		t.Fatalf("expected error without config, got: %#v", resp)
+	}
+
+	if err := writeConfig(ctx, storage, &Config{
+		Realm: "EXAMPLE.COM",
+		Normalization: NormalizationConfig{
+			RealmSuffixes: []string{".local", ".lan"},
+			RealmPrefixes: []string{"dev-"},
+			SPNSuffixes:   []string{".local"},
+			SPNPrefixes:   []string{"old-"},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp := preview(map[string]interface{}{}); resp == nil || !resp.IsError() {
+		t.Fatalf("expected error without samples, got: %#v", resp)
+	}
+
+	tests := []struct {
+		name  string
+		field string
+		input string
+		want  string
+	}{
+		{"realm suffix stripped then upper-cased", "realm", "example.local", "EXAMPLE"},
+		{"realm prefix and suffix stripped", "realm", "dev-example.lan", "EXAMPLE"},
+		// Stripping is case-sensitive and runs before case folding
+		{"upper-case realm suffix kept", "realm", "EXAMPLE.LOCAL", "EXAMPLE.LOCAL"},
+		{"realm prefix only", "realm", "dev-corp.example.com", "CORP.EXAMPLE.COM"},
+		{"SPN suffix stripped, service upper-cased", "spn", "http/vault.example.local", "HTTP/vault.example"},
+		{"SPN prefix stripped", "spn", "old-http/vault.example.com", "HTTP/vault.example.com"},
+		{"SPN host case preserved", "spn", "http/Vault.Example.com", "HTTP/Vault.Example.com"},
+		{"principal realm normalized, user kept", "principal", "User@dev-example.local", "User@EXAMPLE"},
+		{"principal without realm unchanged", "principal", "user", "user"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := preview(map[string]interface{}{tt.field: tt.input})
+			if resp == nil || resp.IsError() {
+				t.Fatalf("unexpected response: %#v", resp)
+			}
+			if got := resp.Data["normalized_"+tt.field]; got != tt.want {
+				t.Errorf("normalized_%s = %v, want %q", tt.field, got, tt.want)
+			}
+			if got := resp.Data[tt.field]; got != tt.input {
+				t.Errorf("%s = %v, want input %q echoed", tt.field, got, tt.input)
+			}
+		})
+	}
+
+	// Case-sensitive realms skip folding after stripping
+	cfg, _ := readConfig(ctx, storage)
+	cfg.Normalization.RealmCaseSensitive = true
+	cfg.Normalization.SPNCaseSensitive = true
+	if err := writeConfig(ctx, storage, cfg); err != nil {
+		t.Fatal(err)
+	}
+	resp := preview(map[string]interface{}{"realm": "dev-example.local", "spn": "http/vault.example.local"})
+	if got := resp.Data["normalized_realm"]; got != "example" {
+		t.Errorf("case-sensitive normalized_realm = %v, want %q", got, "example")
+	}
+	if got := resp.Data["normalized_spn"]; got != "http/vault.example" {
+		t.Errorf("case-sensitive normalized_spn = %v, want %q", got, "http/vault.example")
+	}
+	if _, ok := resp.Data["normalized_principal"]; ok {
+		t.Error("normalized_principal returned without a principal sample")
+	}
+}
+
+func TestConfigWrite_LogRedaction(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	const host = "dc4.corp.internal"
+
+	if got := b.redactor.Load().Redact(host); got != host {
+		t.Fatalf("Redact() without a config = %q, want only the built-in rules", got)
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"realm":               "EXAMPLE.COM",
+			"kdcs":                "dc1.example.com",
+			"spn":                 testLoginSPN,
+			"keytab":              testKeytabB64(t, testLoginSPN, etypeID.AES256_CTS_HMAC_SHA1_96),
+			"log_redact_patterns": []string{`dc\d+\.corp\.internal`},
+		},
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("config write failed: err=%v resp=%#v", err, resp)
+	}
+	if got := b.redactor.Load().Redact(host); got != "<redacted>" {
+		t.Errorf("Redact() after the config write = %q, want the pattern applied", got)
+	}
+
+	// A config written by another node is picked up on invalidation
+	cfg, err := readConfig(ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.LogRedactPatterns = nil
+	if err := writeConfig(ctx, storage, cfg); err != nil {
+		t.Fatal(err)
+	}
+	b.invalidate(ctx, storageKeyConfig)
+	if got := b.redactor.Load().Redact(host); got != host {
+		t.Errorf("Redact() after invalidation = %q, want the pattern dropped", got)
+	}
+}
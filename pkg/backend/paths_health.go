@@ -37,6 +37,12 @@ func pathsHealth(b *gmsaBackend) []*framework.Path {
 		},
 		{
 			Pattern: "metrics$",
+			Fields: map[string]*framework.FieldSchema{
+				"format": {
+					Type:        framework.TypeString,
+					Description: "Output format: json (default) or prometheus",
+				},
+			},
 			Operations: map[logical.Operation]framework.OperationHandler{
 				logical.ReadOperation: &framework.PathOperation{
 					Callback: b.handleMetrics,
@@ -82,6 +88,16 @@ func (b *gmsaBackend) handleHealth(ctx context.Context, req *logical.Request, da
 			"webhook_notifications": "implemented",
 			"health_monitoring":     "implemented",
 		},
+		"rotation": rotationHealth(b.rotationManager, b.rotationConfig(ctx), time.Now()),
+	}
+	cfg, err := b.loadConfig(ctx)
+	if err != nil {
+		cfg = nil
+	}
+	if cfg != nil {
+		if warning := passwordExpiryWarning(b.rotationManager, cfg.PasswordExpiryWarnDays, time.Now()); warning != nil {
+			response["password_expiry_warning"] = warning
+		}
 	}
 
 	if detailed {
@@ -99,6 +115,10 @@ func (b *gmsaBackend) handleHealth(ctx context.Context, req *logical.Request, da
 				"gc_cpu_fraction": m.GCCPUFraction,
 			},
 		}
+		// Probing the network is left to detailed checks
+		if cfg != nil {
+			response["kdc"] = kdcHealth(ctx, cfg.KDCs, cfg.kdcTimeout(), cfg.KDCRetries, cfg.logRedactor())
+		}
 	}
 
 	return &logical.Response{
@@ -106,9 +126,81 @@ func (b *gmsaBackend) handleHealth(ctx context.Context, req *logical.Request, da
 	}, nil
 }
 
+// rotationHealth reports whether the rotation loop is alive so monitoring can
+// alert on a dead loop. A running loop is stalled when its last check started
+// longer ago than rc.stallAfter. rc is nil when rotation isn't configured.
+func rotationHealth(rm RotationManagerInterface, rc *RotationConfig, now time.Time) map[string]interface{} {
+	health := map[string]interface{}{
+		"configured": rm != nil,
+		"is_running": false,
+		"stalled":    false,
+	}
+	if rm == nil {
+		return health
+	}
+
+	status := rm.GetStatus()
+	running := rm.IsRunning()
+	health["is_running"] = running
+	health["status"] = status.Status
+	health["last_error"] = status.LastError
+	if !status.LastCheck.IsZero() {
+		age := now.Sub(status.LastCheck)
+		health["last_check"] = status.LastCheck.UTC().Format(time.RFC3339)
+		health["last_check_age_sec"] = int64(age / time.Second)
+		health["stalled"] = running && rc.stallAfter() > 0 && age > rc.stallAfter()
+	}
+	if stallAfter := rc.stallAfter(); stallAfter > 0 {
+		health["check_interval_sec"] = int64(rc.CheckInterval / time.Second)
+		health["stall_after_sec"] = int64(stallAfter / time.Second)
+	}
+	return health
+}
+
+// passwordExpiryWarning describes the gMSA password expiry when it is within
+// warnDays of now. The expiry comes from the rotation manager's last AD query,
+// which is kept after rotation is disabled; nil means no warning or no data.
+func passwordExpiryWarning(rm RotationManagerInterface, warnDays int, now time.Time) map[string]interface{} {
+	if rm == nil || warnDays <= 0 {
+		return nil
+	}
+	expiry := rm.GetStatus().PasswordExpiry
+	if expiry.IsZero() {
+		return nil
+	}
+	remaining := expiry.Sub(now)
+	if remaining > time.Duration(warnDays)*24*time.Hour {
+		return nil
+	}
+	return map[string]interface{}{
+		"password_expiry":   expiry.UTC().Format(time.RFC3339),
+		"days_until_expiry": int(remaining / (24 * time.Hour)),
+		"expired":           remaining <= 0,
+		"warn_days":         warnDays,
+	}
+}
+
+// rotationConfig returns the stored rotation config, or nil if rotation is
+// not configured
+func (b *gmsaBackend) rotationConfig(ctx context.Context) *RotationConfig {
+	entry, err := b.storage.Get(ctx, "rotation/config")
+	if err != nil || entry == nil {
+		return nil
+	}
+	var rc RotationConfig
+	if err := entry.DecodeJSON(&rc); err != nil {
+		return nil
+	}
+	return &rc
+}
+
 // handleMetrics returns comprehensive metrics and statistics
 // This endpoint provides detailed performance and resource utilization information
 func (b *gmsaBackend) handleMetrics(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	if format, _ := data.Get("format").(string); format == "prometheus" {
+		return prometheusMetricsResponse(b.rotationManager), nil
+	}
+
 	var m runtime.MemStats
 	runtime.ReadMemStats(&m)
 
@@ -120,6 +212,7 @@ func (b *gmsaBackend) handleMetrics(ctx context.Context, req *logical.Request, d
 		"uptime":    time.Since(startTime).String(),
 		"version":   pluginVersion,
 		"metadata":  metadata,
+		"auth":      authMetricsData(),
 		"runtime": map[string]interface{}{
 			"go_version":     runtime.Version(),
 			"num_goroutines": runtime.NumGoroutine(),
@@ -0,0 +1,190 @@
+package backend
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// fakeRotationManager reports a fixed status for health checks
+type fakeRotationManager struct {
+	running bool
+	status  RotationStatus
+}
+
+func (f *fakeRotationManager) Start() error                  { f.running = true; return nil }
+func (f *fakeRotationManager) Stop() error                   { f.running = false; return nil }
+func (f *fakeRotationManager) GetStatus() *RotationStatus    { s := f.status; return &s }
+func (f *fakeRotationManager) IsRunning() bool               { return f.running }
+func (f *fakeRotationManager) performRotation(*Config) error { return nil }
+
+func TestRotationHealth(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	rc := &RotationConfig{CheckInterval: time.Hour}
+
+	tests := []struct {
+		name        string
+		rm          RotationManagerInterface
+		wantRunning bool
+		wantStalled bool
+		wantAge     int64
+	}{
+		{"not configured", nil, false, false, -1},
+		{"running", &fakeRotationManager{running: true, status: RotationStatus{Status: "idle", LastCheck: now.Add(-30 * time.Minute)}}, true, false, 1800},
+		{"running before first check", &fakeRotationManager{running: true, status: RotationStatus{Status: "idle"}}, true, false, -1},
+		{"stopped with old check", &fakeRotationManager{status: RotationStatus{Status: "idle", LastCheck: now.Add(-5 * time.Hour)}}, false, false, 18000},
+		{"stalled", &fakeRotationManager{running: true, status: RotationStatus{Status: "checking", LastCheck: now.Add(-3 * time.Hour), LastError: "ldap timeout"}}, true, true, 10800},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := rotationHealth(tt.rm, rc, now)
+			if h["is_running"] != tt.wantRunning {
+				t.Errorf("is_running = %v, want %v", h["is_running"], tt.wantRunning)
+			}
+			if h["stalled"] != tt.wantStalled {
+				t.Errorf("stalled = %v, want %v", h["stalled"], tt.wantStalled)
+			}
+			age, ok := h["last_check_age_sec"]
+			if tt.wantAge < 0 {
+				if ok {
+					t.Errorf("last_check_age_sec = %v, want absent", age)
+				}
+			} else if age != tt.wantAge {
+				t.Errorf("last_check_age_sec = %v, want %d", age, tt.wantAge)
+			}
+		})
+	}
+
+	h := rotationHealth(tests[4].rm, rc, now)
+	if h["last_error"] != "ldap timeout" || h["status"] != "checking" {
+		t.Errorf("stalled health = %v", h)
+	}
+	if h := rotationHealth(tests[4].rm, nil, now); h["stalled"] != false {
+		t.Error("stalled reported without a known check interval")
+	}
+}
+
+func TestRotationHealth_MaximalJitter(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	// The longest jitter validation allows, with a failing webhook retried
+	rc := &RotationConfig{CheckInterval: time.Hour, CheckIntervalJitter: time.Hour - time.Second, MaxRetries: 3}
+	longestDelay := rc.CheckInterval + rc.CheckIntervalJitter
+	checkDuration := rotationCheckHeadroom + time.Duration(rc.MaxRetries)*maxWebhookBackoff
+
+	// A loop drawing the longest delay after a slow check isn't stalled,
+	// though its last check is older than twice the interval
+	healthy := &fakeRotationManager{running: true, status: RotationStatus{Status: "idle", LastCheck: now.Add(-(longestDelay + checkDuration))}}
+	if h := rotationHealth(healthy, rc, now); h["stalled"] != false || h["stall_after_sec"] != int64((longestDelay+checkDuration)/time.Second) {
+		t.Errorf("health = %v, want not stalled at the longest gap", h)
+	}
+
+	stalled := &fakeRotationManager{running: true, status: RotationStatus{Status: "idle", LastCheck: now.Add(-(longestDelay + checkDuration + time.Second))}}
+	if h := rotationHealth(stalled, rc, now); h["stalled"] != true {
+		t.Errorf("health = %v, want stalled past the longest gap", h)
+	}
+}
+
+func TestHandleHealth_Rotation(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+
+	entry, err := logical.StorageEntryJSON("rotation/config", &RotationConfig{CheckInterval: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put(ctx, entry); err != nil {
+		t.Fatal(err)
+	}
+	b.rotationManager = &fakeRotationManager{running: true, status: RotationStatus{LastCheck: time.Now().Add(-10 * time.Minute)}}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "health",
+		Storage:   storage,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("health failed: err=%v resp=%#v", err, resp)
+	}
+	rotation, ok := resp.Data["rotation"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("rotation section missing: %#v", resp.Data)
+	}
+	if rotation["stalled"] != true || rotation["check_interval_sec"] != int64(60) {
+		t.Errorf("rotation = %v, want stalled with a 60s interval", rotation)
+	}
+}
+
+func TestPasswordExpiryWarning(t *testing.T) {
+	now := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	expiring := func(in time.Duration) RotationManagerInterface {
+		return &fakeRotationManager{status: RotationStatus{PasswordExpiry: now.Add(in)}}
+	}
+
+	tests := []struct {
+		name     string
+		rm       RotationManagerInterface
+		warnDays int
+		wantDays int
+		warn     bool
+	}{
+		{name: "inside window", rm: expiring(3*24*time.Hour + time.Hour), warnDays: 7, wantDays: 3, warn: true},
+		{name: "outside window", rm: expiring(10 * 24 * time.Hour), warnDays: 7},
+		{name: "already expired", rm: expiring(-2 * 24 * time.Hour), warnDays: 7, wantDays: -2, warn: true},
+		{name: "warning disabled", rm: expiring(24 * time.Hour), warnDays: 0},
+		{name: "expiry unknown", rm: &fakeRotationManager{}, warnDays: 7},
+		{name: "no rotation manager", rm: nil, warnDays: 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := passwordExpiryWarning(tt.rm, tt.warnDays, now)
+			if (w != nil) != tt.warn {
+				t.Fatalf("warning = %v, want warning %t", w, tt.warn)
+			}
+			if w == nil {
+				return
+			}
+			if w["days_until_expiry"] != tt.wantDays || w["expired"] != (tt.wantDays < 0) || w["warn_days"] != tt.warnDays {
+				t.Errorf("warning = %v", w)
+			}
+		})
+	}
+}
+
+func TestHandleHealth_PasswordExpiryWarning(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	newTestLoginConfig(t, storage)
+	cfg, err := readConfig(ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.PasswordExpiryWarnDays = 5
+	if err := writeConfig(ctx, storage, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		expiresIn time.Duration
+		warn      bool
+	}{
+		{2 * 24 * time.Hour, true},
+		{20 * 24 * time.Hour, false},
+	} {
+		// Rotation is stopped; the expiry from its last check still counts
+		b.rotationManager = &fakeRotationManager{status: RotationStatus{PasswordExpiry: time.Now().Add(tt.expiresIn)}}
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "health",
+			Storage:   storage,
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("health failed: err=%v resp=%#v", err, resp)
+		}
+		if _, ok := resp.Data["password_expiry_warning"]; ok != tt.warn {
+			t.Errorf("expires in %s: password_expiry_warning present = %t, want %t", tt.expiresIn, ok, tt.warn)
+		}
+	}
+}
@@ -1,14 +1,24 @@
 package backend
 
 import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/lpassig/vault-plugin-auth-gmsa/internal/kerb"
 )
@@ -26,23 +36,134 @@ func pathsLogin(b *gmsaBackend) []*framework.Path {
 			Operations: map[logical.Operation]framework.OperationHandler{
 				// Use Update for writes to avoid ExistenceCheck requirement
 				logical.UpdateOperation: &framework.PathOperation{Callback: b.handleLogin},
+				// Reads start the HTTP Negotiate handshake
+				logical.ReadOperation: &framework.PathOperation{Callback: b.handleLoginChallenge},
+			},
+		},
+		{
+			Pattern:      "login/batch",
+			HelpSynopsis: "Validate several SPNEGO tokens in one request and report the policies each would receive.",
+			HelpDescription: "Requires a Vault token (unlike login). Each item is validated like a login " +
+				"against its role; a failing item reports its error without stopping the batch. " +
+				"No tokens are issued, and items leave lockout, last login, the audit chain and the " +
+				"replay cache unchanged.",
+			Fields: map[string]*framework.FieldSchema{
+				"items": {Type: framework.TypeSlice, Description: fmt.Sprintf("Array of up to %d objects with role, spnego and optional cb_tlse.", maxBatchLoginItems)},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{Callback: b.handleLoginBatch},
+			},
+		},
+		{
+			Pattern:      "login/last/" + framework.MatchAllRegex("principal"),
+			HelpSynopsis: "Read the decoded PAC summary of a principal's last successful login.",
+			HelpDescription: "Requires a Vault token (unlike login) and last_login enabled in config. " +
+				"Returns the principal, realm, role, time, user SID, UPN, group SIDs, logon server, " +
+				"client address and validation flags, minus the fields in last_login_redact.",
+			Fields: map[string]*framework.FieldSchema{
+				"principal": {Type: framework.TypeString, Description: "Principal name, e.g. svc-app$@EXAMPLE.COM."},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{Callback: b.handleLastLoginRead},
 			},
 		},
 	}
 }
 
-func (b *gmsaBackend) handleLogin(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
-	// Track authentication attempt
-	authAttempts.Add(1)
-	startTime := time.Now()
-	defer func() {
-		authLatency.Set(float64(time.Since(startTime).Milliseconds()))
-	}()
+// maxBatchLoginItems bounds the work a single login/batch request can cause
+const maxBatchLoginItems = 10
 
-	// Defensive timeout to avoid long-running Kerberos work under request context
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-	defer cancel()
+// Login error codes are returned as data.error_code alongside the error
+// message so clients can branch on the cause. They are part of the API:
+// don't rename them.
+const (
+	errorCodeInvalidRequest       = "invalid_request"
+	errorCodeInternal             = "internal_error"
+	errorCodeNotConfigured        = "not_configured"
+	errorCodeRoleNotFound         = "role_not_found"
+	errorCodeRoleDisabled         = "role_disabled"
+	errorCodeClientCertRequired   = "client_cert_required"
+	errorCodeClientCertNotAllowed = "client_cert_not_allowed"
+	errorCodeInvalidToken         = "invalid_token"
+	errorCodeChannelBinding       = "channel_binding_required"
+	errorCodeNegotiationFailed    = "negotiation_failed"
+	errorCodeEnctypeDowngrade     = "enctype_downgrade"
+	errorCodePACInvalid           = "pac_invalid"
+	errorCodeClockSkew            = "clock_skew"
+	errorCodeStaleTicket          = "stale_ticket"
+	errorCodeTicketExpired        = "ticket_expired"
+	errorCodeLockedOut            = "locked_out"
+	errorCodeSPNHostMismatch      = "spn_host_mismatch"
+	errorCodeSPNRealmMismatch     = "spn_realm_mismatch"
+	errorCodeRealmNotAllowed      = "realm_not_allowed"
+	errorCodeSPNNotAllowed        = "spn_not_allowed"
+	errorCodeGroupLimit           = "group_limit_exceeded"
+	errorCodePACUnavailable       = "pac_unavailable"
+	errorCodeNoGroupMatch         = "no_group_match"
+	errorCodeNoPolicies           = "no_policies"
+	errorCodePrincipalNotAllowed  = "principal_not_allowed"
+	errorCodePACRequired          = "pac_required"
+	errorCodeBusy                 = "busy"
+	errorCodeInitialRequired      = "initial_ticket_required"
+	errorCodeUPNInfoRequired      = "upn_dns_info_required"
+	errorCodeAliasUnavailable     = "alias_unavailable"
+	errorCodeAccountType          = "account_type_not_allowed"
+	errorCodeUserSIDNotAllowed    = "user_sid_not_allowed"
+	errorCodeUnknownPACBuffer     = "pac_unknown_buffer"
+	errorCodePACFlags             = "pac_flags_not_met"
+)
 
+// authorizationErrorCodes maps authorizeLogin failure reasons to error codes
+var authorizationErrorCodes = map[string]string{
+	failureReasonRealm:          errorCodeRealmNotAllowed,
+	failureReasonSPN:            errorCodeSPNNotAllowed,
+	failureReasonGroupLimit:     errorCodeGroupLimit,
+	failureReasonPACUnavailable: errorCodePACUnavailable,
+	failureReasonGroup:          errorCodeNoGroupMatch,
+	failureReasonPrincipal:      errorCodePrincipalNotAllowed,
+	failureReasonPACMissing:     errorCodePACRequired,
+	failureReasonUPNInfoMissing: errorCodeUPNInfoRequired,
+	failureReasonUserSID:        errorCodeUserSIDNotAllowed,
+	failureReasonUnknownBuffer:  errorCodeUnknownPACBuffer,
+	failureReasonPACFlags:       errorCodePACFlags,
+}
+
+// kerbErrorCode maps a validator error code to a login error code
+func kerbErrorCode(code string) string {
+	switch code {
+	case kerb.ErrCodeInvalidSPNEGO, kerb.ErrCodeInvalidInput:
+		return errorCodeInvalidToken
+	case kerb.ErrCodeMissingChannelBind:
+		return errorCodeChannelBinding
+	case kerb.ErrCodeEnctypeDowngrade:
+		return errorCodeEnctypeDowngrade
+	case kerb.ErrCodePACValidation:
+		return errorCodePACInvalid
+	case kerb.ErrCodeClockSkew:
+		return errorCodeClockSkew
+	case kerb.ErrCodeInvalidKeytab, kerb.ErrCodeConfigNotFound:
+		return errorCodeNotConfigured
+	default:
+		return errorCodeNegotiationFailed
+	}
+}
+
+// loginErrorResponse builds an error response carrying code as
+// data.error_code. Vault returns the nested data map with the errors.
+func loginErrorResponse(code, msg string) *logical.Response {
+	resp := logical.ErrorResponse(msg)
+	resp.Data["data"] = map[string]interface{}{"error_code": code}
+	return resp
+}
+
+// loginErrorCode returns the error code carried by a login error response
+func loginErrorCode(resp *logical.Response) string {
+	data, _ := resp.Data["data"].(map[string]interface{})
+	code, _ := data["error_code"].(string)
+	return code
+}
+
+func (b *gmsaBackend) handleLogin(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 	roleName := d.Get("role").(string)
 	spnegoB64 := d.Get("spnego").(string)
 	cb := d.Get("cb_tlse").(string)
@@ -64,104 +185,643 @@ func (b *gmsaBackend) handleLogin(ctx context.Context, req *logical.Request, d *
 		b.logger.Info("No role specified, using default role", "role", roleName)
 	}
 
-	// Enhanced input validation
-	if err := b.validateLoginInput(roleName, spnegoB64, cb); err != nil {
+	resp, err := b.login(ctx, req, roleName, spnegoB64, cb)
+	if err == nil && resp != nil && resp.Auth != nil {
+		recordTokenIssued(resp.Auth.TokenType)
+	}
+	return resp, err
+}
+
+// login validates a SPNEGO token against a role and builds the auth
+// response inside a gmsa.login span. Failures are returned as error responses.
+func (b *gmsaBackend) login(ctx context.Context, req *logical.Request, roleName, spnegoB64, cb string) (*logical.Response, error) {
+	ctx, span := b.startSpan(ctx, "gmsa.login", trace.WithAttributes(attribute.String("gmsa.role", roleName)))
+	resp, err := b.authenticate(ctx, req, roleName, spnegoB64, cb)
+	endLoginSpan(span, resp, err)
+	b.recordLoginAudit(req, roleName, resp, err)
+	return resp, err
+}
+
+// loginCheck is a login that passed validation and authorization, with
+// the policies its token would carry
+type loginCheck struct {
+	role       *Role
+	cfg        *Config
+	res        *kerb.ValidationResult
+	lockoutKey string
+	alias      string
+	groupNames []string
+	policies   []string
+}
+
+// tokenType returns the type of the token the login would issue
+func (c *loginCheck) tokenType() logical.TokenType {
+	if roleTokenType(c.role, c.cfg) == "service" {
+		return logical.TokenTypeService
+	}
+	return logical.TokenTypeDefault
+}
+
+// authenticate runs the login pipeline for login
+func (b *gmsaBackend) authenticate(ctx context.Context, req *logical.Request, roleName, spnegoB64, cb string) (*logical.Response, error) {
+	// Defensive timeout to avoid long-running Kerberos work under request context
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	check, errResp, err := b.checkLogin(ctx, req, roleName, spnegoB64, cb, true)
+	if err != nil || errResp != nil {
+		return errResp, err
+	}
+	role, cfg, res := check.role, check.cfg, check.res
+
+	metadata := loginMetadata(role, cfg, res)
+	if check.groupNames != nil {
+		metadata["group_names"] = strings.Join(check.groupNames, ",")
+	}
+
+	resp := &logical.Response{
+		Auth: &logical.Auth{
+			Policies:    check.policies,
+			Metadata:    metadata,
+			DisplayName: displayName(cfg, res.Principal),
+			TokenType:   check.tokenType(),
+		},
+		Data: map[string]interface{}{
+			"pac_validation": pacValidationData(res.Flags),
+		},
+	}
+
+	// Group aliases let operators map AD groups to identity groups centrally.
+	// Vault only attaches them to an entity, so the entity alias is set too.
+	// Its metadata records the login for the self path.
+	if cfg.EmitGroupAliases || cfg.AliasSource != "" {
+		resp.Auth.Alias = &logical.Alias{Name: check.alias, Metadata: selfAliasMetadata(metadata, check.policies)}
+	}
+	if cfg.EmitGroupAliases {
+		resp.Auth.GroupAliases = groupAliases(res.GroupSIDs, req.MountAccessor)
+	}
+	if cfg.ExposeGroupSIDsInResponse {
+		// After SID history and prefix filtering, as bound_group_sids sees them
+		resp.Data["group_sids"] = append([]string{}, res.GroupSIDs...)
+	}
+
+	if role.Period > 0 {
+		resp.Auth.Period = time.Duration(role.Period) * time.Second
+	}
+	if role.MaxTTL > 0 {
+		resp.Auth.TTL = time.Duration(role.MaxTTL) * time.Second
+	}
+	// Renewal re-checks the role; see authRenew
+	resp.Auth.Renewable = true
+	resp.Auth.InternalData = map[string]interface{}{internalKeyRole: role.Name}
+	if role.InvalidateOnRotation {
+		// Renewal is refused once rotation moves the keytab past this kvno
+		kvno, err := keytabKVNO(cfg.KeytabB64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read keytab kvno: %w", err)
+		}
+		resp.Auth.InternalData[internalKeyKVNO] = kvno
+	}
+	if role.TTLFromTicket && !res.TicketEndTime.IsZero() {
+		// Don't let the token outlive the ticket that authorized it
+		remaining := res.TicketEndTime.Sub(b.now()).Truncate(time.Second)
+		if remaining <= 0 {
+			b.logger.Warn("login rejected: kerberos ticket expired", "role", role.Name, "principal", res.Principal)
+			return loginErrorResponse(errorCodeTicketExpired, "kerberos ticket has expired"), nil
+		}
+		capTTLToTicket(resp.Auth, remaining)
+		// Renewal keeps the cap; see authRenew
+		resp.Auth.InternalData[internalKeyTicketEnd] = res.TicketEndTime.Unix()
+	}
+	if hint := reauthBefore(b.now(), resp.Auth, b.System(), res.TicketEndTime); !hint.IsZero() {
+		resp.Data["reauth_before"] = hint.UTC().Format(time.RFC3339)
+	}
+
+	if headers, err := negotiateSuccessHeaders(cfg.Negotiate); err != nil {
+		b.logger.Warn("failed to build Negotiate response token", "error", err)
+	} else if headers != nil {
+		resp.Headers = headers
+	}
+
+	// Track successful authentication
+	b.resetPrincipalFailures(check.lockoutKey)
+	authSuccesses.Add(1)
+	if b.successSampler.sample(cfg.SuccessLogSampleRate) {
+		b.logger.Info("login succeeded", "principal", res.Principal, "realm", res.Realm, "role", role.Name, "client_ip", req.Connection.RemoteAddr, "sample_rate", cfg.SuccessLogSampleRate)
+	}
+	b.recordLastLogin(ctx, req, cfg, role, res)
+	return resp, nil
+}
+
+// checkLogin validates a SPNEGO token and authorizes it against a role,
+// returning the resolved login or an error response for a rejected one.
+// Rejections are logged and counted in the failure metrics either way. Only
+// with record does the check change what later logins see: it rewrites
+// migrated role and config entries, records the authenticator in the
+// replay cache and counts authorization failures toward lockout.
+func (b *gmsaBackend) checkLogin(ctx context.Context, req *logical.Request, roleName, spnegoB64, cb string, record bool) (*loginCheck, *logical.Response, error) {
+	// Track authentication attempt
+	authAttempts.Add(1)
+	startTime := time.Now()
+	defer func() {
+		authLatency.Set(float64(time.Since(startTime).Milliseconds()))
+	}()
+
+	// Some clients send URL-safe or wrapped base64, or compress tokens
+	// carrying large PACs. base64_strict is enforced once config is read.
+	_, decodeSpan := b.startSpan(ctx, "gmsa.decode_token")
+	strictValid := isValidBase64(spnegoB64)
+	spnegoB64, err := decompressSPNEGO(canonicalSPNEGOEncoding(spnegoB64))
+	if err == nil {
+		// Enhanced input validation
+		err = b.validateLoginInput(roleName, spnegoB64, cb)
+	}
+	decodeSpan.SetAttributes(attribute.Int("gmsa.token_bytes", len(spnegoB64)), attribute.Bool("gmsa.valid", err == nil))
+	decodeSpan.End()
+	if err != nil {
 		inputValidationFailures.Add(1)
-		authFailures.Add(1)
+		recordAuthFailure(failureReasonInputValidation)
 		b.logger.Warn("invalid login input", "error", err, "client_ip", req.Connection.RemoteAddr)
-		return logical.ErrorResponse(err.Error()), nil
+		return nil, loginErrorResponse(errorCodeInvalidRequest, err.Error()), nil
 	}
 
-	role, err := readRole(ctx, b.storage, roleName)
+	var role *Role
+	if record {
+		role, err = b.loadRole(ctx, roleName)
+	} else {
+		role, err = readRole(ctx, b.storage, roleName)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to read role: %w", err)
+		return nil, nil, fmt.Errorf("failed to read role: %w", err)
 	}
 	if role == nil {
-		return logical.ErrorResponse(fmt.Sprintf("role %q not found", roleName)), nil
+		b.logger.Warn("login rejected: role not found", "role", roleName, "client_ip", req.Connection.RemoteAddr)
+		return nil, loginErrorResponse(errorCodeRoleNotFound, fmt.Sprintf("role %q not found", roleName)), nil
+	}
+	if role.Disabled {
+		recordAuthFailure(failureReasonRoleDisabled)
+		b.logger.Warn("login rejected: role disabled", "role", roleName, "client_ip", req.Connection.RemoteAddr)
+		return nil, loginErrorResponse(errorCodeRoleDisabled, fmt.Sprintf("role %q is disabled", roleName)), nil
+	}
+	if role.MaxSPNEGOBytes > 0 && len(spnegoB64) > role.MaxSPNEGOBytes {
+		inputValidationFailures.Add(1)
+		recordAuthFailure(failureReasonInputValidation)
+		b.logger.Warn("login rejected: SPNEGO token exceeds role limit", "role", roleName, "size", len(spnegoB64), "max_spnego_bytes", role.MaxSPNEGOBytes, "client_ip", req.Connection.RemoteAddr)
+		return nil, loginErrorResponse(errorCodeInvalidRequest, fmt.Sprintf("spnego token too large for role %q (max %d bytes)", roleName, role.MaxSPNEGOBytes)), nil
+	}
+	if len(role.BoundClientCertCNs) > 0 {
+		cn, ok := clientCertCN(req.Connection)
+		if !ok || !containsFold(role.BoundClientCertCNs, cn) {
+			recordAuthFailure(failureReasonClientCert)
+			b.logger.Warn("login rejected: client certificate not bound to role", "role", roleName, "client_cert_cn", cn, "client_ip", req.Connection.RemoteAddr)
+			if !ok {
+				return nil, loginErrorResponse(errorCodeClientCertRequired, fmt.Sprintf("role %q requires a TLS client certificate", roleName)), nil
+			}
+			return nil, loginErrorResponse(errorCodeClientCertNotAllowed, fmt.Sprintf("client certificate is not permitted for role %q", roleName)), nil
+		}
 	}
 
-	cfg, err := readConfig(ctx, b.storage)
+	var cfg *Config
+	if record {
+		cfg, err = b.loadConfig(ctx)
+	} else {
+		cfg, err = readConfig(ctx, b.storage)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to read config: %w", err)
+		return nil, nil, fmt.Errorf("failed to read config: %w", err)
 	}
 	if cfg == nil {
-		return logical.ErrorResponse("auth method not configured"), nil
+		b.logger.Warn("login rejected: auth method not configured", "client_ip", req.Connection.RemoteAddr)
+		return nil, loginErrorResponse(errorCodeNotConfigured, "auth method not configured"), nil
+	}
+	b.usePrincipalPatterns(cfg)
+	if cfg.Base64Strict && !strictValid {
+		inputValidationFailures.Add(1)
+		recordAuthFailure(failureReasonInputValidation)
+		b.logger.Warn("login rejected: spnego token is not standard base64", "role", roleName, "client_ip", req.Connection.RemoteAddr)
+		return nil, loginErrorResponse(errorCodeInvalidRequest, "invalid spnego token encoding: base64_strict requires padded standard base64"), nil
 	}
 
-	v := kerb.NewValidator(kerb.Options{
-		Realm:        cfg.Realm,
-		SPN:          cfg.SPN,
-		ClockSkewSec: cfg.ClockSkewSec,
-		RequireCB:    cfg.AllowChannelBind,
-		KeytabB64:    cfg.KeytabB64,
-	})
+	opt := b.validatorOptions(cfg)
+	opt.IgnorePACLogonTimeSkew = role.IgnorePACLogonTimeSkew
+	opt.SkipReplayCache = !record
+	v := kerb.NewValidator(opt)
+	release, ok := b.loginLimiter.acquire(ctx, cfg.MaxConcurrentLogins)
+	if !ok {
+		recordAuthFailure(failureReasonBusy)
+		b.logger.Warn("login rejected: too many concurrent logins", "role", roleName, "max_concurrent_logins", cfg.MaxConcurrentLogins, "client_ip", req.Connection.RemoteAddr)
+		return nil, loginErrorResponse(errorCodeBusy, "too many concurrent logins; retry shortly"), nil
+	}
 	res, kerr := v.ValidateSPNEGO(ctx, spnegoB64, cb)
+	release()
 	if !kerr.IsZero() {
-		authFailures.Add(1)
-		return logical.ErrorResponse(kerr.SafeMessage()), nil
+		if kerr.Code() == kerb.ErrCodeEnctypeDowngrade {
+			enctypeDowngrades.Add(1)
+			b.logger.Warn("login rejected: ticket enctype downgrade", "client_ip", req.Connection.RemoteAddr)
+		}
+		switch kerr.Code() {
+		case kerb.ErrCodePACValidation:
+			recordAuthFailure(failureReasonPAC)
+		case kerb.ErrCodeMissingChannelBind:
+			// Counted apart from negotiation so a client or proxy stripping
+			// the binding stands out
+			channelBindingFailures.Add(1)
+			recordAuthFailure(failureReasonChannelBinding)
+			b.logger.Warn("login rejected: channel binding required but not provided", "role", roleName, "client_ip", req.Connection.RemoteAddr)
+			return nil, loginErrorResponse(errorCodeChannelBinding, kerr.SafeMessage()), nil
+		default:
+			recordAuthFailure(failureReasonNegotiation)
+		}
+		b.logger.Warn("login rejected: kerberos validation failed", "role", roleName, "code", kerr.Code(), "client_ip", req.Connection.RemoteAddr)
+		return nil, loginErrorResponse(kerbErrorCode(kerr.Code()), kerr.SafeMessage()), nil
 	}
 
-	// Authorization with normalization
-	normalizedRealm := normalizeRealm(res.Realm, cfg.Normalization)
-	normalizedSPN := normalizeSPN(res.SPN, cfg.Normalization)
+	// Reject principals that are locked out after repeated failures before
+	// any role check, so a locked-out principal learns nothing more
+	lockoutKey := normalizePrincipal(res.Principal, cfg.Normalization)
+	if until := b.principalLockedUntil(lockoutKey); !until.IsZero() {
+		lockoutRejections.Add(1)
+		recordAuthFailure(failureReasonLockout)
+		b.logger.Warn("login rejected: principal locked out", "principal", lockoutKey, "locked_until", until.UTC().Format(time.RFC3339))
+		return nil, loginErrorResponse(errorCodeLockedOut, "principal temporarily locked out due to repeated failures"), nil
+	}
 
-	if len(role.AllowedRealms) > 0 {
-		allowed := false
-		for _, allowedRealm := range role.AllowedRealms {
-			normalizedAllowedRealm := normalizeRealm(allowedRealm, cfg.Normalization)
-			if normalizedAllowedRealm == normalizedRealm {
-				allowed = true
-				break
-			}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("gmsa.realm", res.Realm))
+
+	if cfg.FilterSIDHistory {
+		filterSIDHistory(res)
+	}
+	filterSIDPrefixes(res, cfg.OnlySIDPrefixes, cfg.ExcludeSIDPrefixes)
+
+	if res.Flags["ENCTYPE_DOWNGRADE"] {
+		enctypeDowngrades.Add(1)
+		b.logger.Warn("ticket enctype weaker than keytab allows; possible downgrade", "principal", res.Principal, "spn", res.SPN)
+	}
+	if applyUnknownPACBufferMode(cfg, res.Flags) && cfg.pacUnknownBufferMode() == pacUnknownBufferWarn {
+		b.logger.Warn("PAC carries buffer types not defined by MS-PAC", "principal", res.Principal, "spn", res.SPN)
+	}
+
+	// Tickets issued before a key rotation carry the old key version; they
+	// aren't the principal's fault, so they don't count toward lockout
+	if role.MinKVNO > 0 && res.TicketKVNO < role.MinKVNO {
+		recordAuthFailure(failureReasonStaleTicket)
+		b.logger.Warn("login rejected: stale ticket key version", "role", role.Name, "principal", res.Principal, "kvno", res.TicketKVNO, "min_kvno", role.MinKVNO)
+		return nil, loginErrorResponse(errorCodeStaleTicket, fmt.Sprintf("ticket key version %d is below the role minimum %d; obtain a fresh service ticket", res.TicketKVNO, role.MinKVNO)), nil
+	}
+	if role.RequireInitial && !res.Flags["TICKET_INITIAL"] {
+		recordAuthFailure(failureReasonNotInitial)
+		b.logger.Warn("login rejected: ticket not issued by an initial exchange", "role", role.Name, "principal", res.Principal)
+		return nil, loginErrorResponse(errorCodeInitialRequired, "role requires a service ticket obtained directly with credentials (INITIAL flag)"), nil
+	}
+	if !accountTypeAllowed(role, res.Flags) {
+		recordAuthFailure(failureReasonAccountType)
+		b.logger.Warn("login rejected: account type not allowed", "role", role.Name, "principal", res.Principal, "account_type", role.AccountType)
+		return nil, loginErrorResponse(errorCodeAccountType, fmt.Sprintf("role only admits %s accounts", role.AccountType)), nil
+	}
+
+	// Resist ticket relay: the ticket must target the host the client called
+	if cfg.VerifySPNHost {
+		header := cfg.spnHostHeader()
+		host := requestHost(req, header)
+		if host == "" {
+			recordAuthFailure(failureReasonSPNHost)
+			b.logger.Warn("login rejected: request carries no host to check the ticket SPN against", "header", header, "spn", res.SPN, "client_ip", req.Connection.RemoteAddr)
+			return nil, loginErrorResponse(errorCodeSPNHostMismatch, "requested host is unknown, so the ticket service principal can't be checked"), nil
 		}
-		if !allowed {
-			return logical.ErrorResponse("realm not allowed for role"), nil
+		if !spnHostMatches(res.SPN, host) {
+			recordAuthFailure(failureReasonSPNHost)
+			b.logger.Warn("login rejected: ticket SPN does not match requested host", "spn", res.SPN, "host", host, "header", header, "client_ip", req.Connection.RemoteAddr)
+			return nil, loginErrorResponse(errorCodeSPNHostMismatch, "ticket service principal does not match the requested host"), nil
 		}
 	}
+	// A keytab holding the SPN in several realms accepts tickets for any of them
+	if cfg.RequireSPNRealmMatch && normalizeRealm(res.TicketRealm, cfg.Normalization) != normalizeRealm(cfg.Realm, cfg.Normalization) {
+		recordAuthFailure(failureReasonSPNRealm)
+		b.logger.Warn("login rejected: ticket SPN realm does not match configured realm", "spn", res.SPN, "ticket_realm", res.TicketRealm, "realm", cfg.Realm, "client_ip", req.Connection.RemoteAddr)
+		return nil, loginErrorResponse(errorCodeSPNRealmMismatch, "ticket service realm does not match the configured realm"), nil
+	}
 
-	if len(role.AllowedSPNs) > 0 {
-		allowed := false
-		for _, allowedSPN := range role.AllowedSPNs {
-			normalizedAllowedSPN := normalizeSPN(allowedSPN, cfg.Normalization)
-			if normalizedAllowedSPN == normalizedSPN {
-				allowed = true
-				break
-			}
-		}
-		if !allowed {
-			return logical.ErrorResponse("SPN not allowed for role"), nil
+	// Authorization with normalization
+	_, authzSpan := b.startSpan(ctx, "gmsa.authorize")
+	reason, msg := authorizeLogin(role, cfg, res)
+	authzSpan.SetAttributes(attribute.Bool("gmsa.authorized", reason == ""), attribute.String("gmsa.reason", reason))
+	authzSpan.End()
+	if reason != "" {
+		recordAuthFailure(reason)
+		b.logger.Warn("login rejected: not authorized", "role", role.Name, "principal", res.Principal, "reason", reason)
+		if record {
+			b.recordPrincipalFailure(cfg, lockoutKey)
 		}
+		return nil, loginErrorResponse(authorizationErrorCodes[reason], msg), nil
 	}
-	if len(role.BoundGroupSIDs) > 0 && !intersects(role.BoundGroupSIDs, res.GroupSIDs) {
-		authFailures.Add(1)
-		return logical.ErrorResponse("no bound group SID matched"), nil
+
+	// Group names feed the group_name policy template and login metadata
+	var groupNames []string
+	if cfg.GroupNames.Enabled && len(res.GroupSIDs) > 0 {
+		var err error
+		groupNames, err = b.groupNames.names(ctx, cfg.GroupNames, res.GroupSIDs)
+		switch {
+		case errors.Is(err, errGroupNamesBackoff):
+			// The failure that opened the breaker was already logged
+			b.logger.Debug("group name lookup skipped", "role", role.Name, "principal", res.Principal)
+		case err != nil:
+			// Names are informational, so the login proceeds with raw SIDs
+			b.logger.Warn("group name lookup failed", "role", role.Name, "principal", res.Principal, "error", err)
+		}
 	}
 
 	// Build token policies (merge/deny logic)
-	policies := unique(role.TokenPolicies)
+	policies := unique(roleTokenPolicies(role, cfg))
+	if role.PolicyTemplates {
+		policies = resolvePolicyTemplates(policies, policyTemplateValues{
+			Principal:  res.Principal,
+			Realm:      res.Realm,
+			SPN:        res.SPN,
+			GroupSIDs:  res.GroupSIDs,
+			GroupNames: resolvedGroupNames(res.GroupSIDs, groupNames),
+		})
+	}
 	if len(role.DenyPolicies) > 0 {
+		// Vault lowercases policy names, so a deny matches in any case, the
+		// same comparison role writes use to reject a policy granted and
+		// denied
 		tmp := make([]string, 0, len(policies))
 		deny := map[string]struct{}{}
 		for _, p := range role.DenyPolicies {
-			deny[p] = struct{}{}
+			deny[strings.ToLower(p)] = struct{}{}
 		}
 		for _, p := range policies {
-			if _, drop := deny[p]; !drop {
+			if _, drop := deny[strings.ToLower(p)]; !drop {
 				tmp = append(tmp, p)
 			}
 		}
 		policies = tmp
 	}
+	// Base policies are the mount's floor, so role deny_policies don't apply
+	policies = unique(append(policies, cfg.BasePolicies...))
+	if role.RequirePolicies && len(policies) == 0 {
+		recordAuthFailure(failureReasonNoPolicies)
+		b.logger.Warn("login rejected: no policies resolved", "role", role.Name, "principal", res.Principal)
+		return nil, loginErrorResponse(errorCodeNoPolicies, fmt.Sprintf("role %q resolved no policies for this login", role.Name)), nil
+	}
+
+	// An alias from a missing attribute would fork the principal's entity
+	alias, ok := aliasName(cfg, res)
+	if !ok && (cfg.EmitGroupAliases || cfg.AliasSource != "") {
+		recordAuthFailure(failureReasonAliasMissing)
+		b.logger.Warn("login rejected: entity alias source unavailable", "role", role.Name, "principal", res.Principal, "alias_source", cfg.AliasSource)
+		return nil, loginErrorResponse(errorCodeAliasUnavailable, fmt.Sprintf("ticket carries no %s for the entity alias (alias_source)", cfg.AliasSource)), nil
+	}
+
+	return &loginCheck{
+		role:       role,
+		cfg:        cfg,
+		res:        res,
+		lockoutKey: lockoutKey,
+		alias:      alias,
+		groupNames: groupNames,
+		policies:   policies,
+	}, nil, nil
+}
+
+// handleLoginChallenge answers reads of the login endpoint with a
+// WWW-Authenticate: Negotiate challenge so HTTP clients send a SPNEGO token
+func (b *gmsaBackend) handleLoginChallenge(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	cfg, err := b.loadConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	var negotiate NegotiateConfig
+	if cfg != nil {
+		negotiate = cfg.Negotiate
+	}
+	if negotiate.SuppressChallenge {
+		return loginErrorResponse(errorCodeInvalidRequest, "spnego token is required"), nil
+	}
+	return &logical.Response{
+		Headers: map[string][]string{"WWW-Authenticate": {"Negotiate"}},
+	}, logical.CodedError(negotiate.challengeStatus(), "authentication required")
+}
+
+// handleLoginBatch checks each item like a login and returns per-item
+// results, continuing past failed items. Items are checked without record,
+// so a batch leaves lockout, last_login, the audit chain and the replay
+// cache as they were and its tokens can still be used to log in.
+func (b *gmsaBackend) handleLoginBatch(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	items, _ := d.Get("items").([]interface{})
+	if len(items) == 0 {
+		return loginErrorResponse(errorCodeInvalidRequest, "items is required"), nil
+	}
+	if len(items) > maxBatchLoginItems {
+		return loginErrorResponse(errorCodeInvalidRequest, fmt.Sprintf("batch exceeds %d items", maxBatchLoginItems)), nil
+	}
+
+	results := make([]map[string]interface{}, 0, len(items))
+	succeeded := 0
+	for i, raw := range items {
+		item, _ := raw.(map[string]interface{})
+		roleName, _ := item["role"].(string)
+		spnegoB64, _ := item["spnego"].(string)
+		cb, _ := item["cb_tlse"].(string)
+		result := map[string]interface{}{"index": i, "role": roleName}
+		results = append(results, result)
+
+		if roleName == "" || spnegoB64 == "" {
+			result["error"] = "role and spnego are required"
+			result["error_code"] = errorCodeInvalidRequest
+			continue
+		}
+		itemCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		check, errResp, err := b.checkLogin(itemCtx, req, roleName, spnegoB64, cb, false)
+		cancel()
+		switch {
+		case err != nil:
+			b.logger.Error("batch login item failed", "index", i, "role", roleName, "error", err)
+			result["error"] = "internal error"
+			result["error_code"] = errorCodeInternal
+		case errResp != nil:
+			result["error"] = errResp.Data["error"]
+			result["error_code"] = loginErrorCode(errResp)
+		default:
+			succeeded++
+			result["principal"] = displayName(check.cfg, check.res.Principal)
+			result["policies"] = check.policies
+			result["token_type"] = check.tokenType().String()
+		}
+	}
+
+	return &logical.Response{Data: map[string]interface{}{
+		"results":   results,
+		"succeeded": succeeded,
+		"failed":    len(items) - succeeded,
+	}}, nil
+}
 
-	var tokenType logical.TokenType
-	switch role.TokenType {
-	case "service":
-		tokenType = logical.TokenTypeService
+// negotiateSuccessHeaders returns the WWW-Authenticate headers for a
+// successful login, or nil when no continuation token is configured
+func negotiateSuccessHeaders(n NegotiateConfig) (map[string][]string, error) {
+	if !n.ResponseToken {
+		return nil, nil
+	}
+	token, err := kerb.AcceptCompletedToken()
+	if err != nil {
+		return nil, err
+	}
+	return map[string][]string{"WWW-Authenticate": {"Negotiate " + token}}, nil
+}
+
+// validatorOptions builds the Kerberos validator options for cfg, including
+// the previous keytab while its rotation grace window is open
+func (b *gmsaBackend) validatorOptions(cfg *Config) kerb.Options {
+	opt := kerb.Options{
+		Realm:                   cfg.Realm,
+		SPN:                     cfg.SPN,
+		ClockSkewSec:            cfg.ClockSkewSec,
+		RequireCB:               cfg.AllowChannelBind,
+		KeytabB64:               cfg.KeytabB64,
+		PreviousKeytabB64:       cfg.activePreviousKeytab(b.now()),
+		AdditionalKeytabsB64:    cfg.AdditionalKeytabs,
+		RequireUPNMatch:         cfg.PACUPNMatch,
+		RequireAESPACSignatures: cfg.PACRequireAES,
+		AllowedMechOIDs:         cfg.allowedMechOIDs(),
+		RejectEnctypeDowngrade:  cfg.RejectDowngrade,
+		SkipPAC:                 cfg.DisablePACProcessing,
+	}
+	if cfg.PACCache {
+		opt.PACCache = b.pacCache
+	}
+	if cfg.Krb5Conf != "" {
+		// Validated on config write
+		opt.Krb5Conf, _ = parseKrb5Conf(cfg.Krb5Conf)
+	}
+	return opt
+}
+
+// Token display name formats
+const (
+	displayNamePrincipal = "principal"
+	displayNameSanitized = "sanitized"
+	displayNameName      = "name"
+)
+
+// PAC unknown buffer modes
+const (
+	pacUnknownBufferIgnore = "ignore"
+	pacUnknownBufferWarn   = "warn"
+	pacUnknownBufferReject = "reject"
+)
+
+// pacUnknownBufferMode returns the configured handling of undefined PAC
+// buffer types
+func (c *Config) pacUnknownBufferMode() string {
+	if c.PACUnknownBufferMode == "" {
+		return pacUnknownBufferIgnore
+	}
+	return c.PACUnknownBufferMode
+}
+
+// applyUnknownPACBufferMode clears the UNKNOWN_PAC_BUFFER flag when undefined
+// PAC buffer types are ignored; warn and reject keep it for login metadata and
+// authorizeLogin. It reports whether the flag remains set.
+func applyUnknownPACBufferMode(cfg *Config, flags map[string]bool) bool {
+	if cfg.pacUnknownBufferMode() == pacUnknownBufferIgnore {
+		delete(flags, "UNKNOWN_PAC_BUFFER")
+	}
+	return flags["UNKNOWN_PAC_BUFFER"]
+}
+
+// displayNameFormat returns the configured display name format
+func (c *Config) displayNameFormat() string {
+	if c.DisplayNameFormat == "" {
+		return displayNamePrincipal
+	}
+	return c.DisplayNameFormat
+}
+
+// Role account types
+const (
+	accountTypeAny     = "any"
+	accountTypeUser    = "user"
+	accountTypeMachine = "machine"
+)
+
+// accountType returns the role's account type, defaulting to any
+func (r *Role) accountType() string {
+	if r.AccountType == "" {
+		return accountTypeAny
+	}
+	return r.AccountType
+}
+
+// accountTypeAllowed reports whether the ticket's account kind satisfies
+// the role. The kind comes from the PAC, so a role limited to users or
+// machines rejects tickets whose PAC is missing, failed validation or has
+// logon info the account kind couldn't be read from.
+func accountTypeAllowed(r *Role, flags map[string]bool) bool {
+	accountType := r.accountType()
+	if accountType == accountTypeAny {
+		return true
+	}
+	if flags["PAC_NOT_FOUND"] || flags["PAC_VALIDATION_FAILED"] || flags["PAC_SKIPPED"] || flags["ACCOUNT_TYPE_UNKNOWN"] {
+		return false
+	}
+	return flags["IS_MACHINE_ACCOUNT"] == (accountType == accountTypeMachine)
+}
+
+// Entity alias name sources
+const (
+	aliasSourcePrincipal = "principal"
+	aliasSourceSID       = "sid"
+	aliasSourceUPN       = "upn"
+)
+
+// aliasSource returns the configured entity alias source
+func (c *Config) aliasSource() string {
+	if c.AliasSource == "" {
+		return aliasSourcePrincipal
+	}
+	return c.AliasSource
+}
+
+// aliasName returns the entity alias name for a login, or false if the
+// ticket lacks the attribute the mount is configured to use
+func aliasName(cfg *Config, res *kerb.ValidationResult) (string, bool) {
+	switch cfg.aliasSource() {
+	case aliasSourceSID:
+		return res.UserSID, res.UserSID != ""
+	case aliasSourceUPN:
+		return res.UPN, res.UPN != ""
 	default:
-		tokenType = logical.TokenTypeDefault
+		return res.Principal, true
 	}
+}
+
+// displayName renders the token display name for a principal such as
+// HOST$@REALM according to the mount's display name settings
+func displayName(cfg *Config, principal string) string {
+	name := principal
+	switch cfg.displayNameFormat() {
+	case displayNameSanitized:
+		user, realm, found := strings.Cut(principal, "@")
+		name = strings.ToLower(strings.TrimSuffix(user, "$"))
+		if found {
+			name += "@" + strings.ToLower(realm)
+		}
+	case displayNameName:
+		user, _, _ := strings.Cut(principal, "@")
+		name = "gmsa-" + strings.ToLower(strings.TrimSuffix(user, "$"))
+	}
+	if maxLen := cfg.DisplayNameMaxLength; maxLen > 0 {
+		if runes := []rune(name); len(runes) > maxLen {
+			name = string(runes[:maxLen])
+		}
+	}
+	return name
+}
 
-	// Build enhanced metadata with security information
+// loginMetadata builds the token metadata for a successful login, including
+// PAC validation flags and security warnings for audit purposes
+func loginMetadata(role *Role, cfg *Config, res *kerb.ValidationResult) map[string]string {
 	metadata := map[string]string{
 		"principal":  res.Principal,
 		"realm":      res.Realm,
@@ -169,6 +829,12 @@ func (b *gmsaBackend) handleLogin(ctx context.Context, req *logical.Request, d *
 		"spn":        res.SPN,
 		"sids_count": fmt.Sprintf("%d", len(res.GroupSIDs)),
 	}
+	if res.UserSID != "" {
+		metadata["user_sid"] = res.UserSID
+	}
+	if cfg.LogonServerMeta && res.LogonServer != "" {
+		metadata["logon_server"] = res.LogonServer
+	}
 
 	// Add PAC validation flags to metadata for audit purposes
 	for flag, value := range res.Flags {
@@ -183,25 +849,301 @@ func (b *gmsaBackend) handleLogin(ctx context.Context, req *logical.Request, d *
 		metadata["security_warning"] = "PAC not found - group authorization unavailable"
 	}
 
-	resp := &logical.Response{
-		Auth: &logical.Auth{
-			Policies:    policies,
-			Metadata:    metadata,
-			DisplayName: res.Principal,
-			TokenType:   tokenType,
-		},
+	return metadata
+}
+
+// pacValidationFlags are reported as typed booleans in the login response;
+// flags the validator did not set are reported as false
+var pacValidationFlags = []string{
+	"ACCEPTED",
+	"PAC_VALIDATED",
+	"SIGNATURES_VALID",
+	"CLOCK_SKEW_VALID",
+	"UPN_CONSISTENT",
+	"CROSS_REALM",
+	"PAC_NO_GROUPS",
+	"PAC_CACHE_HIT",
+	"PREVIOUS_KEYTAB",
+	"ENCTYPE_DOWNGRADE",
+	"PAC_SKIPPED",
+}
+
+// pacErrorFlags mark why PAC data could not be used for authorization
+var pacErrorFlags = []string{
+	"PAC_NOT_FOUND",
+	"PAC_VALIDATION_FAILED",
+	"PAC_ERROR",
+}
+
+// pacValidationData returns the structured pac_validation object for the
+// login response: one lower-cased boolean per known flag and the error
+// categories that were set
+func pacValidationData(flags map[string]bool) map[string]interface{} {
+	out := make(map[string]interface{}, len(pacValidationFlags)+1)
+	for _, flag := range pacValidationFlags {
+		out[strings.ToLower(flag)] = flags[flag]
+	}
+	errs := []string{}
+	for _, flag := range pacErrorFlags {
+		if flags[flag] {
+			errs = append(errs, strings.ToLower(flag))
+		}
 	}
+	out["errors"] = errs
+	return out
+}
 
-	if role.Period > 0 {
-		resp.Auth.Period = time.Duration(role.Period) * time.Second
+// clientCertCN returns the common name of the TLS client certificate the
+// request was made with, and false when there is none
+func clientCertCN(conn *logical.Connection) (string, bool) {
+	if conn == nil || conn.ConnState == nil || len(conn.ConnState.PeerCertificates) == 0 {
+		return "", false
 	}
-	if role.MaxTTL > 0 {
-		resp.Auth.TTL = time.Duration(role.MaxTTL) * time.Second
+	return conn.ConnState.PeerCertificates[0].Subject.CommonName, true
+}
+
+// requestHost returns the host named by the given header of the login
+// request, or "" when Vault did not pass the header through. Vault never
+// passes Host itself, so the host comes from a header a load balancer sets,
+// such as X-Forwarded-Host; of a comma-separated list the first entry, the
+// host the client called, is used.
+func requestHost(req *logical.Request, header string) string {
+	host, _, _ := strings.Cut(http.Header(req.Headers).Get(header), ",")
+	return strings.TrimSpace(host)
+}
+
+// spnHostHeader returns the request header checked by verify_spn_matches_host
+func (c *Config) spnHostHeader() string {
+	if c.SPNHostHeader == "" {
+		return defaultSPNHostHeader
 	}
+	return c.SPNHostHeader
+}
 
-	// Track successful authentication
-	authSuccesses.Add(1)
-	return resp, nil
+// spnHostMatches reports whether the host part of spn
+// (SERVICE/host[:port][/service-name][@REALM]) names the same host as a Host
+// header value. Ports, a trailing dot and case are ignored. SPNs ParseSPN
+// rejects, such as IPv6 literals, are compared on everything after the
+// service class.
+func spnHostMatches(spn, hostHeader string) bool {
+	var host string
+	if parsed, err := kerb.ParseSPN(spn); err == nil {
+		host = parsed.Host
+	} else {
+		_, instance, ok := strings.Cut(spn, "/")
+		if !ok {
+			return false
+		}
+		host, _, _ = strings.Cut(instance, "@")
+	}
+
+	if h, _, err := net.SplitHostPort(hostHeader); err == nil {
+		hostHeader = h
+	}
+	hostHeader = strings.Trim(hostHeader, "[]")
+
+	normalize := func(h string) string {
+		return strings.ToLower(strings.TrimSuffix(h, "."))
+	}
+	return host != "" && normalize(host) == normalize(hostHeader)
+}
+
+// groupAliases returns one identity group alias per distinct group SID
+func groupAliases(sids []string, mountAccessor string) []*logical.Alias {
+	if len(sids) == 0 {
+		return nil
+	}
+	aliases := make([]*logical.Alias, 0, len(sids))
+	for _, sid := range unique(sids) {
+		aliases = append(aliases, &logical.Alias{Name: sid, MountAccessor: mountAccessor})
+	}
+	return aliases
+}
+
+// authorizeLogin checks the validated identity against the mount's principal
+// patterns and PAC requirement and the role's realm, SPN, group and group
+// count constraints. It returns the failure reason and a client-safe message,
+// or empty strings when the login is authorized.
+func authorizeLogin(role *Role, cfg *Config, res *kerb.ValidationResult) (reason, msg string) {
+	if !principalPermitted(cfg, res.Principal) {
+		return failureReasonPrincipal, "principal not allowed on this mount"
+	}
+	if cfg.RequirePAC && res.Flags["PAC_NOT_FOUND"] {
+		return failureReasonPACMissing, "service ticket carries no PAC"
+	}
+	// A ticket without a PAC is left to require_pac_present
+	if cfg.RequireUPNInfo && !res.Flags["PAC_NOT_FOUND"] && !res.Flags["UPN_DNS_INFO_PRESENT"] {
+		return failureReasonUPNInfoMissing, "PAC carries no UPN_DNS_INFO buffer"
+	}
+	if cfg.pacUnknownBufferMode() == pacUnknownBufferReject && res.Flags["UNKNOWN_PAC_BUFFER"] {
+		return failureReasonUnknownBuffer, "PAC carries buffer types not defined by MS-PAC"
+	}
+
+	normalizedRealm := normalizeRealm(res.Realm, cfg.Normalization)
+	normalizedSPN := normalizeSPN(res.SPN, cfg.Normalization)
+
+	if len(role.AllowedRealms) > 0 {
+		allowed := false
+		for _, allowedRealm := range role.AllowedRealms {
+			normalizedAllowedRealm := normalizeRealm(allowedRealm, cfg.Normalization)
+			if normalizedAllowedRealm == normalizedRealm {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return failureReasonRealm, "realm not allowed for role"
+		}
+	}
+
+	if len(role.AllowedSPNs) > 0 {
+		allowed := false
+		for _, allowedSPN := range role.AllowedSPNs {
+			normalizedAllowedSPN := normalizeSPN(allowedSPN, cfg.Normalization)
+			if normalizedAllowedSPN == normalizedSPN {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return failureReasonSPN, "SPN not allowed for role"
+		}
+	}
+
+	if unmet, ok := pacFlagsMeet(role.RequiredPACFlags, res.Flags); !ok {
+		return failureReasonPACFlags, fmt.Sprintf("PAC validation does not meet the role requirement %s", unmet)
+	}
+
+	if len(role.BoundUserSIDs) > 0 {
+		if res.UserSID == "" {
+			return failureReasonPACUnavailable, "authorization data (PAC) unavailable; cannot evaluate the user SID"
+		}
+		if !containsFold(canonicalSIDs(role.BoundUserSIDs), res.UserSID) {
+			return failureReasonUserSID, "user SID not bound to role"
+		}
+	}
+
+	// Abnormally large group sets point at token bloat or a tampered account
+	if role.MaxGroupSIDs > 0 && len(res.GroupSIDs) > role.MaxGroupSIDs {
+		return failureReasonGroupLimit, "principal exceeds the role's group limit"
+	}
+
+	// Without a PAC there is no group data, which is not the same as the
+	// principal lacking membership
+	if len(role.BoundGroupSIDs) > 0 && (res.Flags["PAC_NOT_FOUND"] || res.Flags["PAC_SKIPPED"]) {
+		return failureReasonPACUnavailable, "authorization data (PAC) unavailable; cannot evaluate group membership"
+	}
+
+	// Roles stored before SIDs were canonicalized at write may hold other forms
+	if len(role.BoundGroupSIDs) > 0 && !intersects(canonicalSIDs(role.BoundGroupSIDs), canonicalSIDs(boundGroupCandidates(role, res))) {
+		return failureReasonGroup, "no bound group SID matched"
+	}
+
+	return "", ""
+}
+
+// roleTokenPolicies returns the role's token policies, or the mount's
+// default_policies when the role sets none
+func roleTokenPolicies(role *Role, cfg *Config) []string {
+	if len(role.TokenPolicies) > 0 {
+		return role.TokenPolicies
+	}
+	return cfg.DefaultPolicies
+}
+
+// reauthBefore returns when a client should log in again: the earlier of the
+// token's initial expiry and the end of the ticket that authorized it. Tokens
+// without a TTL of their own expire after the mount's default lease TTL. The
+// result is zero when neither expiry is known.
+func reauthBefore(now time.Time, auth *logical.Auth, sys logical.SystemView, ticketEnd time.Time) time.Time {
+	ttl := auth.TTL
+	if ttl == 0 {
+		ttl = auth.Period
+	}
+	if ttl == 0 {
+		ttl = sys.DefaultLeaseTTL()
+	}
+	if maxTTL := sys.MaxLeaseTTL(); maxTTL > 0 && ttl > maxTTL {
+		ttl = maxTTL
+	}
+
+	var hint time.Time
+	if ttl > 0 {
+		hint = now.Add(ttl)
+	}
+	if !ticketEnd.IsZero() && (hint.IsZero() || ticketEnd.Before(hint)) {
+		hint = ticketEnd
+	}
+	return hint
+}
+
+// roleTokenType returns the role's token type, or the mount's
+// default_token_type when the role leaves it unset
+func roleTokenType(role *Role, cfg *Config) string {
+	if role.TokenType != "" {
+		return role.TokenType
+	}
+	return cfg.DefaultTokenType
+}
+
+// boundGroupCandidates returns the group SIDs eligible for bound_group_sids
+// matching, dropping resource-domain groups when the role excludes them
+func boundGroupCandidates(role *Role, res *kerb.ValidationResult) []string {
+	if !role.ExcludeResourceGroups || len(res.ResourceGroupSIDs) == 0 {
+		return res.GroupSIDs
+	}
+	resource := make(map[string]struct{}, len(res.ResourceGroupSIDs))
+	for _, sid := range res.ResourceGroupSIDs {
+		resource[sid] = struct{}{}
+	}
+	sids := make([]string, 0, len(res.GroupSIDs))
+	for _, sid := range res.GroupSIDs {
+		if _, ok := resource[sid]; !ok {
+			sids = append(sids, sid)
+		}
+	}
+	return sids
+}
+
+// filterSIDHistory drops SID history from the group SIDs, so it can't satisfy
+// bound_group_sids or reach group aliases and policy templates
+func filterSIDHistory(res *kerb.ValidationResult) {
+	if len(res.SIDHistorySIDs) == 0 {
+		return
+	}
+	history := make(map[string]struct{}, len(res.SIDHistorySIDs))
+	for _, sid := range res.SIDHistorySIDs {
+		history[sid] = struct{}{}
+	}
+	sids := make([]string, 0, len(res.GroupSIDs))
+	for _, sid := range res.GroupSIDs {
+		if _, ok := history[sid]; !ok {
+			sids = append(sids, sid)
+		}
+	}
+	res.GroupSIDs = sids
+	res.Flags["SID_HISTORY_FILTERED"] = true
+}
+
+// filterSIDPrefixes keeps the group SIDs matching only (all when empty) and
+// then drops those matching exclude, so filtered SIDs can't satisfy
+// bound_group_sids or reach group aliases and policy templates
+func filterSIDPrefixes(res *kerb.ValidationResult, only, exclude []string) {
+	if len(only) == 0 && len(exclude) == 0 {
+		return
+	}
+	sids := make([]string, 0, len(res.GroupSIDs))
+	for _, sid := range res.GroupSIDs {
+		canonical, _ := canonicalSID(sid)
+		if (len(only) > 0 && !hasSIDPrefix(canonical, only)) || hasSIDPrefix(canonical, exclude) {
+			continue
+		}
+		sids = append(sids, sid)
+	}
+	if len(sids) < len(res.GroupSIDs) {
+		res.Flags["SID_PREFIX_FILTERED"] = true
+	}
+	res.GroupSIDs = sids
 }
 
 // validateLoginInput performs comprehensive input validation
@@ -221,7 +1163,7 @@ func (b *gmsaBackend) validateLoginInput(roleName, spnegoB64, cb string) error {
 	if spnegoB64 == "" {
 		return fmt.Errorf("spnego token is required")
 	}
-	if len(spnegoB64) > 64*1024 {
+	if len(spnegoB64) > maxSPNEGOTokenLen {
 		return fmt.Errorf("spnego token too large")
 	}
 	if !isValidBase64(spnegoB64) {
@@ -236,6 +1178,77 @@ func (b *gmsaBackend) validateLoginInput(roleName, spnegoB64, cb string) error {
 	return nil
 }
 
+// maxSPNEGOTokenLen is the largest accepted base64 SPNEGO token
+const maxSPNEGOTokenLen = 64 * 1024
+
+// maxDecompressedSPNEGOSize bounds decompression so the re-encoded token
+// still fits within maxSPNEGOTokenLen
+const maxDecompressedSPNEGOSize = maxSPNEGOTokenLen / 4 * 3
+
+// spnegoEncodings are the base64 variants accepted by tolerant decoding, in
+// the order they are tried
+var spnegoEncodings = []*base64.Encoding{
+	base64.StdEncoding,
+	base64.RawStdEncoding,
+	base64.URLEncoding,
+	base64.RawURLEncoding,
+}
+
+// canonicalSPNEGOEncoding returns the token as padded standard base64,
+// dropping whitespace and accepting unpadded or URL-safe encodings. Tokens no
+// variant decodes are returned untouched for the regular input validation to
+// reject.
+func canonicalSPNEGOEncoding(spnegoB64 string) string {
+	if spnegoB64 == "" || len(spnegoB64) > maxSPNEGOTokenLen {
+		return spnegoB64
+	}
+	s := strings.Join(strings.Fields(spnegoB64), "")
+	for _, enc := range spnegoEncodings {
+		if raw, err := enc.DecodeString(s); err == nil {
+			return base64.StdEncoding.EncodeToString(raw)
+		}
+	}
+	return spnegoB64
+}
+
+// decompressSPNEGO transparently inflates a base64 token whose payload is
+// gzip or zlib (deflate) compressed and returns it re-encoded as base64.
+// Uncompressed or undecodable tokens are returned untouched for the regular
+// input validation to handle.
+func decompressSPNEGO(spnegoB64 string) (string, error) {
+	if spnegoB64 == "" || len(spnegoB64) > maxSPNEGOTokenLen {
+		return spnegoB64, nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(spnegoB64)
+	if err != nil || len(raw) < 2 {
+		return spnegoB64, nil
+	}
+
+	var r io.ReadCloser
+	switch {
+	case raw[0] == 0x1f && raw[1] == 0x8b:
+		r, err = gzip.NewReader(bytes.NewReader(raw))
+	case raw[0] == 0x78 && (uint16(raw[0])<<8|uint16(raw[1]))%31 == 0:
+		// SPNEGO tokens start with 0x60 or 0xa1, so a zlib header is unambiguous
+		r, err = zlib.NewReader(bytes.NewReader(raw))
+	default:
+		return spnegoB64, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("invalid compressed spnego token")
+	}
+	defer r.Close()
+
+	out, err := io.ReadAll(io.LimitReader(r, maxDecompressedSPNEGOSize+1))
+	if err != nil {
+		return "", fmt.Errorf("invalid compressed spnego token")
+	}
+	if len(out) > maxDecompressedSPNEGOSize {
+		return "", fmt.Errorf("decompressed spnego token too large")
+	}
+	return base64.StdEncoding.EncodeToString(out), nil
+}
+
 // isValidRoleName validates role name format
 func isValidRoleName(name string) bool {
 	// Role names should be alphanumeric with hyphens and underscores
@@ -248,3 +1261,17 @@ func isValidBase64(s string) bool {
 	_, err := base64.StdEncoding.DecodeString(s)
 	return err == nil
 }
+
+// principalPermitted applies the mount-wide principal patterns. The deny
+// pattern wins over the allow pattern; a pattern that no longer compiles
+// rejects the login rather than silently opening the mount.
+func principalPermitted(cfg *Config, principal string) bool {
+	p := cfg.compiledPrincipalPatterns()
+	if p.err != nil {
+		return false
+	}
+	if p.deny != nil && p.deny.MatchString(principal) {
+		return false
+	}
+	return p.allow == nil || p.allow.MatchString(principal)
+}
@@ -1,15 +1,113 @@
 package backend
 
 import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/base64"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/flags"
+	"github.com/jcmturner/gokrb5/v8/iana/nametype"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+
+	"github.com/lpassig/vault-plugin-auth-gmsa/internal/kerb"
 )
 
+const testLoginSPN = "HTTP/vault.example.com"
+
+// newTestLoginConfig stores a config whose AES256 keytab can accept tokens
+// from newTestLoginSPNEGO and returns the keytab
+func newTestLoginConfig(t *testing.T, storage logical.Storage) *keytab.Keytab {
+	t.Helper()
+	kt := keytab.New()
+	if err := kt.AddEntry(testLoginSPN, "EXAMPLE.COM", "service-password", time.Now(), 1, etypeID.AES256_CTS_HMAC_SHA1_96); err != nil {
+		t.Fatalf("failed to add keytab entry: %v", err)
+	}
+	kb, err := kt.Marshal()
+	if err != nil {
+		t.Fatalf("failed to marshal keytab: %v", err)
+	}
+	if err := writeConfig(context.Background(), storage, &Config{
+		Realm:     "EXAMPLE.COM",
+		KDCs:      []string{"dc1.example.com"},
+		KeytabB64: base64.StdEncoding.EncodeToString(kb),
+		SPN:       testLoginSPN,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return kt
+}
+
+// newTestLoginSPNEGO mints a base64 SPNEGO token for user@EXAMPLE.COM that
+// the keytab accepts. Each call yields a fresh authenticator, so tokens do
+// not trip the replay cache.
+func newTestLoginSPNEGO(t *testing.T, kt *keytab.Keytab) string {
+	t.Helper()
+	return newTestLoginSPNEGOWithLifetime(t, kt, 10*time.Hour)
+}
+
+// newTestLoginSPNEGOWithLifetime is newTestLoginSPNEGO for a ticket that
+// expires after lifetime
+func newTestLoginSPNEGOWithLifetime(t *testing.T, kt *keytab.Keytab, lifetime time.Duration) string {
+	t.Helper()
+	return newTestLoginSPNEGOWithFlags(t, kt, types.NewKrbFlags(), lifetime)
+}
+
+// newTestLoginSPNEGOWithFlags is newTestLoginSPNEGOWithLifetime for a ticket
+// carrying krbFlags
+func newTestLoginSPNEGOWithFlags(t *testing.T, kt *keytab.Keytab, krbFlags asn1.BitString, lifetime time.Duration) string {
+	t.Helper()
+	return newTestLoginSPNEGOForRealm(t, kt, "EXAMPLE.COM", krbFlags, lifetime)
+}
+
+// newTestLoginSPNEGOForRealm is newTestLoginSPNEGOWithFlags for a ticket
+// issued for testLoginSPN in serviceRealm
+func newTestLoginSPNEGOForRealm(t *testing.T, kt *keytab.Keytab, serviceRealm string, krbFlags asn1.BitString, lifetime time.Duration) string {
+	t.Helper()
+	cname := types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "user")
+	sname := types.NewPrincipalName(nametype.KRB_NT_SRV_INST, testLoginSPN)
+	now := time.Now().UTC()
+	tkt, sessionKey, err := messages.NewTicket(cname, "EXAMPLE.COM", sname, serviceRealm,
+		krbFlags, kt, etypeID.AES256_CTS_HMAC_SHA1_96, 1,
+		now, now, now.Add(lifetime), now.Add(lifetime))
+	if err != nil {
+		t.Fatalf("failed to create ticket: %v", err)
+	}
+	cl := client.NewWithPassword("user", "EXAMPLE.COM", "unused", config.New())
+	negInit, err := spnego.NewNegTokenInitKRB5(cl, tkt, sessionKey)
+	if err != nil {
+		t.Fatalf("failed to create NegTokenInit: %v", err)
+	}
+	token := spnego.SPNEGOToken{Init: true, NegTokenInit: negInit}
+	b, err := token.Marshal()
+	if err != nil {
+		t.Fatalf("failed to marshal SPNEGO token: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
 func TestValidateLoginInput(t *testing.T) {
 	b := &gmsaBackend{}
 
@@ -125,6 +223,80 @@ func TestIsValidBase64(t *testing.T) {
 	}
 }
 
+func TestCanonicalSPNEGOEncoding(t *testing.T) {
+	raw := []byte{0x60, 0xfb, 0xff, 0xfe, 0x3f}
+	want := base64.StdEncoding.EncodeToString(raw)
+	tests := map[string]string{
+		"standard":        want,
+		"unpadded":        base64.RawStdEncoding.EncodeToString(raw),
+		"url-safe":        base64.URLEncoding.EncodeToString(raw),
+		"url-safe raw":    base64.RawURLEncoding.EncodeToString(raw),
+		"wrapped":         want[:4] + "\r\n" + want[4:],
+		"spaces and tabs": " " + want[:3] + "\t" + want[3:] + " ",
+	}
+	for name, in := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := canonicalSPNEGOEncoding(in); got != want {
+				t.Errorf("canonicalSPNEGOEncoding(%q) = %q, want %q", in, got, want)
+			}
+		})
+	}
+	if got := canonicalSPNEGOEncoding("invalid-base64!"); got != "invalid-base64!" {
+		t.Errorf("undecodable token changed to %q", got)
+	}
+}
+
+func TestHandleLogin_Base64Strict(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	kt := newTestLoginConfig(t, storage)
+	if err := writeRole(ctx, storage, &Role{Name: "app", TokenPolicies: []string{"app"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	login := func(spnego string) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation:  logical.UpdateOperation,
+			Path:       "login",
+			Storage:    storage,
+			Data:       map[string]interface{}{"role": "app", "spnego": spnego},
+			Connection: &logical.Connection{RemoteAddr: "127.0.0.1"},
+		})
+		if err != nil || resp == nil {
+			t.Fatalf("login failed: err=%v resp=%#v", err, resp)
+		}
+		return resp
+	}
+	urlSafe := func() string {
+		raw, err := base64.StdEncoding.DecodeString(newTestLoginSPNEGO(t, kt))
+		if err != nil {
+			t.Fatal(err)
+		}
+		enc := base64.RawURLEncoding.EncodeToString(raw)
+		return enc[:8] + " " + enc[8:]
+	}
+
+	if resp := login(urlSafe()); resp.IsError() {
+		t.Fatalf("tolerant login failed: %#v", resp)
+	}
+
+	cfg, err := readConfig(ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Base64Strict = true
+	if err := writeConfig(ctx, storage, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if resp := login(urlSafe()); !resp.IsError() || loginErrorCode(resp) != errorCodeInvalidRequest {
+		t.Errorf("strict login with URL-safe token = %#v, want %s", resp, errorCodeInvalidRequest)
+	}
+	if resp := login(newTestLoginSPNEGO(t, kt)); resp.IsError() {
+		t.Errorf("strict login with standard token failed: %#v", resp)
+	}
+}
+
 func TestHandleLogin(t *testing.T) {
 	b := &gmsaBackend{
 		logger: hclog.NewNullLogger(),
@@ -164,3 +336,1669 @@ func TestHandleLogin(t *testing.T) {
 		t.Error("handleLogin() should return error for invalid input")
 	}
 }
+
+func TestDecompressSPNEGO(t *testing.T) {
+	token := append([]byte{0x60, 0x82}, bytes.Repeat([]byte("pac-group-sid"), 500)...)
+	tokenB64 := base64.StdEncoding.EncodeToString(token)
+
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write(token)
+	gw.Close()
+
+	var zl bytes.Buffer
+	zw := zlib.NewWriter(&zl)
+	zw.Write(token)
+	zw.Close()
+
+	for name, compressed := range map[string][]byte{"gzip": gz.Bytes(), "zlib": zl.Bytes()} {
+		t.Run(name, func(t *testing.T) {
+			got, err := decompressSPNEGO(base64.StdEncoding.EncodeToString(compressed))
+			if err != nil {
+				t.Fatalf("decompressSPNEGO() error = %v", err)
+			}
+			if got != tokenB64 {
+				t.Error("decompressSPNEGO() did not restore the original token")
+			}
+		})
+	}
+
+	t.Run("uncompressed untouched", func(t *testing.T) {
+		for _, in := range []string{tokenB64, "", "invalid-base64!"} {
+			if got, err := decompressSPNEGO(in); err != nil || got != in {
+				t.Errorf("decompressSPNEGO(%q) = %q, %v; want input unchanged", in, got, err)
+			}
+		}
+	})
+
+	t.Run("bomb rejected", func(t *testing.T) {
+		var bomb bytes.Buffer
+		bw := gzip.NewWriter(&bomb)
+		bw.Write(make([]byte, 10*1024*1024))
+		bw.Close()
+		if _, err := decompressSPNEGO(base64.StdEncoding.EncodeToString(bomb.Bytes())); err == nil {
+			t.Error("expected oversized decompressed token to be rejected")
+		}
+	})
+
+	t.Run("corrupt gzip rejected", func(t *testing.T) {
+		if _, err := decompressSPNEGO(base64.StdEncoding.EncodeToString([]byte{0x1f, 0x8b, 0x00})); err == nil {
+			t.Error("expected corrupt gzip to be rejected")
+		}
+	})
+}
+
+func TestLoginChallenge_Headers(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+
+	challenge := func() (*logical.Response, error) {
+		t.Helper()
+		return b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "login",
+			Storage:   storage,
+		})
+	}
+	configure := func(negotiate NegotiateConfig) {
+		t.Helper()
+		if err := writeConfig(ctx, storage, &Config{Realm: "EXAMPLE.COM", Negotiate: negotiate}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Defaults match the official Kerberos plugin: 401 with a bare Negotiate
+	resp, err := challenge()
+	var coded logical.HTTPCodedError
+	if !errors.As(err, &coded) || coded.Code() != http.StatusUnauthorized {
+		t.Fatalf("expected 401 coded error, got %v", err)
+	}
+	if got := resp.Headers["WWW-Authenticate"]; len(got) != 1 || got[0] != "Negotiate" {
+		t.Errorf("WWW-Authenticate = %v, want [Negotiate]", got)
+	}
+
+	configure(NegotiateConfig{ChallengeStatus: http.StatusForbidden})
+	resp, err = challenge()
+	if !errors.As(err, &coded) || coded.Code() != http.StatusForbidden {
+		t.Fatalf("expected 403 coded error, got %v", err)
+	}
+	if len(resp.Headers["WWW-Authenticate"]) != 1 {
+		t.Errorf("expected challenge header with custom status, got %v", resp.Headers)
+	}
+
+	configure(NegotiateConfig{SuppressChallenge: true})
+	resp, err = challenge()
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected plain error response with challenge suppressed, got %#v, %v", resp, err)
+	}
+	if _, ok := resp.Headers["WWW-Authenticate"]; ok {
+		t.Errorf("unexpected WWW-Authenticate header: %v", resp.Headers)
+	}
+}
+
+func TestNegotiateSuccessHeaders(t *testing.T) {
+	headers, err := negotiateSuccessHeaders(NegotiateConfig{})
+	if err != nil || headers != nil {
+		t.Errorf("default headers = %v, %v; want none", headers, err)
+	}
+
+	headers, err = negotiateSuccessHeaders(NegotiateConfig{ResponseToken: true})
+	if err != nil {
+		t.Fatalf("negotiateSuccessHeaders() error = %v", err)
+	}
+	got := headers["WWW-Authenticate"]
+	if len(got) != 1 || !strings.HasPrefix(got[0], "Negotiate ") || len(got[0]) <= len("Negotiate ") {
+		t.Errorf("WWW-Authenticate = %v, want Negotiate with a token", got)
+	}
+}
+
+func TestConfigValidate_NegotiateChallengeStatus(t *testing.T) {
+	for status, wantErr := range map[int]bool{0: false, 400: false, 401: false, 403: false, 200: true, 500: true} {
+		err := validateNegotiateConfig(NegotiateConfig{ChallengeStatus: status})
+		if (err != nil) != wantErr {
+			t.Errorf("status %d: error = %v, wantErr %v", status, err, wantErr)
+		}
+	}
+}
+
+func TestLoginMetadata_UserSID(t *testing.T) {
+	role := &Role{Name: "app"}
+	res := &kerb.ValidationResult{Principal: "svc-app$@EXAMPLE.COM", UserSID: "S-1-5-21-1-2-3-1104"}
+	if got := loginMetadata(role, &Config{}, res)["user_sid"]; got != "S-1-5-21-1-2-3-1104" {
+		t.Errorf("user_sid = %q, want S-1-5-21-1-2-3-1104", got)
+	}
+	res.UserSID = ""
+	if _, ok := loginMetadata(role, &Config{}, res)["user_sid"]; ok {
+		t.Error("user_sid emitted without a PAC")
+	}
+}
+
+func TestLoginMetadata_LogonServer(t *testing.T) {
+	role := &Role{Name: "app"}
+	res := &kerb.ValidationResult{
+		Principal:   "svc-app$@EXAMPLE.COM",
+		Realm:       "EXAMPLE.COM",
+		SPN:         "HTTP/vault.example.com",
+		LogonServer: "DC01",
+	}
+
+	md := loginMetadata(role, &Config{LogonServerMeta: true}, res)
+	if got := md["logon_server"]; got != "DC01" {
+		t.Errorf("logon_server = %q, want %q", got, "DC01")
+	}
+
+	if md := loginMetadata(role, &Config{}, res); md["logon_server"] != "" {
+		t.Errorf("logon_server emitted without logon_server_metadata: %q", md["logon_server"])
+	}
+
+	res.LogonServer = ""
+	if _, ok := loginMetadata(role, &Config{LogonServerMeta: true}, res)["logon_server"]; ok {
+		t.Error("logon_server emitted when the PAC carries none")
+	}
+}
+
+func TestGroupAliases(t *testing.T) {
+	sids := []string{"S-1-5-21-1-2-3-513", "S-1-5-21-1-2-3-1104", "S-1-5-21-1-2-3-513"}
+	aliases := groupAliases(sids, "auth_gmsa_1234")
+
+	want := []string{"S-1-5-21-1-2-3-513", "S-1-5-21-1-2-3-1104"}
+	if len(aliases) != len(want) {
+		t.Fatalf("got %d aliases, want %d", len(aliases), len(want))
+	}
+	for i, alias := range aliases {
+		if alias.Name != want[i] {
+			t.Errorf("alias[%d].Name = %q, want %q", i, alias.Name, want[i])
+		}
+		if alias.MountAccessor != "auth_gmsa_1234" {
+			t.Errorf("alias[%d].MountAccessor = %q", i, alias.MountAccessor)
+		}
+	}
+
+	if got := groupAliases(nil, "auth_gmsa_1234"); got != nil {
+		t.Errorf("groupAliases(nil) = %v, want nil", got)
+	}
+}
+
+func TestHandleLogin_EmitGroupAliases(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	kt := newTestLoginConfig(t, storage)
+	if err := writeRole(ctx, storage, &Role{Name: "app"}); err != nil {
+		t.Fatal(err)
+	}
+
+	login := func() *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation:     logical.UpdateOperation,
+			Path:          "login",
+			Storage:       storage,
+			MountAccessor: "auth_gmsa_1234",
+			Data:          map[string]interface{}{"role": "app", "spnego": newTestLoginSPNEGO(t, kt)},
+			Connection:    &logical.Connection{RemoteAddr: "127.0.0.1"},
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("login failed: err=%v resp=%#v", err, resp)
+		}
+		return resp
+	}
+
+	resp := login()
+	if resp.Auth.Alias != nil || resp.Auth.GroupAliases != nil {
+		t.Errorf("aliases emitted while disabled: %v, %v", resp.Auth.Alias, resp.Auth.GroupAliases)
+	}
+
+	cfg, err := readConfig(ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.EmitGroupAliases = true
+	if err := writeConfig(ctx, storage, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	resp = login()
+	if resp.Auth.Alias == nil || resp.Auth.Alias.Name != "user@EXAMPLE.COM" {
+		t.Errorf("Alias = %v, want user@EXAMPLE.COM", resp.Auth.Alias)
+	}
+	sids := resp.Auth.Metadata["sids_count"]
+	if got := fmt.Sprintf("%d", len(resp.Auth.GroupAliases)); got != sids {
+		t.Errorf("got %s group aliases for %s group SIDs", got, sids)
+	}
+}
+
+func TestAccountTypeAllowed(t *testing.T) {
+	user := map[string]bool{"ACCEPTED": true, "PAC_VALIDATED": true}
+	machine := map[string]bool{"ACCEPTED": true, "PAC_VALIDATED": true, "IS_MACHINE_ACCOUNT": true}
+	noPAC := map[string]bool{"ACCEPTED": true, "PAC_NOT_FOUND": true}
+	badPAC := map[string]bool{"ACCEPTED": true, "PAC_VALIDATION_FAILED": true}
+	unknown := map[string]bool{"ACCEPTED": true, "PAC_VALIDATED": true, "ACCOUNT_TYPE_UNKNOWN": true}
+	tests := []struct {
+		accountType string
+		flags       map[string]bool
+		want        bool
+	}{
+		{"", user, true},
+		{"", machine, true},
+		{accountTypeAny, noPAC, true},
+		{accountTypeUser, user, true},
+		{accountTypeUser, machine, false},
+		{accountTypeUser, noPAC, false},
+		{accountTypeMachine, machine, true},
+		{accountTypeMachine, user, false},
+		{accountTypeMachine, badPAC, false},
+		{accountTypeUser, unknown, false},
+		{accountTypeMachine, unknown, false},
+		{accountTypeAny, unknown, true},
+	}
+	for _, tt := range tests {
+		if got := accountTypeAllowed(&Role{AccountType: tt.accountType}, tt.flags); got != tt.want {
+			t.Errorf("accountTypeAllowed(%q, %v) = %t, want %t", tt.accountType, tt.flags, got, tt.want)
+		}
+	}
+}
+
+func TestHandleLogin_AccountType(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	kt := newTestLoginConfig(t, storage)
+
+	// The test ticket carries no PAC, so only account_type=any admits it
+	for accountType, wantOK := range map[string]bool{accountTypeAny: true, accountTypeUser: false, accountTypeMachine: false} {
+		if err := writeRole(ctx, storage, &Role{Name: "app", AccountType: accountType}); err != nil {
+			t.Fatal(err)
+		}
+		before := failureReasonCount(failureReasonAccountType)
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation:  logical.UpdateOperation,
+			Path:       "login",
+			Storage:    storage,
+			Data:       map[string]interface{}{"role": "app", "spnego": newTestLoginSPNEGO(t, kt)},
+			Connection: &logical.Connection{RemoteAddr: "127.0.0.1"},
+		})
+		if err != nil || resp == nil {
+			t.Fatalf("account_type=%s: err=%v resp=%#v", accountType, err, resp)
+		}
+		if wantOK {
+			if resp.IsError() {
+				t.Errorf("account_type=%s: unexpected error %v", accountType, resp.Error())
+			}
+			continue
+		}
+		if !resp.IsError() || loginErrorCode(resp) != errorCodeAccountType {
+			t.Errorf("account_type=%s: expected %s rejection, got %#v", accountType, errorCodeAccountType, resp)
+		}
+		if got := failureReasonCount(failureReasonAccountType); got != before+1 {
+			t.Errorf("account_type=%s: failures = %d, want %d", accountType, got, before+1)
+		}
+	}
+}
+
+func TestRoleWrite_AccountType(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+
+	for accountType, wantErr := range map[string]bool{"": false, accountTypeUser: false, accountTypeMachine: false, accountTypeAny: false, "computer": true} {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "role/app",
+			Storage:   storage,
+			Data:      map[string]interface{}{"account_type": accountType},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := resp != nil && resp.IsError(); got != wantErr {
+			t.Errorf("account_type=%q: IsError() = %t, want %t: %#v", accountType, got, wantErr, resp)
+		}
+	}
+}
+
+func TestAliasName(t *testing.T) {
+	full := &kerb.ValidationResult{Principal: "user@EXAMPLE.COM", UserSID: "S-1-5-21-1-2-3-1104", UPN: "user@example.com"}
+	bare := &kerb.ValidationResult{Principal: "user@EXAMPLE.COM"}
+	tests := []struct {
+		source string
+		res    *kerb.ValidationResult
+		want   string
+		ok     bool
+	}{
+		{"", full, "user@EXAMPLE.COM", true},
+		{aliasSourcePrincipal, bare, "user@EXAMPLE.COM", true},
+		{aliasSourceSID, full, "S-1-5-21-1-2-3-1104", true},
+		{aliasSourceSID, bare, "", false},
+		{aliasSourceUPN, full, "user@example.com", true},
+		{aliasSourceUPN, bare, "", false},
+	}
+	for _, tt := range tests {
+		got, ok := aliasName(&Config{AliasSource: tt.source}, tt.res)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("aliasName(%q, %+v) = %q, %t, want %q, %t", tt.source, tt.res, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestHandleLogin_AliasSource(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	kt := newTestLoginConfig(t, storage)
+	if err := writeRole(ctx, storage, &Role{Name: "app"}); err != nil {
+		t.Fatal(err)
+	}
+
+	login := func(source string) *logical.Response {
+		t.Helper()
+		cfg, err := readConfig(ctx, storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.AliasSource = source
+		if err := writeConfig(ctx, storage, cfg); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation:  logical.UpdateOperation,
+			Path:       "login",
+			Storage:    storage,
+			Data:       map[string]interface{}{"role": "app", "spnego": newTestLoginSPNEGO(t, kt)},
+			Connection: &logical.Connection{RemoteAddr: "127.0.0.1"},
+		})
+		if err != nil || resp == nil {
+			t.Fatalf("login failed: err=%v resp=%#v", err, resp)
+		}
+		return resp
+	}
+
+	resp := login(aliasSourcePrincipal)
+	if resp.IsError() || resp.Auth.Alias == nil || resp.Auth.Alias.Name != "user@EXAMPLE.COM" {
+		t.Fatalf("Alias = %#v, want user@EXAMPLE.COM", resp)
+	}
+	if resp.Auth.GroupAliases != nil {
+		t.Errorf("group aliases emitted without emit_group_aliases: %v", resp.Auth.GroupAliases)
+	}
+
+	// The test ticket has no PAC, so there is no SID or UPN to alias on
+	for _, source := range []string{aliasSourceSID, aliasSourceUPN} {
+		before := failureReasonCount(failureReasonAliasMissing)
+		resp = login(source)
+		if !resp.IsError() || loginErrorCode(resp) != errorCodeAliasUnavailable {
+			t.Errorf("alias_source=%s: expected %s rejection, got %#v", source, errorCodeAliasUnavailable, resp)
+		}
+		if got := failureReasonCount(failureReasonAliasMissing); got != before+1 {
+			t.Errorf("alias_source=%s: alias_unavailable failures = %d, want %d", source, got, before+1)
+		}
+	}
+}
+
+func TestPACValidationData(t *testing.T) {
+	tests := []struct {
+		name  string
+		flags map[string]bool
+		want  map[string]bool
+		errs  []string
+	}{
+		{
+			"validated",
+			map[string]bool{"ACCEPTED": true, "PAC_VALIDATED": true, "SIGNATURES_VALID": true, "CLOCK_SKEW_VALID": true, "UPN_CONSISTENT": true},
+			map[string]bool{"accepted": true, "pac_validated": true, "signatures_valid": true, "clock_skew_valid": true, "upn_consistent": true},
+			[]string{},
+		},
+		{
+			"not found",
+			map[string]bool{"ACCEPTED": true, "PAC_NOT_FOUND": true},
+			map[string]bool{"accepted": true},
+			[]string{"pac_not_found"},
+		},
+		{
+			"validation error",
+			map[string]bool{"ACCEPTED": true, "PREVIOUS_KEYTAB": true, "PAC_VALIDATION_FAILED": true, "PAC_ERROR": true},
+			map[string]bool{"accepted": true, "previous_keytab": true},
+			[]string{"pac_validation_failed", "pac_error"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := pacValidationData(tt.flags)
+			for _, flag := range pacValidationFlags {
+				key := strings.ToLower(flag)
+				v, ok := got[key].(bool)
+				if !ok {
+					t.Fatalf("%s = %#v, want a bool", key, got[key])
+				}
+				if v != tt.want[key] {
+					t.Errorf("%s = %t, want %t", key, v, tt.want[key])
+				}
+			}
+			if errs, _ := got["errors"].([]string); !reflect.DeepEqual(errs, tt.errs) {
+				t.Errorf("errors = %#v, want %#v", got["errors"], tt.errs)
+			}
+		})
+	}
+}
+
+func TestHandleLogin_PACValidationData(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	kt := newTestLoginConfig(t, storage)
+	if err := writeRole(ctx, storage, &Role{Name: "app", TokenPolicies: []string{"app"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation:  logical.UpdateOperation,
+		Path:       "login",
+		Storage:    storage,
+		Data:       map[string]interface{}{"role": "app", "spnego": newTestLoginSPNEGO(t, kt)},
+		Connection: &logical.Connection{RemoteAddr: "127.0.0.1"},
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("login failed: err=%v resp=%#v", err, resp)
+	}
+
+	pv, ok := resp.Data["pac_validation"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("pac_validation missing from response data: %#v", resp.Data)
+	}
+	// The structured object agrees with the pac_* metadata flags
+	for _, flag := range pacValidationFlags {
+		key := strings.ToLower(flag)
+		want := resp.Auth.Metadata["pac_"+flag] == "true"
+		if pv[key] != want {
+			t.Errorf("pac_validation.%s = %v, metadata pac_%s = %q", key, pv[key], flag, resp.Auth.Metadata["pac_"+flag])
+		}
+	}
+	// The test tickets carry no PAC
+	if errs, _ := pv["errors"].([]string); !reflect.DeepEqual(errs, []string{"pac_not_found"}) {
+		t.Errorf("pac_validation.errors = %#v, want [pac_not_found]", pv["errors"])
+	}
+}
+
+func TestHandleLogin_DisablePACProcessing(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	kt := newTestLoginConfig(t, storage)
+	if err := writeRole(ctx, storage, &Role{Name: "app", TokenPolicies: []string{"app"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeRole(ctx, storage, &Role{Name: "groups", BoundGroupSIDs: []string{"S-1-5-21-1-2-3-513"}}); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := readConfig(ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeCfg := func() *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "config",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"realm":                  cfg.Realm,
+				"kdcs":                   "dc1.example.com",
+				"keytab":                 cfg.KeytabB64,
+				"spn":                    cfg.SPN,
+				"disable_pac_processing": true,
+			},
+		})
+		if err != nil || resp == nil {
+			t.Fatalf("config write: err=%v resp=%#v", err, resp)
+		}
+		return resp
+	}
+
+	// Roles binding groups would never match without a PAC
+	if resp := writeCfg(); !resp.IsError() || !strings.Contains(resp.Error().Error(), "groups") {
+		t.Fatalf("expected the group-bound role to block the config, got %#v", resp)
+	}
+	if err := deleteRole(ctx, storage, "groups"); err != nil {
+		t.Fatal(err)
+	}
+	if resp := writeCfg(); resp.IsError() {
+		t.Fatalf("config write failed: %#v", resp)
+	}
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/groups",
+		Storage:   storage,
+		Data:      map[string]interface{}{"bound_group_sids": "S-1-5-21-1-2-3-513"},
+	})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected bound_group_sids to be rejected, got err=%v resp=%#v", err, resp)
+	}
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation:  logical.UpdateOperation,
+		Path:       "login",
+		Storage:    storage,
+		Data:       map[string]interface{}{"role": "app", "spnego": newTestLoginSPNEGO(t, kt)},
+		Connection: &logical.Connection{RemoteAddr: "127.0.0.1"},
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("login failed: err=%v resp=%#v", err, resp)
+	}
+	md := resp.Auth.Metadata
+	if md["pac_PAC_SKIPPED"] != "true" || md["pac_PAC_NOT_FOUND"] != "" || md["security_warning"] != "" {
+		t.Errorf("metadata = %v, want PAC_SKIPPED without a PAC_NOT_FOUND warning", md)
+	}
+	if _, ok := md["user_sid"]; ok || len(resp.Auth.GroupAliases) != 0 {
+		t.Errorf("login carries PAC data: metadata=%v group aliases=%v", md, resp.Auth.GroupAliases)
+	}
+	pv, _ := resp.Data["pac_validation"].(map[string]interface{})
+	if pv["pac_skipped"] != true {
+		t.Errorf("pac_validation = %v, want pac_skipped", pv)
+	}
+}
+
+func TestSPNHostMatches(t *testing.T) {
+	tests := []struct {
+		spn  string
+		host string
+		want bool
+	}{
+		{"HTTP/vault.example.com", "vault.example.com", true},
+		{"HTTP/vault.example.com", "Vault.Example.COM:8200", true},
+		{"HTTP/vault.example.com@EXAMPLE.COM", "vault.example.com.", true},
+		{"HTTP/vault.example.com", "other.example.com", false},
+		{"HTTP/vault.example.com", "vault.example.com.evil.com", false},
+		{"HTTP/fe80::1", "[fe80::1]:8200", true},
+		{"MSSQLSvc/db.example.com:1433", "db.example.com:1433", true},
+		{"ldap/dc1.example.com/example.com", "dc1.example.com", true},
+		{"ldap/dc1.example.com/example.com", "example.com", false},
+		{"HTTP/", "vault.example.com", false},
+		{"HTTP", "HTTP", false},
+	}
+
+	for _, tt := range tests {
+		if got := spnHostMatches(tt.spn, tt.host); got != tt.want {
+			t.Errorf("spnHostMatches(%q, %q) = %t, want %t", tt.spn, tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestHandleLogin_VerifySPNMatchesHost(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	kt := newTestLoginConfig(t, storage)
+	cfg, err := readConfig(ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.VerifySPNHost = true
+	if err := writeConfig(ctx, storage, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeRole(ctx, storage, &Role{Name: "app", TokenPolicies: []string{"app"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	login := func(headers map[string][]string) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation:  logical.UpdateOperation,
+			Path:       "login",
+			Storage:    storage,
+			Headers:    headers,
+			Data:       map[string]interface{}{"role": "app", "spnego": newTestLoginSPNEGO(t, kt)},
+			Connection: &logical.Connection{RemoteAddr: "127.0.0.1"},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return resp
+	}
+
+	if resp := login(map[string][]string{"X-Forwarded-Host": {"VAULT.example.com:8200, lb.internal"}}); resp == nil || resp.IsError() {
+		t.Fatalf("matching X-Forwarded-Host rejected: %#v", resp)
+	}
+
+	before := failureReasonCount(failureReasonSPNHost)
+	resp := login(map[string][]string{"X-Forwarded-Host": {"other.example.com"}})
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("mismatched X-Forwarded-Host accepted: %#v", resp)
+	}
+	if got := failureReasonCount(failureReasonSPNHost); got != before+1 {
+		t.Errorf("%s = %d, want %d", failureReasonSPNHost, got, before+1)
+	}
+
+	// Without the header there is nothing to compare against, so the check
+	// fails closed; Host itself is never consulted
+	for _, headers := range []map[string][]string{nil, {"Host": {"vault.example.com"}}} {
+		if resp := login(headers); !resp.IsError() || loginErrorCode(resp) != errorCodeSPNHostMismatch {
+			t.Errorf("login with headers %v = %#v, want %s", headers, resp, errorCodeSPNHostMismatch)
+		}
+	}
+
+	// spn_host_header names another header
+	cfg.SPNHostHeader = "X-Original-Host"
+	if err := writeConfig(ctx, storage, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if resp := login(map[string][]string{"X-Original-Host": {"vault.example.com"}}); resp == nil || resp.IsError() {
+		t.Fatalf("matching X-Original-Host rejected: %#v", resp)
+	}
+	if resp := login(map[string][]string{"X-Forwarded-Host": {"vault.example.com"}}); !resp.IsError() {
+		t.Errorf("X-Forwarded-Host consulted instead of X-Original-Host: %#v", resp)
+	}
+}
+
+func TestHandleLogin_TTLFromTicket(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	kt := newTestLoginConfig(t, storage)
+
+	for _, role := range []*Role{
+		{Name: "capped", TokenPolicies: []string{"app"}, MaxTTL: 8 * 3600, TTLFromTicket: true},
+		{Name: "capped-periodic", TokenPolicies: []string{"app"}, Period: 8 * 3600, TTLFromTicket: true},
+		{Name: "short-role", TokenPolicies: []string{"app"}, MaxTTL: 600, TTLFromTicket: true},
+		{Name: "uncapped", TokenPolicies: []string{"app"}, MaxTTL: 8 * 3600},
+	} {
+		if err := writeRole(ctx, storage, role); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	login := func(role string, lifetime time.Duration) *logical.Auth {
+		t.Helper()
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation:  logical.UpdateOperation,
+			Path:       "login",
+			Storage:    storage,
+			Data:       map[string]interface{}{"role": role, "spnego": newTestLoginSPNEGOWithLifetime(t, kt, lifetime)},
+			Connection: &logical.Connection{RemoteAddr: "127.0.0.1"},
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("login failed: err=%v resp=%#v", err, resp)
+		}
+		return resp.Auth
+	}
+
+	// A ticket with an hour left caps an 8h role TTL at about an hour
+	auth := login("capped", time.Hour)
+	if auth.TTL > time.Hour || auth.TTL < 59*time.Minute {
+		t.Errorf("TTL = %v, want about 1h", auth.TTL)
+	}
+	if auth.MaxTTL != auth.TTL {
+		t.Errorf("MaxTTL = %v, want %v", auth.MaxTTL, auth.TTL)
+	}
+
+	auth = login("capped-periodic", time.Hour)
+	if auth.Period > time.Hour || auth.MaxTTL > time.Hour {
+		t.Errorf("Period = %v, MaxTTL = %v, want both capped at 1h", auth.Period, auth.MaxTTL)
+	}
+
+	// A role TTL shorter than the ticket is kept
+	if auth = login("short-role", time.Hour); auth.TTL != 10*time.Minute {
+		t.Errorf("TTL = %v, want the role's 10m", auth.TTL)
+	}
+
+	if auth = login("uncapped", time.Hour); auth.TTL != 8*time.Hour || auth.MaxTTL != 0 {
+		t.Errorf("TTL = %v, MaxTTL = %v, want 8h and unset without ttl_from_ticket", auth.TTL, auth.MaxTTL)
+	}
+}
+
+func TestHandleLogin_ReauthBefore(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	kt := newTestLoginConfig(t, storage)
+	now := time.Now().UTC().Truncate(time.Second)
+	b.now = func() time.Time { return now }
+	if err := writeRole(ctx, storage, &Role{Name: "app", TokenPolicies: []string{"app"}, MaxTTL: 2 * 3600}); err != nil {
+		t.Fatal(err)
+	}
+
+	reauthBefore := func(lifetime time.Duration) time.Time {
+		t.Helper()
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation:  logical.UpdateOperation,
+			Path:       "login",
+			Storage:    storage,
+			Data:       map[string]interface{}{"role": "app", "spnego": newTestLoginSPNEGOWithLifetime(t, kt, lifetime)},
+			Connection: &logical.Connection{RemoteAddr: "127.0.0.1"},
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("login failed: err=%v resp=%#v", err, resp)
+		}
+		hint, err := time.Parse(time.RFC3339, resp.Data["reauth_before"].(string))
+		if err != nil {
+			t.Fatalf("reauth_before: %v", err)
+		}
+		return hint
+	}
+
+	// The 2h token expires before a 10h ticket
+	if hint := reauthBefore(10 * time.Hour); !hint.Equal(now.Add(2 * time.Hour)) {
+		t.Errorf("reauth_before = %v, want the token expiry %v", hint, now.Add(2*time.Hour))
+	}
+	// A ticket ending in 30m comes first
+	if hint := reauthBefore(30 * time.Minute); hint.Before(now.Add(29*time.Minute)) || hint.After(now.Add(31*time.Minute)) {
+		t.Errorf("reauth_before = %v, want the ticket end about %v", hint, now.Add(30*time.Minute))
+	}
+}
+
+func TestReauthBefore_MountDefaults(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	ticketEnd := now.Add(10 * time.Hour)
+	sys := &logical.StaticSystemView{DefaultLeaseTTLVal: time.Hour, MaxLeaseTTLVal: 4 * time.Hour}
+
+	tests := []struct {
+		name      string
+		auth      *logical.Auth
+		sys       logical.SystemView
+		ticketEnd time.Time
+		want      time.Time
+	}{
+		{"mount default TTL", &logical.Auth{}, sys, ticketEnd, now.Add(time.Hour)},
+		{"period", &logical.Auth{Period: 3 * time.Hour}, sys, ticketEnd, now.Add(3 * time.Hour)},
+		{"capped by mount max", &logical.Auth{LeaseOptions: logical.LeaseOptions{TTL: 8 * time.Hour}}, sys, ticketEnd, now.Add(4 * time.Hour)},
+		{"ticket first", &logical.Auth{LeaseOptions: logical.LeaseOptions{TTL: 8 * time.Hour}}, &logical.StaticSystemView{}, now.Add(time.Minute), now.Add(time.Minute)},
+		{"no ticket end", &logical.Auth{LeaseOptions: logical.LeaseOptions{TTL: 8 * time.Hour}}, &logical.StaticSystemView{}, time.Time{}, now.Add(8 * time.Hour)},
+		{"nothing known", &logical.Auth{}, &logical.StaticSystemView{}, time.Time{}, time.Time{}},
+	}
+	for _, tt := range tests {
+		if got := reauthBefore(now, tt.auth, tt.sys, tt.ticketEnd); !got.Equal(tt.want) {
+			t.Errorf("%s: reauthBefore() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHandleLogin_ExposeGroupSIDsInResponse(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	kt := newTestLoginConfig(t, storage)
+	if err := writeRole(ctx, storage, &Role{Name: "app", TokenPolicies: []string{"app"}}); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := readConfig(ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	login := func() *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation:  logical.UpdateOperation,
+			Path:       "login",
+			Storage:    storage,
+			Data:       map[string]interface{}{"role": "app", "spnego": newTestLoginSPNEGO(t, kt)},
+			Connection: &logical.Connection{RemoteAddr: "127.0.0.1"},
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("login failed: err=%v resp=%#v", err, resp)
+		}
+		return resp
+	}
+
+	if _, ok := login().Data["group_sids"]; ok {
+		t.Fatal("group_sids returned while expose_group_sids_in_response is off")
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"realm":                         cfg.Realm,
+			"kdcs":                          "dc1.example.com",
+			"keytab":                        cfg.KeytabB64,
+			"spn":                           cfg.SPN,
+			"expose_group_sids_in_response": true,
+		},
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("config write failed: err=%v resp=%#v", err, resp)
+	}
+	if len(resp.Warnings) != 1 || !strings.Contains(resp.Warnings[0], "expose_group_sids_in_response") {
+		t.Errorf("warnings = %v, want one about expose_group_sids_in_response", resp.Warnings)
+	}
+
+	// The test tickets carry no PAC, so the list is present but empty
+	sids, ok := login().Data["group_sids"].([]string)
+	if !ok || len(sids) != 0 {
+		t.Errorf("group_sids = %#v, want an empty list", sids)
+	}
+}
+
+func TestHandleLogin_RequireSPNRealmMatch(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	kt := newTestLoginConfig(t, storage)
+	if err := writeRole(ctx, storage, &Role{Name: "app", TokenPolicies: []string{"app"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A cross-realm keytab holding the same SPN in OTHER.COM
+	other := keytab.New()
+	if err := other.AddEntry(testLoginSPN, "OTHER.COM", "other-password", time.Now(), 1, etypeID.AES256_CTS_HMAC_SHA1_96); err != nil {
+		t.Fatal(err)
+	}
+	ob, err := other.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := readConfig(ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.AdditionalKeytabs = []string{base64.StdEncoding.EncodeToString(ob)}
+	if err := writeConfig(ctx, storage, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	login := func(spnego string) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation:  logical.UpdateOperation,
+			Path:       "login",
+			Storage:    storage,
+			Data:       map[string]interface{}{"role": "app", "spnego": spnego},
+			Connection: &logical.Connection{RemoteAddr: "127.0.0.1"},
+		})
+		if err != nil || resp == nil {
+			t.Fatalf("unexpected result: err=%v resp=%#v", err, resp)
+		}
+		return resp
+	}
+	otherRealm := func() string {
+		return newTestLoginSPNEGOForRealm(t, other, "OTHER.COM", types.NewKrbFlags(), 10*time.Hour)
+	}
+
+	// Without the option the merged keytab accepts the other realm's ticket
+	if resp := login(otherRealm()); resp.IsError() {
+		t.Fatalf("login failed without require_spn_realm_match: %#v", resp)
+	}
+
+	cfg.RequireSPNRealmMatch = true
+	if err := writeConfig(ctx, storage, cfg); err != nil {
+		t.Fatal(err)
+	}
+	before := failureReasonCount(failureReasonSPNRealm)
+	resp := login(otherRealm())
+	if !resp.IsError() || loginErrorCode(resp) != errorCodeSPNRealmMismatch {
+		t.Fatalf("expected %s, got %#v", errorCodeSPNRealmMismatch, resp)
+	}
+	if got := failureReasonCount(failureReasonSPNRealm); got != before+1 {
+		t.Errorf("%s = %d, want %d", failureReasonSPNRealm, got, before+1)
+	}
+	if resp := login(newTestLoginSPNEGO(t, kt)); resp.IsError() {
+		t.Fatalf("login for the configured realm failed: %#v", resp)
+	}
+}
+
+func TestHandleLogin_BoundClientCertCNs(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	kt := newTestLoginConfig(t, storage)
+	if err := writeRole(ctx, storage, &Role{Name: "mtls", TokenPolicies: []string{"app"}, BoundClientCertCNs: []string{"vault-client-a", "vault-client-b"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// connWithCert simulates a Vault client TLS connection; nil cn means no
+	// client certificate was presented
+	connWithCert := func(cn *string) *logical.Connection {
+		conn := &logical.Connection{RemoteAddr: "127.0.0.1"}
+		if cn != nil {
+			conn.ConnState = &tls.ConnectionState{
+				PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: *cn}}},
+			}
+		}
+		return conn
+	}
+	login := func(conn *logical.Connection) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation:  logical.UpdateOperation,
+			Path:       "login",
+			Storage:    storage,
+			Data:       map[string]interface{}{"role": "mtls", "spnego": newTestLoginSPNEGO(t, kt)},
+			Connection: conn,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return resp
+	}
+
+	match, other := "VAULT-CLIENT-B", "someone-else"
+	if resp := login(connWithCert(&match)); resp == nil || resp.IsError() {
+		t.Fatalf("bound client cert rejected: %#v", resp)
+	}
+
+	before := failureReasonCount(failureReasonClientCert)
+	resp := login(connWithCert(&other))
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "not permitted") {
+		t.Fatalf("unbound client cert accepted: %#v", resp)
+	}
+	resp = login(connWithCert(nil))
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "requires a TLS client certificate") {
+		t.Fatalf("login without client cert accepted: %#v", resp)
+	}
+	if got := failureReasonCount(failureReasonClientCert); got != before+2 {
+		t.Errorf("%s = %d, want %d", failureReasonClientCert, got, before+2)
+	}
+}
+
+func TestHandleLogin_MinKVNO(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	kt := newTestLoginConfig(t, storage) // test tickets are issued at kvno 1
+
+	tests := []struct {
+		minKVNO int
+		wantErr bool
+	}{
+		{0, false},
+		{1, false},
+		{2, true},
+		{5, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("min_kvno=%d", tt.minKVNO), func(t *testing.T) {
+			if err := writeRole(ctx, storage, &Role{Name: "sensitive", TokenPolicies: []string{"app"}, MinKVNO: tt.minKVNO}); err != nil {
+				t.Fatal(err)
+			}
+			before := failureReasonCount(failureReasonStaleTicket)
+			resp, err := b.HandleRequest(ctx, &logical.Request{
+				Operation:  logical.UpdateOperation,
+				Path:       "login",
+				Storage:    storage,
+				Data:       map[string]interface{}{"role": "sensitive", "spnego": newTestLoginSPNEGO(t, kt)},
+				Connection: &logical.Connection{RemoteAddr: "127.0.0.1"},
+			})
+			if err != nil || resp == nil {
+				t.Fatalf("unexpected result: err=%v resp=%#v", err, resp)
+			}
+			if resp.IsError() != tt.wantErr {
+				t.Fatalf("IsError() = %t, want %t: %#v", resp.IsError(), tt.wantErr, resp)
+			}
+			want := before
+			if tt.wantErr {
+				want++
+				if !strings.Contains(resp.Error().Error(), "obtain a fresh service ticket") {
+					t.Errorf("error = %q", resp.Error())
+				}
+			}
+			if got := failureReasonCount(failureReasonStaleTicket); got != want {
+				t.Errorf("%s = %d, want %d", failureReasonStaleTicket, got, want)
+			}
+		})
+	}
+}
+
+func TestHandleLogin_RequireInitial(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	kt := newTestLoginConfig(t, storage)
+
+	initial := types.NewKrbFlags()
+	types.SetFlag(&initial, flags.Initial)
+
+	tests := []struct {
+		name           string
+		requireInitial bool
+		krbFlags       asn1.BitString
+		wantErr        bool
+	}{
+		{name: "not required, TGS ticket", requireInitial: false, krbFlags: types.NewKrbFlags()},
+		{name: "required, AS ticket", requireInitial: true, krbFlags: initial},
+		{name: "required, TGS ticket", requireInitial: true, krbFlags: types.NewKrbFlags(), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := writeRole(ctx, storage, &Role{Name: "fresh", TokenPolicies: []string{"app"}, RequireInitial: tt.requireInitial}); err != nil {
+				t.Fatal(err)
+			}
+			before := failureReasonCount(failureReasonNotInitial)
+			resp, err := b.HandleRequest(ctx, &logical.Request{
+				Operation:  logical.UpdateOperation,
+				Path:       "login",
+				Storage:    storage,
+				Data:       map[string]interface{}{"role": "fresh", "spnego": newTestLoginSPNEGOWithFlags(t, kt, tt.krbFlags, 10*time.Hour)},
+				Connection: &logical.Connection{RemoteAddr: "127.0.0.1"},
+			})
+			if err != nil || resp == nil {
+				t.Fatalf("unexpected result: err=%v resp=%#v", err, resp)
+			}
+			if resp.IsError() != tt.wantErr {
+				t.Fatalf("IsError() = %t, want %t: %#v", resp.IsError(), tt.wantErr, resp)
+			}
+			want := before
+			if tt.wantErr {
+				want++
+				if got := loginErrorCode(resp); got != errorCodeInitialRequired {
+					t.Errorf("error_code = %q, want %q", got, errorCodeInitialRequired)
+				}
+			}
+			if got := failureReasonCount(failureReasonNotInitial); got != want {
+				t.Errorf("%s = %d, want %d", failureReasonNotInitial, got, want)
+			}
+		})
+	}
+}
+
+func TestHandleLogin_MaxSPNEGOBytes(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	kt := newTestLoginConfig(t, storage)
+
+	// max is relative to the token size; 0 leaves only the global limit
+	tests := []struct {
+		name    string
+		max     func(size int) int
+		wantErr bool
+	}{
+		{"global limit only", func(int) int { return 0 }, false},
+		{"above token size", func(n int) int { return n + 1 }, false},
+		{"equal to token size", func(n int) int { return n }, false},
+		{"below token size", func(n int) int { return n - 1 }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := newTestLoginSPNEGO(t, kt) // fresh ticket avoids replay rejection
+			if err := writeRole(ctx, storage, &Role{Name: "small", TokenPolicies: []string{"app"}, MaxSPNEGOBytes: tt.max(len(token))}); err != nil {
+				t.Fatal(err)
+			}
+			resp, err := b.HandleRequest(ctx, &logical.Request{
+				Operation:  logical.UpdateOperation,
+				Path:       "login",
+				Storage:    storage,
+				Data:       map[string]interface{}{"role": "small", "spnego": token},
+				Connection: &logical.Connection{RemoteAddr: "127.0.0.1"},
+			})
+			if err != nil || resp == nil {
+				t.Fatalf("unexpected result: err=%v resp=%#v", err, resp)
+			}
+			if resp.IsError() != tt.wantErr {
+				t.Fatalf("IsError() = %t, want %t: %#v", resp.IsError(), tt.wantErr, resp)
+			}
+			if tt.wantErr {
+				if !strings.Contains(resp.Error().Error(), "too large for role") {
+					t.Errorf("error = %q", resp.Error())
+				}
+				if code := loginErrorCode(resp); code != errorCodeInvalidRequest {
+					t.Errorf("error_code = %q, want %q", code, errorCodeInvalidRequest)
+				}
+			}
+		})
+	}
+}
+
+func TestRoleWrite_MaxSPNEGOBytes(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+
+	for _, tt := range []struct {
+		max     int
+		wantErr bool
+	}{
+		{0, false},
+		{4096, false},
+		{maxSPNEGOTokenLen, false},
+		{maxSPNEGOTokenLen + 1, true},
+		{-1, true},
+	} {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "role/app",
+			Storage:   storage,
+			Data:      map[string]interface{}{"max_spnego_bytes": tt.max},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := resp != nil && resp.IsError(); got != tt.wantErr {
+			t.Errorf("max_spnego_bytes=%d: IsError() = %t, want %t: %#v", tt.max, got, tt.wantErr, resp)
+		}
+	}
+}
+
+func TestRoleWrite_IgnorePACLogonTimeSkew(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/app",
+		Storage:   storage,
+		Data:      map[string]interface{}{"ignore_pac_logon_time_skew": true},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("role write failed: err=%v resp=%#v", err, resp)
+	}
+
+	role, err := readRole(ctx, storage, "app")
+	if err != nil || role == nil {
+		t.Fatalf("read role: %v", err)
+	}
+	if !role.IgnorePACLogonTimeSkew {
+		t.Error("ignore_pac_logon_time_skew was not persisted")
+	}
+	if opt := b.validatorOptions(&Config{}); opt.IgnorePACLogonTimeSkew {
+		t.Error("mount options should not skip the logon time check by default")
+	}
+}
+
+func TestHandleLogin_PrincipalPatterns(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	kt := newTestLoginConfig(t, storage) // test tickets are for user@EXAMPLE.COM
+
+	// The role itself would admit the principal; only the mount patterns differ
+	if err := writeRole(ctx, storage, &Role{Name: "app", AllowedRealms: []string{"EXAMPLE.COM"}, TokenPolicies: []string{"app"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, allow, deny string
+		wantErr           bool
+	}{
+		{"no patterns", "", "", false},
+		{"allow matches", `^user@`, "", false},
+		{"allow does not match", `^svc-`, "", true},
+		{"deny matches", "", `(?i)USER`, true},
+		{"deny does not match", "", `.*admin.*`, false},
+		{"deny wins over allow", `EXAMPLE\.COM$`, `^user@`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := readConfig(ctx, storage)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg.PrincipalAllowPattern, cfg.PrincipalDenyPattern = tt.allow, tt.deny
+			if err := writeConfig(ctx, storage, cfg); err != nil {
+				t.Fatal(err)
+			}
+			before := failureReasonCount(failureReasonPrincipal)
+			resp, err := b.HandleRequest(ctx, &logical.Request{
+				Operation:  logical.UpdateOperation,
+				Path:       "login",
+				Storage:    storage,
+				Data:       map[string]interface{}{"role": "app", "spnego": newTestLoginSPNEGO(t, kt)},
+				Connection: &logical.Connection{RemoteAddr: "127.0.0.1"},
+			})
+			if err != nil || resp == nil {
+				t.Fatalf("unexpected result: err=%v resp=%#v", err, resp)
+			}
+			if resp.IsError() != tt.wantErr {
+				t.Fatalf("IsError() = %t, want %t: %#v", resp.IsError(), tt.wantErr, resp)
+			}
+			want := before
+			if tt.wantErr {
+				want++
+				if code := loginErrorCode(resp); code != errorCodePrincipalNotAllowed {
+					t.Errorf("error_code = %q, want %q", code, errorCodePrincipalNotAllowed)
+				}
+			}
+			if got := failureReasonCount(failureReasonPrincipal); got != want {
+				t.Errorf("%s = %d, want %d", failureReasonPrincipal, got, want)
+			}
+		})
+	}
+}
+
+func TestDisplayName(t *testing.T) {
+	tests := []struct {
+		name      string
+		format    string
+		maxLen    int
+		principal string
+		want      string
+	}{
+		{"default is the principal", "", 0, "WEB01$@EXAMPLE.COM", "WEB01$@EXAMPLE.COM"},
+		{"principal", displayNamePrincipal, 0, "WEB01$@EXAMPLE.COM", "WEB01$@EXAMPLE.COM"},
+		{"sanitized", displayNameSanitized, 0, "WEB01$@EXAMPLE.COM", "web01@example.com"},
+		{"sanitized without realm", displayNameSanitized, 0, "svc-App$", "svc-app"},
+		{"sanitized keeps inner dollar", displayNameSanitized, 0, "a$b@EXAMPLE.COM", "a$b@example.com"},
+		{"name", displayNameName, 0, "WEB01$@EXAMPLE.COM", "gmsa-web01"},
+		{"name for user", displayNameName, 0, "Alice@EXAMPLE.COM", "gmsa-alice"},
+		{"truncated principal", displayNamePrincipal, 8, "WEB01$@EXAMPLE.COM", "WEB01$@E"},
+		{"truncated sanitized", displayNameSanitized, 5, "WEB01$@EXAMPLE.COM", "web01"},
+		{"truncated name", displayNameName, 7, "WEB01$@EXAMPLE.COM", "gmsa-we"},
+		{"truncation counts runes", displayNamePrincipal, 3, "Jürgen@EXAMPLE.COM", "Jür"},
+		{"shorter than limit", displayNameName, 64, "WEB01$@EXAMPLE.COM", "gmsa-web01"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{DisplayNameFormat: tt.format, DisplayNameMaxLength: tt.maxLen}
+			if got := displayName(cfg, tt.principal); got != tt.want {
+				t.Errorf("displayName(%q) = %q, want %q", tt.principal, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleLogin_DisplayName(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	kt := newTestLoginConfig(t, storage)
+	if err := writeRole(ctx, storage, &Role{Name: "app", TokenPolicies: []string{"app"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for format, want := range map[string]string{
+		displayNamePrincipal: "user@EXAMPLE.COM",
+		displayNameSanitized: "user@example.com",
+		displayNameName:      "gmsa-user",
+	} {
+		t.Run(format, func(t *testing.T) {
+			cfg, err := readConfig(ctx, storage)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg.DisplayNameFormat = format
+			if err := writeConfig(ctx, storage, cfg); err != nil {
+				t.Fatal(err)
+			}
+			resp, err := b.HandleRequest(ctx, &logical.Request{
+				Operation:  logical.UpdateOperation,
+				Path:       "login",
+				Storage:    storage,
+				Data:       map[string]interface{}{"role": "app", "spnego": newTestLoginSPNEGO(t, kt)},
+				Connection: &logical.Connection{RemoteAddr: "127.0.0.1"},
+			})
+			if err != nil || resp == nil || resp.Auth == nil {
+				t.Fatalf("unexpected result: err=%v resp=%#v", err, resp)
+			}
+			if resp.Auth.DisplayName != want {
+				t.Errorf("DisplayName = %q, want %q", resp.Auth.DisplayName, want)
+			}
+			if got := resp.Auth.Metadata["principal"]; got != "user@EXAMPLE.COM" {
+				t.Errorf("metadata principal = %q, want the raw principal", got)
+			}
+		})
+	}
+}
+
+func TestHandleLoginBatch(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	kt := newTestLoginConfig(t, storage)
+
+	for _, role := range []*Role{
+		{Name: "app", TokenPolicies: []string{"app-read"}},
+		{Name: "off", TokenPolicies: []string{"app-read"}, Disabled: true},
+	} {
+		if err := writeRole(ctx, storage, role); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	batch := func(items []interface{}) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation:  logical.UpdateOperation,
+			Path:       "login/batch",
+			Storage:    storage,
+			Data:       map[string]interface{}{"items": items},
+			Connection: &logical.Connection{RemoteAddr: "127.0.0.1"},
+		})
+		if err != nil || resp == nil {
+			t.Fatalf("unexpected result: err=%v resp=%#v", err, resp)
+		}
+		return resp
+	}
+
+	beforeTokens := tokensIssuedCount("default")
+	resp := batch([]interface{}{
+		map[string]interface{}{"role": "app", "spnego": newTestLoginSPNEGO(t, kt)},
+		map[string]interface{}{"role": "app", "spnego": "bm90LWEtdG9rZW4="},
+		map[string]interface{}{"role": "missing", "spnego": newTestLoginSPNEGO(t, kt)},
+		map[string]interface{}{"role": "off", "spnego": newTestLoginSPNEGO(t, kt)},
+		map[string]interface{}{"role": "app"},
+		map[string]interface{}{"role": "app", "spnego": newTestLoginSPNEGO(t, kt)},
+	})
+	if resp.IsError() {
+		t.Fatalf("unexpected error: %v", resp.Error())
+	}
+	if resp.Data["succeeded"] != 2 || resp.Data["failed"] != 4 {
+		t.Errorf("succeeded=%v failed=%v, want 2 and 4", resp.Data["succeeded"], resp.Data["failed"])
+	}
+
+	results := resp.Data["results"].([]map[string]interface{})
+	if len(results) != 6 {
+		t.Fatalf("got %d results, want 6", len(results))
+	}
+	for _, i := range []int{0, 5} {
+		if _, failed := results[i]["error"]; failed {
+			t.Errorf("item %d failed: %v", i, results[i]["error"])
+		}
+		if !reflect.DeepEqual(results[i]["policies"], []string{"app-read"}) {
+			t.Errorf("item %d policies = %v", i, results[i]["policies"])
+		}
+	}
+	for i, want := range map[int]string{
+		2: `role "missing" not found`,
+		3: `role "off" is disabled`,
+		4: "role and spnego are required",
+	} {
+		if results[i]["error"] != want {
+			t.Errorf("item %d error = %v, want %q", i, results[i]["error"], want)
+		}
+	}
+	if results[1]["error"] == nil {
+		t.Error("item 1 with an invalid token succeeded")
+	}
+	if results[2]["error_code"] != errorCodeRoleNotFound || results[4]["error_code"] != errorCodeInvalidRequest {
+		t.Errorf("error codes = %v, %v", results[2]["error_code"], results[4]["error_code"])
+	}
+	if got := tokensIssuedCount("default"); got != beforeTokens {
+		t.Errorf("batch counted %d issued tokens", got-beforeTokens)
+	}
+
+	// Oversized and empty batches are rejected outright
+	items := make([]interface{}, maxBatchLoginItems+1)
+	for i := range items {
+		items[i] = map[string]interface{}{"role": "app", "spnego": newTestLoginSPNEGO(t, kt)}
+	}
+	if resp := batch(items); !resp.IsError() {
+		t.Errorf("expected error for %d items, got %#v", len(items), resp.Data)
+	}
+	if resp := batch(nil); !resp.IsError() {
+		t.Errorf("expected error for empty batch, got %#v", resp.Data)
+	}
+}
+
+func TestHandleLoginBatch_NoSideEffects(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	kt := newTestLoginConfig(t, storage)
+	cfg, err := readConfig(ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.LastLogin = true
+	cfg.AuditChain = true
+	cfg.PrincipalLockoutThreshold, cfg.PrincipalLockoutDurationSec = 1, 60
+	if err := writeConfig(ctx, storage, cfg); err != nil {
+		t.Fatal(err)
+	}
+	// A role stored before schema versions, which a login would rewrite
+	putRaw(t, storage, storageKeyRole+"/app", `{"name":"app","token_policies":["app-read"]}`)
+	if err := writeRole(ctx, storage, &Role{Name: "other-realm", AllowedRealms: []string{"OTHER.COM"}}); err != nil {
+		t.Fatal(err)
+	}
+	before := maps.Clone(storage.(*memStorage).data)
+
+	token := newTestLoginSPNEGO(t, kt)
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "login/batch",
+		Storage:   storage,
+		Data: map[string]interface{}{"items": []interface{}{
+			map[string]interface{}{"role": "app", "spnego": token},
+			map[string]interface{}{"role": "other-realm", "spnego": newTestLoginSPNEGO(t, kt)},
+		}},
+		Connection: &logical.Connection{RemoteAddr: "127.0.0.1"},
+	})
+	if err != nil || resp == nil || resp.IsError() || resp.Data["succeeded"] != 1 {
+		t.Fatalf("unexpected result: err=%v resp=%#v", err, resp)
+	}
+
+	// Nothing was stored: no last_login summary, audit entry or migration
+	if after := storage.(*memStorage).data; !maps.Equal(before, after) {
+		t.Errorf("batch changed storage: %d entries before, %d after", len(before), len(after))
+	}
+	// The authorization failure didn't count toward lockout
+	if until := b.principalLockedUntil(normalizePrincipal("user@EXAMPLE.COM", cfg.Normalization)); !until.IsZero() {
+		t.Errorf("principal locked out until %v by a batch", until)
+	}
+	// The checked token is still good for a login
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation:  logical.UpdateOperation,
+		Path:       "login",
+		Storage:    storage,
+		Data:       map[string]interface{}{"role": "app", "spnego": token},
+		Connection: &logical.Connection{RemoteAddr: "127.0.0.1"},
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("login with the checked token failed: err=%v resp=%#v", err, resp)
+	}
+}
+
+func TestHandleLogin_ErrorCodes(t *testing.T) {
+	ctx := context.Background()
+	lockoutConfig := func(c *Config) {
+		c.PrincipalLockoutThreshold, c.PrincipalLockoutDurationSec = 1, 60
+	}
+	lockOut := func(b *gmsaBackend) {
+		b.recordPrincipalFailure(&Config{PrincipalLockoutThreshold: 1, PrincipalLockoutDurationSec: 60}, normalizePrincipal("user@EXAMPLE.COM", getDefaultNormalizationConfig()))
+	}
+
+	tests := []struct {
+		name    string
+		role    *Role
+		config  func(*Config)
+		request func(*logical.Request)
+		setup   func(*gmsaBackend)
+		code    string
+	}{
+		{name: "malformed token", role: &Role{}, request: func(r *logical.Request) { r.Data["spnego"] = "not base64!" }, code: errorCodeInvalidRequest},
+		{name: "unknown role", request: func(r *logical.Request) { r.Data["role"] = "missing" }, code: errorCodeRoleNotFound},
+		{name: "disabled role", role: &Role{Disabled: true}, code: errorCodeRoleDisabled},
+		{name: "missing client cert", role: &Role{BoundClientCertCNs: []string{"ci"}}, code: errorCodeClientCertRequired},
+		{name: "invalid token", role: &Role{}, request: func(r *logical.Request) { r.Data["spnego"] = "bm90LWEtdG9rZW4=" }, code: errorCodeInvalidToken},
+		{name: "stale ticket", role: &Role{MinKVNO: 2}, code: errorCodeStaleTicket},
+		{name: "locked out", role: &Role{}, config: lockoutConfig, setup: lockOut, code: errorCodeLockedOut},
+		// The lockout gate runs before the role's ticket checks
+		{name: "locked out with stale ticket", role: &Role{MinKVNO: 2}, config: lockoutConfig, setup: lockOut, code: errorCodeLockedOut},
+		{name: "locked out without initial ticket", role: &Role{RequireInitial: true}, config: lockoutConfig, setup: lockOut, code: errorCodeLockedOut},
+		{name: "spn host mismatch", role: &Role{}, config: func(c *Config) { c.VerifySPNHost = true }, request: func(r *logical.Request) {
+			r.Headers = map[string][]string{"Host": {"other.example.com"}}
+		}, code: errorCodeSPNHostMismatch},
+		{name: "realm not allowed", role: &Role{AllowedRealms: []string{"OTHER.COM"}}, code: errorCodeRealmNotAllowed},
+		{name: "spn not allowed", role: &Role{AllowedSPNs: []string{"HTTP/other.example.com"}}, code: errorCodeSPNNotAllowed},
+		{name: "pac unavailable", role: &Role{BoundGroupSIDs: []string{"S-1-5-21-1-2-3-513"}}, code: errorCodePACUnavailable},
+		{name: "no policies", role: &Role{RequirePolicies: true}, code: errorCodeNoPolicies},
+		{name: "ticket expired", role: &Role{TTLFromTicket: true}, setup: func(b *gmsaBackend) {
+			b.now = func() time.Time { return time.Now().Add(11 * time.Hour) }
+		}, code: errorCodeTicketExpired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, storage := getTestBackend(t)
+			kt := newTestLoginConfig(t, storage)
+			if tt.config != nil {
+				cfg, err := readConfig(ctx, storage)
+				if err != nil {
+					t.Fatal(err)
+				}
+				tt.config(cfg)
+				if err := writeConfig(ctx, storage, cfg); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.role != nil {
+				tt.role.Name = "app"
+				if err := writeRole(ctx, storage, tt.role); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.setup != nil {
+				tt.setup(b)
+			}
+			req := &logical.Request{
+				Operation:  logical.UpdateOperation,
+				Path:       "login",
+				Storage:    storage,
+				Data:       map[string]interface{}{"role": "app", "spnego": newTestLoginSPNEGO(t, kt)},
+				Connection: &logical.Connection{RemoteAddr: "127.0.0.1"},
+			}
+			if tt.request != nil {
+				tt.request(req)
+			}
+			resp, err := b.HandleRequest(ctx, req)
+			if err != nil || resp == nil || !resp.IsError() {
+				t.Fatalf("expected error response: err=%v resp=%#v", err, resp)
+			}
+			if got := loginErrorCode(resp); got != tt.code {
+				t.Errorf("error_code = %q, want %q (error %q)", got, tt.code, resp.Error())
+			}
+		})
+	}
+
+	// Codes without an end-to-end path in the test fixtures
+	b, storage := getTestBackend(t)
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation:  logical.UpdateOperation,
+		Path:       "login",
+		Storage:    storage,
+		Data:       map[string]interface{}{"role": "app", "spnego": "bm90LWEtdG9rZW4="},
+		Connection: &logical.Connection{RemoteAddr: "127.0.0.1"},
+	})
+	if err != nil || loginErrorCode(resp) != errorCodeRoleNotFound {
+		t.Errorf("unconfigured mount without role: err=%v resp=%#v", err, resp)
+	}
+	if err := writeRole(ctx, storage, &Role{Name: "app"}); err != nil {
+		t.Fatal(err)
+	}
+	resp, _ = b.HandleRequest(ctx, &logical.Request{
+		Operation:  logical.UpdateOperation,
+		Path:       "login",
+		Storage:    storage,
+		Data:       map[string]interface{}{"role": "app", "spnego": "bm90LWEtdG9rZW4="},
+		Connection: &logical.Connection{RemoteAddr: "127.0.0.1"},
+	})
+	if got := loginErrorCode(resp); got != errorCodeNotConfigured {
+		t.Errorf("unconfigured mount error_code = %q, want %q", got, errorCodeNotConfigured)
+	}
+	for reason, code := range map[string]string{
+		failureReasonGroup:      errorCodeNoGroupMatch,
+		failureReasonGroupLimit: errorCodeGroupLimit,
+	} {
+		if got := authorizationErrorCodes[reason]; got != code {
+			t.Errorf("authorizationErrorCodes[%q] = %q, want %q", reason, got, code)
+		}
+	}
+	for kerbCode, code := range map[string]string{
+		kerb.ErrCodePACValidation:      errorCodePACInvalid,
+		kerb.ErrCodeEnctypeDowngrade:   errorCodeEnctypeDowngrade,
+		kerb.ErrCodeMissingChannelBind: errorCodeChannelBinding,
+		kerb.ErrCodeClockSkew:          errorCodeClockSkew,
+		kerb.ErrCodeKerberosFailed:     errorCodeNegotiationFailed,
+	} {
+		if got := kerbErrorCode(kerbCode); got != code {
+			t.Errorf("kerbErrorCode(%q) = %q, want %q", kerbCode, got, code)
+		}
+	}
+}
+
+func TestHandleLogin_BasePolicies(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	kt := newTestLoginConfig(t, storage)
+	cfg, err := readConfig(ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.BasePolicies = []string{"gmsa-base"}
+	if err := writeConfig(ctx, storage, cfg); err != nil {
+		t.Fatal(err)
+	}
+	for _, role := range []*Role{
+		{Name: "app", TokenPolicies: []string{"app"}},
+		{Name: "denies-base", TokenPolicies: []string{"app", "gmsa-base"}, DenyPolicies: []string{"gmsa-base"}},
+		{Name: "denies-case", TokenPolicies: []string{"app", "App-Admin"}, DenyPolicies: []string{"app-admin"}},
+		{Name: "empty", RequirePolicies: true},
+	} {
+		if err := writeRole(ctx, storage, role); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := map[string][]string{
+		"app":         {"app", "gmsa-base"},
+		"denies-base": {"app", "gmsa-base"},
+		"denies-case": {"app", "gmsa-base"},
+		"empty":       {"gmsa-base"},
+	}
+	for role, want := range tests {
+		t.Run(role, func(t *testing.T) {
+			resp, err := b.HandleRequest(ctx, &logical.Request{
+				Operation:  logical.UpdateOperation,
+				Path:       "login",
+				Storage:    storage,
+				Data:       map[string]interface{}{"role": role, "spnego": newTestLoginSPNEGO(t, kt)},
+				Connection: &logical.Connection{RemoteAddr: "127.0.0.1"},
+			})
+			if err != nil || resp == nil || resp.IsError() {
+				t.Fatalf("login failed: err=%v resp=%#v", err, resp)
+			}
+			if !reflect.DeepEqual(resp.Auth.Policies, want) {
+				t.Errorf("Policies = %v, want %v", resp.Auth.Policies, want)
+			}
+		})
+	}
+}
+
+func TestHandleLogin_MountTokenDefaults(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	kt := newTestLoginConfig(t, storage)
+	cfg, err := readConfig(ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.DefaultTokenType = "service"
+	cfg.DefaultPolicies = []string{"mount-default"}
+	if err := writeConfig(ctx, storage, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	// A role written without token_type or token_policies inherits both
+	if resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/inherit",
+		Storage:   storage,
+		Data:      map[string]interface{}{"allowed_realms": "EXAMPLE.COM"},
+	}); err != nil || resp.IsError() {
+		t.Fatalf("role write failed: err=%v resp=%#v", err, resp)
+	}
+	for _, role := range []*Role{
+		{Name: "override", TokenType: "default", TokenPolicies: []string{"role-policy"}},
+		{Name: "policies-only", TokenPolicies: []string{"role-policy"}},
+	} {
+		if err := writeRole(ctx, storage, role); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Roles written before mount defaults stored "default" without choosing
+	// it; they keep the type they were issuing
+	putRaw(t, storage, storageKeyRole+"/legacy", `{"name":"legacy","allowed_realms":["EXAMPLE.COM"],"token_type":"default"}`)
+
+	tests := []struct {
+		role      string
+		tokenType logical.TokenType
+		policies  []string
+	}{
+		{"inherit", logical.TokenTypeService, []string{"mount-default"}},
+		{"legacy", logical.TokenTypeDefault, []string{"mount-default"}},
+		{"override", logical.TokenTypeDefault, []string{"role-policy"}},
+		{"policies-only", logical.TokenTypeService, []string{"role-policy"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.role, func(t *testing.T) {
+			resp, err := b.HandleRequest(ctx, &logical.Request{
+				Operation:  logical.UpdateOperation,
+				Path:       "login",
+				Storage:    storage,
+				Data:       map[string]interface{}{"role": tt.role, "spnego": newTestLoginSPNEGO(t, kt)},
+				Connection: &logical.Connection{RemoteAddr: "127.0.0.1"},
+			})
+			if err != nil || resp == nil || resp.IsError() {
+				t.Fatalf("login failed: err=%v resp=%#v", err, resp)
+			}
+			if resp.Auth.TokenType != tt.tokenType {
+				t.Errorf("TokenType = %v, want %v", resp.Auth.TokenType, tt.tokenType)
+			}
+			if !reflect.DeepEqual(resp.Auth.Policies, tt.policies) {
+				t.Errorf("Policies = %v, want %v", resp.Auth.Policies, tt.policies)
+			}
+		})
+	}
+}
@@ -2,6 +2,9 @@ package backend
 
 import (
 	"context"
+	"fmt"
+	"net/http"
+	"strings"
 
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
@@ -17,6 +20,12 @@ This endpoint provides authentication metrics for monitoring and observability.
 Returns structured metrics including authentication attempts, successes, failures,
 and performance data.
 			`,
+			Fields: map[string]*framework.FieldSchema{
+				"format": {
+					Type:        framework.TypeString,
+					Description: "Output format: json (default) or prometheus",
+				},
+			},
 			Operations: map[logical.Operation]framework.OperationHandler{
 				logical.ReadOperation: &framework.PathOperation{
 					Callback: b.handleAuthMetrics,
@@ -28,6 +37,20 @@ and performance data.
 }
 
 func (b *gmsaBackend) handleAuthMetrics(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	if format, _ := d.Get("format").(string); format == "prometheus" {
+		return prometheusMetricsResponse(b.rotationManager), nil
+	}
+
+	// Create response
+	resp := &logical.Response{
+		Data: authMetricsData(),
+	}
+
+	return resp, nil
+}
+
+// authMetricsData collects the authentication counters and derived rates
+func authMetricsData() map[string]interface{} {
 	// Collect metrics
 	metrics := map[string]interface{}{
 		"auth_attempts":             authAttempts.Value(),
@@ -37,7 +60,27 @@ func (b *gmsaBackend) handleAuthMetrics(ctx context.Context, req *logical.Reques
 		"pac_validations":           pacValidations.Value(),
 		"pac_validation_failures":   pacValidationFailures.Value(),
 		"input_validation_failures": inputValidationFailures.Value(),
+		"principal_lockouts":        principalLockouts.Value(),
+		"lockout_rejections":        lockoutRejections.Value(),
+		"enctype_downgrades":        enctypeDowngrades.Value(),
+		"channel_binding_failures":  channelBindingFailures.Value(),
+		"kdc_probe_failures":        kdcProbeFailures.Value(),
+	}
+
+	// Break failures down by reason, including reasons that have not occurred
+	byReason := make(map[string]interface{}, len(failureReasons))
+	for _, reason := range failureReasons {
+		byReason[reason] = failureReasonCount(reason)
 	}
+	metrics["failures_by_reason"] = byReason
+
+	// Service tokens are non-renewable while default tokens are leased, so
+	// the split informs lease storage planning
+	byType := make(map[string]interface{}, len(tokenTypes))
+	for _, tokenType := range tokenTypes {
+		byType[tokenType] = tokensIssuedCount(tokenType)
+	}
+	metrics["tokens_issued_by_type"] = byType
 
 	// Add success rate calculation
 	totalAttempts := authAttempts.Value()
@@ -59,10 +102,67 @@ func (b *gmsaBackend) handleAuthMetrics(ctx context.Context, req *logical.Reques
 		metrics["pac_success_rate_percent"] = pacSuccessRate
 	}
 
-	// Create response
-	resp := &logical.Response{
-		Data: metrics,
+	return metrics
+}
+
+// rotationStates are the rotation manager statuses reported by the
+// gmsa_rotation_state gauge
+var rotationStates = []string{"idle", "checking", "rotating", "error", "degraded"}
+
+// prometheusMetrics renders the authentication counters and the rotation
+// manager state in the Prometheus text exposition format
+func prometheusMetrics(rm RotationManagerInterface) string {
+	var sb strings.Builder
+
+	writeCounter := func(name, help string, value int64) {
+		fmt.Fprintf(&sb, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, value)
 	}
+	writeCounter("gmsa_auth_attempts_total", "Total authentication attempts.", authAttempts.Value())
+	writeCounter("gmsa_auth_successes_total", "Total successful authentications.", authSuccesses.Value())
+	writeCounter("gmsa_principal_lockouts_total", "Total principals locked out after repeated failures.", principalLockouts.Value())
+	writeCounter("gmsa_enctype_downgrades_total", "Total tickets encrypted with a weaker enctype than the keytab's best key.", enctypeDowngrades.Value())
+	writeCounter("gmsa_channel_binding_failures_total", "Total logins rejected for missing TLS channel binding.", channelBindingFailures.Value())
+	writeCounter("gmsa_kdc_probe_failures_total", "Total health checks that could not connect to a configured KDC.", kdcProbeFailures.Value())
 
-	return resp, nil
+	sb.WriteString("# HELP gmsa_auth_failures_total Authentication failures by reason.\n")
+	sb.WriteString("# TYPE gmsa_auth_failures_total counter\n")
+	for _, reason := range failureReasons {
+		fmt.Fprintf(&sb, "gmsa_auth_failures_total{reason=%q} %d\n", reason, failureReasonCount(reason))
+	}
+
+	sb.WriteString("# HELP gmsa_tokens_issued_total Tokens issued by token type.\n")
+	sb.WriteString("# TYPE gmsa_tokens_issued_total counter\n")
+	for _, tokenType := range tokenTypes {
+		fmt.Fprintf(&sb, "gmsa_tokens_issued_total{type=%q} %d\n", tokenType, tokensIssuedCount(tokenType))
+	}
+
+	// One series per state with 1 for the current one, so dashboards can
+	// alert on a manager stuck in "rotating" or "error". Every state is 0
+	// while no rotation manager exists.
+	current := ""
+	if rm != nil {
+		current = rm.GetStatus().Status
+	}
+	sb.WriteString("# HELP gmsa_rotation_state Rotation manager state; 1 for the current state, 0 otherwise.\n")
+	sb.WriteString("# TYPE gmsa_rotation_state gauge\n")
+	for _, state := range rotationStates {
+		value := 0
+		if state == current {
+			value = 1
+		}
+		fmt.Fprintf(&sb, "gmsa_rotation_state{state=%q} %d\n", state, value)
+	}
+
+	return sb.String()
+}
+
+// prometheusMetricsResponse wraps the Prometheus output in a raw HTTP response
+func prometheusMetricsResponse(rm RotationManagerInterface) *logical.Response {
+	return &logical.Response{
+		Data: map[string]interface{}{
+			logical.HTTPContentType: "text/plain; version=0.0.4",
+			logical.HTTPRawBody:     []byte(prometheusMetrics(rm)),
+			logical.HTTPStatusCode:  http.StatusOK,
+		},
+	}
 }
@@ -0,0 +1,806 @@
+package backend
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"github.com/lpassig/vault-plugin-auth-gmsa/internal/kerb"
+)
+
+func TestHandleLogin_FailureReasons(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+
+	if err := writeConfig(ctx, storage, &Config{
+		Realm:     "EXAMPLE.COM",
+		KDCs:      []string{"dc1.example.com"},
+		KeytabB64: base64.StdEncoding.EncodeToString([]byte("not-a-keytab")),
+		SPN:       "HTTP/vault.example.com",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeRole(ctx, storage, &Role{Name: "app"}); err != nil {
+		t.Fatal(err)
+	}
+
+	login := func(data map[string]interface{}) {
+		t.Helper()
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation:  logical.UpdateOperation,
+			Path:       "login",
+			Storage:    storage,
+			Data:       data,
+			Connection: &logical.Connection{RemoteAddr: "127.0.0.1"},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if resp == nil || !resp.IsError() {
+			t.Fatalf("expected error response, got: %#v", resp)
+		}
+	}
+
+	before := failureReasonCount(failureReasonInputValidation)
+	login(map[string]interface{}{"role": "bad role!", "spnego": "dGVzdA=="})
+	if got := failureReasonCount(failureReasonInputValidation); got != before+1 {
+		t.Errorf("input_validation = %d, want %d", got, before+1)
+	}
+
+	before = failureReasonCount(failureReasonNegotiation)
+	login(map[string]interface{}{"role": "app", "spnego": "dGVzdA=="})
+	if got := failureReasonCount(failureReasonNegotiation); got != before+1 {
+		t.Errorf("negotiation = %d, want %d", got, before+1)
+	}
+}
+
+func TestAuthorizeLogin_FailureReasons(t *testing.T) {
+	cfg := &Config{Normalization: getDefaultNormalizationConfig()}
+	res := &kerb.ValidationResult{
+		Principal: "svc@EXAMPLE.COM",
+		Realm:     "EXAMPLE.COM",
+		SPN:       "HTTP/vault.example.com",
+		GroupSIDs: []string{"S-1-5-21-1-2-3-1104"},
+	}
+
+	tests := []struct {
+		name   string
+		role   *Role
+		reason string
+	}{
+		{"authorized", &Role{AllowedRealms: []string{"example.com"}, BoundGroupSIDs: []string{"S-1-5-21-1-2-3-1104"}}, ""},
+		{"realm", &Role{AllowedRealms: []string{"OTHER.COM"}}, failureReasonRealm},
+		{"spn", &Role{AllowedSPNs: []string{"HTTP/other.example.com"}}, failureReasonSPN},
+		{"group", &Role{BoundGroupSIDs: []string{"S-1-5-21-1-2-3-9999"}}, failureReasonGroup},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason, msg := authorizeLogin(tt.role, cfg, res)
+			if reason != tt.reason {
+				t.Errorf("authorizeLogin() reason = %q, want %q", reason, tt.reason)
+			}
+			if (reason == "") != (msg == "") {
+				t.Errorf("authorizeLogin() reason %q with message %q", reason, msg)
+			}
+		})
+	}
+}
+
+func TestHandleLogin_PACUnavailableWithBoundGroups(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	kt := newTestLoginConfig(t, storage)
+	if err := writeRole(ctx, storage, &Role{Name: "grouped", BoundGroupSIDs: []string{"S-1-5-21-1-2-3-1104"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	before := failureReasonCount(failureReasonPACUnavailable)
+	beforeGroup := failureReasonCount(failureReasonGroup)
+
+	// The test tickets carry no PAC
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation:  logical.UpdateOperation,
+		Path:       "login",
+		Storage:    storage,
+		Data:       map[string]interface{}{"role": "grouped", "spnego": newTestLoginSPNEGO(t, kt)},
+		Connection: &logical.Connection{RemoteAddr: "127.0.0.1"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected error response, got: %#v", resp)
+	}
+	if msg := resp.Error().Error(); !strings.Contains(msg, "authorization data (PAC) unavailable") {
+		t.Errorf("error = %q, want PAC unavailable message", msg)
+	}
+	if got := failureReasonCount(failureReasonPACUnavailable); got != before+1 {
+		t.Errorf("%s = %d, want %d", failureReasonPACUnavailable, got, before+1)
+	}
+	if got := failureReasonCount(failureReasonGroup); got != beforeGroup {
+		t.Errorf("%s = %d, want unchanged %d", failureReasonGroup, got, beforeGroup)
+	}
+}
+
+func TestAuthorizeLogin_RequirePACPresent(t *testing.T) {
+	role := &Role{}
+	tests := []struct {
+		name       string
+		requirePAC bool
+		flags      map[string]bool
+		reason     string
+	}{
+		{"PAC present", true, map[string]bool{"PAC_VALIDATED": true}, ""},
+		{"PAC present but unvalidated", true, map[string]bool{"PAC_VALIDATION_FAILED": true}, ""},
+		{"PAC missing", true, map[string]bool{"PAC_NOT_FOUND": true}, failureReasonPACMissing},
+		{"PAC missing, not required", false, map[string]bool{"PAC_NOT_FOUND": true}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Normalization: getDefaultNormalizationConfig(), RequirePAC: tt.requirePAC}
+			res := &kerb.ValidationResult{Principal: "svc@EXAMPLE.COM", Realm: "EXAMPLE.COM", Flags: tt.flags}
+			if reason, _ := authorizeLogin(role, cfg, res); reason != tt.reason {
+				t.Errorf("authorizeLogin() reason = %q, want %q", reason, tt.reason)
+			}
+		})
+	}
+}
+
+func TestAuthorizeLogin_RequireUPNDNSInfo(t *testing.T) {
+	role := &Role{}
+	tests := []struct {
+		name    string
+		require bool
+		flags   map[string]bool
+		reason  string
+	}{
+		{"UPN_DNS_INFO present", true, map[string]bool{"PAC_VALIDATED": true, "UPN_DNS_INFO_PRESENT": true}, ""},
+		{"UPN_DNS_INFO missing", true, map[string]bool{"PAC_VALIDATED": true}, failureReasonUPNInfoMissing},
+		{"UPN_DNS_INFO missing, not required", false, map[string]bool{"PAC_VALIDATED": true}, ""},
+		{"PAC missing", true, map[string]bool{"PAC_NOT_FOUND": true}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Normalization: getDefaultNormalizationConfig(), RequireUPNInfo: tt.require}
+			res := &kerb.ValidationResult{Principal: "svc@EXAMPLE.COM", Realm: "EXAMPLE.COM", Flags: tt.flags}
+			reason, _ := authorizeLogin(role, cfg, res)
+			if reason != tt.reason {
+				t.Errorf("authorizeLogin() reason = %q, want %q", reason, tt.reason)
+			}
+			if reason != "" && authorizationErrorCodes[reason] != errorCodeUPNInfoRequired {
+				t.Errorf("error code = %q, want %q", authorizationErrorCodes[reason], errorCodeUPNInfoRequired)
+			}
+		})
+	}
+}
+
+func TestAuthorizeLogin_PACUnknownBufferMode(t *testing.T) {
+	role := &Role{}
+	tests := []struct {
+		mode     string
+		unknown  bool
+		wantFlag bool
+		reason   string
+	}{
+		{"", true, false, ""},
+		{pacUnknownBufferIgnore, true, false, ""},
+		{pacUnknownBufferWarn, true, true, ""},
+		{pacUnknownBufferReject, true, true, failureReasonUnknownBuffer},
+		{pacUnknownBufferReject, false, false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/%t", tt.mode, tt.unknown), func(t *testing.T) {
+			cfg := &Config{Normalization: getDefaultNormalizationConfig(), PACUnknownBufferMode: tt.mode}
+			flags := map[string]bool{"PAC_VALIDATED": true}
+			if tt.unknown {
+				flags["UNKNOWN_PAC_BUFFER"] = true
+			}
+			res := &kerb.ValidationResult{Principal: "svc@EXAMPLE.COM", Realm: "EXAMPLE.COM", Flags: flags}
+			if got := applyUnknownPACBufferMode(cfg, res.Flags); got != tt.wantFlag || res.Flags["UNKNOWN_PAC_BUFFER"] != tt.wantFlag {
+				t.Errorf("UNKNOWN_PAC_BUFFER kept = %t, want %t", got, tt.wantFlag)
+			}
+			reason, _ := authorizeLogin(role, cfg, res)
+			if reason != tt.reason {
+				t.Errorf("authorizeLogin() reason = %q, want %q", reason, tt.reason)
+			}
+			if reason != "" && authorizationErrorCodes[reason] != errorCodeUnknownPACBuffer {
+				t.Errorf("error code = %q, want %q", authorizationErrorCodes[reason], errorCodeUnknownPACBuffer)
+			}
+		})
+	}
+}
+
+func TestAuthorizeLogin_BoundUserSIDs(t *testing.T) {
+	const userSID = "S-1-5-21-1-2-3-1104"
+	tests := []struct {
+		name      string
+		bound     []string
+		principal string
+		userSID   string
+		flags     map[string]bool
+		reason    string
+	}{
+		{"unbound", nil, "svc@EXAMPLE.COM", userSID, map[string]bool{"PAC_VALIDATED": true}, ""},
+		{"bound match", []string{"S-1-5-21-1-2-3-500", userSID}, "svc@EXAMPLE.COM", userSID, map[string]bool{"PAC_VALIDATED": true}, ""},
+		{"bound match after rename", []string{userSID}, "svc-renamed@EXAMPLE.COM", userSID, map[string]bool{"PAC_VALIDATED": true}, ""},
+		{"bound mismatch", []string{"S-1-5-21-1-2-3-500"}, "svc@EXAMPLE.COM", userSID, map[string]bool{"PAC_VALIDATED": true}, failureReasonUserSID},
+		{"PAC missing", []string{userSID}, "svc@EXAMPLE.COM", "", map[string]bool{"PAC_NOT_FOUND": true}, failureReasonPACUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			role := &Role{BoundUserSIDs: tt.bound}
+			cfg := &Config{Normalization: getDefaultNormalizationConfig()}
+			res := &kerb.ValidationResult{Principal: tt.principal, Realm: "EXAMPLE.COM", UserSID: tt.userSID, Flags: tt.flags}
+			if reason, _ := authorizeLogin(role, cfg, res); reason != tt.reason {
+				t.Errorf("authorizeLogin() reason = %q, want %q", reason, tt.reason)
+			}
+		})
+	}
+	if authorizationErrorCodes[failureReasonUserSID] != errorCodeUserSIDNotAllowed {
+		t.Errorf("error code = %q, want %q", authorizationErrorCodes[failureReasonUserSID], errorCodeUserSIDNotAllowed)
+	}
+}
+
+func TestHandleLogin_RequirePACPresent(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	kt := newTestLoginConfig(t, storage)
+	if err := writeRole(ctx, storage, &Role{Name: "app", TokenPolicies: []string{"app"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, requirePAC := range []bool{false, true} {
+		t.Run(fmt.Sprintf("require_pac_present=%t", requirePAC), func(t *testing.T) {
+			cfg, err := readConfig(ctx, storage)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg.RequirePAC = requirePAC
+			if err := writeConfig(ctx, storage, cfg); err != nil {
+				t.Fatal(err)
+			}
+
+			before := failureReasonCount(failureReasonPACMissing)
+			// The test tickets carry no PAC
+			resp, err := b.HandleRequest(ctx, &logical.Request{
+				Operation:  logical.UpdateOperation,
+				Path:       "login",
+				Storage:    storage,
+				Data:       map[string]interface{}{"role": "app", "spnego": newTestLoginSPNEGO(t, kt)},
+				Connection: &logical.Connection{RemoteAddr: "127.0.0.1"},
+			})
+			if err != nil || resp == nil {
+				t.Fatalf("unexpected result: err=%v resp=%#v", err, resp)
+			}
+			if resp.IsError() != requirePAC {
+				t.Fatalf("IsError() = %t, want %t: %#v", resp.IsError(), requirePAC, resp)
+			}
+			want := before
+			if requirePAC {
+				want++
+				if code := loginErrorCode(resp); code != errorCodePACRequired {
+					t.Errorf("error_code = %q, want %q", code, errorCodePACRequired)
+				}
+			}
+			if got := failureReasonCount(failureReasonPACMissing); got != want {
+				t.Errorf("%s = %d, want %d", failureReasonPACMissing, got, want)
+			}
+		})
+	}
+}
+
+func TestHandleLogin_RequiredPACFlags(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	kt := newTestLoginConfig(t, storage)
+	// The test tickets carry no PAC, so SIGNATURES_VALID is never set
+	if err := writeRole(ctx, storage, &Role{Name: "strict", TokenPolicies: []string{"app"}, RequiredPACFlags: []string{"SIGNATURES_VALID"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeRole(ctx, storage, &Role{Name: "lax", TokenPolicies: []string{"app"}, RequiredPACFlags: []string{"SIGNATURES_VALID=false"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		role    string
+		wantErr bool
+	}{{"strict", true}, {"lax", false}} {
+		before := failureReasonCount(failureReasonPACFlags)
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation:  logical.UpdateOperation,
+			Path:       "login",
+			Storage:    storage,
+			Data:       map[string]interface{}{"role": tc.role, "spnego": newTestLoginSPNEGO(t, kt)},
+			Connection: &logical.Connection{RemoteAddr: "127.0.0.1"},
+		})
+		if err != nil || resp == nil {
+			t.Fatalf("%s: unexpected result: err=%v resp=%#v", tc.role, err, resp)
+		}
+		if resp.IsError() != tc.wantErr {
+			t.Fatalf("%s: IsError() = %t, want %t: %#v", tc.role, resp.IsError(), tc.wantErr, resp)
+		}
+		if !tc.wantErr {
+			continue
+		}
+		if code := loginErrorCode(resp); code != errorCodePACFlags {
+			t.Errorf("%s: error_code = %q, want %q", tc.role, code, errorCodePACFlags)
+		}
+		if got := failureReasonCount(failureReasonPACFlags); got != before+1 {
+			t.Errorf("%s = %d, want %d", failureReasonPACFlags, got, before+1)
+		}
+	}
+}
+
+func TestAuthorizeLogin_MaxGroupSIDs(t *testing.T) {
+	cfg := &Config{Normalization: getDefaultNormalizationConfig()}
+	role := &Role{MaxGroupSIDs: 2, BoundGroupSIDs: []string{"S-1-5-21-1-2-3-1104"}}
+	groups := []string{"S-1-5-21-1-2-3-1104", "S-1-5-21-1-2-3-513", "S-1-5-21-1-2-3-1105"}
+
+	tests := []struct {
+		name   string
+		count  int
+		reason string
+	}{
+		{"under limit", 1, ""},
+		{"at limit", 2, ""},
+		{"over limit", 3, failureReasonGroupLimit},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := &kerb.ValidationResult{
+				Principal: "svc@EXAMPLE.COM",
+				Realm:     "EXAMPLE.COM",
+				GroupSIDs: groups[:tt.count],
+			}
+			if reason, _ := authorizeLogin(role, cfg, res); reason != tt.reason {
+				t.Errorf("authorizeLogin() reason = %q, want %q", reason, tt.reason)
+			}
+		})
+	}
+
+	if reason, _ := authorizeLogin(&Role{}, cfg, &kerb.ValidationResult{GroupSIDs: groups}); reason != "" {
+		t.Errorf("unlimited role rejected with reason %q", reason)
+	}
+}
+
+func TestAuthorizeLogin_IncludeResourceGroups(t *testing.T) {
+	cfg := &Config{Normalization: getDefaultNormalizationConfig()}
+	resourceSID := "S-1-5-21-4-5-6-1201"
+	res := &kerb.ValidationResult{
+		Principal:         "svc@EXAMPLE.COM",
+		Realm:             "EXAMPLE.COM",
+		GroupSIDs:         []string{"S-1-5-21-1-2-3-513", resourceSID},
+		ResourceGroupSIDs: []string{resourceSID},
+	}
+
+	tests := []struct {
+		name    string
+		exclude bool
+		bound   string
+		reason  string
+	}{
+		{"resource group included", false, resourceSID, ""},
+		{"resource group excluded", true, resourceSID, failureReasonGroup},
+		{"domain group with resource groups excluded", true, "S-1-5-21-1-2-3-513", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			role := &Role{BoundGroupSIDs: []string{tt.bound}, ExcludeResourceGroups: tt.exclude}
+			if reason, _ := authorizeLogin(role, cfg, res); reason != tt.reason {
+				t.Errorf("authorizeLogin() reason = %q, want %q", reason, tt.reason)
+			}
+		})
+	}
+}
+
+func TestAuthorizeLogin_GroupSIDCase(t *testing.T) {
+	cfg := &Config{Normalization: getDefaultNormalizationConfig()}
+
+	tests := []struct {
+		name, bound, pac string
+	}{
+		{"lowercase role SID", "s-1-5-21-1-2-3-1104", "S-1-5-21-1-2-3-1104"},
+		{"lowercase PAC SID", "S-1-5-21-1-2-3-1104", "s-1-5-21-1-2-3-1104"},
+		{"padded role SID", " S-1-5-21-1-2-3-1104 ", "S-1-5-21-1-2-3-1104"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Roles written before canonicalization may hold any form
+			role := &Role{BoundGroupSIDs: []string{tt.bound}}
+			res := &kerb.ValidationResult{GroupSIDs: []string{tt.pac}, Flags: map[string]bool{}}
+			if reason, _ := authorizeLogin(role, cfg, res); reason != "" {
+				t.Errorf("authorizeLogin() reason = %q, want match", reason)
+			}
+		})
+	}
+
+	role := &Role{BoundGroupSIDs: []string{"S-1-5-21-1-2-3-1104"}}
+	res := &kerb.ValidationResult{GroupSIDs: []string{"S-1-5-21-1-2-3-1105"}, Flags: map[string]bool{}}
+	if reason, _ := authorizeLogin(role, cfg, res); reason != failureReasonGroup {
+		t.Errorf("different SID: reason = %q, want %q", reason, failureReasonGroup)
+	}
+}
+
+func TestAuthorizeLogin_FilterSIDHistory(t *testing.T) {
+	cfg := &Config{Normalization: getDefaultNormalizationConfig()}
+	domainSID := "S-1-5-21-1-2-3-513"
+	historySID := "S-1-5-21-7-8-9-1104"
+	newResult := func() *kerb.ValidationResult {
+		return &kerb.ValidationResult{
+			Principal:      "svc@EXAMPLE.COM",
+			Realm:          "EXAMPLE.COM",
+			GroupSIDs:      []string{domainSID, historySID},
+			SIDHistorySIDs: []string{historySID},
+			Flags:          map[string]bool{"PAC_VALIDATED": true},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter bool
+		bound  string
+		reason string
+	}{
+		{"history admitted without filter", false, historySID, ""},
+		{"history filtered", true, historySID, failureReasonGroup},
+		{"domain group with history filtered", true, domainSID, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := newResult()
+			if tt.filter {
+				filterSIDHistory(res)
+				if !res.Flags["SID_HISTORY_FILTERED"] {
+					t.Error("expected SID_HISTORY_FILTERED flag")
+				}
+				if !reflect.DeepEqual(res.GroupSIDs, []string{domainSID}) {
+					t.Errorf("GroupSIDs = %v, want only %s", res.GroupSIDs, domainSID)
+				}
+			}
+			role := &Role{BoundGroupSIDs: []string{tt.bound}}
+			if reason, _ := authorizeLogin(role, cfg, res); reason != tt.reason {
+				t.Errorf("authorizeLogin() reason = %q, want %q", reason, tt.reason)
+			}
+		})
+	}
+
+	// Nothing to filter leaves the result untouched
+	res := newResult()
+	res.SIDHistorySIDs = nil
+	filterSIDHistory(res)
+	if len(res.GroupSIDs) != 2 || res.Flags["SID_HISTORY_FILTERED"] {
+		t.Errorf("result changed without SID history: %#v", res)
+	}
+}
+
+func TestFilterSIDPrefixes(t *testing.T) {
+	const (
+		domainUsers = "S-1-5-21-1-2-3-513"
+		otherDomain = "S-1-5-21-7-8-9-1104"
+		admins      = "S-1-5-32-544"
+		everyone    = "S-1-1-0"
+		// Shares the S-1-5-32 text prefix but not its sub-authorities
+		lookalike = "S-1-5-320-1"
+	)
+	groups := []string{domainUsers, admins, everyone, otherDomain, lookalike}
+
+	tests := []struct {
+		name          string
+		only, exclude []string
+		want          []string
+	}{
+		{"no filter", nil, nil, groups},
+		{"exclude builtin and everyone", nil, []string{"S-1-5-32", "S-1-1-0"}, []string{domainUsers, otherDomain, lookalike}},
+		{"only domain", []string{"S-1-5-21-1-2-3"}, nil, []string{domainUsers}},
+		{"only domains, exclude one", []string{"S-1-5-21"}, []string{"S-1-5-21-7-8-9"}, []string{domainUsers}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := &kerb.ValidationResult{GroupSIDs: append([]string(nil), groups...), Flags: map[string]bool{}}
+			filterSIDPrefixes(res, tt.only, tt.exclude)
+			if !reflect.DeepEqual(res.GroupSIDs, tt.want) {
+				t.Errorf("GroupSIDs = %v, want %v", res.GroupSIDs, tt.want)
+			}
+			if filtered := len(tt.want) < len(groups); res.Flags["SID_PREFIX_FILTERED"] != filtered {
+				t.Errorf("SID_PREFIX_FILTERED = %t, want %t", res.Flags["SID_PREFIX_FILTERED"], filtered)
+			}
+		})
+	}
+
+	// A builtin group bound by a role no longer authorizes once excluded
+	res := &kerb.ValidationResult{Principal: "svc@EXAMPLE.COM", Realm: "EXAMPLE.COM", GroupSIDs: []string{domainUsers, admins}, Flags: map[string]bool{"PAC_VALIDATED": true}}
+	filterSIDPrefixes(res, nil, []string{"S-1-5-32"})
+	cfg := &Config{Normalization: getDefaultNormalizationConfig()}
+	if reason, _ := authorizeLogin(&Role{BoundGroupSIDs: []string{admins}}, cfg, res); reason != failureReasonGroup {
+		t.Errorf("authorizeLogin() reason = %q, want %q", reason, failureReasonGroup)
+	}
+}
+
+func TestHandleLogin_TokensIssuedByType(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	kt := newTestLoginConfig(t, storage)
+
+	for _, role := range []*Role{
+		{Name: "svc", TokenType: "service"},
+		{Name: "dflt", TokenType: "default"},
+	} {
+		if err := writeRole(ctx, storage, role); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	login := func(role string) {
+		t.Helper()
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation:  logical.UpdateOperation,
+			Path:       "login",
+			Storage:    storage,
+			Data:       map[string]interface{}{"role": role, "spnego": newTestLoginSPNEGO(t, kt)},
+			Connection: &logical.Connection{RemoteAddr: "127.0.0.1"},
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("login failed: err=%v resp=%#v", err, resp)
+		}
+	}
+
+	beforeService := tokensIssuedCount("service")
+	beforeDefault := tokensIssuedCount("default")
+	login("svc")
+	login("svc")
+	login("dflt")
+	if got := tokensIssuedCount("service"); got != beforeService+2 {
+		t.Errorf("service tokens = %d, want %d", got, beforeService+2)
+	}
+	if got := tokensIssuedCount("default"); got != beforeDefault+1 {
+		t.Errorf("default tokens = %d, want %d", got, beforeDefault+1)
+	}
+
+	byType, ok := authMetricsData()["tokens_issued_by_type"].(map[string]interface{})
+	if !ok {
+		t.Fatal("tokens_issued_by_type missing from metrics")
+	}
+	if byType["service"] != beforeService+2 || byType["default"] != beforeDefault+1 {
+		t.Errorf("tokens_issued_by_type = %v", byType)
+	}
+	if out := prometheusMetrics(nil); !strings.Contains(out, `gmsa_tokens_issued_total{type="service"}`) {
+		t.Errorf("prometheus output missing token type counter:\n%s", out)
+	}
+}
+
+func TestRecordAuthFailure(t *testing.T) {
+	for _, reason := range failureReasons {
+		before := failureReasonCount(reason)
+		total := authFailures.Value()
+		recordAuthFailure(reason)
+		if got := failureReasonCount(reason); got != before+1 {
+			t.Errorf("%s = %d, want %d", reason, got, before+1)
+		}
+		if authFailures.Value() != total+1 {
+			t.Errorf("auth_failures not incremented for %s", reason)
+		}
+	}
+}
+
+func TestMetrics_FailuresByReason(t *testing.T) {
+	b, storage := getTestBackend(t)
+	recordAuthFailure(failureReasonLockout)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "metrics",
+		Storage:   storage,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("unexpected metrics response: %v, %#v", err, resp)
+	}
+	auth, _ := resp.Data["auth"].(map[string]interface{})
+	byReason, _ := auth["failures_by_reason"].(map[string]interface{})
+	for _, reason := range failureReasons {
+		if _, ok := byReason[reason]; !ok {
+			t.Errorf("failures_by_reason missing %q", reason)
+		}
+	}
+	if byReason[failureReasonLockout].(int64) < 1 {
+		t.Errorf("lockout bucket not incremented: %v", byReason[failureReasonLockout])
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "metrics",
+		Storage:   storage,
+		Data:      map[string]interface{}{"format": "prometheus"},
+	})
+	if err != nil || resp == nil {
+		t.Fatalf("unexpected prometheus response: %v", err)
+	}
+	body := string(resp.Data[logical.HTTPRawBody].([]byte))
+	if !strings.Contains(body, `gmsa_auth_failures_total{reason="lockout"}`) {
+		t.Errorf("prometheus output missing lockout reason:\n%s", body)
+	}
+	if !strings.Contains(body, "# TYPE gmsa_auth_failures_total counter") {
+		t.Errorf("prometheus output missing TYPE line:\n%s", body)
+	}
+}
+
+func TestHandleLogin_RequirePolicies(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	kt := newTestLoginConfig(t, storage)
+
+	for _, role := range []*Role{
+		{Name: "strict", RequirePolicies: true},
+		{Name: "strict-denied", RequirePolicies: true, TokenPolicies: []string{"app"}, DenyPolicies: []string{"app"}},
+		{Name: "strict-ok", RequirePolicies: true, TokenPolicies: []string{"app"}},
+		{Name: "permissive"},
+	} {
+		if err := writeRole(ctx, storage, role); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	login := func(role string) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation:  logical.UpdateOperation,
+			Path:       "login",
+			Storage:    storage,
+			Data:       map[string]interface{}{"role": role, "spnego": newTestLoginSPNEGO(t, kt)},
+			Connection: &logical.Connection{RemoteAddr: "127.0.0.1"},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return resp
+	}
+
+	before := failureReasonCount(failureReasonNoPolicies)
+	for _, role := range []string{"strict", "strict-denied"} {
+		resp := login(role)
+		if resp == nil || !resp.IsError() {
+			t.Fatalf("role %s: expected empty policy set to be rejected, got: %#v", role, resp)
+		}
+		if msg := resp.Error().Error(); !strings.Contains(msg, "resolved no policies") {
+			t.Errorf("role %s: error = %q", role, msg)
+		}
+	}
+	if got := failureReasonCount(failureReasonNoPolicies); got != before+2 {
+		t.Errorf("%s = %d, want %d", failureReasonNoPolicies, got, before+2)
+	}
+
+	if resp := login("strict-ok"); resp == nil || resp.IsError() || len(resp.Auth.Policies) != 1 {
+		t.Fatalf("strict role with policies: unexpected response: %#v", resp)
+	}
+
+	// Without require_policies an empty policy set still issues a token
+	resp := login("permissive")
+	if resp == nil || resp.IsError() || resp.Auth == nil {
+		t.Fatalf("permissive role: unexpected response: %#v", resp)
+	}
+	if len(resp.Auth.Policies) != 0 {
+		t.Errorf("permissive role policies = %v, want none", resp.Auth.Policies)
+	}
+}
+
+func TestHandleLogin_ChannelBindingFailureMetric(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	kt := newTestLoginConfig(t, storage)
+	if err := writeRole(ctx, storage, &Role{Name: "app", TokenPolicies: []string{"app"}}); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := readConfig(ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.AllowChannelBind = true
+	if err := writeConfig(ctx, storage, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	login := func(cb string) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation:  logical.UpdateOperation,
+			Path:       "login",
+			Storage:    storage,
+			Data:       map[string]interface{}{"role": "app", "spnego": newTestLoginSPNEGO(t, kt), "cb_tlse": cb},
+			Connection: &logical.Connection{RemoteAddr: "127.0.0.1"},
+		})
+		if err != nil || resp == nil {
+			t.Fatalf("unexpected result: err=%v resp=%#v", err, resp)
+		}
+		return resp
+	}
+
+	cbBefore := channelBindingFailures.Value()
+	reasonBefore := failureReasonCount(failureReasonChannelBinding)
+	negotiationBefore := failureReasonCount(failureReasonNegotiation)
+	totalBefore := authFailures.Value()
+
+	resp := login("")
+	if !resp.IsError() || loginErrorCode(resp) != errorCodeChannelBinding {
+		t.Fatalf("expected channel binding error, got %#v", resp)
+	}
+	if got := channelBindingFailures.Value(); got != cbBefore+1 {
+		t.Errorf("channel_binding_failures = %d, want %d", got, cbBefore+1)
+	}
+	if got := failureReasonCount(failureReasonChannelBinding); got != reasonBefore+1 {
+		t.Errorf("channel_binding reason = %d, want %d", got, reasonBefore+1)
+	}
+	if got := failureReasonCount(failureReasonNegotiation); got != negotiationBefore {
+		t.Errorf("negotiation reason = %d, want %d (no double count)", got, negotiationBefore)
+	}
+	if got := authFailures.Value(); got != totalBefore+1 {
+		t.Errorf("auth_failures = %d, want %d", got, totalBefore+1)
+	}
+
+	if resp := login("deadbeef"); resp.IsError() {
+		t.Fatalf("login with binding failed: %#v", resp)
+	}
+	if got := channelBindingFailures.Value(); got != cbBefore+1 {
+		t.Errorf("channel_binding_failures moved on success: %d", got)
+	}
+
+	if got := authMetricsData()["channel_binding_failures"]; got != channelBindingFailures.Value() {
+		t.Errorf("metrics channel_binding_failures = %v", got)
+	}
+	if out := prometheusMetrics(nil); !strings.Contains(out, fmt.Sprintf("gmsa_channel_binding_failures_total %d", channelBindingFailures.Value())) {
+		t.Errorf("prometheus output missing channel binding counter:\n%s", out)
+	}
+}
+
+func TestPrometheusMetrics_RotationState(t *testing.T) {
+	b, storage := getTestBackend(t)
+	rm := &fakeRotationManager{running: true, status: RotationStatus{Status: "idle"}}
+	b.rotationManager = rm
+
+	scrape := func() string {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "metrics",
+			Storage:   storage,
+			Data:      map[string]interface{}{"format": "prometheus"},
+		})
+		if err != nil || resp == nil {
+			t.Fatalf("unexpected prometheus response: %v", err)
+		}
+		return string(resp.Data[logical.HTTPRawBody].([]byte))
+	}
+
+	for _, status := range []string{"idle", "rotating", "error"} {
+		rm.status.Status = status
+		body := scrape()
+		for _, state := range rotationStates {
+			want := fmt.Sprintf("gmsa_rotation_state{state=%q} 0", state)
+			if state == status {
+				want = fmt.Sprintf("gmsa_rotation_state{state=%q} 1", state)
+			}
+			if !strings.Contains(body, want) {
+				t.Errorf("status %s: prometheus output missing %s:\n%s", status, want, body)
+			}
+		}
+	}
+	if body := scrape(); !strings.Contains(body, "# TYPE gmsa_rotation_state gauge") {
+		t.Errorf("prometheus output missing TYPE line:\n%s", body)
+	}
+
+	// Without a manager no state is current
+	if out := prometheusMetrics(nil); strings.Contains(out, "gmsa_rotation_state{state=\"idle\"} 1") {
+		t.Errorf("rotation state reported without a manager:\n%s", out)
+	}
+}
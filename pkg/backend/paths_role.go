@@ -16,15 +16,30 @@ func pathsRole(b *gmsaBackend) []*framework.Path {
 			Pattern:      "role/" + framework.GenericNameRegex("name"),
 			HelpSynopsis: "Create or manage a role that maps principals/groups to policies and constraints.",
 			Fields: map[string]*framework.FieldSchema{
-				"allowed_realms":   {Type: framework.TypeString, Description: "Comma-separated allowed realms."},
-				"allowed_spns":     {Type: framework.TypeString, Description: "Comma-separated allowed SPNs."},
-				"bound_group_sids": {Type: framework.TypeString, Description: "Comma-separated allowed AD group SIDs."},
-				"token_policies":   {Type: framework.TypeString, Description: "Comma-separated default token policies."},
-				"token_type":       {Type: framework.TypeString, Description: "default or service"},
-				"period":           {Type: framework.TypeDurationSecond, Description: "Periodic token period seconds."},
-				"max_ttl":          {Type: framework.TypeDurationSecond, Description: "Max TTL seconds."},
-				"deny_policies":    {Type: framework.TypeString, Description: "Comma-separated policies to deny (cap ceiling)."},
-				"merge_strategy":   {Type: framework.TypeString, Description: "union or override (default union)."},
+				"allowed_realms":             {Type: framework.TypeString, Description: "Comma-separated allowed realms."},
+				"allowed_spns":               {Type: framework.TypeString, Description: "Comma-separated allowed SPNs."},
+				"bound_group_sids":           {Type: framework.TypeString, Description: "Comma-separated allowed AD group SIDs."},
+				"token_policies":             {Type: framework.TypeString, Description: "Comma-separated default token policies (unset inherits the mount's default_policies)."},
+				"token_type":                 {Type: framework.TypeString, Description: "default or service (unset inherits the mount's default_token_type)."},
+				"period":                     {Type: framework.TypeDurationSecond, Description: "Periodic token period, in seconds or as a duration such as 12h or 90m (max 24h)."},
+				"max_ttl":                    {Type: framework.TypeDurationSecond, Description: "Max token TTL, in seconds or as a duration such as 12h or 90m (max 24h)."},
+				"deny_policies":              {Type: framework.TypeString, Description: "Comma-separated policies to deny (cap ceiling)."},
+				"merge_strategy":             {Type: framework.TypeString, Description: "union or override (default union)."},
+				"policy_templates":           {Type: framework.TypeBool, Description: "Resolve {{principal}}, {{user}}, {{realm}}, {{spn}}, {{group_sid}}, {{group_rid}} and {{group_name}} in token_policies at login."},
+				"disabled":                   {Type: framework.TypeBool, Description: "Reject logins against this role without deleting it. When written alone, only toggles the flag on an existing role."},
+				"max_group_sids":             {Type: framework.TypeInt, Description: "Reject logins whose principal carries more than this many group SIDs (0 = no limit)."},
+				"bound_client_cert_cns":      {Type: framework.TypeString, Description: "Comma-separated TLS client certificate common names allowed to log in with this role (empty = any client)."},
+				"min_kvno":                   {Type: framework.TypeInt, Description: "Reject tickets encrypted with a key version number below this, e.g. tickets issued before a password rotation (0 = any)."},
+				"max_spnego_bytes":           {Type: framework.TypeInt, Description: "Reject base64 SPNEGO tokens longer than this for this role; cannot exceed the global 64KiB limit (0 = global limit only)."},
+				"ignore_pac_logon_time_skew": {Type: framework.TypeBool, Description: "Skip the PAC logon time clock skew check for accounts that legitimately present old logon times; the ticket authenticator time is still checked (default false)."},
+				"require_initial":            {Type: framework.TypeBool, Description: "Only accept service tickets carrying the INITIAL flag, i.e. requested directly with the account's credentials rather than with a TGT (default false)."},
+				"account_type":               {Type: framework.TypeString, Description: "Admit only user or machine accounts, as marked by the PAC's UserAccountControl; gMSAs are machine accounts. Tickets without a validated PAC are rejected unless any (user, machine or any; default any)."},
+				"bound_user_sids":            {Type: framework.TypeString, Description: "Comma-separated user SIDs allowed to log in with this role, taken from the PAC; unlike principal names they survive account renames (empty = any account)."},
+				"ttl_from_ticket":            {Type: framework.TypeBool, Description: "Cap the token TTL, period and max TTL at the Kerberos ticket's remaining lifetime (default false)."},
+				"require_policies":           {Type: framework.TypeBool, Description: "Reject logins whose resolved policy set is empty instead of issuing a token with only the implicit default policy (default false)."},
+				"invalidate_on_rotation":     {Type: framework.TypeBool, Description: "Issue renewable tokens that can no longer be renewed once the keytab is rotated to a newer kvno (default false)."},
+				"required_pac_flags":         {Type: framework.TypeString, Description: "Comma-separated PAC validation flags the login must carry, e.g. SIGNATURES_VALID,UPN_CONSISTENT; FLAG=false requires a flag to be unset (empty = no requirement)."},
+				"include_resource_groups":    {Type: framework.TypeBool, Default: true, Description: "Match bound_group_sids against group SIDs from the user's resource domain as well as their account domain (default true)."},
 			},
 			Operations: map[logical.Operation]framework.OperationHandler{
 				// Use Update for writes to avoid requiring ExistenceCheck
@@ -33,6 +48,37 @@ func pathsRole(b *gmsaBackend) []*framework.Path {
 				logical.DeleteOperation: &framework.PathOperation{Callback: b.roleDelete},
 			},
 		},
+		{
+			Pattern:      "role/" + framework.GenericNameRegex("name") + "/policies",
+			HelpSynopsis: "Update only the policy settings of an existing role.",
+			HelpDescription: "Sets the policy-related fields that are supplied (token_policies, deny_policies, " +
+				"merge_strategy, policy_templates) in one atomic update. Realm, SPN, group and token " +
+				"constraints are left untouched.",
+			Fields: map[string]*framework.FieldSchema{
+				"name":             {Type: framework.TypeString, Description: "Role name."},
+				"token_policies":   {Type: framework.TypeString, Description: "Comma-separated default token policies."},
+				"deny_policies":    {Type: framework.TypeString, Description: "Comma-separated policies to deny (cap ceiling)."},
+				"merge_strategy":   {Type: framework.TypeString, Description: "union or override."},
+				"policy_templates": {Type: framework.TypeBool, Description: "Resolve {{variable}} placeholders in token_policies at login."},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{Callback: b.rolePoliciesWrite},
+			},
+		},
+		{
+			Pattern:      "role/" + framework.GenericNameRegex("name") + "/bind-groups",
+			HelpSynopsis: "Replace a role's bound group SIDs with the SIDs of named AD groups.",
+			HelpDescription: "Resolves each group's sAMAccountName to its objectSid over LDAP, using the " +
+				"group_names_* settings in config, and stores the SIDs as bound_group_sids. If any " +
+				"name doesn't resolve, the role is left unchanged and the unresolved names are reported.",
+			Fields: map[string]*framework.FieldSchema{
+				"name":   {Type: framework.TypeString, Description: "Role name."},
+				"groups": {Type: framework.TypeString, Description: "Comma-separated group sAMAccountNames."},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{Callback: b.roleBindGroupsWrite},
+			},
+		},
 		{
 			Pattern:      "role/?",
 			HelpSynopsis: "List all roles.",
@@ -56,19 +102,53 @@ func (b *gmsaBackend) roleWrite(ctx context.Context, req *logical.Request, d *fr
 		return logical.ErrorResponse("role name is required"), nil
 	}
 
+	b.roleLock.Lock()
+	defer b.roleLock.Unlock()
+
+	// Writing only "disabled" toggles the flag and keeps the rest of the role
+	if onlyDisabledField(d.Raw) {
+		existing, err := readRole(ctx, b.storage, name)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			existing.Disabled = d.Get("disabled").(bool)
+			if err := writeRole(ctx, b.storage, existing); err != nil {
+				return nil, err
+			}
+			return &logical.Response{Data: existing.Safe()}, nil
+		}
+	}
+
 	tokenTypeRaw, _ := d.Get("token_type").(string)
 	role := Role{
-		Name:           name,
-		AllowedRealms:  csvToSlice(d.Get("allowed_realms")),
-		AllowedSPNs:    csvToSlice(d.Get("allowed_spns")),
-		BoundGroupSIDs: csvToSlice(d.Get("bound_group_sids")),
-		TokenPolicies:  csvToSlice(d.Get("token_policies")),
-		TokenType:      tokenTypeRaw,
-		Period:         intOrDefault(d.Get("period"), 0),
-		MaxTTL:         intOrDefault(d.Get("max_ttl"), 0),
-		DenyPolicies:   csvToSlice(d.Get("deny_policies")),
-		MergeStrategy:  mergeStrategyOrDefault(d.Get("merge_strategy")),
+		Name:               name,
+		AllowedRealms:      csvToSlice(d.Get("allowed_realms")),
+		AllowedSPNs:        csvToSlice(d.Get("allowed_spns")),
+		BoundGroupSIDs:     canonicalSIDs(csvToSlice(d.Get("bound_group_sids"))),
+		TokenPolicies:      csvToSlice(d.Get("token_policies")),
+		TokenType:          tokenTypeRaw,
+		Period:             intOrDefault(d.Get("period"), 0),
+		MaxTTL:             intOrDefault(d.Get("max_ttl"), 0),
+		DenyPolicies:       csvToSlice(d.Get("deny_policies")),
+		MergeStrategy:      mergeStrategyOrDefault(d.Get("merge_strategy")),
+		MaxGroupSIDs:       intOrDefault(d.Get("max_group_sids"), 0),
+		BoundClientCertCNs: csvToSlice(d.Get("bound_client_cert_cns")),
+		MinKVNO:            intOrDefault(d.Get("min_kvno"), 0),
+		MaxSPNEGOBytes:     intOrDefault(d.Get("max_spnego_bytes"), 0),
+		AccountType:        d.Get("account_type").(string),
+		BoundUserSIDs:      canonicalSIDs(csvToSlice(d.Get("bound_user_sids"))),
+		RequiredPACFlags:   unique(csvToSlice(d.Get("required_pac_flags"))),
 	}
+	role.PolicyTemplates, _ = d.Get("policy_templates").(bool)
+	role.Disabled, _ = d.Get("disabled").(bool)
+	role.RequirePolicies, _ = d.Get("require_policies").(bool)
+	role.TTLFromTicket, _ = d.Get("ttl_from_ticket").(bool)
+	role.InvalidateOnRotation, _ = d.Get("invalidate_on_rotation").(bool)
+	role.IgnorePACLogonTimeSkew, _ = d.Get("ignore_pac_logon_time_skew").(bool)
+	role.RequireInitial, _ = d.Get("require_initial").(bool)
+	includeResourceGroups, _ := d.Get("include_resource_groups").(bool)
+	role.ExcludeResourceGroups = !includeResourceGroups
 	// Validate SID format if provided in raw input
 	boundGroupSIDsRaw, _ := d.Get("bound_group_sids").(string)
 	if d.Raw != nil {
@@ -84,7 +164,7 @@ func (b *gmsaBackend) roleWrite(ctx context.Context, req *logical.Request, d *fr
 				if sid == "" {
 					return logical.ErrorResponse("SID cannot be empty"), nil
 				}
-				if !isValidSID(sid) {
+				if _, ok := canonicalSID(sid); !ok {
 					return logical.ErrorResponse("invalid SID format: " + sid), nil
 				}
 			}
@@ -94,12 +174,21 @@ func (b *gmsaBackend) roleWrite(ctx context.Context, req *logical.Request, d *fr
 	if err := validateRole(&role); err != nil {
 		return logical.ErrorResponse(err.Error()), nil
 	}
+	if len(role.BoundGroupSIDs) > 0 {
+		disabled, err := pacProcessingDisabled(ctx, b.storage)
+		if err != nil {
+			return nil, err
+		}
+		if disabled {
+			return logical.ErrorResponse("bound_group_sids requires PAC processing; unset disable_pac_processing in config first"), nil
+		}
+	}
 	// Validate durations: non-negative, reasonable caps (<= 24h)
-	if role.Period < 0 || role.Period > int(24*time.Hour/time.Second) {
-		return logical.ErrorResponse("period must be between 0 and 86400 seconds"), nil
+	if err := validateRoleDuration("period", role.Period); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
 	}
-	if role.MaxTTL < 0 || role.MaxTTL > int(24*time.Hour/time.Second) {
-		return logical.ErrorResponse("max_ttl must be between 0 and 86400 seconds"), nil
+	if err := validateRoleDuration("max_ttl", role.MaxTTL); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
 	}
 	// Validate merge strategy - must be explicitly set to valid values
 	mergeStrategyRaw, _ := d.Get("merge_strategy").(string)
@@ -109,11 +198,9 @@ func (b *gmsaBackend) roleWrite(ctx context.Context, req *logical.Request, d *fr
 	// Normalize policy lists (dedupe)
 	role.TokenPolicies = unique(role.TokenPolicies)
 	role.DenyPolicies = unique(role.DenyPolicies)
-	// Validate token type and normalize
+	// Validate token type; empty inherits the mount's default_token_type
 	switch role.TokenType {
-	case "", "default":
-		role.TokenType = "default"
-	case "service":
+	case "", "default", "service":
 		// ok
 	default:
 		return logical.ErrorResponse("token_type must be 'default' or 'service'"), nil
@@ -124,6 +211,138 @@ func (b *gmsaBackend) roleWrite(ctx context.Context, req *logical.Request, d *fr
 	return &logical.Response{Data: role.Safe()}, nil
 }
 
+// maxRoleDuration caps a role's period and max_ttl
+const maxRoleDuration = 24 * time.Hour
+
+// validateRoleDuration bounds a role duration field given in seconds. Fields
+// accept duration strings like 12h, so errors name the values as durations.
+func validateRoleDuration(field string, seconds int) error {
+	d := time.Duration(seconds) * time.Second
+	if d < 0 {
+		return fmt.Errorf("%s must not be negative, got %v", field, d)
+	}
+	if d > maxRoleDuration {
+		return fmt.Errorf("%s must be at most 24 hours (86400 seconds), got %v", field, d)
+	}
+	return nil
+}
+
+// onlyDisabledField reports whether a role write sets nothing but "disabled"
+// (the name path parameter aside)
+func onlyDisabledField(raw map[string]interface{}) bool {
+	if _, ok := raw["disabled"]; !ok {
+		return false
+	}
+	for k := range raw {
+		if k != "disabled" && k != "name" {
+			return false
+		}
+	}
+	return true
+}
+
+// rolePoliciesWrite updates the policy fields of an existing role that are
+// present in the request, leaving every other field as stored
+func (b *gmsaBackend) rolePoliciesWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+
+	b.roleLock.Lock()
+	defer b.roleLock.Unlock()
+
+	role, err := readRole(ctx, b.storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse(fmt.Sprintf("role %q not found", name)), nil
+	}
+
+	if _, ok := d.GetOk("token_policies"); ok {
+		role.TokenPolicies = unique(csvToSlice(d.Get("token_policies")))
+	}
+	if _, ok := d.GetOk("deny_policies"); ok {
+		role.DenyPolicies = unique(csvToSlice(d.Get("deny_policies")))
+	}
+	if raw, ok := d.GetOk("merge_strategy"); ok {
+		if s := raw.(string); s != "union" && s != "override" {
+			return logical.ErrorResponse("merge_strategy must be 'union' or 'override'"), nil
+		}
+		role.MergeStrategy = raw.(string)
+	}
+	if raw, ok := d.GetOk("policy_templates"); ok {
+		role.PolicyTemplates = raw.(bool)
+	}
+
+	if err := validateRole(role); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err := writeRole(ctx, b.storage, role); err != nil {
+		return nil, err
+	}
+	return &logical.Response{Data: role.Safe()}, nil
+}
+
+func (b *gmsaBackend) roleBindGroupsWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	groups := unique(csvToSlice(d.Get("groups")))
+	if len(groups) == 0 {
+		return logical.ErrorResponse("groups is required"), nil
+	}
+
+	cfg, err := b.loadConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil || cfg.GroupNames.LDAPURL == "" || cfg.GroupNames.BaseDN == "" {
+		return logical.ErrorResponse("resolving group names requires group_names_ldap_url and group_names_base_dn in config"), nil
+	}
+	if cfg.DisablePACProcessing {
+		return logical.ErrorResponse("bound_group_sids requires PAC processing; unset disable_pac_processing in config first"), nil
+	}
+
+	// Resolve before taking the role lock so a slow DC doesn't block other
+	// role updates
+	lookupCtx, cancel := context.WithTimeout(ctx, groupNameLookupTimeout)
+	resolved, err := b.groupSIDs.resolveNames(lookupCtx, cfg.GroupNames, groups)
+	cancel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve group names: %w", err)
+	}
+	var sids, unresolved []string
+	for _, group := range groups {
+		if sid, ok := resolved[group]; ok {
+			sids = append(sids, sid)
+		} else {
+			unresolved = append(unresolved, group)
+		}
+	}
+	if len(unresolved) > 0 {
+		return logical.ErrorResponse(fmt.Sprintf("groups not found in the directory: %s", strings.Join(unresolved, ", "))), nil
+	}
+
+	b.roleLock.Lock()
+	defer b.roleLock.Unlock()
+
+	role, err := readRole(ctx, b.storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse(fmt.Sprintf("role %q not found", name)), nil
+	}
+	role.BoundGroupSIDs = unique(canonicalSIDs(sids))
+	if err := validateRole(role); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err := writeRole(ctx, b.storage, role); err != nil {
+		return nil, err
+	}
+
+	data := role.Safe()
+	data["resolved_groups"] = resolved
+	return &logical.Response{Data: data}, nil
+}
+
 func (b *gmsaBackend) roleRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 	// Extract name from URL path
 	pathParts := strings.Split(req.Path, "/")
@@ -132,7 +351,7 @@ func (b *gmsaBackend) roleRead(ctx context.Context, req *logical.Request, d *fra
 	}
 	name := pathParts[len(pathParts)-1]
 
-	role, err := readRole(ctx, b.storage, name)
+	role, err := b.loadRole(ctx, name)
 	if err != nil {
 		return nil, err
 	}
@@ -150,6 +369,8 @@ func (b *gmsaBackend) roleDelete(ctx context.Context, req *logical.Request, d *f
 	}
 	name := pathParts[len(pathParts)-1]
 
+	b.roleLock.Lock()
+	defer b.roleLock.Unlock()
 	if err := deleteRole(ctx, b.storage, name); err != nil {
 		return nil, err
 	}
@@ -161,5 +382,17 @@ func (b *gmsaBackend) roleList(ctx context.Context, req *logical.Request, _ *fra
 	if err != nil {
 		return nil, err
 	}
-	return logical.ListResponse(keys), nil
+	// Flag disabled roles so operators can spot them in listings
+	keyInfo := make(map[string]interface{}, len(keys))
+	for _, name := range keys {
+		role, err := b.loadRole(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if role == nil {
+			continue
+		}
+		keyInfo[name] = map[string]interface{}{"disabled": role.Disabled}
+	}
+	return logical.ListResponseWithInfo(keys, keyInfo), nil
 }
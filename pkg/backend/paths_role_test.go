@@ -2,6 +2,7 @@ package backend
 
 import (
 	"context"
+	"reflect"
 	"sort"
 	"strings"
 	"sync"
@@ -55,6 +56,7 @@ func getTestBackend(t *testing.T) (*gmsaBackend, logical.Storage) {
 		t.Fatalf("failed to create backend: %v", err)
 	}
 	ret := b.(*gmsaBackend)
+	t.Cleanup(func() { ret.Cleanup(context.Background()) })
 	return ret, ms
 }
 
@@ -122,3 +124,515 @@ func (m *memStorage) List(_ context.Context, prefix string) ([]string, error) {
 	sort.Strings(out)
 	return out, nil
 }
+
+func TestRole_DisableAndReenable(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+
+	write := func(data map[string]interface{}) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "role/app",
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("role write failed: %v, %#v", err, resp)
+		}
+		return resp
+	}
+	login := func() *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation:  logical.UpdateOperation,
+			Path:       "login",
+			Storage:    storage,
+			Data:       map[string]interface{}{"role": "app", "spnego": "dGVzdA=="},
+			Connection: &logical.Connection{RemoteAddr: "127.0.0.1"},
+		})
+		if err != nil || resp == nil {
+			t.Fatalf("unexpected login result: %v, %#v", err, resp)
+		}
+		return resp
+	}
+
+	write(map[string]interface{}{"token_policies": "app-read", "allowed_realms": "EXAMPLE.COM"})
+
+	// Disabling alone keeps the rest of the role
+	resp := write(map[string]interface{}{"disabled": true})
+	if resp.Data["disabled"] != true || resp.Data["token_policies"] != "app-read" {
+		t.Fatalf("unexpected role after disable: %#v", resp.Data)
+	}
+
+	before := failureReasonCount(failureReasonRoleDisabled)
+	resp = login()
+	if !resp.IsError() || !strings.Contains(resp.Error().Error(), "disabled") {
+		t.Fatalf("expected role disabled error, got: %#v", resp)
+	}
+	if got := failureReasonCount(failureReasonRoleDisabled); got != before+1 {
+		t.Errorf("role_disabled = %d, want %d", got, before+1)
+	}
+
+	// Listing flags the disabled role
+	resp, err := b.HandleRequest(ctx, &logical.Request{Operation: logical.ListOperation, Path: "role/", Storage: storage})
+	if err != nil || resp == nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	keyInfo, _ := resp.Data["key_info"].(map[string]interface{})
+	info, _ := keyInfo["app"].(map[string]interface{})
+	if info["disabled"] != true {
+		t.Errorf("list key_info for app = %#v, want disabled", keyInfo["app"])
+	}
+
+	// Re-enabling restores login processing (which now fails later, at Kerberos)
+	resp = write(map[string]interface{}{"disabled": false})
+	if resp.Data["disabled"] != false || resp.Data["allowed_realms"] != "EXAMPLE.COM" {
+		t.Fatalf("unexpected role after enable: %#v", resp.Data)
+	}
+	resp = login()
+	if resp.IsError() && strings.Contains(resp.Error().Error(), "disabled") {
+		t.Errorf("role still disabled after re-enable: %v", resp.Error())
+	}
+}
+
+func TestRoleWrite_MaxGroupSIDs(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+
+	write := func(v interface{}) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "role/capped",
+			Storage:   storage,
+			Data:      map[string]interface{}{"max_group_sids": v},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return resp
+	}
+
+	if resp := write(-1); resp == nil || !resp.IsError() {
+		t.Fatalf("expected error for negative max_group_sids, got: %#v", resp)
+	}
+	resp := write(50)
+	if resp == nil || resp.IsError() {
+		t.Fatalf("unexpected response: %#v", resp)
+	}
+	if got := resp.Data["max_group_sids"]; got != 50 {
+		t.Errorf("max_group_sids = %v, want 50", got)
+	}
+}
+
+func TestRoleWrite_MinKVNO(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	for _, tc := range []struct {
+		value   interface{}
+		wantErr bool
+	}{{-1, true}, {3, false}} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "role/sensitive",
+			Storage:   storage,
+			Data:      map[string]interface{}{"min_kvno": tc.value},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if resp == nil || resp.IsError() != tc.wantErr {
+			t.Fatalf("min_kvno=%v: unexpected response: %#v", tc.value, resp)
+		}
+		if !tc.wantErr && resp.Data["min_kvno"] != 3 {
+			t.Errorf("min_kvno = %v, want 3", resp.Data["min_kvno"])
+		}
+	}
+}
+
+func TestRoleWrite_RequiredPACFlags(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	for _, tc := range []struct {
+		value   string
+		wantErr bool
+	}{
+		{"SIGNATURES_VALID,UPN_CONSISTENT", false},
+		{"SID_HISTORY_FILTERED=false", false},
+		{"PAC_VALIDATED,ENCTYPE_DOWNGRADE=false", false},
+		{"KDC_SIGNATURE_UNVERIFIED=false", true},
+		{"SIGNATURE_VALID", true},
+		{"signatures_valid", true},
+		{"SIGNATURES_VALID=yes", true},
+		{"=true", true},
+	} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "role/strict",
+			Storage:   storage,
+			Data:      map[string]interface{}{"required_pac_flags": tc.value},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if resp == nil || resp.IsError() != tc.wantErr {
+			t.Fatalf("required_pac_flags=%q: unexpected response: %#v", tc.value, resp)
+		}
+		if !tc.wantErr && resp.Data["required_pac_flags"] != tc.value {
+			t.Errorf("required_pac_flags = %v, want %q", resp.Data["required_pac_flags"], tc.value)
+		}
+	}
+}
+
+func TestRoleWrite_DurationStrings(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	for _, tc := range []struct {
+		field   string
+		value   interface{}
+		want    int
+		wantErr string
+	}{
+		{field: "max_ttl", value: "12h", want: 43200},
+		{field: "period", value: "90m", want: 5400},
+		{field: "max_ttl", value: 3600, want: 3600},
+		{field: "max_ttl", value: "48h", wantErr: "max_ttl must be at most 24 hours (86400 seconds), got 48h0m0s"},
+		{field: "period", value: "24h1s", wantErr: "got 24h0m1s"},
+	} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "role/timed",
+			Storage:   storage,
+			Data:      map[string]interface{}{tc.field: tc.value},
+		})
+		if err != nil {
+			t.Fatalf("%s=%v: unexpected error: %v", tc.field, tc.value, err)
+		}
+		if resp == nil {
+			t.Fatalf("%s=%v: nil response", tc.field, tc.value)
+		}
+		if tc.wantErr != "" {
+			if !resp.IsError() || !strings.Contains(resp.Error().Error(), tc.wantErr) {
+				t.Errorf("%s=%v: response = %#v, want error containing %q", tc.field, tc.value, resp, tc.wantErr)
+			}
+			continue
+		}
+		if resp.IsError() {
+			t.Fatalf("%s=%v: unexpected error: %v", tc.field, tc.value, resp.Error())
+		}
+		if resp.Data[tc.field] != tc.want {
+			t.Errorf("%s = %v, want %d", tc.field, resp.Data[tc.field], tc.want)
+		}
+	}
+}
+
+func TestRoleWrite_IncludeResourceGroups(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		data map[string]interface{}
+		want bool
+	}{
+		{map[string]interface{}{"token_policies": "default"}, true},
+		{map[string]interface{}{"include_resource_groups": false, "bound_group_sids": "S-1-5-21-1-2-3-513"}, false},
+		{map[string]interface{}{"include_resource_groups": true}, true},
+	} {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "role/forest",
+			Storage:   storage,
+			Data:      tc.data,
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("write %v: err=%v resp=%#v", tc.data, err, resp)
+		}
+		if got := resp.Data["include_resource_groups"]; got != tc.want {
+			t.Errorf("write %v: include_resource_groups = %v, want %v", tc.data, got, tc.want)
+		}
+		role, err := readRole(ctx, storage, "forest")
+		if err != nil || role == nil {
+			t.Fatalf("readRole: role=%v err=%v", role, err)
+		}
+		if role.ExcludeResourceGroups == tc.want {
+			t.Errorf("write %v: stored ExcludeResourceGroups = %v", tc.data, role.ExcludeResourceGroups)
+		}
+	}
+}
+
+func TestRoleWrite_RejectsContradictoryFields(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		data map[string]interface{}
+		msg  string
+	}{
+		{
+			name: "policy granted and denied",
+			data: map[string]interface{}{"token_policies": "app-read,app-write", "deny_policies": "APP-WRITE"},
+			msg:  `policy "app-write" is in both token_policies and deny_policies`,
+		},
+		{
+			name: "resource groups excluded without bound groups",
+			data: map[string]interface{}{"token_policies": "app-read", "include_resource_groups": false},
+			msg:  "include_resource_groups=false has no effect without bound_group_sids",
+		},
+		{
+			name: "override without token policies",
+			data: map[string]interface{}{"merge_strategy": "override", "deny_policies": "dev-only"},
+			msg:  "merge_strategy=override requires token_policies; without them the mount's default_policies apply unchanged",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := b.HandleRequest(ctx, &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "role/contradictory",
+				Storage:   storage,
+				Data:      tt.data,
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if resp == nil || !resp.IsError() || resp.Error().Error() != tt.msg {
+				t.Fatalf("expected error %q, got: %#v", tt.msg, resp)
+			}
+		})
+	}
+
+	// The policies endpoint applies the same checks to the merged role
+	if err := writeRole(ctx, storage, &Role{Name: "app", TokenPolicies: []string{"app-read"}}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/app/policies",
+		Storage:   storage,
+		Data:      map[string]interface{}{"deny_policies": "app-read"},
+	})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected error denying a granted policy, got: err=%v resp=%#v", err, resp)
+	}
+}
+
+func TestRoleWrite_RejectsMalformedSIDs(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	for _, sids := range []string{"S--", "Sx-1", "S-1-5", "S-1-5-21-abc", "S-1-5-21-4294967296", "S-1-5-21-1-513,S-1-5-021-1"} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "role/sids",
+			Storage:   storage,
+			Data:      map[string]interface{}{"bound_group_sids": sids},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if resp == nil || !resp.IsError() {
+			t.Errorf("bound_group_sids %q accepted: %#v", sids, resp)
+		}
+	}
+}
+
+func TestRoleWrite_CanonicalizesSIDs(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/sids",
+		Storage:   storage,
+		Data:      map[string]interface{}{"bound_group_sids": "s-1-5-21-1-2-3-513, S-1-5-32-544"},
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("role write failed: err=%v resp=%#v", err, resp)
+	}
+	role, err := readRole(ctx, storage, "sids")
+	if err != nil || role == nil {
+		t.Fatalf("read role: %v", err)
+	}
+	if want := []string{"S-1-5-21-1-2-3-513", "S-1-5-32-544"}; !reflect.DeepEqual(role.BoundGroupSIDs, want) {
+		t.Errorf("BoundGroupSIDs = %v, want %v", role.BoundGroupSIDs, want)
+	}
+}
+
+func TestRolePolicies_UpdateKeepsConstraints(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+
+	original := &Role{
+		Name:           "app",
+		AllowedRealms:  []string{"EXAMPLE.COM"},
+		AllowedSPNs:    []string{"HTTP/vault.example.com"},
+		BoundGroupSIDs: []string{"S-1-5-21-1-2-3-1104"},
+		TokenPolicies:  []string{"old"},
+		DenyPolicies:   []string{"root"},
+		TokenType:      "service",
+		Period:         3600,
+		MaxTTL:         7200,
+		MergeStrategy:  "union",
+		MaxGroupSIDs:   50,
+	}
+	if err := writeRole(ctx, storage, original); err != nil {
+		t.Fatal(err)
+	}
+
+	update := func(data map[string]interface{}) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "role/app/policies",
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return resp
+	}
+
+	resp := update(map[string]interface{}{"token_policies": "app-read,app-write,app-read", "merge_strategy": "override"})
+	if resp == nil || resp.IsError() {
+		t.Fatalf("unexpected response: %#v", resp)
+	}
+
+	role, err := readRole(ctx, storage, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(role.TokenPolicies, ","); got != "app-read,app-write" {
+		t.Errorf("token_policies = %q, want %q", got, "app-read,app-write")
+	}
+	if role.MergeStrategy != "override" {
+		t.Errorf("merge_strategy = %q, want override", role.MergeStrategy)
+	}
+	// Fields not in the request keep their stored values
+	want := *original
+	want.TokenPolicies = role.TokenPolicies
+	want.MergeStrategy = role.MergeStrategy
+	if !reflect.DeepEqual(*role, want) {
+		t.Errorf("role after policy update = %+v, want %+v", *role, want)
+	}
+
+	if resp := update(map[string]interface{}{"deny_policies": ""}); resp == nil || resp.IsError() {
+		t.Fatalf("unexpected response clearing deny_policies: %#v", resp)
+	}
+	role, _ = readRole(ctx, storage, "app")
+	if len(role.DenyPolicies) != 0 || len(role.TokenPolicies) != 2 {
+		t.Errorf("deny_policies = %v, token_policies = %v", role.DenyPolicies, role.TokenPolicies)
+	}
+
+	for _, data := range []map[string]interface{}{
+		{"token_policies": "bad policy!"},
+		{"merge_strategy": "replace"},
+	} {
+		if resp := update(data); resp == nil || !resp.IsError() {
+			t.Errorf("expected error for %v, got: %#v", data, resp)
+		}
+	}
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/missing/policies",
+		Storage:   storage,
+		Data:      map[string]interface{}{"token_policies": "x"},
+	})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Errorf("expected error for missing role, got: %#v, %v", resp, err)
+	}
+}
+
+// mockGroupSIDResolver maps group names to SIDs like a directory would
+type mockGroupSIDResolver struct {
+	sids    map[string]string
+	queries [][]string
+}
+
+func (m *mockGroupSIDResolver) resolveNames(_ context.Context, _ GroupNamesConfig, names []string) (map[string]string, error) {
+	m.queries = append(m.queries, names)
+	out := make(map[string]string)
+	for _, name := range names {
+		if sid, ok := m.sids[strings.ToLower(name)]; ok {
+			out[name] = sid
+		}
+	}
+	return out, nil
+}
+
+func TestRoleBindGroups(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	resolver := &mockGroupSIDResolver{sids: map[string]string{
+		"domain admins": "S-1-5-21-1-2-3-512",
+		"vault-users":   "S-1-5-21-1-2-3-1105",
+	}}
+	b.groupSIDs = resolver
+
+	bindGroups := func(role, groups string) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "role/" + role + "/bind-groups",
+			Storage:   storage,
+			Data:      map[string]interface{}{"groups": groups},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return resp
+	}
+
+	if err := writeRole(ctx, storage, &Role{Name: "app", BoundGroupSIDs: []string{"S-1-5-21-1-2-3-513"}, TokenPolicies: []string{"app"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// No LDAP settings in config
+	if resp := bindGroups("app", "Domain Admins"); resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "group_names_ldap_url") {
+		t.Fatalf("expected an LDAP config error, got %#v", resp)
+	}
+	if len(resolver.queries) != 0 {
+		t.Fatalf("queried LDAP without config: %v", resolver.queries)
+	}
+
+	if err := writeConfig(ctx, storage, &Config{Realm: "EXAMPLE.COM", GroupNames: GroupNamesConfig{LDAPURL: "ldaps://dc1.example.com", BaseDN: "DC=example,DC=com"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := bindGroups("app", "Domain Admins,vault-users")
+	if resp == nil || resp.IsError() {
+		t.Fatalf("unexpected response: %#v", resp)
+	}
+	role, err := readRole(ctx, storage, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"S-1-5-21-1-2-3-512", "S-1-5-21-1-2-3-1105"}; !reflect.DeepEqual(role.BoundGroupSIDs, want) {
+		t.Errorf("bound_group_sids = %v, want %v", role.BoundGroupSIDs, want)
+	}
+	if got := resp.Data["resolved_groups"].(map[string]string)["Domain Admins"]; got != "S-1-5-21-1-2-3-512" {
+		t.Errorf("resolved_groups[Domain Admins] = %q", got)
+	}
+	if !reflect.DeepEqual(role.TokenPolicies, []string{"app"}) {
+		t.Errorf("token_policies = %v, want them untouched", role.TokenPolicies)
+	}
+
+	// An unresolved name fails the whole update
+	resp = bindGroups("app", "vault-users,Ghosts")
+	if resp == nil || !resp.IsError() || !strings.HasSuffix(resp.Error().Error(), ": Ghosts") {
+		t.Fatalf("expected an error naming the unresolved group, got %#v", resp)
+	}
+	if role, _ := readRole(ctx, storage, "app"); len(role.BoundGroupSIDs) != 2 {
+		t.Errorf("bound_group_sids = %v, want the role unchanged", role.BoundGroupSIDs)
+	}
+
+	if resp := bindGroups("missing", "vault-users"); resp == nil || !resp.IsError() {
+		t.Errorf("expected an error for a missing role, got %#v", resp)
+	}
+	if resp := bindGroups("app", ""); resp == nil || !resp.IsError() {
+		t.Errorf("expected an error without groups, got %#v", resp)
+	}
+}
@@ -2,6 +2,9 @@ package backend
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net/http"
 	"runtime"
 	"time"
 
@@ -25,6 +28,10 @@ func pathsRotation(b *gmsaBackend) []*framework.Path {
 					Description: "How often to check for password changes (in seconds)",
 					Default:     3600, // 1 hour
 				},
+				"check_interval_jitter": {
+					Type:        framework.TypeDurationSecond,
+					Description: "Randomize each check by up to this many seconds either way to spread load on the domain controller (default 0, no jitter)",
+				},
 				"rotation_threshold": {
 					Type:        framework.TypeDurationSecond,
 					Description: "When to rotate before expiry (in seconds)",
@@ -66,6 +73,27 @@ func pathsRotation(b *gmsaBackend) []*framework.Path {
 					Type:        framework.TypeString,
 					Description: "Webhook endpoint for rotation notifications",
 				},
+				"notification_endpoints": {
+					Type:        framework.TypeSlice,
+					Description: "Additional webhook endpoints, as objects with url and optional headers (name to value) and hmac_secret sent only to that endpoint, or as plain URLs; every notification is sent to each of them and to notification_endpoint, and a failing endpoint doesn't block the others. Headers and secrets are never returned on read",
+				},
+				"notification_headers": {
+					Type:        framework.TypeKVPairs,
+					Description: "Extra headers sent with webhook notifications, as key=value pairs (e.g. Authorization=Bearer ...). Values are secret and never returned on read",
+				},
+				"notification_hmac_secret": {
+					Type:        framework.TypeString,
+					Description: "Secret for signing webhook bodies with HMAC-SHA256, sent as X-GMSA-Signature: sha256=<hex>. Never returned on read",
+				},
+				"rotation_grace_period": {
+					Type:        framework.TypeDurationSecond,
+					Description: "How long the replaced keytab keeps validating in-flight tickets after rotation (in seconds, 0 disables)",
+					Default:     0,
+				},
+				"gmsa_container_dn": {
+					Type:        framework.TypeString,
+					Description: "DN of the container holding the gMSA account for password age queries (default CN=Managed Service Accounts under the realm's domain, e.g. CN=Managed Service Accounts,DC=example,DC=com)",
+				},
 			},
 			Operations: map[logical.Operation]framework.OperationHandler{
 				logical.UpdateOperation: &framework.PathOperation{
@@ -128,6 +156,28 @@ func pathsRotation(b *gmsaBackend) []*framework.Path {
 			HelpSynopsis:    "Trigger manual rotation",
 			HelpDescription: "Manually trigger password rotation",
 		},
+		{
+			Pattern: "rotation/restart$",
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.rotationRestart,
+					Summary:  "Restart automatic rotation",
+				},
+			},
+			HelpSynopsis:    "Restart automatic rotation",
+			HelpDescription: "Stop the rotation loop if it is running and start a fresh one from the stored configuration, resetting its status",
+		},
+		{
+			Pattern: "rotation/test-notification$",
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.rotationTestNotification,
+					Summary:  "Send a test webhook notification",
+				},
+			},
+			HelpSynopsis:    "Send a test webhook notification",
+			HelpDescription: "Send a synthetic \"test\" event to the configured notification endpoint, with its headers and signature, and report the HTTP status. The delivery is attempted once, without retries",
+		},
 	}
 }
 
@@ -136,6 +186,7 @@ func (b *gmsaBackend) rotationConfigWrite(ctx context.Context, req *logical.Requ
 	config := &RotationConfig{
 		Enabled:              d.Get("enabled").(bool),
 		CheckInterval:        time.Duration(d.Get("check_interval").(int)) * time.Second,
+		CheckIntervalJitter:  time.Duration(d.Get("check_interval_jitter").(int)) * time.Second,
 		RotationThreshold:    time.Duration(d.Get("rotation_threshold").(int)) * time.Second,
 		MaxRetries:           d.Get("max_retries").(int),
 		RetryDelay:           time.Duration(d.Get("retry_delay").(int)) * time.Second,
@@ -145,7 +196,16 @@ func (b *gmsaBackend) rotationConfigWrite(ctx context.Context, req *logical.Requ
 		KeytabCommand:        d.Get("keytab_command").(string),
 		BackupKeytabs:        d.Get("backup_keytabs").(bool),
 		NotificationEndpoint: d.Get("notification_endpoint").(string),
+		NotificationHeaders:  notificationHeaders(d.Get("notification_headers").(map[string]string)),
+		NotificationSecret:   d.Get("notification_hmac_secret").(string),
+		GracePeriod:          time.Duration(d.Get("rotation_grace_period").(int)) * time.Second,
+		GMSAContainerDN:      d.Get("gmsa_container_dn").(string),
+	}
+	targets, err := notificationTargets(d.Get("notification_endpoints").([]interface{}))
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
 	}
+	config.NotificationEndpoints = targets
 
 	// Validate configuration
 	if err := config.Validate(); err != nil {
@@ -190,6 +250,7 @@ func (b *gmsaBackend) rotationConfigWrite(ctx context.Context, req *logical.Requ
 		Data: map[string]interface{}{
 			"enabled":               config.Enabled,
 			"check_interval":        int(config.CheckInterval.Seconds()),
+			"check_interval_jitter": int(config.CheckIntervalJitter.Seconds()),
 			"rotation_threshold":    int(config.RotationThreshold.Seconds()),
 			"max_retries":           config.MaxRetries,
 			"retry_delay":           int(config.RetryDelay.Seconds()),
@@ -197,7 +258,9 @@ func (b *gmsaBackend) rotationConfigWrite(ctx context.Context, req *logical.Requ
 			"domain_admin_user":     config.DomainAdminUser,
 			"keytab_command":        config.KeytabCommand,
 			"backup_keytabs":        config.BackupKeytabs,
-			"notification_endpoint": config.NotificationEndpoint,
+			"notification_endpoint": redactedEndpoint(config.NotificationEndpoint),
+			"rotation_grace_period": int(config.GracePeriod.Seconds()),
+			"gmsa_container_dn":     config.GMSAContainerDN,
 		},
 	}, nil
 }
@@ -217,20 +280,7 @@ func (b *gmsaBackend) rotationConfigRead(ctx context.Context, req *logical.Reque
 		return nil, err
 	}
 
-	return &logical.Response{
-		Data: map[string]interface{}{
-			"enabled":               config.Enabled,
-			"check_interval":        int(config.CheckInterval.Seconds()),
-			"rotation_threshold":    int(config.RotationThreshold.Seconds()),
-			"max_retries":           config.MaxRetries,
-			"retry_delay":           int(config.RetryDelay.Seconds()),
-			"domain_controller":     config.DomainController,
-			"domain_admin_user":     config.DomainAdminUser,
-			"keytab_command":        config.KeytabCommand,
-			"backup_keytabs":        config.BackupKeytabs,
-			"notification_endpoint": config.NotificationEndpoint,
-		},
-	}, nil
+	return &logical.Response{Data: config.Safe()}, nil
 }
 
 // rotationConfigDelete handles rotation configuration deletion
@@ -269,6 +319,11 @@ func (b *gmsaBackend) rotationStatusRead(ctx context.Context, req *logical.Reque
 			"password_age":    status.PasswordAge,
 			"password_expiry": status.PasswordExpiry.Format(time.RFC3339),
 			"is_running":      b.rotationManager.IsRunning(),
+			"ldap_breaker": map[string]interface{}{
+				"state":                status.LDAPBreaker.State,
+				"consecutive_failures": status.LDAPBreaker.ConsecutiveFailures,
+				"retry_at":             status.LDAPBreaker.RetryAt.Format(time.RFC3339),
+			},
 		},
 	}, nil
 }
@@ -326,6 +381,55 @@ func (b *gmsaBackend) rotationStop(ctx context.Context, req *logical.Request, d
 	}, nil
 }
 
+// rotationRestart replaces the rotation manager with a fresh one built from
+// the stored configuration. A stopped manager can't be started again, so the
+// old one is discarded along with its status.
+func (b *gmsaBackend) rotationRestart(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	if !b.restartLock.TryLock() {
+		return logical.ErrorResponse("rotation restart already in progress"), nil
+	}
+	defer b.restartLock.Unlock()
+
+	entry, err := b.storage.Get(ctx, "rotation/config")
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return logical.ErrorResponse("rotation configuration not found"), nil
+	}
+
+	var config RotationConfig
+	if err := entry.DecodeJSON(&config); err != nil {
+		return nil, err
+	}
+	// Leave a running loop alone rather than stop it and fail to start another
+	if !config.Enabled {
+		return logical.ErrorResponse("rotation is not enabled"), nil
+	}
+
+	if b.rotationManager != nil && b.rotationManager.IsRunning() {
+		if err := b.rotationManager.Stop(); err != nil {
+			return logical.ErrorResponse("Failed to stop rotation: %s", err.Error()), nil
+		}
+	}
+
+	if runtime.GOOS == "windows" {
+		b.rotationManager = NewRotationManager(b, &config)
+	} else {
+		b.rotationManager = NewLinuxRotationManager(b, &config)
+	}
+	if err := b.rotationManager.Start(); err != nil {
+		return logical.ErrorResponse("Failed to start rotation: %s", err.Error()), nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"status":     b.rotationManager.GetStatus().Status,
+			"is_running": b.rotationManager.IsRunning(),
+		},
+	}, nil
+}
+
 // rotationManual handles manual rotation triggers
 func (b *gmsaBackend) rotationManual(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 	if b.rotationManager == nil {
@@ -333,13 +437,16 @@ func (b *gmsaBackend) rotationManual(ctx context.Context, req *logical.Request,
 	}
 
 	// Get current configuration
-	cfg, err := readConfig(ctx, b.storage)
+	cfg, err := b.loadConfig(ctx)
 	if err != nil {
 		return logical.ErrorResponse("Failed to read config: %s", err.Error()), nil
 	}
 
 	// Perform manual rotation
 	if err := b.rotationManager.performRotation(cfg); err != nil {
+		if errors.Is(err, errRotationInProgress) {
+			return logical.ErrorResponse(err.Error()), nil
+		}
 		return logical.ErrorResponse("Manual rotation failed: %s", err.Error()), nil
 	}
 
@@ -351,3 +458,107 @@ func (b *gmsaBackend) rotationManual(ctx context.Context, req *logical.Request,
 	}, nil
 }
 
+// rotationTestNotification sends a synthetic event through the same webhook
+// fan-out the rotation managers use, without retries, so operators can check
+// the endpoints before a real rotation
+func (b *gmsaBackend) rotationTestNotification(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	entry, err := b.storage.Get(ctx, "rotation/config")
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return logical.ErrorResponse("rotation configuration not found"), nil
+	}
+
+	var config RotationConfig
+	if err := entry.DecodeJSON(&config); err != nil {
+		return nil, err
+	}
+	targets := config.notificationTargets()
+	if len(targets) == 0 {
+		return logical.ErrorResponse("notification_endpoint is not configured"), nil
+	}
+
+	payload := map[string]interface{}{
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+		"event":     "test",
+		"message":   "Test notification from the gMSA auth method",
+		"status":    "test",
+		"plugin":    "gmsa-auth",
+		"platform":  runtime.GOOS,
+	}
+	results, err := fanOutWebhook(ctx, targets, payload, 0, 0)
+	if err != nil {
+		return logical.ErrorResponse("test notification failed: %s", err.Error()), nil
+	}
+
+	delivered := make([]map[string]interface{}, len(results))
+	for i, r := range results {
+		delivered[i] = map[string]interface{}{"endpoint": r.Endpoint, "status_code": r.Status}
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"status":    "delivered",
+			"endpoints": delivered,
+		},
+	}, nil
+}
+
+// notificationHeaders converts key=value pairs into webhook request headers
+func notificationHeaders(pairs map[string]string) http.Header {
+	if len(pairs) == 0 {
+		return nil
+	}
+	headers := make(http.Header, len(pairs))
+	for name, value := range pairs {
+		headers.Set(name, value)
+	}
+	return headers
+}
+
+// notificationTargets parses notification_endpoints: objects with url and
+// optional headers and hmac_secret, or plain URLs, which may also be given
+// comma-separated as before endpoints carried their own headers
+func notificationTargets(raw []interface{}) ([]NotificationTarget, error) {
+	var targets []NotificationTarget
+	for _, item := range raw {
+		switch v := item.(type) {
+		case string:
+			for _, url := range csvToSlice(v) {
+				targets = append(targets, NotificationTarget{URL: url})
+			}
+		case map[string]interface{}:
+			var target NotificationTarget
+			for key, value := range v {
+				switch key {
+				case "url":
+					target.URL, _ = value.(string)
+				case "hmac_secret":
+					target.Secret, _ = value.(string)
+				case "headers":
+					pairs, ok := value.(map[string]interface{})
+					if !ok {
+						return nil, fmt.Errorf("notification_endpoints headers must be an object of header names to values")
+					}
+					target.Headers = make(http.Header, len(pairs))
+					for name, v := range pairs {
+						s, ok := v.(string)
+						if !ok {
+							return nil, fmt.Errorf("notification_endpoints header %q must be a string", name)
+						}
+						target.Headers.Set(name, s)
+					}
+				default:
+					return nil, fmt.Errorf("notification_endpoints has an unknown field %q; use url, headers and hmac_secret", key)
+				}
+			}
+			if target.URL == "" {
+				return nil, fmt.Errorf("notification_endpoints entries need a url")
+			}
+			targets = append(targets, target)
+		default:
+			return nil, fmt.Errorf("notification_endpoints entries must be URLs or objects with a url")
+		}
+	}
+	return targets, nil
+}
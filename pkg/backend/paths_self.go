@@ -0,0 +1,91 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// Entity alias metadata keys recording a login for the self path
+const (
+	aliasMetaPrincipal = "principal"
+	aliasMetaRealm     = "realm"
+	aliasMetaRole      = "role"
+	aliasMetaSIDCount  = "sids_count"
+	aliasMetaPolicies  = "policies"
+)
+
+func pathsSelf(b *gmsaBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern:      "self",
+			HelpSynopsis: "Show the authorization recorded for the calling token's identity",
+			HelpDescription: `
+Returns the principal, realm, role, group SID count and policies of the most
+recent login through this auth method by the calling token's identity entity.
+Vault doesn't pass a plugin the calling token itself, so the record is kept on
+the entity alias and requires emit_group_aliases or alias_source. Only the
+caller's own entity is ever described.
+			`,
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handleSelfRead,
+					Summary:  "Show the calling identity's gMSA authorization",
+				},
+			},
+		},
+	}
+}
+
+// selfAliasMetadata records what a login granted on its entity alias, where
+// the self path can read it back
+func selfAliasMetadata(metadata map[string]string, policies []string) map[string]string {
+	return map[string]string{
+		aliasMetaPrincipal: metadata["principal"],
+		aliasMetaRealm:     metadata["realm"],
+		aliasMetaRole:      metadata["role"],
+		aliasMetaSIDCount:  metadata["sids_count"],
+		aliasMetaPolicies:  strings.Join(policies, ","),
+	}
+}
+
+func (b *gmsaBackend) handleSelfRead(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	// Tokens from logins without an entity alias have no entity
+	if req.EntityID == "" {
+		return logical.ErrorResponse("calling token has no identity entity; self requires emit_group_aliases or alias_source, otherwise use auth/token/lookup-self"), nil
+	}
+	entity, err := b.System().EntityInfo(req.EntityID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the calling entity: %w", err)
+	}
+	var alias *logical.Alias
+	for _, a := range entity.GetAliases() {
+		if a.MountAccessor == req.MountAccessor && a.Metadata[aliasMetaRole] != "" {
+			alias = a
+			break
+		}
+	}
+	if alias == nil {
+		return logical.ErrorResponse("calling identity has no login recorded by this auth method"), nil
+	}
+
+	sidCount, _ := strconv.Atoi(alias.Metadata[aliasMetaSIDCount])
+	policies := []string{}
+	if p := alias.Metadata[aliasMetaPolicies]; p != "" {
+		policies = strings.Split(p, ",")
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"principal":       alias.Metadata[aliasMetaPrincipal],
+			"realm":           alias.Metadata[aliasMetaRealm],
+			"role":            alias.Metadata[aliasMetaRole],
+			"group_sid_count": sidCount,
+			"policies":        policies,
+			"alias_name":      alias.Name,
+		},
+	}, nil
+}
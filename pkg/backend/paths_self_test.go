@@ -0,0 +1,88 @@
+package backend
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestHandleSelfRead(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	kt := newTestLoginConfig(t, storage)
+	if err := writeRole(ctx, storage, &Role{Name: "app", TokenPolicies: []string{"reader", "writer"}}); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := readConfig(ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.EmitGroupAliases = true
+	if err := writeConfig(ctx, storage, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation:     logical.UpdateOperation,
+		Path:          "login",
+		Storage:       storage,
+		MountAccessor: "auth_gmsa_1234",
+		Data:          map[string]interface{}{"role": "app", "spnego": newTestLoginSPNEGO(t, kt)},
+		Connection:    &logical.Connection{RemoteAddr: "127.0.0.1"},
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("login failed: err=%v resp=%#v", err, resp)
+	}
+	alias := resp.Auth.Alias
+	if alias == nil || alias.Metadata["role"] != "app" || alias.Metadata["policies"] != "reader,writer" {
+		t.Fatalf("login alias = %#v, want the login recorded in its metadata", alias)
+	}
+
+	// Vault stores the alias on the entity with the mount accessor filled in
+	alias.MountAccessor = "auth_gmsa_1234"
+	sys := b.System().(*logical.StaticSystemView)
+	sys.EntityVal = &logical.Entity{ID: "entity-1", Aliases: []*logical.Alias{
+		{MountAccessor: "auth_other_5678", Name: "other", Metadata: map[string]string{"role": "other"}},
+		alias,
+	}}
+
+	self := func(entityID, accessor string) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation:     logical.ReadOperation,
+			Path:          "self",
+			Storage:       storage,
+			EntityID:      entityID,
+			MountAccessor: accessor,
+		})
+		if err != nil || resp == nil {
+			t.Fatalf("self read failed: err=%v resp=%#v", err, resp)
+		}
+		return resp
+	}
+
+	resp = self("entity-1", "auth_gmsa_1234")
+	if resp.IsError() {
+		t.Fatalf("self read returned error: %v", resp.Error())
+	}
+	want := map[string]interface{}{
+		"principal":       "user@EXAMPLE.COM",
+		"realm":           "EXAMPLE.COM",
+		"role":            "app",
+		"group_sid_count": 0,
+		"policies":        []string{"reader", "writer"},
+		"alias_name":      "user@EXAMPLE.COM",
+	}
+	if !reflect.DeepEqual(resp.Data, want) {
+		t.Errorf("self = %#v, want %#v", resp.Data, want)
+	}
+
+	if resp := self("entity-1", "auth_gmsa_9999"); !resp.IsError() {
+		t.Errorf("self read without an alias on this mount succeeded: %#v", resp.Data)
+	}
+	if resp := self("", "auth_gmsa_1234"); !resp.IsError() {
+		t.Errorf("self read without an entity succeeded: %#v", resp.Data)
+	}
+}
@@ -0,0 +1,157 @@
+package backend
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+
+	"github.com/lpassig/vault-plugin-auth-gmsa/internal/kerb"
+)
+
+// Self-test component results
+const (
+	selfTestPass = "pass"
+	selfTestFail = "fail"
+	selfTestSkip = "skip" // A component it depends on failed
+)
+
+func pathsSelfTest(b *gmsaBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern:      "selftest$",
+			HelpSynopsis: "Run an end-to-end check of the mount's validation path",
+			HelpDescription: `
+Checks, without contacting a KDC or domain controller, that the stored config
+loads and validates, that the keytab parses and holds a key for the SPN, that
+a service ticket issued with that key and carrying a signed PAC is accepted by
+the login validation path with its PAC validated, and that realm, SPN and
+principal normalization behave. Returns a
+pass, fail or skip result per component; a component is skipped when one it
+depends on failed.
+			`,
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handleSelfTest,
+					Summary:  "Run the plugin self-test",
+				},
+			},
+		},
+	}
+}
+
+func (b *gmsaBackend) handleSelfTest(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	cfg, err := b.loadConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	components := map[string]interface{}{}
+	var failed []string
+	record := func(name string, err error, skipped bool) bool {
+		switch {
+		case skipped:
+			components[name] = map[string]interface{}{"status": selfTestSkip}
+		case err != nil:
+			components[name] = map[string]interface{}{"status": selfTestFail, "error": err.Error()}
+			failed = append(failed, name)
+		default:
+			components[name] = map[string]interface{}{"status": selfTestPass}
+		}
+		return !skipped && err == nil
+	}
+
+	configOK := record("config", selfTestConfig(cfg), false)
+	var kt *keytab.Keytab
+	keytabOK := false
+	if configOK {
+		kt, err = selfTestKeytab(cfg)
+		keytabOK = record("keytab", err, false)
+	} else {
+		record("keytab", nil, true)
+	}
+	if keytabOK {
+		record("pac", kerb.SelfTestPAC(ctx, kt, cfg.SPN, cfg.Realm), false)
+	} else {
+		record("pac", nil, true)
+	}
+	record("normalization", selfTestNormalization(cfg), false)
+
+	if len(failed) > 0 {
+		b.logger.Warn("self-test failed", "components", failed)
+	}
+	return &logical.Response{Data: map[string]interface{}{
+		"passed":     len(failed) == 0,
+		"components": components,
+	}}, nil
+}
+
+// selfTestConfig checks that a config is stored and still passes the
+// validation applied when it was written
+func selfTestConfig(cfg *Config) error {
+	if cfg == nil {
+		return errors.New("no config stored; write config first")
+	}
+	c := *cfg
+	return normalizeAndValidateConfig(&c)
+}
+
+// selfTestKeytab parses the keytab and requires an entry for the SPN,
+// regardless of keytab_require_spn, since PAC signatures are keyed by it
+func selfTestKeytab(cfg *Config) (*keytab.Keytab, error) {
+	kb, err := base64.StdEncoding.DecodeString(cfg.KeytabB64)
+	if err != nil {
+		return nil, errors.New("keytab must be base64-encoded")
+	}
+	spn, err := kerb.ParseSPN(cfg.SPN)
+	if err != nil {
+		return nil, err
+	}
+	kt := &keytab.Keytab{}
+	if err := kt.Unmarshal(kb); err != nil {
+		return nil, fmt.Errorf("keytab could not be parsed: %w", err)
+	}
+	for _, e := range kt.Entries {
+		if e.Principal.Realm == cfg.Realm && slices.Equal(e.Principal.Components, spn.Components()) {
+			return kt, nil
+		}
+	}
+	return nil, fmt.Errorf("keytab has no entry for %s@%s", spn.Principal(), cfg.Realm)
+}
+
+// selfTestNormalization checks the normalization functions against fixed
+// samples under the default rules and, with a config, that the configured
+// realm and SPN survive the mount's own rules
+func selfTestNormalization(cfg *Config) error {
+	defaults := getDefaultNormalizationConfig()
+	samples := []struct {
+		fn       func(string, NormalizationConfig) string
+		in, want string
+	}{
+		{normalizeRealm, "example.com.local", "EXAMPLE.COM"},
+		{normalizeSPN, "http/vault.example.com.lan", "HTTP/vault.example.com"},
+		{normalizePrincipal, "svc-app$@example.com.local", "svc-app$@EXAMPLE.COM"},
+	}
+	for _, s := range samples {
+		if got := s.fn(s.in, defaults); got != s.want {
+			return fmt.Errorf("normalizing %q gave %q, want %q", s.in, got, s.want)
+		}
+	}
+
+	if cfg == nil {
+		return nil
+	}
+	if realm := normalizeRealm(cfg.Realm, cfg.Normalization); realm == "" {
+		return fmt.Errorf("realm %s normalizes to an empty string", cfg.Realm)
+	}
+	spn := normalizeSPN(cfg.SPN, cfg.Normalization)
+	if _, err := kerb.ParseSPN(spn); err != nil {
+		return fmt.Errorf("spn %s normalizes to %q, which is not a valid SPN: %w", cfg.SPN, spn, err)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package backend
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+)
+
+func TestSelfTest(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+
+	selfTest := func() (bool, map[string]string, map[string]interface{}) {
+		t.Helper()
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "selftest",
+			Storage:   storage,
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("selftest failed: err=%v resp=%#v", err, resp)
+		}
+		components := resp.Data["components"].(map[string]interface{})
+		status := map[string]string{}
+		for name, c := range components {
+			status[name] = c.(map[string]interface{})["status"].(string)
+		}
+		return resp.Data["passed"].(bool), status, components
+	}
+	want := func(t *testing.T, got map[string]string, want map[string]string) {
+		t.Helper()
+		for name, w := range want {
+			if got[name] != w {
+				t.Errorf("%s = %q, want %q (components %v)", name, got[name], w, got)
+			}
+		}
+		if len(got) != len(want) {
+			t.Errorf("components = %v, want %d", got, len(want))
+		}
+	}
+
+	t.Run("no config", func(t *testing.T) {
+		passed, status, _ := selfTest()
+		if passed {
+			t.Error("passed without a config")
+		}
+		want(t, status, map[string]string{"config": "fail", "keytab": "skip", "pac": "skip", "normalization": "pass"})
+	})
+
+	t.Run("healthy", func(t *testing.T) {
+		newTestLoginConfig(t, storage)
+		passed, status, _ := selfTest()
+		if !passed {
+			t.Errorf("failed with a valid config: %v", status)
+		}
+		want(t, status, map[string]string{"config": "pass", "keytab": "pass", "pac": "pass", "normalization": "pass"})
+	})
+
+	// An account-principal keytab passes config validation without
+	// keytab_require_spn but holds no key to sign PACs for the SPN
+	t.Run("keytab without SPN", func(t *testing.T) {
+		cfg, err := readConfig(ctx, storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.KeytabB64 = testKeytabB64(t, "svc-vault$", etypeID.AES256_CTS_HMAC_SHA1_96)
+		if err := writeConfig(ctx, storage, cfg); err != nil {
+			t.Fatal(err)
+		}
+		passed, status, components := selfTest()
+		if passed {
+			t.Error("passed with a keytab lacking the SPN")
+		}
+		want(t, status, map[string]string{"config": "pass", "keytab": "fail", "pac": "skip", "normalization": "pass"})
+		if msg, _ := components["keytab"].(map[string]interface{})["error"].(string); !strings.Contains(msg, testLoginSPN) {
+			t.Errorf("keytab error = %q, want the SPN named", msg)
+		}
+	})
+
+	t.Run("invalid config", func(t *testing.T) {
+		cfg, err := readConfig(ctx, storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.KeytabB64 = "not base64"
+		if err := writeConfig(ctx, storage, cfg); err != nil {
+			t.Fatal(err)
+		}
+		_, status, _ := selfTest()
+		want(t, status, map[string]string{"config": "fail", "keytab": "skip", "pac": "skip", "normalization": "pass"})
+	})
+}
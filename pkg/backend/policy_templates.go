@@ -0,0 +1,173 @@
+package backend
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// Policy templates let a role derive policy names from the authenticated
+// identity, e.g. "host-{{user}}" or "team-{{group_name}}". Only a fixed set of
+// variables is supported and every resolved value is sanitized before it
+// becomes part of a policy name.
+
+// policyTemplateVarRe matches a single {{variable}} placeholder
+var policyTemplateVarRe = regexp.MustCompile(`\{\{\s*([a-z_]+)\s*\}\}`)
+
+// policyTemplateLiteralRe limits the literal (non-placeholder) parts of a template
+var policyTemplateLiteralRe = regexp.MustCompile(`^[a-zA-Z0-9_-]*$`)
+
+// policyValueUnsafeRe matches characters that are not allowed in a policy name
+var policyValueUnsafeRe = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+// Allow-listed template variables. Multi-valued variables expand to one
+// policy per value.
+var policyTemplateVars = map[string]bool{
+	"principal":  false, // user@REALM
+	"user":       false, // principal without the realm
+	"realm":      false, // authenticated realm
+	"spn":        false, // service principal the client targeted
+	"group_sid":  true,  // each group SID from the PAC
+	"group_rid":  true,  // trailing RID of each group SID
+	"group_name": true,  // sAMAccountName of each group, when group_names is enabled
+}
+
+// maxTemplatedPolicies caps how many policies a single template may expand to
+const maxTemplatedPolicies = 64
+
+// policyTemplateValues holds the login-time values used to resolve templates
+type policyTemplateValues struct {
+	Principal  string
+	Realm      string
+	SPN        string
+	GroupSIDs  []string
+	GroupNames []string // Group names that resolved; see resolvedGroupNames
+}
+
+// resolvedGroupNames returns the names the group name lookup found for sids.
+// The lookup returns a SID it couldn't resolve in place of its name; those
+// are left out so group_name never names a policy after a SID.
+func resolvedGroupNames(sids, names []string) []string {
+	var out []string
+	for i, name := range names {
+		if i < len(sids) && name != sids[i] {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+// isPolicyTemplate reports whether a policy string contains a placeholder
+func isPolicyTemplate(policy string) bool {
+	return strings.Contains(policy, "{{")
+}
+
+// validatePolicyTemplate checks a policy template at role write time. It
+// rejects unknown variables, unbalanced braces and unsafe literal characters.
+func validatePolicyTemplate(tmpl string) error {
+	if tmpl == "" {
+		return errors.New("policy template cannot be empty")
+	}
+	if len(tmpl) > 128 {
+		return errors.New("policy template too long; maximum 128 characters")
+	}
+	multi := 0
+	for _, m := range policyTemplateVarRe.FindAllStringSubmatch(tmpl, -1) {
+		isMulti, ok := policyTemplateVars[m[1]]
+		if !ok {
+			return errors.New("unknown policy template variable: " + m[1])
+		}
+		if isMulti {
+			multi++
+		}
+	}
+	if multi > 1 {
+		return errors.New("policy template may reference at most one group variable: " + tmpl)
+	}
+	literal := policyTemplateVarRe.ReplaceAllString(tmpl, "")
+	if !policyTemplateLiteralRe.MatchString(literal) {
+		return errors.New("policy template contains unsafe characters: " + tmpl)
+	}
+	return nil
+}
+
+// sanitizePolicyValue lowercases a resolved value and replaces any run of
+// characters that are not valid in a policy name with a single underscore.
+func sanitizePolicyValue(v string) string {
+	v = strings.ToLower(strings.TrimSpace(v))
+	return strings.Trim(policyValueUnsafeRe.ReplaceAllString(v, "_"), "_")
+}
+
+// resolvePolicyTemplates expands templated policy names using the login-time
+// values. Static policies pass through unchanged; templates that resolve to an
+// empty or invalid name are dropped rather than granting a malformed policy.
+func resolvePolicyTemplates(policies []string, vals policyTemplateValues) []string {
+	user := vals.Principal
+	if i := strings.LastIndex(user, "@"); i >= 0 {
+		user = user[:i]
+	}
+	single := map[string]string{
+		"principal": sanitizePolicyValue(vals.Principal),
+		"user":      sanitizePolicyValue(user),
+		"realm":     sanitizePolicyValue(vals.Realm),
+		"spn":       sanitizePolicyValue(vals.SPN),
+	}
+	groupRIDs := make([]string, 0, len(vals.GroupSIDs))
+	for _, sid := range vals.GroupSIDs {
+		if i := strings.LastIndex(sid, "-"); i >= 0 {
+			groupRIDs = append(groupRIDs, sid[i+1:])
+		}
+	}
+	multi := map[string][]string{
+		"group_sid":  vals.GroupSIDs,
+		"group_rid":  groupRIDs,
+		"group_name": vals.GroupNames,
+	}
+
+	out := make([]string, 0, len(policies))
+	for _, p := range policies {
+		if !isPolicyTemplate(p) {
+			out = append(out, p)
+			continue
+		}
+		if validatePolicyTemplate(p) != nil {
+			continue
+		}
+
+		// Find the multi-valued variable, if any, and expand once per value
+		var multiName string
+		for _, m := range policyTemplateVarRe.FindAllStringSubmatch(p, -1) {
+			if policyTemplateVars[m[1]] {
+				multiName = m[1]
+			}
+		}
+		expansions := []string{""}
+		if multiName != "" {
+			expansions = multi[multiName]
+			if len(expansions) > maxTemplatedPolicies {
+				expansions = expansions[:maxTemplatedPolicies]
+			}
+		}
+
+		for _, mv := range expansions {
+			mv = sanitizePolicyValue(mv)
+			missing := false
+			resolved := policyTemplateVarRe.ReplaceAllStringFunc(p, func(ph string) string {
+				name := policyTemplateVarRe.FindStringSubmatch(ph)[1]
+				v := single[name]
+				if policyTemplateVars[name] {
+					v = mv
+				}
+				if v == "" {
+					missing = true
+				}
+				return v
+			})
+			if missing || !isValidPolicyName(resolved) {
+				continue
+			}
+			out = append(out, resolved)
+		}
+	}
+	return unique(out)
+}
@@ -0,0 +1,127 @@
+package backend
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestResolvePolicyTemplates(t *testing.T) {
+	vals := policyTemplateValues{
+		Principal:  "web01$@EXAMPLE.COM",
+		Realm:      "EXAMPLE.COM",
+		SPN:        "HTTP/vault.example.com",
+		GroupSIDs:  []string{"S-1-5-21-1-2-3-1104", "S-1-5-21-1-2-3-1105"},
+		GroupNames: []string{"Web Admins", "SQL-Readers"},
+	}
+
+	tests := []struct {
+		name     string
+		policies []string
+		want     []string
+	}{
+		{"static passthrough", []string{"base"}, []string{"base"}},
+		{"user", []string{"host-{{user}}"}, []string{"host-web01"}},
+		{"principal sanitized", []string{"p-{{principal}}"}, []string{"p-web01_example_com"}},
+		{"realm", []string{"realm-{{ realm }}"}, []string{"realm-example_com"}},
+		{"group rid expands", []string{"team-{{group_rid}}"}, []string{"team-1104", "team-1105"}},
+		{"group name expands", []string{"team-{{group_name}}"}, []string{"team-web_admins", "team-sql-readers"}},
+		{"group sid expands", []string{"sid-{{group_sid}}"}, []string{"sid-s-1-5-21-1-2-3-1104", "sid-s-1-5-21-1-2-3-1105"}},
+		{"unknown variable dropped", []string{"base", "x-{{password}}"}, []string{"base"}},
+		{"dedupes", []string{"host-{{user}}", "host-{{user}}"}, []string{"host-web01"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := resolvePolicyTemplates(tt.policies, vals)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("resolvePolicyTemplates() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolvePolicyTemplates_EmptyValueDropped(t *testing.T) {
+	got := resolvePolicyTemplates([]string{"team-{{group_rid}}", "host-{{user}}"}, policyTemplateValues{Principal: "$$$"})
+	if len(got) != 0 {
+		t.Errorf("expected no policies for empty values, got %v", got)
+	}
+}
+
+func TestResolvedGroupNames(t *testing.T) {
+	sids := []string{testAdminsSID, testGoneSID, testUsersSID}
+
+	// The lookup returns unresolved SIDs as themselves
+	got := resolvedGroupNames(sids, []string{"Domain Admins", testGoneSID, "Domain Users"})
+	if want := []string{"Domain Admins", "Domain Users"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("resolvedGroupNames() = %v, want %v", got, want)
+	}
+	if got := resolvedGroupNames(sids, nil); len(got) != 0 {
+		t.Errorf("resolvedGroupNames() without a lookup = %v, want none", got)
+	}
+	if got := resolvePolicyTemplates([]string{"team-{{group_name}}"}, policyTemplateValues{GroupSIDs: sids}); len(got) != 0 {
+		t.Errorf("group_name without names resolved to %v, want none", got)
+	}
+}
+
+func TestValidatePolicyTemplate(t *testing.T) {
+	tests := []struct {
+		tmpl    string
+		wantErr bool
+	}{
+		{"team-{{group_rid}}", false},
+		{"host-{{user}}-{{realm}}", false},
+		{"team-{{group_name}}", false},
+		{"team-{{group_names}}", true},
+		{"team-{{GROUP_RID}}", true},
+		{"../{{user}}", true},
+		{"team {{user}}", true},
+		{"team-{{user}", true},
+		{"{{group_sid}}-{{group_rid}}", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.tmpl, func(t *testing.T) {
+			err := validatePolicyTemplate(tt.tmpl)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validatePolicyTemplate(%q) error = %v, wantErr %v", tt.tmpl, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRoleWrite_PolicyTemplates(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	write := func(data map[string]interface{}) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "role/tmpl",
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return resp
+	}
+
+	// Templates are rejected unless the role opts in
+	resp := write(map[string]interface{}{"token_policies": "team-{{group_rid}}"})
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected error without policy_templates, got: %#v", resp)
+	}
+
+	resp = write(map[string]interface{}{"token_policies": "team-{{group_rid}}", "policy_templates": true})
+	if resp != nil && resp.IsError() {
+		t.Fatalf("unexpected error with policy_templates: %#v", resp)
+	}
+
+	resp = write(map[string]interface{}{"token_policies": "team-{{group_rid}}/x", "policy_templates": true})
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected error for unsafe template, got: %#v", resp)
+	}
+}
@@ -1,22 +1,29 @@
 package backend
 
 import (
-	"bytes"
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math/rand/v2"
 	"net/http"
 	"os"
 	"os/exec"
 	"regexp"
 	"runtime"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/jcmturner/gokrb5/v8/keytab"
+	"golang.org/x/net/http/httpguts"
+
+	"github.com/lpassig/vault-plugin-auth-gmsa/internal/kerb"
+	"github.com/lpassig/vault-plugin-auth-gmsa/internal/logging"
 )
 
 // RotationConfig holds configuration for automated password rotation
@@ -32,6 +39,118 @@ type RotationConfig struct {
 	KeytabCommand        string        `json:"keytab_command"`        // Command to generate keytab
 	BackupKeytabs        bool          `json:"backup_keytabs"`        // Keep backup keytabs
 	NotificationEndpoint string        `json:"notification_endpoint"` // Webhook for notifications
+	NotificationHeaders  http.Header   `json:"notification_headers"`  // Extra webhook request headers (secret)
+	GracePeriod          time.Duration `json:"rotation_grace_period"` // How long the replaced keytab stays valid
+	// NotificationSecret signs webhook bodies with HMAC-SHA256 (secret)
+	NotificationSecret string `json:"notification_hmac_secret"`
+	// NotificationEndpoints receive every notification alongside
+	// NotificationEndpoint, each with its own headers and secret
+	NotificationEndpoints []NotificationTarget `json:"notification_endpoints,omitempty"`
+	// CheckIntervalJitter moves each check by a random offset within
+	// ±CheckIntervalJitter so nodes and mounts don't query the DC in step
+	CheckIntervalJitter time.Duration `json:"check_interval_jitter"`
+	// GMSAContainerDN is the DN of the container holding the gMSA account;
+	// empty means CN=Managed Service Accounts under the realm's domain
+	GMSAContainerDN string `json:"gmsa_container_dn,omitempty"`
+}
+
+// defaultGMSAContainer is the RDN of the container AD creates gMSAs in
+const defaultGMSAContainer = "CN=Managed Service Accounts"
+
+// gmsaAccountDN returns the DN of the named gMSA account in realm's domain
+func (c *RotationConfig) gmsaAccountDN(account, realm string) string {
+	container := c.GMSAContainerDN
+	if container == "" {
+		container = defaultGMSAContainer + "," + realmDomainDN(realm)
+	}
+	return "CN=" + account + "," + container
+}
+
+// realmDomainDN maps a realm to its AD domain DN, one DC component per
+// label, e.g. CORP.EXAMPLE.COM to DC=corp,DC=example,DC=com
+func realmDomainDN(realm string) string {
+	var rdns []string
+	for _, label := range strings.Split(strings.ToLower(realm), ".") {
+		if label != "" {
+			rdns = append(rdns, "DC="+label)
+		}
+	}
+	return strings.Join(rdns, ",")
+}
+
+// validateContainerDN checks that dn is a sequence of attribute=value RDNs.
+// The DN is passed to ldapsearch through a shell, so quoting and expansion
+// characters are rejected as well.
+func validateContainerDN(dn string) error {
+	if strings.ContainsAny(dn, "\"$`'\n\r") {
+		return fmt.Errorf("gmsa_container_dn contains invalid characters")
+	}
+	for _, rdn := range strings.Split(dn, ",") {
+		attr, value, ok := strings.Cut(strings.TrimSpace(rdn), "=")
+		if !ok || attr == "" || value == "" {
+			return fmt.Errorf("gmsa_container_dn must be a DN such as CN=Managed Service Accounts,DC=example,DC=com, got %q", dn)
+		}
+	}
+	return nil
+}
+
+// NotificationTarget is a webhook endpoint with the headers and signing
+// secret sent only to it
+type NotificationTarget struct {
+	URL     string      `json:"url"`
+	Headers http.Header `json:"headers,omitempty"`     // Extra request headers (secret)
+	Secret  string      `json:"hmac_secret,omitempty"` // Signs bodies with HMAC-SHA256 (secret)
+}
+
+// UnmarshalJSON also accepts a bare URL, the form notification_endpoints
+// were stored in before targets carried their own headers and secret
+func (t *NotificationTarget) UnmarshalJSON(data []byte) error {
+	var url string
+	if err := json.Unmarshal(data, &url); err == nil {
+		*t = NotificationTarget{URL: url}
+		return nil
+	}
+	type target NotificationTarget
+	return json.Unmarshal(data, (*target)(t))
+}
+
+// notificationTargets returns every configured webhook endpoint, once each:
+// notification_endpoint with notification_headers and
+// notification_hmac_secret, then notification_endpoints with their own
+func (c *RotationConfig) notificationTargets() []NotificationTarget {
+	primary := NotificationTarget{URL: c.NotificationEndpoint, Headers: c.NotificationHeaders, Secret: c.NotificationSecret}
+	var targets []NotificationTarget
+	for _, target := range append([]NotificationTarget{primary}, c.NotificationEndpoints...) {
+		seen := slices.ContainsFunc(targets, func(t NotificationTarget) bool { return t.URL == target.URL })
+		if target.URL != "" && !seen {
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+// nextCheckDelay returns the delay before the next rotation check: the check
+// interval moved by a uniformly random offset in [-jitter, +jitter]
+func (c *RotationConfig) nextCheckDelay() time.Duration {
+	if c.CheckIntervalJitter <= 0 {
+		return c.CheckInterval
+	}
+	return c.CheckInterval - c.CheckIntervalJitter + rand.N(2*c.CheckIntervalJitter+1)
+}
+
+// rotationCheckHeadroom allows for a check's AD query, keytab update and
+// first notification attempt when judging whether the loop has stalled
+const rotationCheckHeadroom = 5 * time.Minute
+
+// stallAfter returns how long after a check started the next one is overdue.
+// Checks start when the previous one finishes plus a jittered delay, so the
+// gap can reach the longest delay plus the check's own duration, including
+// the webhook retries a failing endpoint can add. 0 means unknown.
+func (c *RotationConfig) stallAfter() time.Duration {
+	if c == nil || c.CheckInterval <= 0 {
+		return 0
+	}
+	return c.CheckInterval + c.CheckIntervalJitter + rotationCheckHeadroom + time.Duration(c.MaxRetries)*maxWebhookBackoff
 }
 
 // Validate validates the rotation configuration
@@ -39,18 +158,29 @@ func (c *RotationConfig) Validate() error {
 	if c.Enabled {
 		// Validate check interval (minimum 1 minute, maximum 24 hours)
 		if c.CheckInterval < time.Minute {
-			return fmt.Errorf("check_interval must be at least 1 minute")
+			return fmt.Errorf("check_interval must be at least 1 minute (60 seconds), got %v", c.CheckInterval)
 		}
 		if c.CheckInterval > 24*time.Hour {
-			return fmt.Errorf("check_interval must be at most 24 hours")
+			return fmt.Errorf("check_interval must be at most 24 hours (86400 seconds), got %v", c.CheckInterval)
 		}
 
 		// Validate rotation threshold (minimum 1 hour, maximum 7 days)
 		if c.RotationThreshold < time.Hour {
-			return fmt.Errorf("rotation_threshold must be at least 1 hour")
+			return fmt.Errorf("rotation_threshold must be at least 1 hour (3600 seconds), got %v", c.RotationThreshold)
 		}
 		if c.RotationThreshold > 7*24*time.Hour {
-			return fmt.Errorf("rotation_threshold must be at most 7 days")
+			return fmt.Errorf("rotation_threshold must be at most 7 days (604800 seconds), got %v", c.RotationThreshold)
+		}
+
+		// Jitter must leave every delay positive
+		if c.CheckIntervalJitter < 0 || c.CheckIntervalJitter >= c.CheckInterval {
+			return fmt.Errorf("check_interval_jitter must be at least 0 and shorter than check_interval (%v), got %v", c.CheckInterval, c.CheckIntervalJitter)
+		}
+
+		// A check interval as long as the threshold can step over the whole
+		// rotation window between two checks
+		if longest := c.CheckInterval + c.CheckIntervalJitter; longest >= c.RotationThreshold {
+			return fmt.Errorf("check_interval (%v) plus check_interval_jitter (%v) must be shorter than rotation_threshold (%v), or the rotation window can pass between two checks; lower check_interval or raise rotation_threshold", c.CheckInterval, c.CheckIntervalJitter, c.RotationThreshold)
 		}
 
 		// Validate retry configuration
@@ -84,16 +214,99 @@ func (c *RotationConfig) Validate() error {
 		}
 	}
 
+	if c.GMSAContainerDN != "" {
+		if err := validateContainerDN(c.GMSAContainerDN); err != nil {
+			return err
+		}
+	}
+
+	// Validate grace period (0 disables, maximum 7 days)
+	if c.GracePeriod < 0 || c.GracePeriod > maxRotationGracePeriod {
+		return fmt.Errorf("rotation_grace_period must be between 0 and 7 days")
+	}
+
 	// Validate notification endpoint format if provided
 	if c.NotificationEndpoint != "" {
 		if !strings.HasPrefix(c.NotificationEndpoint, "http://") && !strings.HasPrefix(c.NotificationEndpoint, "https://") {
 			return fmt.Errorf("notification_endpoint must be a valid HTTP/HTTPS URL")
 		}
 	}
+	if len(c.NotificationEndpoints) > maxNotificationEndpoints {
+		return fmt.Errorf("notification_endpoints accepts at most %d endpoints", maxNotificationEndpoints)
+	}
+	for _, target := range c.NotificationEndpoints {
+		if !strings.HasPrefix(target.URL, "http://") && !strings.HasPrefix(target.URL, "https://") {
+			return fmt.Errorf("notification_endpoints must be valid HTTP/HTTPS URLs: %q", redactedEndpoint(target.URL))
+		}
+		if err := validateNotificationHeaders(target.Headers); err != nil {
+			return fmt.Errorf("notification_endpoints %s: %w", redactedEndpoint(target.URL), err)
+		}
+	}
+	if err := validateNotificationHeaders(c.NotificationHeaders); err != nil {
+		return fmt.Errorf("notification_headers %w", err)
+	}
+
+	return nil
+}
 
+// validateNotificationHeaders checks that webhook headers can be sent
+func validateNotificationHeaders(headers http.Header) error {
+	for name, values := range headers {
+		if !httpguts.ValidHeaderFieldName(name) {
+			return fmt.Errorf("contains an invalid header name %q", name)
+		}
+		for _, v := range values {
+			if !httpguts.ValidHeaderFieldValue(v) {
+				return fmt.Errorf("contains an invalid value for header %q", name)
+			}
+		}
+	}
 	return nil
 }
 
+// Safe returns the rotation config for read responses. Secrets are reported
+// only as *_set booleans, credentials embedded in the notification endpoint
+// URLs are redacted, and notification header values are hidden.
+func (c *RotationConfig) Safe() map[string]any {
+	endpoints := make([]map[string]any, len(c.NotificationEndpoints))
+	for i, target := range c.NotificationEndpoints {
+		endpoints[i] = map[string]any{
+			"url":             redactedEndpoint(target.URL),
+			"headers":         redactedHeaders(target.Headers),
+			"hmac_secret_set": target.Secret != "",
+		}
+	}
+	headers := redactedHeaders(c.NotificationHeaders)
+	return map[string]any{
+		"enabled":                      c.Enabled,
+		"check_interval":               int(c.CheckInterval.Seconds()),
+		"check_interval_jitter":        int(c.CheckIntervalJitter.Seconds()),
+		"rotation_threshold":           int(c.RotationThreshold.Seconds()),
+		"max_retries":                  c.MaxRetries,
+		"retry_delay":                  int(c.RetryDelay.Seconds()),
+		"domain_controller":            c.DomainController,
+		"domain_admin_user":            c.DomainAdminUser,
+		"domain_admin_password_set":    c.DomainAdminPassword != "",
+		"keytab_command":               c.KeytabCommand,
+		"backup_keytabs":               c.BackupKeytabs,
+		"notification_endpoint":        redactedEndpoint(c.NotificationEndpoint),
+		"notification_endpoints":       endpoints,
+		"notification_headers":         headers,
+		"notification_hmac_secret_set": c.NotificationSecret != "",
+		"rotation_grace_period":        int(c.GracePeriod.Seconds()),
+		"gmsa_container_dn":            c.GMSAContainerDN,
+	}
+}
+
+// redactedHeaders returns the header names with their values hidden
+func redactedHeaders(headers http.Header) map[string]string {
+	redacted := make(map[string]string, len(headers))
+	for name := range headers {
+		redacted[name] = "redacted"
+	}
+	return redacted
+}
+
 // isValidCommand validates command names to prevent injection
 func isValidCommand(cmd string) bool {
 	if cmd == "" {
@@ -145,11 +358,17 @@ type RotationStatus struct {
 	NextRotation   time.Time `json:"next_rotation"`
 	RotationCount  int       `json:"rotation_count"`
 	LastError      string    `json:"last_error"`
-	Status         string    `json:"status"` // "idle", "checking", "rotating", "error"
+	Status         string    `json:"status"` // "idle", "checking", "rotating", "error", "degraded"
 	PasswordAge    int       `json:"password_age_days"`
 	PasswordExpiry time.Time `json:"password_expiry"`
+	// LDAPBreaker reports whether AD queries are backed off after failures
+	LDAPBreaker BreakerStatus `json:"ldap_breaker"`
 }
 
+// errRotationInProgress is returned by performRotation while another rotation,
+// manual or scheduled, holds the backend's rotation lock
+var errRotationInProgress = errors.New("rotation already in progress")
+
 // RotationManager handles automated password rotation
 type RotationManager struct {
 	config    *RotationConfig
@@ -158,25 +377,40 @@ type RotationManager struct {
 	ctx       context.Context
 	cancel    context.CancelFunc
 	mu        sync.RWMutex
+	breaker   ldapBreaker // Backs off AD queries while the DC is unreachable
 	logger    *log.Logger
 	stopChan  chan struct{}
 	isRunning bool
+
+	generateKeytab func(cfg *Config) (string, error) // generateNewKeytab, replaceable in tests
+}
+
+// rotationLogWriter returns the rotation managers' log output: the standard
+// logger's writer, redacted with the backend's log redaction rules
+func rotationLogWriter(b *gmsaBackend) io.Writer {
+	redactor := func() *logging.Redactor { return nil }
+	if b != nil {
+		redactor = b.redactor.Load
+	}
+	return logging.NewRedactingWriter(log.Writer(), redactor)
 }
 
 // NewRotationManager creates a new rotation manager
 func NewRotationManager(backend *gmsaBackend, config *RotationConfig) *RotationManager {
 	ctx, cancel := context.WithCancel(context.Background())
 
-	return &RotationManager{
+	rm := &RotationManager{
 		config:    config,
-		status:    &RotationStatus{Status: "idle"},
+		status:    &RotationStatus{Status: "idle", LDAPBreaker: BreakerStatus{State: breakerClosed}},
 		backend:   backend,
 		ctx:       ctx,
 		cancel:    cancel,
-		logger:    log.New(log.Writer(), "[gmsa-rotation] ", log.LstdFlags),
+		logger:    log.New(rotationLogWriter(backend), "[gmsa-rotation] ", log.LstdFlags),
 		stopChan:  make(chan struct{}),
 		isRunning: false,
 	}
+	rm.generateKeytab = rm.generateNewKeytab
+	return rm
 }
 
 // Start begins the automated rotation process
@@ -222,15 +456,16 @@ func (rm *RotationManager) Stop() error {
 
 // rotationLoop is the main rotation loop that runs in the background
 func (rm *RotationManager) rotationLoop() {
-	ticker := time.NewTicker(rm.config.CheckInterval)
-	defer ticker.Stop()
+	timer := time.NewTimer(rm.config.nextCheckDelay())
+	defer timer.Stop()
 
 	for {
 		select {
 		case <-rm.ctx.Done():
 			return
-		case <-ticker.C:
+		case <-timer.C:
 			rm.checkAndRotate()
+			timer.Reset(rm.config.nextCheckDelay())
 		case <-rm.stopChan:
 			return
 		}
@@ -247,20 +482,45 @@ func (rm *RotationManager) checkAndRotate() {
 	rm.logger.Printf("Checking password rotation status...")
 
 	// Get current configuration
-	cfg, err := readConfig(rm.ctx, rm.backend.storage)
+	cfg, err := rm.backend.loadConfig(rm.ctx)
 	if err != nil {
 		rm.handleError(fmt.Errorf("failed to read config: %w", err))
 		return
 	}
 
+	// Drop the previous keytab once its grace window has elapsed
+	rm.backend.configLock.Lock()
+	dropped, err := dropExpiredPreviousKeytab(rm.ctx, rm.backend.storage, cfg, rm.backend.now())
+	rm.backend.configLock.Unlock()
+	if err != nil {
+		rm.logger.Printf("Warning: failed to drop expired previous keytab: %v", err)
+	} else if dropped {
+		rm.logger.Printf("Rotation grace period elapsed, previous keytab removed")
+	}
+
+	// Back off while the DC is unreachable instead of querying every check
+	now := time.Now()
+	rm.mu.Lock()
+	allowed := rm.breaker.allow(now)
+	if !allowed {
+		rm.status.Status = "degraded"
+		rm.status.LDAPBreaker = rm.breaker.status(now)
+	}
+	rm.mu.Unlock()
+	if !allowed {
+		return
+	}
+
 	// Check password age and expiry
 	passwordInfo, err := rm.getPasswordInfo(cfg)
 	if err != nil {
-		rm.handleError(fmt.Errorf("failed to get password info: %w", err))
+		rm.handleQueryError(fmt.Errorf("failed to get password info: %w", err))
 		return
 	}
 
 	rm.mu.Lock()
+	rm.breaker.success()
+	rm.status.LDAPBreaker = rm.breaker.status(now)
 	rm.status.PasswordAge = passwordInfo.AgeDays
 	rm.status.PasswordExpiry = passwordInfo.ExpiryTime
 	rm.mu.Unlock()
@@ -270,7 +530,16 @@ func (rm *RotationManager) checkAndRotate() {
 		rm.logger.Printf("Password rotation needed (age: %d days, expiry: %v)",
 			passwordInfo.AgeDays, passwordInfo.ExpiryTime)
 
-		if err := rm.performRotation(cfg); err != nil {
+		err := rm.performRotation(cfg)
+		if errors.Is(err, errRotationInProgress) {
+			// A manual rotation got there first; check again next interval
+			rm.mu.Lock()
+			rm.status.Status = "idle"
+			rm.mu.Unlock()
+			rm.logger.Printf("Skipping scheduled rotation: %v", err)
+			return
+		}
+		if err != nil {
 			rm.handleError(fmt.Errorf("rotation failed: %w", err))
 			return
 		}
@@ -304,16 +573,13 @@ type PasswordInfo struct {
 // getPasswordInfo retrieves password information from Active Directory
 func (rm *RotationManager) getPasswordInfo(cfg *Config) (*PasswordInfo, error) {
 	// Extract gMSA account name from SPN
-	spnParts := strings.SplitN(cfg.SPN, "/", 2)
-	if len(spnParts) != 2 {
-		return nil, fmt.Errorf("invalid SPN format: %s", cfg.SPN)
+	spn, err := kerb.ParseSPN(cfg.SPN)
+	if err != nil {
+		return nil, err
 	}
 
 	// For gMSA, the account name is typically the hostname part
-	accountName := spnParts[1]
-	if strings.Contains(accountName, "@") {
-		accountName = strings.SplitN(accountName, "@", 2)[0]
-	}
+	accountName := spn.Host
 
 	// Query AD for password information using PowerShell
 	psScript := fmt.Sprintf(`
@@ -371,8 +637,14 @@ func (rm *RotationManager) needsRotation(info *PasswordInfo) bool {
 	return false
 }
 
-// performRotation performs the actual password rotation
+// performRotation performs the actual password rotation. Only one rotation
+// runs at a time per backend, whichever manager or endpoint started it.
 func (rm *RotationManager) performRotation(cfg *Config) error {
+	if !rm.backend.rotationLock.TryLock() {
+		return errRotationInProgress
+	}
+	defer rm.backend.rotationLock.Unlock()
+
 	rm.mu.Lock()
 	rm.status.Status = "rotating"
 	rm.mu.Unlock()
@@ -380,7 +652,7 @@ func (rm *RotationManager) performRotation(cfg *Config) error {
 	rm.logger.Printf("Starting password rotation...")
 
 	// Generate new keytab
-	newKeytabB64, err := rm.generateNewKeytab(cfg)
+	newKeytabB64, err := rm.generateKeytab(cfg)
 	if err != nil {
 		return fmt.Errorf("failed to generate new keytab: %w", err)
 	}
@@ -395,12 +667,16 @@ func (rm *RotationManager) performRotation(cfg *Config) error {
 	// Update configuration with new keytab
 	newCfg := *cfg
 	newCfg.KeytabB64 = newKeytabB64
+	retainPreviousKeytab(&newCfg, cfg, rm.config.GracePeriod, rm.backend.now())
 
 	if err := normalizeAndValidateConfig(&newCfg); err != nil {
 		return fmt.Errorf("new keytab validation failed: %w", err)
 	}
 
-	if err := writeConfig(rm.ctx, rm.backend.storage, &newCfg); err != nil {
+	rm.backend.configLock.Lock()
+	err = writeConfig(rm.ctx, rm.backend.storage, &newCfg)
+	rm.backend.configLock.Unlock()
+	if err != nil {
 		return fmt.Errorf("failed to update config: %w", err)
 	}
 
@@ -408,7 +684,10 @@ func (rm *RotationManager) performRotation(cfg *Config) error {
 	if err := rm.testNewKeytab(&newCfg); err != nil {
 		// Rollback on test failure
 		rm.logger.Printf("New keytab test failed, rolling back: %v", err)
-		if rollbackErr := writeConfig(rm.ctx, rm.backend.storage, cfg); rollbackErr != nil {
+		rm.backend.configLock.Lock()
+		rollbackErr := writeConfig(rm.ctx, rm.backend.storage, cfg)
+		rm.backend.configLock.Unlock()
+		if rollbackErr != nil {
 			rm.logger.Printf("Critical: rollback failed: %v", rollbackErr)
 		}
 		return fmt.Errorf("new keytab test failed: %w", err)
@@ -421,15 +700,9 @@ func (rm *RotationManager) performRotation(cfg *Config) error {
 // generateNewKeytab generates a new keytab using the configured command
 func (rm *RotationManager) generateNewKeytab(cfg *Config) (string, error) {
 	// Extract account information from SPN
-	spnParts := strings.SplitN(cfg.SPN, "/", 2)
-	if len(spnParts) != 2 {
-		return "", fmt.Errorf("invalid SPN format: %s", cfg.SPN)
-	}
-
-	service := spnParts[0]
-	hostname := spnParts[1]
-	if strings.Contains(hostname, "@") {
-		hostname = strings.SplitN(hostname, "@", 2)[0]
+	spn, err := kerb.ParseSPN(cfg.SPN)
+	if err != nil {
+		return "", err
 	}
 
 	// Generate temporary keytab file
@@ -437,8 +710,8 @@ func (rm *RotationManager) generateNewKeytab(cfg *Config) (string, error) {
 
 	// Build ktpass command
 	cmd := exec.Command("ktpass",
-		"-princ", fmt.Sprintf("%s/%s@%s", service, hostname, cfg.Realm),
-		"-mapuser", fmt.Sprintf("%s\\%s$", cfg.Realm, hostname),
+		"-princ", fmt.Sprintf("%s@%s", spn.Principal(), cfg.Realm),
+		"-mapuser", fmt.Sprintf("%s\\%s$", cfg.Realm, spn.Host),
 		"-crypto", "AES256-SHA1",
 		"-ptype", "KRB5_NT_PRINCIPAL",
 		"-pass", "*", // Use current password
@@ -531,9 +804,36 @@ func (rm *RotationManager) handleError(err error) {
 	rm.sendNotification(fmt.Sprintf("Password rotation error: %v", err))
 }
 
+// handleQueryError records a failed AD query. Once the breaker opens,
+// rotation is marked degraded and only the failure that tripped it is
+// reported, so an unreachable DC doesn't flood logs and notifications.
+func (rm *RotationManager) handleQueryError(err error) {
+	now := time.Now()
+	rm.mu.Lock()
+	wasOpen := rm.breaker.status(now).State != breakerClosed
+	open := rm.breaker.failure(now)
+	if open {
+		rm.status.LastError = err.Error()
+		rm.status.Status = "degraded"
+	}
+	rm.status.LDAPBreaker = rm.breaker.status(now)
+	retryAt := rm.status.LDAPBreaker.RetryAt
+	rm.mu.Unlock()
+
+	switch {
+	case !open:
+		rm.handleError(err)
+	case !wasOpen:
+		rm.logger.Printf("Rotation degraded: AD queries backed off until %v: %v", retryAt, err)
+		rm.sendNotification(fmt.Sprintf("Password rotation degraded, domain controller unreachable: %v", err))
+	default:
+		rm.logger.Printf("Domain controller still unreachable, next probe at %v", retryAt)
+	}
+}
+
 // sendNotification sends a notification about rotation status
 func (rm *RotationManager) sendNotification(message string) {
-	if rm.config.NotificationEndpoint == "" {
+	if len(rm.config.notificationTargets()) == 0 {
 		return
 	}
 
@@ -548,41 +848,19 @@ func (rm *RotationManager) sendNotification(message string) {
 		"platform":       runtime.GOOS,
 	}
 
-	// Send webhook notification
+	// Send webhook notification; a failing endpoint doesn't stop delivery
+	// to the others or fail the rotation
 	if err := rm.sendWebhook(payload); err != nil {
-		rm.logger.Printf("ERROR: failed to send notification: %v (endpoint: %s)", err, rm.config.NotificationEndpoint)
+		rm.logger.Printf("ERROR: failed to send notification: %v", err)
 	} else {
 		rm.logger.Printf("INFO: notification sent successfully: %s", message)
 	}
 }
 
-// sendWebhook sends a webhook notification with retry logic
+// sendWebhook sends a webhook notification to every endpoint with retry logic
 func (rm *RotationManager) sendWebhook(payload map[string]interface{}) error {
-	jsonData, err := json.Marshal(payload)
-	if err != nil {
-		return fmt.Errorf("failed to marshal payload: %w", err)
-	}
-
-	req, err := http.NewRequest("POST", rm.config.NotificationEndpoint, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("User-Agent", "vault-gmsa-auth-plugin/"+pluginVersion)
-
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to send webhook: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode >= 400 {
-		return fmt.Errorf("webhook failed with status: %d", resp.StatusCode)
-	}
-
-	return nil
+	_, err := fanOutWebhook(rm.ctx, rm.config.notificationTargets(), payload, rm.config.MaxRetries, rm.config.RetryDelay)
+	return err
 }
 
 // GetStatus returns the current rotation status
@@ -4,13 +4,11 @@
 package backend
 
 import (
-	"bytes"
 	"context"
 	"encoding/base64"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -20,6 +18,8 @@ import (
 	"time"
 
 	"github.com/jcmturner/gokrb5/v8/keytab"
+
+	"github.com/lpassig/vault-plugin-auth-gmsa/internal/kerb"
 )
 
 // UnixRotationManager handles automated password rotation on Unix-like systems (Linux, macOS, etc.)
@@ -33,6 +33,9 @@ type UnixRotationManager struct {
 	stopChan  chan struct{}
 	isRunning bool
 	mu        sync.RWMutex
+	breaker   ldapBreaker // Backs off LDAP queries while the DC is unreachable
+
+	generateKeytab func(cfg *Config) (string, error) // generateNewKeytabUnix, replaceable in tests
 }
 
 // NewLinuxRotationManager creates a new Unix-compatible rotation manager
@@ -40,16 +43,18 @@ type UnixRotationManager struct {
 func NewLinuxRotationManager(backend *gmsaBackend, config *RotationConfig) RotationManagerInterface {
 	ctx, cancel := context.WithCancel(context.Background())
 
-	return &UnixRotationManager{
+	rm := &UnixRotationManager{
 		config:    config,
-		status:    &RotationStatus{Status: "idle"},
+		status:    &RotationStatus{Status: "idle", LDAPBreaker: BreakerStatus{State: breakerClosed}},
 		backend:   backend,
 		ctx:       ctx,
 		cancel:    cancel,
-		logger:    log.New(log.Writer(), getUnixLoggerPrefix(), log.LstdFlags),
+		logger:    log.New(rotationLogWriter(backend), getUnixLoggerPrefix(), log.LstdFlags),
 		stopChan:  make(chan struct{}),
 		isRunning: false,
 	}
+	rm.generateKeytab = rm.generateNewKeytabUnix
+	return rm
 }
 
 // getUnixLoggerPrefix returns platform-specific logger prefix
@@ -117,15 +122,16 @@ func (rm *UnixRotationManager) Stop() error {
 
 // rotationLoop is the main rotation loop that runs in the background
 func (rm *UnixRotationManager) rotationLoop() {
-	ticker := time.NewTicker(rm.config.CheckInterval)
-	defer ticker.Stop()
+	timer := time.NewTimer(rm.config.nextCheckDelay())
+	defer timer.Stop()
 
 	for {
 		select {
 		case <-rm.ctx.Done():
 			return
-		case <-ticker.C:
+		case <-timer.C:
 			rm.checkAndRotate()
+			timer.Reset(rm.config.nextCheckDelay())
 		case <-rm.stopChan:
 			return
 		}
@@ -140,18 +146,38 @@ func (rm *UnixRotationManager) checkAndRotate() {
 	rm.logger.Printf("Checking password rotation status...")
 
 	// Get current configuration
-	cfg, err := readConfig(rm.ctx, rm.backend.storage)
+	cfg, err := rm.backend.loadConfig(rm.ctx)
 	if err != nil {
 		rm.handleError(fmt.Errorf("failed to read config: %w", err))
 		return
 	}
 
+	// Drop the previous keytab once its grace window has elapsed
+	rm.backend.configLock.Lock()
+	dropped, err := dropExpiredPreviousKeytab(rm.ctx, rm.backend.storage, cfg, rm.backend.now())
+	rm.backend.configLock.Unlock()
+	if err != nil {
+		rm.logger.Printf("Warning: failed to drop expired previous keytab: %v", err)
+	} else if dropped {
+		rm.logger.Printf("Rotation grace period elapsed, previous keytab removed")
+	}
+
+	// Back off while the DC is unreachable instead of querying every check
+	now := time.Now()
+	if !rm.breaker.allow(now) {
+		rm.status.Status = "degraded"
+		rm.status.LDAPBreaker = rm.breaker.status(now)
+		return
+	}
+
 	// Check password age and expiry using LDAP
 	passwordInfo, err := rm.getPasswordInfoLDAP(cfg)
 	if err != nil {
-		rm.handleError(fmt.Errorf("failed to get password info: %w", err))
+		rm.handleLDAPError(fmt.Errorf("failed to get password info: %w", err))
 		return
 	}
+	rm.breaker.success()
+	rm.status.LDAPBreaker = rm.breaker.status(now)
 
 	rm.status.PasswordAge = passwordInfo.AgeDays
 	rm.status.PasswordExpiry = passwordInfo.ExpiryTime
@@ -161,7 +187,14 @@ func (rm *UnixRotationManager) checkAndRotate() {
 		rm.logger.Printf("Password rotation needed (age: %d days, expiry: %v)",
 			passwordInfo.AgeDays, passwordInfo.ExpiryTime)
 
-		if err := rm.performRotation(cfg); err != nil {
+		err := rm.performRotation(cfg)
+		if errors.Is(err, errRotationInProgress) {
+			// A manual rotation got there first; check again next interval
+			rm.status.Status = "idle"
+			rm.logger.Printf("Skipping scheduled rotation: %v", err)
+			return
+		}
+		if err != nil {
 			rm.handleError(fmt.Errorf("rotation failed: %w", err))
 			return
 		}
@@ -181,28 +214,24 @@ func (rm *UnixRotationManager) checkAndRotate() {
 // getPasswordInfoLDAP retrieves password information using LDAP queries
 func (rm *UnixRotationManager) getPasswordInfoLDAP(cfg *Config) (*PasswordInfo, error) {
 	// Extract gMSA account name from SPN
-	spnParts := strings.SplitN(cfg.SPN, "/", 2)
-	if len(spnParts) != 2 {
-		return nil, fmt.Errorf("invalid SPN format: %s", cfg.SPN)
+	spn, err := kerb.ParseSPN(cfg.SPN)
+	if err != nil {
+		return nil, err
 	}
 
-	accountName := spnParts[1]
-	if strings.Contains(accountName, "@") {
-		accountName = strings.SplitN(accountName, "@", 2)[0]
-	}
+	accountName := spn.Host
 
 	// Use ldapsearch to query AD for password information
 	ldapQuery := fmt.Sprintf(`
 		# Query gMSA account for password information
-		ldapsearch -H ldap://%s -D "%s" -w "%s" -b "CN=%s,CN=Managed Service Accounts,CN=Users,DC=%s" \
+		ldapsearch -H ldap://%s -D "%s" -w "%s" -b "%s" \
 			-s base "(objectClass=msDS-GroupManagedServiceAccount)" \
 			pwdLastSet msDS-ManagedPasswordId msDS-ManagedPasswordInterval
 	`,
 		rm.config.DomainController,
 		rm.config.DomainAdminUser,
 		rm.config.DomainAdminPassword,
-		accountName,
-		strings.ToLower(cfg.Realm))
+		rm.config.gmsaAccountDN(accountName, cfg.Realm))
 
 	cmd := exec.Command("sh", "-c", ldapQuery)
 	output, err := cmd.Output()
@@ -294,14 +323,20 @@ func (rm *UnixRotationManager) needsRotation(info *PasswordInfo) bool {
 	return false
 }
 
-// performRotation performs the actual password rotation
+// performRotation performs the actual password rotation. Only one rotation
+// runs at a time per backend, whichever manager or endpoint started it.
 func (rm *UnixRotationManager) performRotation(cfg *Config) error {
+	if !rm.backend.rotationLock.TryLock() {
+		return errRotationInProgress
+	}
+	defer rm.backend.rotationLock.Unlock()
+
 	rm.status.Status = "rotating"
 
 	rm.logger.Printf("Starting password rotation...")
 
 	// Generate new keytab using Unix-compatible method
-	newKeytabB64, err := rm.generateNewKeytabUnix(cfg)
+	newKeytabB64, err := rm.generateKeytab(cfg)
 	if err != nil {
 		return fmt.Errorf("failed to generate new keytab: %w", err)
 	}
@@ -316,12 +351,16 @@ func (rm *UnixRotationManager) performRotation(cfg *Config) error {
 	// Update configuration with new keytab
 	newCfg := *cfg
 	newCfg.KeytabB64 = newKeytabB64
+	retainPreviousKeytab(&newCfg, cfg, rm.config.GracePeriod, rm.backend.now())
 
 	if err := normalizeAndValidateConfig(&newCfg); err != nil {
 		return fmt.Errorf("new keytab validation failed: %w", err)
 	}
 
-	if err := writeConfig(rm.ctx, rm.backend.storage, &newCfg); err != nil {
+	rm.backend.configLock.Lock()
+	err = writeConfig(rm.ctx, rm.backend.storage, &newCfg)
+	rm.backend.configLock.Unlock()
+	if err != nil {
 		return fmt.Errorf("failed to update config: %w", err)
 	}
 
@@ -329,7 +368,10 @@ func (rm *UnixRotationManager) performRotation(cfg *Config) error {
 	if err := rm.testNewKeytab(&newCfg); err != nil {
 		// Rollback on test failure
 		rm.logger.Printf("New keytab test failed, rolling back: %v", err)
-		if rollbackErr := writeConfig(rm.ctx, rm.backend.storage, cfg); rollbackErr != nil {
+		rm.backend.configLock.Lock()
+		rollbackErr := writeConfig(rm.ctx, rm.backend.storage, cfg)
+		rm.backend.configLock.Unlock()
+		if rollbackErr != nil {
 			rm.logger.Printf("Critical: rollback failed: %v", rollbackErr)
 		}
 		return fmt.Errorf("new keytab test failed: %w", err)
@@ -342,15 +384,9 @@ func (rm *UnixRotationManager) performRotation(cfg *Config) error {
 // generateNewKeytabUnix generates a new keytab using Unix-compatible methods
 func (rm *UnixRotationManager) generateNewKeytabUnix(cfg *Config) (string, error) {
 	// Extract account information from SPN
-	spnParts := strings.SplitN(cfg.SPN, "/", 2)
-	if len(spnParts) != 2 {
-		return "", fmt.Errorf("invalid SPN format: %s", cfg.SPN)
-	}
-
-	service := spnParts[0]
-	hostname := spnParts[1]
-	if strings.Contains(hostname, "@") {
-		hostname = strings.SplitN(hostname, "@", 2)[0]
+	spn, err := kerb.ParseSPN(cfg.SPN)
+	if err != nil {
+		return "", err
 	}
 
 	// Generate temporary keytab file
@@ -361,11 +397,11 @@ func (rm *UnixRotationManager) generateNewKeytabUnix(cfg *Config) (string, error
 	ktutilScript := fmt.Sprintf(`
 		# Generate keytab using ktutil
 		ktutil << EOF
-		addent -password -p %s/%s@%s -k 1 -e aes256-cts-hmac-sha1-96
+		addent -password -p %s@%s -k 1 -e aes256-cts-hmac-sha1-96
 		wkt %s
 		q
 		EOF
-	`, service, hostname, cfg.Realm, tempFile)
+	`, spn.Principal(), cfg.Realm, tempFile)
 
 	cmd := exec.Command("sh", "-c", ktutilScript)
 
@@ -455,9 +491,31 @@ func (rm *UnixRotationManager) handleError(err error) {
 	rm.sendNotification(fmt.Sprintf("Password rotation error: %v", err))
 }
 
+// handleLDAPError records a failed LDAP query. Once the breaker opens,
+// rotation is marked degraded and only the failure that tripped it is
+// reported, so an unreachable DC doesn't flood logs and notifications.
+func (rm *UnixRotationManager) handleLDAPError(err error) {
+	now := time.Now()
+	wasOpen := rm.breaker.status(now).State != breakerClosed
+	if !rm.breaker.failure(now) {
+		rm.handleError(err)
+		return
+	}
+	rm.status.LastError = err.Error()
+	rm.status.Status = "degraded"
+	rm.status.LDAPBreaker = rm.breaker.status(now)
+
+	if wasOpen {
+		rm.logger.Printf("Domain controller still unreachable, next probe at %v", rm.status.LDAPBreaker.RetryAt)
+		return
+	}
+	rm.logger.Printf("Rotation degraded: LDAP queries backed off until %v: %v", rm.status.LDAPBreaker.RetryAt, err)
+	rm.sendNotification(fmt.Sprintf("Password rotation degraded, domain controller unreachable: %v", err))
+}
+
 // sendNotification sends a notification about rotation status
 func (rm *UnixRotationManager) sendNotification(message string) {
-	if rm.config.NotificationEndpoint == "" {
+	if len(rm.config.notificationTargets()) == 0 {
 		return
 	}
 
@@ -472,41 +530,19 @@ func (rm *UnixRotationManager) sendNotification(message string) {
 		"platform":       runtime.GOOS,
 	}
 
-	// Send webhook notification
+	// Send webhook notification; a failing endpoint doesn't stop delivery
+	// to the others or fail the rotation
 	if err := rm.sendWebhook(payload); err != nil {
-		rm.logger.Printf("ERROR: failed to send notification: %v (endpoint: %s)", err, rm.config.NotificationEndpoint)
+		rm.logger.Printf("ERROR: failed to send notification: %v", err)
 	} else {
 		rm.logger.Printf("INFO: notification sent successfully: %s", message)
 	}
 }
 
-// sendWebhook sends a webhook notification with retry logic
+// sendWebhook sends a webhook notification to every endpoint with retry logic
 func (rm *UnixRotationManager) sendWebhook(payload map[string]interface{}) error {
-	jsonData, err := json.Marshal(payload)
-	if err != nil {
-		return fmt.Errorf("failed to marshal payload: %w", err)
-	}
-
-	req, err := http.NewRequest("POST", rm.config.NotificationEndpoint, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("User-Agent", "vault-gmsa-auth-plugin/"+pluginVersion)
-
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to send webhook: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode >= 400 {
-		return fmt.Errorf("webhook failed with status: %d", resp.StatusCode)
-	}
-
-	return nil
+	_, err := fanOutWebhook(rm.ctx, rm.config.notificationTargets(), payload, rm.config.MaxRetries, rm.config.RetryDelay)
+	return err
 }
 
 // GetStatus returns the current rotation status
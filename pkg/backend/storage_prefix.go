@@ -0,0 +1,61 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// storagePrefixOption is the mount option that namespaces every storage key
+// (config, roles, rotation state) so separate tenants can share a backend
+const storagePrefixOption = "storage_prefix"
+
+// storagePrefixRe allows slash-separated segments of safe characters
+var storagePrefixRe = regexp.MustCompile(`^[A-Za-z0-9_-]+(/[A-Za-z0-9_-]+)*/?$`)
+
+// parseStoragePrefix validates a storage_prefix option and returns it with
+// a trailing "/", or "" when unset so existing mounts keep their layout
+func parseStoragePrefix(prefix string) (string, error) {
+	prefix = strings.TrimSpace(prefix)
+	if prefix == "" {
+		return "", nil
+	}
+	if !storagePrefixRe.MatchString(prefix) {
+		return "", fmt.Errorf("invalid %s %q: use letters, digits, '-', '_' and '/' separators", storagePrefixOption, prefix)
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return prefix, nil
+}
+
+// scopedStorage returns s scoped to the mount's storage prefix. Handlers
+// pass both req.Storage and b.storage to the config, role and rotation
+// helpers, so both are scoped before any helper sees them.
+func (b *gmsaBackend) scopedStorage(s logical.Storage) logical.Storage {
+	if b.storagePrefix == "" || s == nil {
+		return s
+	}
+	return logical.NewStorageView(s, b.storagePrefix)
+}
+
+// HandleRequest scopes the request's storage to the mount's storage prefix
+// before routing it
+func (b *gmsaBackend) HandleRequest(ctx context.Context, req *logical.Request) (*logical.Response, error) {
+	if req != nil {
+		req.Storage = b.scopedStorage(req.Storage)
+	}
+	return b.Backend.HandleRequest(ctx, req)
+}
+
+// unscopedKey strips the storage prefix from a key Vault reports, such as
+// an invalidated key. ok is false for keys outside the prefix.
+func (b *gmsaBackend) unscopedKey(key string) (string, bool) {
+	if b.storagePrefix == "" {
+		return key, true
+	}
+	return strings.CutPrefix(key, b.storagePrefix)
+}
@@ -0,0 +1,116 @@
+package backend
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// newTenantBackend creates a backend on shared storage scoped by prefix
+func newTenantBackend(t *testing.T, shared logical.Storage, prefix string) *gmsaBackend {
+	t.Helper()
+	conf := &logical.BackendConfig{
+		System:      &logical.StaticSystemView{},
+		StorageView: shared,
+		Config:      map[string]string{storagePrefixOption: prefix},
+	}
+	b, err := Factory(context.Background(), conf)
+	if err != nil {
+		t.Fatalf("failed to create backend: %v", err)
+	}
+	ret := b.(*gmsaBackend)
+	t.Cleanup(func() { ret.Cleanup(context.Background()) })
+	return ret
+}
+
+func TestStoragePrefix_TenantIsolation(t *testing.T) {
+	ctx := context.Background()
+	shared := newMemStorage()
+	tenantA := newTenantBackend(t, shared, "tenant-a")
+	tenantB := newTenantBackend(t, shared, "tenant-b/")
+
+	request := func(b *gmsaBackend, op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   shared,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("%s %s failed: err=%v resp=%#v", op, path, err, resp)
+		}
+		return resp
+	}
+	request(tenantA, logical.UpdateOperation, "role/app", map[string]interface{}{"token_policies": "tenant-a"})
+	request(tenantB, logical.UpdateOperation, "role/app", map[string]interface{}{"token_policies": "tenant-b"})
+	request(tenantB, logical.UpdateOperation, "role/only-b", map[string]interface{}{"token_policies": "tenant-b"})
+	request(tenantA, logical.UpdateOperation, "rotation/config", map[string]interface{}{"enabled": false, "domain_controller": "dc1.a.example.com"})
+	if err := writeConfig(ctx, tenantA.storage, &Config{Realm: "A.EXAMPLE.COM"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		b      *gmsaBackend
+		policy string
+		roles  []string
+	}{
+		{tenantA, "tenant-a", []string{"app"}},
+		{tenantB, "tenant-b", []string{"app", "only-b"}},
+	} {
+		resp := request(tc.b, logical.ReadOperation, "role/app", nil)
+		if got := resp.Data["token_policies"]; got != tc.policy {
+			t.Errorf("role app token_policies = %v, want %s", got, tc.policy)
+		}
+		resp = request(tc.b, logical.ListOperation, "role/", nil)
+		roles, _ := resp.Data["keys"].([]string)
+		sort.Strings(roles)
+		if !reflect.DeepEqual(roles, tc.roles) {
+			t.Errorf("roles = %v, want %v", roles, tc.roles)
+		}
+	}
+
+	if resp := request(tenantA, logical.ReadOperation, "config", nil); resp == nil || resp.Data["realm"] != "A.EXAMPLE.COM" {
+		t.Errorf("tenant-a config = %#v, want its realm", resp)
+	}
+	if resp, err := tenantB.HandleRequest(ctx, &logical.Request{Operation: logical.ReadOperation, Path: "config", Storage: shared}); err != nil || resp == nil || !resp.IsError() {
+		t.Errorf("tenant-b sees tenant-a config: err=%v resp=%#v", err, resp)
+	}
+	if rc := tenantB.rotationConfig(ctx); rc != nil {
+		t.Errorf("tenant-b sees tenant-a rotation config: %#v", rc)
+	}
+	if cfg, err := readConfig(ctx, shared); err != nil || cfg != nil {
+		t.Errorf("unprefixed storage sees tenant config: cfg=%v err=%v", cfg, err)
+	}
+	for _, key := range []string{"tenant-a/config", "tenant-a/role/app", "tenant-a/rotation/config", "tenant-b/role/only-b"} {
+		if entry, _ := shared.Get(ctx, key); entry == nil {
+			t.Errorf("%s not stored under its tenant prefix", key)
+		}
+	}
+	if sealed := tenantA.SpecialPaths().SealWrapStorage; !reflect.DeepEqual(sealed, []string{"tenant-a/rotation/config", "tenant-a/" + storageKeyAuditChainKey}) {
+		t.Errorf("SealWrapStorage = %v, want the tenant's rotation config and audit key", sealed)
+	}
+}
+
+func TestParseStoragePrefix(t *testing.T) {
+	for in, want := range map[string]string{"": "", " ": "", "tenant-a": "tenant-a/", "org/tenant_b/": "org/tenant_b/"} {
+		if got, err := parseStoragePrefix(in); err != nil || got != want {
+			t.Errorf("parseStoragePrefix(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+	for _, prefix := range []string{"../x", "a//b", "/a", "a b", "a/./b"} {
+		if _, err := parseStoragePrefix(prefix); err == nil {
+			t.Errorf("parseStoragePrefix(%q) accepted an invalid prefix", prefix)
+		}
+	}
+	if _, err := Factory(context.Background(), &logical.BackendConfig{
+		System:      &logical.StaticSystemView{},
+		StorageView: newMemStorage(),
+		Config:      map[string]string{storagePrefixOption: "../escape"},
+	}); err == nil {
+		t.Error("Factory accepted an invalid storage_prefix")
+	}
+}
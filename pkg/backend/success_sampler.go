@@ -0,0 +1,23 @@
+package backend
+
+import "sync/atomic"
+
+// successSampler decides which successful logins are logged. Sampling is
+// deterministic: the nth success is logged when floor(n*rate) advances, so
+// exactly rate of any run of logins is logged without a random source.
+// The counter is atomic, so concurrent logins never double-count.
+type successSampler struct {
+	count atomic.Uint64
+}
+
+// sample records one success and reports whether it should be logged
+func (s *successSampler) sample(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	if rate >= 1 {
+		return true
+	}
+	n := s.count.Add(1)
+	return uint64(float64(n)*rate) != uint64(float64(n-1)*rate)
+}
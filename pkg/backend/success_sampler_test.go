@@ -0,0 +1,94 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestSuccessSampler_Rate(t *testing.T) {
+	const (
+		workers = 8
+		perWork = 2500
+		total   = workers * perWork
+	)
+
+	for _, rate := range []float64{0, 0.001, 0.1, 0.25, 0.5, 1} {
+		var s successSampler
+		var logged atomic.Int64
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < perWork; i++ {
+					if s.sample(rate) {
+						logged.Add(1)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		// Sampling is deterministic, so the count is exact up to rounding
+		want := int64(float64(total) * rate)
+		if got := logged.Load(); got < want-1 || got > want+1 {
+			t.Errorf("rate %v: logged %d of %d, want about %d", rate, got, total, want)
+		}
+	}
+}
+
+func TestHandleLogin_SuccessLogSampling(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	kt := newTestLoginConfig(t, storage)
+	cfg, err := readConfig(ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.SuccessLogSampleRate = 0.25
+	if err := writeConfig(ctx, storage, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeRole(ctx, storage, &Role{Name: "app", TokenPolicies: []string{"app"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	b.logger = hclog.New(&hclog.LoggerOptions{Output: &buf, Level: hclog.Info})
+
+	login := func(role string) {
+		t.Helper()
+		if _, err := b.HandleRequest(ctx, &logical.Request{
+			Operation:  logical.UpdateOperation,
+			Path:       "login",
+			Storage:    storage,
+			Data:       map[string]interface{}{"role": role, "spnego": newTestLoginSPNEGO(t, kt)},
+			Connection: &logical.Connection{RemoteAddr: "127.0.0.1"},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 40; i++ {
+		login("app")
+	}
+	if got := strings.Count(buf.String(), "login succeeded"); got != 10 {
+		t.Errorf("logged %d of 40 successful logins, want 10 at rate 0.25", got)
+	}
+
+	// Failures are logged regardless of the sample rate
+	buf.Reset()
+	for i := 0; i < 3; i++ {
+		login("missing")
+	}
+	if got := strings.Count(buf.String(), "login rejected"); got != 3 {
+		t.Errorf("logged %d of 3 failed logins, want 3", got)
+	}
+}
@@ -0,0 +1,139 @@
+package backend
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+)
+
+// Token InternalData keys set at login
+const (
+	internalKeyRole = "role"        // Role the token was issued for
+	internalKeyKVNO = "keytab_kvno" // Keytab kvno at login, for invalidate_on_rotation
+	// Unix end time of the service ticket, for ttl_from_ticket
+	internalKeyTicketEnd = "ticket_end"
+)
+
+// authRenew re-reads the token's role on renewal: tokens of deleted or
+// disabled roles are not renewed, and the role's current period and max_ttl
+// apply. invalidate_on_rotation tokens also stop renewing once the mount's
+// keytab rotates past the kvno recorded at login, and ttl_from_ticket tokens
+// stay capped at the end of the ticket they were issued for.
+func (b *gmsaBackend) authRenew(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	if req.Auth == nil {
+		return nil, errors.New("request auth was nil")
+	}
+
+	// Tokens issued before the role was recorded internally carry it in metadata
+	roleName, _ := req.Auth.InternalData[internalKeyRole].(string)
+	if roleName == "" {
+		roleName = req.Auth.Metadata["role"]
+	}
+	if roleName == "" {
+		return logical.ErrorResponse("token is not associated with a role"), nil
+	}
+	role, err := b.loadRole(ctx, roleName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read role: %w", err)
+	}
+	if role == nil {
+		return logical.ErrorResponse(fmt.Sprintf("role %q no longer exists", roleName)), nil
+	}
+	if role.Disabled {
+		return logical.ErrorResponse(fmt.Sprintf("role %q is disabled", roleName)), nil
+	}
+
+	if raw, ok := req.Auth.InternalData[internalKeyKVNO]; ok {
+		issued, err := internalInt(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s in token: %w", internalKeyKVNO, err)
+		}
+		cfg, err := b.loadConfig(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config: %w", err)
+		}
+		if cfg == nil {
+			return logical.ErrorResponse("auth method not configured"), nil
+		}
+		current, err := keytabKVNO(cfg.KeytabB64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read keytab kvno: %w", err)
+		}
+		if current > issued {
+			b.logger.Info("renewal denied: keytab rotated since login", "display_name", req.Auth.DisplayName, "issued_kvno", issued, "current_kvno", current)
+			return logical.ErrorResponse("token was invalidated by credential rotation; log in again"), nil
+		}
+	}
+
+	resp := &logical.Response{Auth: req.Auth}
+	resp.Auth.Period = time.Duration(role.Period) * time.Second
+	resp.Auth.TTL = time.Duration(role.MaxTTL) * time.Second
+
+	// The cap holds even if the role has since dropped ttl_from_ticket
+	if raw, ok := req.Auth.InternalData[internalKeyTicketEnd]; ok {
+		end, err := internalInt(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s in token: %w", internalKeyTicketEnd, err)
+		}
+		remaining := time.Unix(int64(end), 0).Sub(b.now()).Truncate(time.Second)
+		if remaining <= 0 {
+			return logical.ErrorResponse("kerberos ticket has expired; log in again"), nil
+		}
+		capTTLToTicket(resp.Auth, remaining)
+	}
+	return resp, nil
+}
+
+// capTTLToTicket keeps a token from outliving the service ticket that
+// authorized it, remaining being the ticket's lifetime left
+func capTTLToTicket(auth *logical.Auth, remaining time.Duration) {
+	if auth.TTL == 0 || auth.TTL > remaining {
+		auth.TTL = remaining
+	}
+	if auth.Period > remaining {
+		auth.Period = remaining
+	}
+	auth.MaxTTL = remaining
+}
+
+// keytabKVNO returns the highest key version number in a base64 keytab
+func keytabKVNO(keytabB64 string) (int, error) {
+	raw, err := base64.StdEncoding.DecodeString(keytabB64)
+	if err != nil {
+		return 0, err
+	}
+	kt := &keytab.Keytab{}
+	if err := kt.Unmarshal(raw); err != nil {
+		return 0, err
+	}
+	kvno := 0
+	for _, e := range kt.Entries {
+		kvno = max(kvno, int(e.KVNO))
+	}
+	return kvno, nil
+}
+
+// internalInt reads an integer from token InternalData, which comes back
+// from storage as a JSON number
+func internalInt(v interface{}) (int, error) {
+	switch n := v.(type) {
+	case int:
+		return n, nil
+	case int64:
+		return int(n), nil
+	case float64:
+		return int(n), nil
+	case json.Number:
+		i, err := n.Int64()
+		return int(i), err
+	default:
+		return 0, fmt.Errorf("unexpected type %T", v)
+	}
+}
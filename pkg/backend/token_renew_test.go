@@ -0,0 +1,238 @@
+package backend
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+)
+
+// testLoginAuth logs in against role and returns the issued auth
+func testLoginAuth(t *testing.T, b *gmsaBackend, storage logical.Storage, kt *keytab.Keytab, role string) *logical.Auth {
+	t.Helper()
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation:  logical.UpdateOperation,
+		Path:       "login",
+		Storage:    storage,
+		Data:       map[string]interface{}{"role": role, "spnego": newTestLoginSPNEGO(t, kt)},
+		Connection: &logical.Connection{RemoteAddr: "127.0.0.1"},
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("login failed: err=%v resp=%#v", err, resp)
+	}
+	return resp.Auth
+}
+
+// testRenew sends a renewal for auth the way Vault does, with InternalData
+// after a round trip through storage
+func testRenew(t *testing.T, b *gmsaBackend, storage logical.Storage, auth *logical.Auth) *logical.Response {
+	t.Helper()
+	raw, err := json.Marshal(auth.InternalData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := *auth
+	stored.InternalData = nil
+	if err := json.Unmarshal(raw, &stored.InternalData); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RenewOperation,
+		Path:      "login",
+		Storage:   storage,
+		Auth:      &stored,
+	})
+	if err != nil || resp == nil {
+		t.Fatalf("renew failed: err=%v resp=%#v", err, resp)
+	}
+	return resp
+}
+
+func TestAuthRenew_RoleChanges(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	kt := newTestLoginConfig(t, storage)
+
+	if err := writeRole(ctx, storage, &Role{Name: "app", TokenPolicies: []string{"app"}, MaxTTL: 3600}); err != nil {
+		t.Fatal(err)
+	}
+	auth := testLoginAuth(t, b, storage, kt, "app")
+	if !auth.Renewable || auth.InternalData[internalKeyRole] != "app" {
+		t.Fatalf("token: renewable=%t internal=%v", auth.Renewable, auth.InternalData)
+	}
+
+	// A changed TTL applies on the next renewal
+	if err := writeRole(ctx, storage, &Role{Name: "app", TokenPolicies: []string{"app"}, MaxTTL: 600, Period: 300}); err != nil {
+		t.Fatal(err)
+	}
+	resp := testRenew(t, b, storage, auth)
+	if resp.IsError() {
+		t.Fatalf("renewal denied: %v", resp.Error())
+	}
+	if resp.Auth.TTL != 10*time.Minute || resp.Auth.Period != 5*time.Minute {
+		t.Errorf("TTL = %v, Period = %v, want 10m and 5m", resp.Auth.TTL, resp.Auth.Period)
+	}
+
+	// Tokens issued before the role was kept in InternalData fall back to metadata
+	legacy := *auth
+	legacy.InternalData = nil
+	if resp := testRenew(t, b, storage, &legacy); resp.IsError() {
+		t.Errorf("legacy token renewal denied: %v", resp.Error())
+	}
+
+	if err := writeRole(ctx, storage, &Role{Name: "app", TokenPolicies: []string{"app"}, Disabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	if resp := testRenew(t, b, storage, auth); !resp.IsError() {
+		t.Error("renewal for a disabled role succeeded")
+	}
+
+	if _, err := b.HandleRequest(ctx, &logical.Request{Operation: logical.DeleteOperation, Path: "role/app", Storage: storage}); err != nil {
+		t.Fatal(err)
+	}
+	if resp := testRenew(t, b, storage, auth); !resp.IsError() {
+		t.Error("renewal for a deleted role succeeded")
+	}
+}
+
+func TestAuthRenew_InvalidateOnRotation(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	kt := newTestLoginConfig(t, storage) // keytab at kvno 1
+
+	for _, role := range []*Role{
+		{Name: "rotating", TokenPolicies: []string{"app"}, Period: 3600, InvalidateOnRotation: true},
+		{Name: "plain", TokenPolicies: []string{"app"}, Period: 3600},
+	} {
+		if err := writeRole(ctx, storage, role); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	login := func(role string) *logical.Auth { return testLoginAuth(t, b, storage, kt, role) }
+	renew := func(auth *logical.Auth) *logical.Response { return testRenew(t, b, storage, auth) }
+
+	plain := login("plain")
+	if _, ok := plain.InternalData[internalKeyKVNO]; ok {
+		t.Errorf("plain role token recorded a kvno: %v", plain.InternalData)
+	}
+
+	auth := login("rotating")
+	if !auth.Renewable || auth.InternalData[internalKeyKVNO] != 1 {
+		t.Fatalf("rotating role token: renewable=%t internal=%v", auth.Renewable, auth.InternalData)
+	}
+	if resp := renew(auth); resp.IsError() || resp.Auth == nil || resp.Auth.Period != time.Hour {
+		t.Fatalf("renewal before rotation: %#v", resp)
+	}
+
+	// Simulate a rotation: the config now holds the kvno 2 key
+	rotated := keytab.New()
+	if err := rotated.AddEntry(testLoginSPN, "EXAMPLE.COM", "rotated-password", time.Now(), 2, etypeID.AES256_CTS_HMAC_SHA1_96); err != nil {
+		t.Fatal(err)
+	}
+	kb, err := rotated.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := readConfig(ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.KeytabB64 = base64.StdEncoding.EncodeToString(kb)
+	if err := writeConfig(ctx, storage, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp := renew(auth); !resp.IsError() {
+		t.Errorf("renewal after rotation succeeded: %#v", resp)
+	}
+	if resp := renew(plain); resp.IsError() {
+		t.Errorf("plain token renewal after rotation denied: %v", resp.Error())
+	}
+}
+
+func TestAuthRenew_TTLFromTicket(t *testing.T) {
+	b, storage := getTestBackend(t)
+	ctx := context.Background()
+	kt := newTestLoginConfig(t, storage)
+
+	for _, role := range []*Role{
+		{Name: "capped", TokenPolicies: []string{"app"}, MaxTTL: 8 * 3600, TTLFromTicket: true},
+		{Name: "capped-periodic", TokenPolicies: []string{"app"}, Period: 8 * 3600, TTLFromTicket: true},
+	} {
+		if err := writeRole(ctx, storage, role); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	login := func(role string) *logical.Auth {
+		t.Helper()
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation:  logical.UpdateOperation,
+			Path:       "login",
+			Storage:    storage,
+			Data:       map[string]interface{}{"role": role, "spnego": newTestLoginSPNEGOWithLifetime(t, kt, time.Hour)},
+			Connection: &logical.Connection{RemoteAddr: "127.0.0.1"},
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("login failed: err=%v resp=%#v", err, resp)
+		}
+		return resp.Auth
+	}
+
+	capped, periodic := login("capped"), login("capped-periodic")
+	if _, ok := capped.InternalData[internalKeyTicketEnd]; !ok {
+		t.Fatalf("capped token has no ticket end: %v", capped.InternalData)
+	}
+
+	// Half an hour on, renewal keeps the token within the ticket's last 30m
+	start := time.Now()
+	b.now = func() time.Time { return start.Add(30 * time.Minute) }
+	for name, auth := range map[string]*logical.Auth{"capped": capped, "capped-periodic": periodic} {
+		resp := testRenew(t, b, storage, auth)
+		if resp.IsError() {
+			t.Fatalf("%s: renewal denied: %v", name, resp.Error())
+		}
+		if got := resp.Auth; got.TTL > 30*time.Minute || got.Period > 30*time.Minute || got.MaxTTL > 30*time.Minute || got.MaxTTL < 29*time.Minute {
+			t.Errorf("%s: TTL = %v, Period = %v, MaxTTL = %v, want all capped at the ticket's remaining 30m", name, got.TTL, got.Period, got.MaxTTL)
+		}
+	}
+
+	// The cap outlives the role dropping ttl_from_ticket
+	if err := writeRole(ctx, storage, &Role{Name: "capped", TokenPolicies: []string{"app"}, MaxTTL: 8 * 3600}); err != nil {
+		t.Fatal(err)
+	}
+	if resp := testRenew(t, b, storage, capped); resp.IsError() || resp.Auth.TTL > 30*time.Minute {
+		t.Errorf("renewal after the role dropped ttl_from_ticket = %#v, want the ticket cap kept", resp)
+	}
+
+	// Once the ticket has ended the token isn't renewed
+	b.now = func() time.Time { return start.Add(2 * time.Hour) }
+	if resp := testRenew(t, b, storage, capped); !resp.IsError() {
+		t.Errorf("renewal after the ticket ended succeeded: %#v", resp.Auth)
+	}
+}
+
+func TestKeytabKVNO(t *testing.T) {
+	kt := keytab.New()
+	for _, kvno := range []uint8{3, 5, 4} {
+		if err := kt.AddEntry(testLoginSPN, "EXAMPLE.COM", "password", time.Now(), kvno, etypeID.AES256_CTS_HMAC_SHA1_96); err != nil {
+			t.Fatal(err)
+		}
+	}
+	kb, err := kt.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := keytabKVNO(base64.StdEncoding.EncodeToString(kb)); err != nil || got != 5 {
+		t.Errorf("keytabKVNO() = %d, %v, want 5", got, err)
+	}
+	if _, err := keytabKVNO("not base64!"); err == nil {
+		t.Error("expected error for invalid keytab")
+	}
+}
@@ -0,0 +1,53 @@
+package backend
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName identifies spans started by the backend
+const tracerName = "github.com/lpassig/vault-plugin-auth-gmsa/pkg/backend"
+
+// defaultTracer uses the global OpenTelemetry provider, which is a no-op
+// until the host process installs one
+func defaultTracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// startSpan starts a span with the backend's tracer, falling back to the
+// global provider for backends built without Factory
+func (b *gmsaBackend) startSpan(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	tracer := b.tracer
+	if tracer == nil {
+		tracer = defaultTracer()
+	}
+	return tracer.Start(ctx, name, opts...)
+}
+
+// endLoginSpan records the login outcome on span and ends it. The outcome is
+// "success", the response's error_code, or "error" for internal failures.
+func endLoginSpan(span trace.Span, resp *logical.Response, err error) {
+	outcome := "success"
+	switch {
+	case err != nil:
+		outcome = "error"
+		span.RecordError(err)
+	case resp == nil:
+		outcome = "error"
+	case resp.Auth == nil:
+		outcome = loginErrorCode(resp)
+		if outcome == "" {
+			outcome = "error"
+		}
+	}
+	span.SetAttributes(attribute.String("gmsa.outcome", outcome))
+	if outcome != "success" {
+		span.SetStatus(codes.Error, outcome)
+	}
+	span.End()
+}
@@ -0,0 +1,114 @@
+package backend
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// recordSpans points the backend at an in-memory span recorder
+func recordSpans(b *gmsaBackend) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	b.tracer = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer(tracerName)
+	return recorder
+}
+
+// spanAttrs flattens a span's attributes for lookups
+func spanAttrs(s sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range s.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func TestLoginTracing(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		role    *Role
+		outcome string
+	}{
+		{name: "success", role: &Role{Name: "app", TokenPolicies: []string{"app"}}, outcome: "success"},
+		{name: "unauthorized", role: &Role{Name: "app", AllowedRealms: []string{"OTHER.COM"}}, outcome: errorCodeRealmNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, storage := getTestBackend(t)
+			kt := newTestLoginConfig(t, storage)
+			if err := writeRole(ctx, storage, tt.role); err != nil {
+				t.Fatal(err)
+			}
+			recorder := recordSpans(b)
+
+			if _, err := b.HandleRequest(ctx, &logical.Request{
+				Operation:  logical.UpdateOperation,
+				Path:       "login",
+				Storage:    storage,
+				Data:       map[string]interface{}{"role": "app", "spnego": newTestLoginSPNEGO(t, kt)},
+				Connection: &logical.Connection{RemoteAddr: "127.0.0.1"},
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			spans := map[string]sdktrace.ReadOnlySpan{}
+			for _, s := range recorder.Ended() {
+				spans[s.Name()] = s
+			}
+			root, ok := spans["gmsa.login"]
+			if !ok {
+				t.Fatalf("no gmsa.login span in %v", spans)
+			}
+			if root.Parent().IsValid() {
+				t.Error("gmsa.login should be a root span")
+			}
+			for _, name := range []string{"gmsa.decode_token", "gmsa.accept_sec_context", "gmsa.pac_validation", "gmsa.authorize"} {
+				s, ok := spans[name]
+				if !ok {
+					t.Errorf("missing %s span", name)
+					continue
+				}
+				if s.Parent().SpanID() != root.SpanContext().SpanID() {
+					t.Errorf("%s parent = %s, want gmsa.login", name, s.Parent().SpanID())
+				}
+			}
+
+			attrs := spanAttrs(root)
+			if got := attrs["gmsa.role"].AsString(); got != "app" {
+				t.Errorf("gmsa.role = %q", got)
+			}
+			if got := attrs["gmsa.realm"].AsString(); got != "EXAMPLE.COM" {
+				t.Errorf("gmsa.realm = %q", got)
+			}
+			if got := attrs["gmsa.outcome"].AsString(); got != tt.outcome {
+				t.Errorf("gmsa.outcome = %q, want %q", got, tt.outcome)
+			}
+			if wantErr := tt.outcome != "success"; (root.Status().Code == codes.Error) != wantErr {
+				t.Errorf("status = %v, want error %t", root.Status(), wantErr)
+			}
+			// Test tickets are AES256 (enctype 18)
+			if got := spanAttrs(spans["gmsa.accept_sec_context"])["gmsa.enctype"].AsInt64(); got != 18 {
+				t.Errorf("gmsa.enctype = %d, want 18", got)
+			}
+			if got := spanAttrs(spans["gmsa.authorize"])["gmsa.authorized"].AsBool(); got != (tt.outcome == "success") {
+				t.Errorf("gmsa.authorized = %t", got)
+			}
+		})
+	}
+}
+
+func TestLoginTracing_NoopByDefault(t *testing.T) {
+	b := &gmsaBackend{}
+	_, span := b.startSpan(context.Background(), "gmsa.login")
+	defer span.End()
+	if span.IsRecording() {
+		t.Error("spans should not record without a configured tracer provider")
+	}
+}
@@ -0,0 +1,174 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	webhookTimeout           = 10 * time.Second // Per-attempt HTTP timeout
+	defaultWebhookRetryDelay = time.Second      // Base backoff when no retry delay is configured
+	maxWebhookBackoff        = 5 * time.Minute  // Cap on a single backoff so rotation isn't stalled
+)
+
+// maxNotificationEndpoints bounds the notification fan-out
+const maxNotificationEndpoints = 10
+
+// webhookSignatureHeader carries the body's HMAC-SHA256 when a secret is set
+const webhookSignatureHeader = "X-GMSA-Signature"
+
+// errWebhookPermanent marks webhook failures that retrying cannot fix
+var errWebhookPermanent = errors.New("permanent webhook failure")
+
+// waitWebhookRetry waits out a retry backoff, returning early with ctx's
+// error when it is cancelled; tests replace it to skip the wait
+var waitWebhookRetry = func(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// deliverWebhook POSTs payload as JSON to endpoint with the extra headers
+// added to each attempt, signing the body when hmacSecret is set. It returns
+// the last HTTP status received (0 if none). 2xx responses succeed and
+// 4xx responses fail immediately; 5xx responses and network errors are
+// retried up to maxRetries times with exponential backoff and jitter starting
+// at retryDelay. Cancelling ctx aborts the attempt in flight and any backoff.
+func deliverWebhook(ctx context.Context, endpoint string, headers http.Header, hmacSecret string, payload map[string]interface{}, maxRetries int, retryDelay time.Duration) (int, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal payload: %w", err)
+	}
+	if hmacSecret != "" {
+		headers = headers.Clone()
+		if headers == nil {
+			headers = http.Header{}
+		}
+		headers.Set(webhookSignatureHeader, webhookSignature(hmacSecret, body))
+	}
+
+	client := &http.Client{Timeout: webhookTimeout}
+	for attempt := 0; ; attempt++ {
+		status, err := postWebhook(ctx, client, endpoint, headers, body)
+		if err == nil || errors.Is(err, errWebhookPermanent) || attempt >= maxRetries {
+			return status, err
+		}
+		if werr := waitWebhookRetry(ctx, webhookBackoff(retryDelay, attempt)); werr != nil {
+			return status, fmt.Errorf("%w (retries abandoned: %v)", err, werr)
+		}
+	}
+}
+
+// webhookResult is the outcome of delivering a payload to one endpoint
+type webhookResult struct {
+	Endpoint string // Endpoint with credentials redacted
+	Status   int    // Last HTTP status received (0 if none)
+	Err      error
+}
+
+// fanOutWebhook delivers payload to every target concurrently with
+// deliverWebhook, each with its own headers and signing secret, so a slow or
+// failing endpoint doesn't hold up the others. It returns the per-target
+// results in target order and the failures joined into one error.
+func fanOutWebhook(ctx context.Context, targets []NotificationTarget, payload map[string]interface{}, maxRetries int, retryDelay time.Duration) ([]webhookResult, error) {
+	results := make([]webhookResult, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target NotificationTarget) {
+			defer wg.Done()
+			status, err := deliverWebhook(ctx, target.URL, target.Headers, target.Secret, payload, maxRetries, retryDelay)
+			results[i] = webhookResult{Endpoint: redactedEndpoint(target.URL), Status: status, Err: err}
+		}(i, target)
+	}
+	wg.Wait()
+
+	var errs []error
+	for _, r := range results {
+		if r.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", r.Endpoint, r.Err))
+		}
+	}
+	return results, errors.Join(errs...)
+}
+
+// redactedEndpoint returns endpoint with any embedded credentials redacted
+func redactedEndpoint(endpoint string) string {
+	if u, err := url.Parse(endpoint); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	return endpoint
+}
+
+// postWebhook makes a single delivery attempt and returns the HTTP status
+func postWebhook(ctx context.Context, client *http.Client, endpoint string, headers http.Header, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("%w: failed to create request: %v", errWebhookPermanent, err)
+	}
+	for name, values := range headers {
+		for _, v := range values {
+			req.Header.Add(name, v)
+		}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "vault-gmsa-auth-plugin/"+pluginVersion)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to send webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return resp.StatusCode, nil
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		return resp.StatusCode, fmt.Errorf("%w: webhook failed with status: %d", errWebhookPermanent, resp.StatusCode)
+	default:
+		return resp.StatusCode, fmt.Errorf("webhook failed with status: %d", resp.StatusCode)
+	}
+}
+
+// webhookSignature returns the X-GMSA-Signature value for body:
+// "sha256=" followed by the hex HMAC-SHA256 of the body under secret
+func webhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff returns the delay before retry attempt+1: base doubled per
+// attempt, capped, with up to half of it replaced by random jitter
+func webhookBackoff(base time.Duration, attempt int) time.Duration {
+	if base <= 0 {
+		base = defaultWebhookRetryDelay
+	}
+	delay := base
+	for i := 0; i < attempt && delay < maxWebhookBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxWebhookBackoff {
+		delay = maxWebhookBackoff
+	}
+	half := delay / 2
+	return half + rand.N(half+1)
+}
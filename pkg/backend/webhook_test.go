@@ -0,0 +1,372 @@
+package backend
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// webhookServer answers with the given statuses in turn, repeating the last
+func webhookServer(t *testing.T, statuses ...int) (*httptest.Server, *int32) {
+	t.Helper()
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(&calls, 1)) - 1
+		if n >= len(statuses) {
+			n = len(statuses) - 1
+		}
+		w.WriteHeader(statuses[n])
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+// stubWebhookWait records retry backoffs instead of waiting them out
+func stubWebhookWait(t *testing.T) *[]time.Duration {
+	t.Helper()
+	orig := waitWebhookRetry
+	t.Cleanup(func() { waitWebhookRetry = orig })
+	var mu sync.Mutex
+	var delays []time.Duration
+	waitWebhookRetry = func(ctx context.Context, d time.Duration) error {
+		mu.Lock()
+		delays = append(delays, d)
+		mu.Unlock()
+		return ctx.Err()
+	}
+	return &delays
+}
+
+func TestDeliverWebhook_RetriesServerErrors(t *testing.T) {
+	srv, calls := webhookServer(t, http.StatusInternalServerError, http.StatusBadGateway, http.StatusOK)
+
+	waits := stubWebhookWait(t)
+	_, err := deliverWebhook(context.Background(), srv.URL, nil, "", map[string]interface{}{"message": "rotated"}, 3, time.Second)
+	delays := *waits
+	if err != nil {
+		t.Fatalf("expected eventual success, got: %v", err)
+	}
+	if got := atomic.LoadInt32(calls); got != 3 {
+		t.Errorf("attempts = %d, want 3", got)
+	}
+	if len(delays) != 2 {
+		t.Fatalf("backoffs = %v, want 2", delays)
+	}
+	// Exponential backoff with jitter: [base/2, base], then [base, 2*base]
+	if delays[0] < 500*time.Millisecond || delays[0] > time.Second {
+		t.Errorf("first backoff = %v, want within [500ms, 1s]", delays[0])
+	}
+	if delays[1] < time.Second || delays[1] > 2*time.Second {
+		t.Errorf("second backoff = %v, want within [1s, 2s]", delays[1])
+	}
+}
+
+func TestDeliverWebhook_CustomHeaders(t *testing.T) {
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+
+	headers := notificationHeaders(map[string]string{"authorization": "Bearer s3cret", "X-Signature": "abc123"})
+	if _, err := deliverWebhook(context.Background(), srv.URL, headers, "", map[string]interface{}{"message": "rotated"}, 0, time.Second); err != nil {
+		t.Fatalf("delivery failed: %v", err)
+	}
+	if v := got.Get("Authorization"); v != "Bearer s3cret" {
+		t.Errorf("Authorization = %q", v)
+	}
+	if v := got.Get("X-Signature"); v != "abc123" {
+		t.Errorf("X-Signature = %q", v)
+	}
+	if v := got.Get("Content-Type"); v != "application/json" {
+		t.Errorf("Content-Type = %q", v)
+	}
+}
+
+func TestDeliverWebhook_HMACSignature(t *testing.T) {
+	const secret = "hook-signing-key"
+	var body []byte
+	var signature string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		signature = r.Header.Get("X-GMSA-Signature")
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+
+	if _, err := deliverWebhook(context.Background(), srv.URL, nil, secret, map[string]interface{}{"message": "rotated"}, 0, time.Second); err != nil {
+		t.Fatalf("delivery failed: %v", err)
+	}
+
+	// Verify the way a receiver would
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(signature), []byte(want)) {
+		t.Errorf("X-GMSA-Signature = %q, want %q", signature, want)
+	}
+
+	// Unsigned without a secret
+	if _, err := deliverWebhook(context.Background(), srv.URL, nil, "", map[string]interface{}{"message": "rotated"}, 0, time.Second); err != nil {
+		t.Fatalf("delivery failed: %v", err)
+	}
+	if signature != "" {
+		t.Errorf("unexpected signature %q without a secret", signature)
+	}
+}
+
+func TestDeliverWebhook_NoRetryOnClientError(t *testing.T) {
+	srv, calls := webhookServer(t, http.StatusBadRequest, http.StatusOK)
+
+	waits := stubWebhookWait(t)
+	_, err := deliverWebhook(context.Background(), srv.URL, nil, "", map[string]interface{}{}, 3, time.Second)
+	if len(*waits) != 0 {
+		t.Error("unexpected retry after 4xx")
+	}
+	if !errors.Is(err, errWebhookPermanent) {
+		t.Errorf("expected permanent failure, got: %v", err)
+	}
+	if got := atomic.LoadInt32(calls); got != 1 {
+		t.Errorf("attempts = %d, want 1", got)
+	}
+}
+
+func TestDeliverWebhook_GivesUpAfterMaxRetries(t *testing.T) {
+	srv, calls := webhookServer(t, http.StatusServiceUnavailable)
+
+	stubWebhookWait(t)
+	_, err := deliverWebhook(context.Background(), srv.URL, nil, "", map[string]interface{}{}, 2, time.Second)
+	if err == nil {
+		t.Fatal("expected failure after exhausting retries")
+	}
+	if got := atomic.LoadInt32(calls); got != 3 {
+		t.Errorf("attempts = %d, want 3 (1 + 2 retries)", got)
+	}
+}
+
+func TestDeliverWebhook_RetriesNetworkErrors(t *testing.T) {
+	srv, _ := webhookServer(t, http.StatusOK)
+	url := srv.URL
+	srv.Close()
+
+	waits := stubWebhookWait(t)
+	if _, err := deliverWebhook(context.Background(), url, nil, "", map[string]interface{}{}, 1, time.Second); err == nil {
+		t.Fatal("expected network failure")
+	}
+	if retries := len(*waits); retries != 1 {
+		t.Errorf("retries = %d, want 1", retries)
+	}
+}
+
+func TestDeliverWebhook_CancelStopsRetries(t *testing.T) {
+	srv, calls := webhookServer(t, http.StatusServiceUnavailable)
+
+	// The real wait: a cancelled context ends the backoff at once instead of
+	// sleeping out the 1h retry delay
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		for atomic.LoadInt32(calls) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+	}()
+	done := make(chan error, 1)
+	go func() {
+		_, err := deliverWebhook(ctx, srv.URL, nil, "", map[string]interface{}{}, 5, time.Hour)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+			t.Errorf("error = %v, want the cancellation reported", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("delivery kept waiting after the context was cancelled")
+	}
+	if got := atomic.LoadInt32(calls); got != 1 {
+		t.Errorf("attempts = %d, want 1", got)
+	}
+}
+
+func TestFanOutWebhook(t *testing.T) {
+	first, firstCalls := webhookServer(t, http.StatusOK)
+	failing, failingCalls := webhookServer(t, http.StatusInternalServerError)
+	last, lastCalls := webhookServer(t, http.StatusAccepted)
+
+	stubWebhookWait(t)
+	results, err := fanOutWebhook(context.Background(), []NotificationTarget{{URL: first.URL}, {URL: failing.URL}, {URL: last.URL}}, map[string]interface{}{"message": "rotated"}, 1, time.Second)
+	if err == nil || !strings.Contains(err.Error(), failing.URL) || strings.Contains(err.Error(), first.URL) {
+		t.Fatalf("error = %v, want only the failing endpoint", err)
+	}
+	if atomic.LoadInt32(firstCalls) != 1 || atomic.LoadInt32(lastCalls) != 1 {
+		t.Errorf("healthy endpoints called %d and %d times, want once each", atomic.LoadInt32(firstCalls), atomic.LoadInt32(lastCalls))
+	}
+	if got := atomic.LoadInt32(failingCalls); got != 2 {
+		t.Errorf("failing endpoint attempts = %d, want 2 (1 + 1 retry)", got)
+	}
+	want := []int{http.StatusOK, http.StatusInternalServerError, http.StatusAccepted}
+	for i, r := range results {
+		if r.Status != want[i] || (r.Err != nil) != (i == 1) {
+			t.Errorf("results[%d] = %+v, want status %d", i, r, want[i])
+		}
+	}
+}
+
+func TestRotationManager_SendNotificationFansOut(t *testing.T) {
+	type delivery struct {
+		Message, Authorization string
+		Signature              string // none, own (the endpoint's secret) or foreign
+	}
+	var mu sync.Mutex
+	received := map[string]delivery{}
+	handler := func(name, secret string, status int) *httptest.Server {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			var payload map[string]interface{}
+			_ = json.Unmarshal(body, &payload)
+			d := delivery{Authorization: r.Header.Get("Authorization")}
+			d.Message, _ = payload["message"].(string)
+			switch sig := r.Header.Get(webhookSignatureHeader); {
+			case sig == "":
+				d.Signature = "none"
+			case secret != "" && sig == webhookSignature(secret, body):
+				d.Signature = "own"
+			default:
+				d.Signature = "foreign"
+			}
+			mu.Lock()
+			received[name] = d
+			mu.Unlock()
+			w.WriteHeader(status)
+		}))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	slack := handler("slack", "slack-key", http.StatusOK)
+	pager := handler("pager", "pager-key", http.StatusBadRequest)
+	logs := handler("logs", "", http.StatusOK)
+
+	rm := NewRotationManager(nil, &RotationConfig{
+		NotificationEndpoint: slack.URL,
+		NotificationHeaders:  http.Header{"Authorization": {"Bearer slack-token"}},
+		NotificationSecret:   "slack-key",
+		NotificationEndpoints: []NotificationTarget{
+			{URL: pager.URL, Headers: http.Header{"Authorization": {"Token pager-token"}}, Secret: "pager-key"},
+			{URL: logs.URL},
+			{URL: slack.URL, Headers: http.Header{"Authorization": {"ignored"}}},
+		},
+	})
+	rm.sendNotification("Password rotation completed")
+
+	// Each endpoint gets only its own headers and signature
+	const msg = "Password rotation completed"
+	want := map[string]delivery{
+		"slack": {Message: msg, Authorization: "Bearer slack-token", Signature: "own"},
+		"pager": {Message: msg, Authorization: "Token pager-token", Signature: "own"},
+		"logs":  {Message: msg, Signature: "none"},
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(received, want) {
+		t.Errorf("received = %+v, want %+v", received, want)
+	}
+}
+
+func TestRotationConfig_NotificationEndpoints(t *testing.T) {
+	c := &RotationConfig{
+		NotificationEndpoint:  "https://a.example.com",
+		NotificationSecret:    "a-key",
+		NotificationEndpoints: []NotificationTarget{{URL: "https://b.example.com", Secret: "b-key"}, {URL: "https://a.example.com"}},
+	}
+	want := []NotificationTarget{{URL: "https://a.example.com", Secret: "a-key"}, {URL: "https://b.example.com", Secret: "b-key"}}
+	if got := c.notificationTargets(); !reflect.DeepEqual(got, want) {
+		t.Errorf("notificationTargets() = %+v, want a then b once each", got)
+	}
+	if err := c.Validate(); err != nil {
+		t.Errorf("Validate() = %v", err)
+	}
+	c.NotificationEndpoints = []NotificationTarget{{URL: "ftp://c.example.com"}}
+	if err := c.Validate(); err == nil {
+		t.Error("Validate() accepted a non-HTTP endpoint")
+	}
+	c.NotificationEndpoints = []NotificationTarget{{URL: "https://c.example.com", Headers: http.Header{"Bad Header": {"x"}}}}
+	if err := c.Validate(); err == nil {
+		t.Error("Validate() accepted an invalid endpoint header")
+	}
+	c.NotificationEndpoints = make([]NotificationTarget, maxNotificationEndpoints+1)
+	for i := range c.NotificationEndpoints {
+		c.NotificationEndpoints[i] = NotificationTarget{URL: fmt.Sprintf("https://%d.example.com", i)}
+	}
+	if err := c.Validate(); err == nil {
+		t.Errorf("Validate() accepted %d endpoints", len(c.NotificationEndpoints))
+	}
+}
+
+func TestNotificationTargets(t *testing.T) {
+	got, err := notificationTargets([]interface{}{
+		"https://a.example.com, https://b.example.com",
+		map[string]interface{}{
+			"url":         "https://c.example.com",
+			"headers":     map[string]interface{}{"authorization": "Bearer c"},
+			"hmac_secret": "c-key",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []NotificationTarget{
+		{URL: "https://a.example.com"},
+		{URL: "https://b.example.com"},
+		{URL: "https://c.example.com", Headers: http.Header{"Authorization": {"Bearer c"}}, Secret: "c-key"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("notificationTargets() = %+v, want %+v", got, want)
+	}
+
+	for _, raw := range []interface{}{
+		map[string]interface{}{"headers": map[string]interface{}{"X": "y"}},
+		map[string]interface{}{"url": "https://d.example.com", "secret": "typo"},
+		map[string]interface{}{"url": "https://d.example.com", "headers": "X=y"},
+		42,
+	} {
+		if _, err := notificationTargets([]interface{}{raw}); err == nil {
+			t.Errorf("notificationTargets(%v) accepted an invalid entry", raw)
+		}
+	}
+}
+
+func TestNotificationTarget_UnmarshalLegacyURL(t *testing.T) {
+	var c RotationConfig
+	if err := json.Unmarshal([]byte(`{"notification_endpoints":["https://a.example.com",{"url":"https://b.example.com","hmac_secret":"b-key"}]}`), &c); err != nil {
+		t.Fatal(err)
+	}
+	want := []NotificationTarget{{URL: "https://a.example.com"}, {URL: "https://b.example.com", Secret: "b-key"}}
+	if !reflect.DeepEqual(c.NotificationEndpoints, want) {
+		t.Errorf("NotificationEndpoints = %+v, want %+v", c.NotificationEndpoints, want)
+	}
+}
+
+func TestWebhookBackoff_Capped(t *testing.T) {
+	for attempt := 0; attempt < 20; attempt++ {
+		if d := webhookBackoff(time.Minute, attempt); d > maxWebhookBackoff || d < time.Minute/2 {
+			t.Errorf("webhookBackoff(1m, %d) = %v, want within [30s, %v]", attempt, d, maxWebhookBackoff)
+		}
+	}
+	if d := webhookBackoff(0, 0); d > defaultWebhookRetryDelay {
+		t.Errorf("webhookBackoff(0, 0) = %v, want <= %v", d, defaultWebhookRetryDelay)
+	}
+}